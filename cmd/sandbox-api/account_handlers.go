@@ -5,12 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/jackc/pgx/v4"
 	"github.com/rhpds/sandbox/internal/api/v1"
 	"github.com/rhpds/sandbox/internal/config"
 	"github.com/rhpds/sandbox/internal/log"
 	"github.com/rhpds/sandbox/internal/models"
+	"github.com/rhpds/sandbox/internal/tracing"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
@@ -40,6 +43,16 @@ func (h *AccountHandler) GetAccountsHandler(w http.ResponseWriter, r *http.Reque
 	// Get available from Query
 	available := r.URL.Query().Get("available")
 
+	// A token restricted to a service_uuid prefix can only list the accounts of its services
+	scope := GetTokenScope(r.Context())
+	if !kindInScope(w, r, kind) {
+		return
+	}
+	if scope != nil && scope.ServiceUuidPrefix != "" && !scope.AllowsServiceUuid(serviceUuid) {
+		forbidden(w, r, "service_uuid out of the scope of the token")
+		return
+	}
+
 	var err error
 	var accountlist []interface{}
 	switch kind {
@@ -49,17 +62,19 @@ func (h *AccountHandler) GetAccountsHandler(w http.ResponseWriter, r *http.Reque
 		)
 		if serviceUuid != "" {
 			// Get the account from DynamoDB
-			accounts, err = h.awsAccountProvider.FetchAllByServiceUuid(serviceUuid)
+			accounts, err = h.awsAccounts(r.Context()).FetchAllByServiceUuid(serviceUuid)
 		} else {
 			if available != "" && available == "true" {
-				accounts, err = h.awsAccountProvider.FetchAllAvailable()
+				accounts, err = h.awsAccounts(r.Context()).FetchAllAvailable()
 			} else {
-				accounts, err = h.awsAccountProvider.FetchAll()
+				accounts, err = h.awsAccounts(r.Context()).FetchAll()
 			}
 		}
-		accountlist = make([]interface{}, len(accounts))
-		for i, acc := range accounts {
-			accountlist[i] = acc
+		accountlist = make([]interface{}, 0, len(accounts))
+		for _, acc := range accounts {
+			if scope.AllowsAnnotations(acc.Annotations) {
+				accountlist = append(accountlist, acc)
+			}
 		}
 	case "OcpSandbox", "ocp":
 		var (
@@ -78,14 +93,20 @@ func (h *AccountHandler) GetAccountsHandler(w http.ResponseWriter, r *http.Reque
 		}
 		if serviceUuid != "" {
 			// Get the account from DynamoDB
-			accounts, err = h.OcpSandboxProvider.FetchAllByServiceUuid(serviceUuid)
+			accounts, err = tracing.Call(r.Context(), "OcpSandboxProvider.FetchAllByServiceUuid", func() ([]models.OcpSandbox, error) {
+				return h.OcpSandboxProvider.FetchAllByServiceUuid(serviceUuid)
+			})
 		} else {
-			accounts, err = h.OcpSandboxProvider.FetchAll()
+			accounts, err = tracing.Call(r.Context(), "OcpSandboxProvider.FetchAll", func() ([]models.OcpSandbox, error) {
+				return h.OcpSandboxProvider.FetchAll()
+			})
 		}
 
-		accountlist = make([]interface{}, len(accounts))
-		for i, acc := range accounts {
-			accountlist[i] = acc
+		accountlist = make([]interface{}, 0, len(accounts))
+		for _, acc := range accounts {
+			if scope.AllowsAnnotations(acc.Annotations) {
+				accountlist = append(accountlist, acc)
+			}
 		}
 	}
 
@@ -123,11 +144,15 @@ func (h *AccountHandler) GetAccountHandler(w http.ResponseWriter, r *http.Reques
 	accountName := chi.URLParam(r, "account")
 	kind := chi.URLParam(r, "kind")
 
+	if !kindInScope(w, r, kind) {
+		return
+	}
+
 	switch kind {
 	case "AwsSandbox", "aws":
 
 		// Get the account from DynamoDB
-		sandbox, err := h.awsAccountProvider.FetchByName(accountName)
+		sandbox, err := h.awsAccounts(r.Context()).FetchByName(accountName)
 		if err != nil {
 			if err == models.ErrAccountNotFound {
 				log.Logger.Warn("GET account", "error", err)
@@ -147,13 +172,18 @@ func (h *AccountHandler) GetAccountHandler(w http.ResponseWriter, r *http.Reques
 			})
 			return
 		}
+		if !resourceInScope(w, r, sandbox.Kind, sandbox.ServiceUuid, sandbox.Annotations) {
+			return
+		}
 		// Print account using JSON
 		w.WriteHeader(http.StatusOK)
 		render.Render(w, r, &sandbox)
 		return
 	case "OcpSandbox", "ocp":
 		// Get the account from DynamoDB
-		sandbox, err := h.OcpSandboxProvider.FetchByName(accountName)
+		sandbox, err := tracing.Call(r.Context(), "OcpSandboxProvider.FetchByName", func() (models.OcpSandbox, error) {
+			return h.OcpSandboxProvider.FetchByName(accountName)
+		})
 		if err != nil {
 			if err == models.ErrAccountNotFound {
 				log.Logger.Warn("GET account", "error", err)
@@ -173,6 +203,9 @@ func (h *AccountHandler) GetAccountHandler(w http.ResponseWriter, r *http.Reques
 			})
 			return
 		}
+		if !resourceInScope(w, r, sandbox.Kind, sandbox.ServiceUuid, sandbox.Annotations) {
+			return
+		}
 		// Print account using JSON
 		w.WriteHeader(http.StatusOK)
 		render.Render(w, r, &sandbox)
@@ -187,7 +220,7 @@ func (h *AccountHandler) CleanupAccountHandler(w http.ResponseWriter, r *http.Re
 	// by the swagger openAPI spec.
 
 	// Get the account from DynamoDB
-	sandbox, err := h.awsAccountProvider.FetchByName(accountName)
+	sandbox, err := h.awsAccounts(r.Context()).FetchByName(accountName)
 	if err != nil {
 		if err == models.ErrAccountNotFound {
 			log.Logger.Warn("GET account", "error", err)
@@ -207,8 +240,12 @@ func (h *AccountHandler) CleanupAccountHandler(w http.ResponseWriter, r *http.Re
 		})
 		return
 	}
+	if !resourceInScope(w, r, sandbox.Kind, sandbox.ServiceUuid, sandbox.Annotations) {
+		return
+	}
+
 	// Mark account for cleanup
-	if err := h.awsAccountProvider.MarkForCleanup(sandbox.Name); err != nil {
+	if err := h.awsAccounts(r.Context()).MarkForCleanup(sandbox.Name); err != nil {
 		log.Logger.Error("PUT account cleanup", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		render.Render(w, r, &v1.Error{
@@ -224,6 +261,188 @@ func (h *AccountHandler) CleanupAccountHandler(w http.ResponseWriter, r *http.Re
 	})
 }
 
+// GetAccountCleanupsHandler returns the cleanup attempts of an AWS account, most recent first,
+// and its state in the cleanup queue.
+// GET /accounts/{kind}/{account}/cleanups
+// Query parameters:
+//   - limit: maximum number of attempts, default 20
+func (h *BaseHandler) GetAccountCleanupsHandler(w http.ResponseWriter, r *http.Request) {
+	accountName := chi.URLParam(r, "account")
+	kind := chi.URLParam(r, "kind")
+
+	if kind != "AwsSandbox" && kind != "aws" {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusBadRequest,
+			Message:        "The cleanups are only recorded for AwsSandbox",
+		})
+		return
+	}
+
+	limit := 20
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > 500 {
+			w.WriteHeader(http.StatusBadRequest)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusBadRequest,
+				Message:        "Invalid limit, must be between 1 and 500",
+			})
+			return
+		}
+	}
+
+	sandbox, err := h.awsAccounts(r.Context()).FetchByName(accountName)
+	if err != nil {
+		if err == models.ErrAccountNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusNotFound,
+				Message:        "Account not found",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error reading account",
+		})
+		log.Logger.Error("GetAccountCleanupsHandler", "error", err)
+		return
+	}
+	if !resourceInScope(w, r, sandbox.Kind, sandbox.ServiceUuid, sandbox.Annotations) {
+		return
+	}
+
+	cleanups, err := models.FetchAwsAccountCleanups(h.dbpool, sandbox.Name, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error reading cleanups",
+		})
+		log.Logger.Error("GetAccountCleanupsHandler", "error", err)
+		return
+	}
+
+	response := &v1.AccountCleanupsResponse{
+		HTTPStatusCode: http.StatusOK,
+		Name:           sandbox.Name,
+		Cleanups:       cleanups,
+		Count:          len(cleanups),
+	}
+	if queue := models.AwsAccountCleanupQueue([]models.AwsAccount{sandbox}, time.Now(), h.cleanupPolicy); len(queue) > 0 {
+		response.Queue = &queue[0]
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, response)
+}
+
+// GetCleanupsHandler returns the AWS accounts in the cleanup queue, the longest in the queue first.
+// The states are computed with the policy of the cleaners, see models.CleanupPolicyFromEnv.
+// GET /cleanups
+func (h *BaseHandler) GetCleanupsHandler(w http.ResponseWriter, r *http.Request) {
+	if !kindInScope(w, r, "AwsSandbox") {
+		return
+	}
+
+	accounts, err := h.awsAccounts(r.Context()).FetchAllToCleanup()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error reading accounts to cleanup",
+		})
+		log.Logger.Error("GetCleanupsHandler", "error", err)
+		return
+	}
+
+	// A token restricted to some services only sees their accounts
+	scope := GetTokenScope(r.Context())
+	inScope := []models.AwsAccount{}
+	for _, account := range accounts {
+		if scope.Allows(account.ServiceUuid, account.Annotations) {
+			inScope = append(inScope, account)
+		}
+	}
+
+	queue := models.AwsAccountCleanupQueue(inScope, time.Now(), h.cleanupPolicy)
+	states := map[string]int{"claimable": 0, "leased": 0, "max_retries": 0, "quarantined": 0}
+	for _, entry := range queue {
+		states[entry.State]++
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, &v1.CleanupQueueResponse{
+		HTTPStatusCode: http.StatusOK,
+		Accounts:       queue,
+		Count:          len(queue),
+		States:         states,
+	})
+}
+
+// GetQuarantinedAccountsHandler returns the AWS accounts quarantined after too many failed cleanups
+// GET /admin/quarantined-accounts
+func (h *AccountHandler) GetQuarantinedAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	accounts, err := h.awsAccounts(r.Context()).FetchAllQuarantined()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error reading quarantined accounts",
+		})
+		log.Logger.Error("GetQuarantinedAccountsHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, &v1.QuarantinedAccountsResponse{
+		HTTPStatusCode: http.StatusOK,
+		Accounts:       accounts,
+		Count:          len(accounts),
+	})
+}
+
+// ReleaseQuarantinedAccountHandler releases a quarantined AWS account, it is cleaned up again
+// before going back in the pool.
+// PUT /admin/quarantined-accounts/{account}/release
+func (h *AccountHandler) ReleaseQuarantinedAccountHandler(w http.ResponseWriter, r *http.Request) {
+	accountName := chi.URLParam(r, "account")
+
+	if err := h.awsAccounts(r.Context()).ReleaseQuarantine(accountName); err != nil {
+		if err == models.ErrAccountNotQuarantined {
+			w.WriteHeader(http.StatusNotFound)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusNotFound,
+				Message:        "Quarantined account not found",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error releasing account",
+		})
+		log.Logger.Error("ReleaseQuarantinedAccountHandler", "error", err)
+		return
+	}
+
+	log.Logger.Info("Account released from quarantine", "name", accountName)
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, &v1.SimpleMessage{
+		Message: "Account released from quarantine, marked for cleanup",
+	})
+}
+
 func (h *BaseHandler) LifeCycleAccountHandler(action string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Grab the parameters from Params
@@ -236,7 +455,7 @@ func (h *BaseHandler) LifeCycleAccountHandler(action string) http.HandlerFunc {
 		reqId := GetReqID(r.Context())
 
 		// Get the account from DynamoDB
-		sandbox, err := h.awsAccountProvider.FetchByName(accountName)
+		sandbox, err := h.awsAccounts(r.Context()).FetchByName(accountName)
 		if err != nil {
 			if err == models.ErrAccountNotFound {
 				log.Logger.Warn("GET account", "error", err)
@@ -257,6 +476,10 @@ func (h *BaseHandler) LifeCycleAccountHandler(action string) http.HandlerFunc {
 			return
 		}
 
+		if !resourceInScope(w, r, sandbox.Kind, sandbox.ServiceUuid, sandbox.Annotations) {
+			return
+		}
+
 		// Create a new LifecycleResourceJob
 		lifecycleResourceJob := models.LifecycleResourceJob{
 			ResourceType: sandbox.Kind,
@@ -266,6 +489,7 @@ func (h *BaseHandler) LifeCycleAccountHandler(action string) http.HandlerFunc {
 			Action:       action,
 			Status:       "new",
 			DbPool:       h.dbpool,
+			TraceContext: tracing.Inject(r.Context()),
 		}
 
 		// Create job in DB
@@ -297,7 +521,7 @@ func (h *BaseHandler) GetStatusAccountHandler(w http.ResponseWriter, r *http.Req
 	// by the swagger openAPI spec.
 
 	// Get the account from DynamoDB
-	sandbox, err := h.awsAccountProvider.FetchByName(accountName)
+	sandbox, err := h.awsAccounts(r.Context()).FetchByName(accountName)
 	if err != nil {
 		if err == models.ErrAccountNotFound {
 			log.Logger.Warn("GET account", "error", err)
@@ -318,6 +542,10 @@ func (h *BaseHandler) GetStatusAccountHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if !resourceInScope(w, r, sandbox.Kind, sandbox.ServiceUuid, sandbox.Annotations) {
+		return
+	}
+
 	// Get the last saved status for that account
 	job, err := sandbox.GetLastStatus(h.dbpool)
 	if err != nil {
@@ -371,7 +599,7 @@ func (h *BaseHandler) DeleteAccountHandler(w http.ResponseWriter, r *http.Reques
 	switch kind {
 	case "AwsSandbox", "AwsAccount", "aws_account":
 		// Get the account from DynamoDB
-		sandbox, err := h.awsAccountProvider.FetchByName(accountName)
+		sandbox, err := h.awsAccounts(r.Context()).FetchByName(accountName)
 		if err != nil {
 			if err == models.ErrAccountNotFound {
 				log.Logger.Warn("DELETE account", "error", err)
@@ -392,6 +620,10 @@ func (h *BaseHandler) DeleteAccountHandler(w http.ResponseWriter, r *http.Reques
 			return
 		}
 
+		if !resourceInScope(w, r, sandbox.Kind, sandbox.ServiceUuid, sandbox.Annotations) {
+			return
+		}
+
 		// Ensure:
 		// - the account is marked for cleanup
 		// - cleanup was attempted at least 3 times
@@ -436,7 +668,7 @@ func (h *BaseHandler) DeleteAccountHandler(w http.ResponseWriter, r *http.Reques
 			return
 		}
 
-		err = h.awsAccountProvider.Delete(sandbox.Name)
+		err = h.awsAccounts(r.Context()).Delete(sandbox.Name)
 		if err != nil {
 			log.Logger.Error("Error deleting account", "error", err)
 
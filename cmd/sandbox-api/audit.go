@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/go-chi/render"
+
+	"github.com/rhpds/sandbox/internal/api/v1"
+	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/models"
+)
+
+// Bodies bigger than this are not recorded in the audit events
+const auditMaxBody = 1 << 20
+
+// Audit is a middleware that writes an audit event for each POST, PUT and
+// DELETE call, once the response is sent.
+// It must be used after the Verifier, to know the actor, and before the
+// authenticator, so the rejected calls are recorded too. The body is recorded
+// only if the token was verified, and if it's smaller than auditMaxBody.
+func (h *BaseHandler) Audit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, claims, tokenErr := jwtauth.FromContext(r.Context())
+		verified := tokenErr == nil && token != nil
+
+		var body []byte
+		if verified && r.Body != nil {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(r.Body, auditMaxBody+1))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				render.Render(w, r, &v1.Error{
+					HTTPStatusCode: http.StatusBadRequest,
+					Message:        "Error reading request body",
+				})
+				return
+			}
+			// The handler reads the whole body, what was read first then the rest
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			if len(body) > auditMaxBody {
+				body = nil
+			}
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		event := models.AuditEvent{
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			PathParams: map[string]string{},
+			Body:       models.RedactBody(body),
+			StatusCode: ww.Status(),
+			RequestID:  GetReqID(r.Context()),
+		}
+		if event.StatusCode == 0 {
+			event.StatusCode = http.StatusOK
+		}
+
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			event.Route = rctx.RoutePattern()
+			for i, key := range rctx.URLParams.Keys {
+				if key != "*" {
+					event.PathParams[key] = rctx.URLParams.Values[i]
+				}
+			}
+		}
+		event.Target = models.AuditTarget(event.Route, event.PathParams, body)
+
+		// The actor is known only if the token was verified
+		if verified {
+			event.ActorName, _ = claims["name"].(string)
+			event.ActorRole, _ = claims["role"].(string)
+			event.ActorJti = token.JwtID()
+		}
+
+		if err := event.Save(h.dbpool); err != nil {
+			log.Logger.Error("Error saving audit event", "error", err, "method", event.Method, "path", event.Path)
+		}
+	})
+}
+
+// GetAuditEventsHandler returns the audit events, most recent first.
+// They can be filtered by actor, target and time range.
+func (h *BaseHandler) GetAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.AuditEventFilter{
+		Actor:  query.Get("actor"),
+		Target: query.Get("target"),
+		Limit:  100,
+	}
+
+	if l := query.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > 1000 {
+			w.WriteHeader(http.StatusBadRequest)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusBadRequest,
+				Message:        "Invalid limit, must be between 1 and 1000",
+			})
+			return
+		}
+		filter.Limit = limit
+	}
+
+	for param, dest := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		v := query.Get(param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusBadRequest,
+				Message:        "Invalid '" + param + "', must be a RFC 3339 date, for example 2024-01-02T15:04:05Z",
+			})
+			return
+		}
+		*dest = &t
+	}
+
+	events, err := models.FetchAuditEvents(h.dbpool, filter)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting audit events",
+		})
+		log.Logger.Error("GetAuditEventsHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, events)
+}
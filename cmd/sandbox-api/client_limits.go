@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/go-chi/render"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/rhpds/sandbox/internal/api/v1"
+	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/metrics"
+	"github.com/rhpds/sandbox/internal/models"
+)
+
+// When the caps are reached, the client must wait for its placements to be deleted
+const clientCapsRetryAfter = time.Minute
+
+// tokenName returns the name of the token of the request, it identifies the client
+func tokenName(r *http.Request) string {
+	_, claims, err := jwtauth.FromContext(r.Context())
+	if err != nil {
+		return ""
+	}
+	name, _ := claims["name"].(string)
+	return name
+}
+
+// tooManyRequests sends a 429 Too Many Requests response with the Retry-After header
+func tooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter time.Duration, message string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	render.Render(w, r, &v1.Error{
+		HTTPStatusCode: http.StatusTooManyRequests,
+		Message:        message,
+	})
+}
+
+// placementWithinLimits checks the placement request against the limits of
+// the client: the rate of placement creations and the caps on the resources
+// held. It sends a 429 and returns false if a limit is exceeded.
+// If the client has caps, it holds the lock of the client until the returned
+// function is called, once the placement is saved.
+func (h *BaseHandler) placementWithinLimits(w http.ResponseWriter, r *http.Request, placementRequest *v1.PlacementRequest, name string) (func(), bool) {
+	noop := func() {}
+
+	limit, err := models.GetEffectiveClientLimit(h.dbpool, name)
+	if err != nil {
+		// The limits can't be enforced if they are unknown
+		log.Logger.Error("Error getting client limits", "error", err, "client", name)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusServiceUnavailable,
+			Message:        "Client limits unavailable, try again later",
+		})
+		return noop, false
+	}
+	if limit == nil {
+		return noop, true
+	}
+
+	retryAfter, err := models.TakeClientRateToken(h.dbpool, name, limit)
+	if err != nil {
+		log.Logger.Error("Error checking client rate", "error", err, "client", name)
+	} else if retryAfter > 0 {
+		metrics.ClientLimitRejections.WithLabelValues("rate").Inc()
+		tooManyRequests(w, r, retryAfter, "Too many placements created, placements_per_minute is "+strconv.Itoa(*limit.PlacementsPerMinute))
+		return noop, false
+	}
+
+	if !limit.HasCaps() {
+		return noop, true
+	}
+
+	aws, ocp := 0, 0
+	for _, request := range placementRequest.Resources {
+		switch kind, n := models.RequestedResources(request.Kind, request.Count); kind {
+		case "AwsSandbox":
+			aws += n
+		case "OcpSandbox":
+			ocp += n
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	release, err := models.LockClient(ctx, h.dbpool, name)
+	if err != nil {
+		log.Logger.Error("Error locking client", "error", err, "client", name)
+		tooManyRequests(w, r, time.Second, "Another placement of the client is being created")
+		return noop, false
+	}
+
+	heldAws, heldOcp, err := models.HeldResources(r.Context(), h.dbpool, h.awsAccounts(r.Context()), name)
+	if err != nil {
+		release()
+		log.Logger.Error("Error counting client resources", "error", err, "client", name)
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error checking client limits",
+		})
+		return noop, false
+	}
+
+	if err := limit.AllowsResources(heldAws, heldOcp, aws, ocp); err != nil {
+		release()
+		metrics.ClientLimitRejections.WithLabelValues("caps").Inc()
+		tooManyRequests(w, r, clientCapsRetryAfter, err.Error())
+		return noop, false
+	}
+
+	return release, true
+}
+
+// GetClientLimitsHandler returns the limits of all the clients
+func (h *BaseHandler) GetClientLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	limits, err := models.FetchClientLimits(h.dbpool)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting client limits",
+		})
+		log.Logger.Error("GetClientLimitsHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, limits)
+}
+
+// GetClientLimitHandler returns the limits of a client
+func (h *BaseHandler) GetClientLimitHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	limit, err := models.GetClientLimit(h.dbpool, name)
+	if err == pgx.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusNotFound,
+			Message:        "Client limits not found",
+		})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting client limits",
+		})
+		log.Logger.Error("GetClientLimitHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, limit)
+}
+
+// PutClientLimitHandler creates or replaces the limits of a client.
+// The name '*' sets the limits of the clients without their own limits.
+func (h *BaseHandler) PutClientLimitHandler(w http.ResponseWriter, r *http.Request) {
+	limit := &models.ClientLimit{}
+	if err := render.Bind(r, limit); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusBadRequest,
+			Message:        "Invalid client limits",
+			ErrorMultiline: []string{err.Error()},
+		})
+		return
+	}
+	limit.Name = chi.URLParam(r, "name")
+
+	if err := limit.Save(h.dbpool); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error saving client limits",
+		})
+		log.Logger.Error("PutClientLimitHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, limit)
+}
+
+// DeleteClientLimitHandler deletes the limits of a client
+func (h *BaseHandler) DeleteClientLimitHandler(w http.ResponseWriter, r *http.Request) {
+	err := models.DeleteClientLimit(h.dbpool, chi.URLParam(r, "name"))
+	if err == pgx.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusNotFound,
+			Message:        "Client limits not found",
+		})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error deleting client limits",
+		})
+		log.Logger.Error("DeleteClientLimitHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, &v1.SimpleMessage{
+		Message: "Client limits deleted",
+	})
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/rhpds/sandbox/internal/api/v1"
+	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/models"
+)
+
+// requestStatus returns the global status of a request.
+// Returns pgx.ErrNoRows if the request doesn't exist.
+func (h *BaseHandler) requestStatus(requestID string) (string, error) {
+	placementJob, err := models.GetLifecyclePlacementJobByRequestID(h.dbpool, requestID)
+	if err == nil {
+		return placementJob.GlobalStatus()
+	}
+
+	if err != pgx.ErrNoRows {
+		return "", err
+	}
+
+	// No placement request found, try any resource request
+	job, err := models.GetLifecycleResourceJobByRequestID(h.dbpool, requestID)
+	if err != nil {
+		return "", err
+	}
+
+	return job.Status, nil
+}
+
+// writeEvent writes a Server-Sent Event with data encoded as JSON
+func writeEvent(w http.ResponseWriter, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
+
+// GetEventsRequestHandler streams the progress of a request using Server-Sent Events.
+//
+// The stream starts with the current state: the resource jobs of the request,
+// the lifecycle events already recorded and the status of the request.
+// Then every change is sent as it happens:
+//   - event "job": a resource job changed status
+//   - event "lifecycle_event": an event was recorded, for example an instance was stopped
+//   - event "status": the global status of the request changed
+//
+// The stream ends when the request and all its resource jobs are done.
+func (h *BaseHandler) GetEventsRequestHandler(w http.ResponseWriter, r *http.Request) {
+	requestID := chi.URLParam(r, "id")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Streaming not supported",
+		})
+		log.Logger.Error("GetEventsRequestHandler", "error", "ResponseWriter is not a Flusher")
+		return
+	}
+
+	if !h.requestInScope(w, r, requestID) {
+		return
+	}
+
+	// Subscribe before reading the current state, so no change is missed.
+	notifications := h.notifier.Subscribe()
+	defer h.notifier.Unsubscribe(notifications)
+
+	status, err := h.requestStatus(requestID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusNotFound,
+				Message:        "Request not found",
+			})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting request",
+		})
+		log.Logger.Error("GetEventsRequestHandler", "error", err)
+		return
+	}
+
+	jobs, err := models.GetLifecycleResourceJobsByRequestID(h.dbpool, requestID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting jobs",
+		})
+		log.Logger.Error("GetEventsRequestHandler", "error", err)
+		return
+	}
+
+	events, err := models.GetLifecycleEventsByRequestID(h.dbpool, requestID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting lifecycle events",
+		})
+		log.Logger.Error("GetEventsRequestHandler", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Disable buffering in nginx-based proxies
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// Status of the resource jobs, to know when everything is done
+	jobStatus := map[int]string{}
+	for _, job := range jobs {
+		jobStatus[job.ID] = job.Status
+		writeEvent(w, "job", job)
+	}
+
+	// Events already sent, an event can be both in the initial state and notified
+	sentEvents := map[int]bool{}
+	for _, event := range events {
+		sentEvents[event.ID] = true
+		writeEvent(w, "lifecycle_event", event)
+	}
+
+	sendStatus := func(status string) {
+		writeEvent(w, "status", v1.LifecycleRequestResponse{
+			HTTPStatusCode: http.StatusOK,
+			RequestID:      requestID,
+			Status:         status,
+		})
+	}
+	sendStatus(status)
+	flusher.Flush()
+
+	// done returns true when the request and all its jobs are finished
+	done := func() bool {
+		if !models.LifecycleDone(status) {
+			return false
+		}
+		for _, s := range jobStatus {
+			if !models.LifecycleDone(s) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// refreshStatus sends the global status of the request if it changed
+	refreshStatus := func() {
+		newStatus, err := h.requestStatus(requestID)
+		if err != nil {
+			log.Logger.Error("GetEventsRequestHandler", "error", err, "request_id", requestID)
+			return
+		}
+		if newStatus != status {
+			status = newStatus
+			sendStatus(status)
+		}
+	}
+
+	// refreshJobs sends the jobs and the lifecycle events that changed, in case
+	// their notifications were dropped: done depends on the status of every job.
+	refreshJobs := func() {
+		jobs, err := models.GetLifecycleResourceJobsByRequestID(h.dbpool, requestID)
+		if err != nil {
+			log.Logger.Error("GetEventsRequestHandler", "error", err, "request_id", requestID)
+			return
+		}
+		for _, job := range jobs {
+			if jobStatus[job.ID] != job.Status {
+				jobStatus[job.ID] = job.Status
+				writeEvent(w, "job", job)
+			}
+		}
+
+		events, err := models.GetLifecycleEventsByRequestID(h.dbpool, requestID)
+		if err != nil {
+			log.Logger.Error("GetEventsRequestHandler", "error", err, "request_id", requestID)
+			return
+		}
+		for _, event := range events {
+			if !sentEvents[event.ID] {
+				sentEvents[event.ID] = true
+				writeEvent(w, "lifecycle_event", event)
+			}
+		}
+	}
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for !done() {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-heartbeat.C:
+			// Comment line to keep the connection open.
+			// Also refresh the jobs and the status in case a notification was dropped.
+			fmt.Fprint(w, ": heartbeat\n\n")
+			refreshJobs()
+			refreshStatus()
+
+		case notification, ok := <-notifications:
+			if !ok {
+				return
+			}
+
+			id, err := strconv.Atoi(notification.Payload)
+			if err != nil {
+				log.Logger.Error("Error converting payload to int", "error", err, "payload", notification.Payload)
+				continue
+			}
+
+			switch notification.Channel {
+			case "lifecycle_resource_jobs_status_channel":
+				job, err := models.GetLifecycleResourceJob(h.dbpool, id)
+				if err != nil || job.RequestID != requestID {
+					continue
+				}
+				if jobStatus[job.ID] == job.Status {
+					continue
+				}
+				jobStatus[job.ID] = job.Status
+				writeEvent(w, "job", job)
+				refreshStatus()
+
+			case "lifecycle_placement_jobs_status_channel":
+				job, err := models.GetLifecyclePlacementJob(h.dbpool, id)
+				if err != nil || job.RequestID != requestID {
+					continue
+				}
+				refreshStatus()
+
+			case "lifecycle_events_channel":
+				if sentEvents[id] {
+					continue
+				}
+				event, err := models.GetLifecycleEvent(h.dbpool, id)
+				if err != nil || event.RequestID != requestID {
+					continue
+				}
+				sentEvents[id] = true
+				writeEvent(w, "lifecycle_event", event)
+			}
+		}
+
+		flusher.Flush()
+	}
+}
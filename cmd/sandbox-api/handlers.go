@@ -2,9 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -21,7 +23,9 @@ import (
 	"github.com/rhpds/sandbox/internal/api/v1"
 	"github.com/rhpds/sandbox/internal/config"
 	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/metrics"
 	"github.com/rhpds/sandbox/internal/models"
+	"github.com/rhpds/sandbox/internal/tracing"
 )
 
 type BaseHandler struct {
@@ -31,14 +35,23 @@ type BaseHandler struct {
 	oaRouter           oarouters.Router
 	awsAccountProvider models.AwsAccountProvider
 	OcpSandboxProvider models.OcpSandboxProvider
+	notifier           *Notifier
+	revocations        *models.Revocations
+	// The scheduled reservations are activated this long before their start
+	reservationLeadTime time.Duration
+	// Policy of sandbox-cleaner, to report the states of the cleanup queue
+	cleanupPolicy models.CleanupPolicy
 }
 
 type AdminHandler struct {
 	BaseHandler
 	tokenAuth *jwtauth.JWTAuth
+	// Lifetime of the access and refresh tokens issued on login
+	accessTokenLifetime  time.Duration
+	refreshTokenLifetime time.Duration
 }
 
-func NewBaseHandler(svc *dynamodb.DynamoDB, dbpool *pgxpool.Pool, doc *openapi3.T, oaRouter oarouters.Router, awsAccountProvider models.AwsAccountProvider, OcpSandboxProvider models.OcpSandboxProvider) *BaseHandler {
+func NewBaseHandler(svc *dynamodb.DynamoDB, dbpool *pgxpool.Pool, doc *openapi3.T, oaRouter oarouters.Router, awsAccountProvider models.AwsAccountProvider, OcpSandboxProvider models.OcpSandboxProvider, notifier *Notifier, revocations *models.Revocations) *BaseHandler {
 	return &BaseHandler{
 		svc:                svc,
 		dbpool:             dbpool,
@@ -46,23 +59,46 @@ func NewBaseHandler(svc *dynamodb.DynamoDB, dbpool *pgxpool.Pool, doc *openapi3.
 		oaRouter:           oaRouter,
 		awsAccountProvider: awsAccountProvider,
 		OcpSandboxProvider: OcpSandboxProvider,
+		notifier:           notifier,
+		revocations:        revocations,
+		// Can be changed with the RESERVATION_LEAD_TIME environment variable
+		reservationLeadTime: defaultReservationLeadTime,
+		cleanupPolicy:       models.DefaultCleanupPolicy(),
 	}
 }
 
-func NewAdminHandler(b *BaseHandler, tokenAuth *jwtauth.JWTAuth) *AdminHandler {
+func NewAdminHandler(b *BaseHandler, tokenAuth *jwtauth.JWTAuth, accessTokenLifetime time.Duration, refreshTokenLifetime time.Duration) *AdminHandler {
 	return &AdminHandler{
 		BaseHandler: BaseHandler{
-			svc:                b.svc,
-			dbpool:             b.dbpool,
-			doc:                b.doc,
-			oaRouter:           b.oaRouter,
-			awsAccountProvider: b.awsAccountProvider,
-			OcpSandboxProvider: b.OcpSandboxProvider,
+			svc:                 b.svc,
+			dbpool:              b.dbpool,
+			doc:                 b.doc,
+			oaRouter:            b.oaRouter,
+			awsAccountProvider:  b.awsAccountProvider,
+			OcpSandboxProvider:  b.OcpSandboxProvider,
+			notifier:            b.notifier,
+			revocations:         b.revocations,
+			reservationLeadTime: b.reservationLeadTime,
+			cleanupPolicy:       b.cleanupPolicy,
 		},
-		tokenAuth: tokenAuth,
+		tokenAuth:            tokenAuth,
+		accessTokenLifetime:  accessTokenLifetime,
+		refreshTokenLifetime: refreshTokenLifetime,
 	}
 }
 
+// kindLabel returns the kind of a resource as a metric label, the aliases of
+// AwsSandbox are merged.
+func kindLabel(kind string) string {
+	switch kind {
+	case "AwsSandbox", "AwsAccount", "aws_account":
+		return "AwsSandbox"
+	case "OcpSandbox":
+		return "OcpSandbox"
+	}
+	return "unknown"
+}
+
 func multipleKind(resources []v1.ResourceRequest, kind string) bool {
 	count := 0
 	for _, request := range resources {
@@ -100,6 +136,10 @@ func (h *BaseHandler) CreatePlacementHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if !placementRequestInScope(w, r, placementRequest) {
+		return
+	}
+
 	_, err := models.GetPlacementByServiceUuid(h.dbpool, placementRequest.ServiceUuid)
 	if err != pgx.ErrNoRows {
 		if err != nil {
@@ -122,6 +162,22 @@ func (h *BaseHandler) CreatePlacementHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// Rate of placement creations and caps on the resources held by the client
+	client := tokenName(r)
+	release, ok := h.placementWithinLimits(w, r, placementRequest, client)
+	if !ok {
+		return
+	}
+	defer release()
+
+	// Quotas of the tenant of the token, if any
+	tenant := tenantName(r)
+	releaseTenant, ok := h.placementWithinTenantQuotas(w, r, placementRequest, tenant)
+	if !ok {
+		return
+	}
+	defer releaseTenant()
+
 	// Create the placement
 
 	// keep resources to cleanup in case something goes wrong while creating the placement
@@ -134,7 +190,7 @@ func (h *BaseHandler) CreatePlacementHandler(w http.ResponseWriter, r *http.Requ
 		switch request.Kind {
 		case "AwsSandbox", "AwsAccount", "aws_account":
 			// Create the placement in AWS
-			accounts, err := h.awsAccountProvider.Request(
+			accounts, err := h.awsAccounts(r.Context()).Request(
 				placementRequest.ServiceUuid,
 				placementRequest.Reservation,
 				request.Count,
@@ -150,6 +206,7 @@ func (h *BaseHandler) CreatePlacementHandler(w http.ResponseWriter, r *http.Requ
 					}
 				}()
 				if err == models.ErrNoEnoughAccountsAvailable {
+					metrics.PlacementResources.WithLabelValues("AwsSandbox", "no_capacity").Inc()
 					w.WriteHeader(http.StatusInsufficientStorage)
 					render.Render(w, r, &v1.Error{
 						HTTPStatusCode: http.StatusInsufficientStorage,
@@ -158,6 +215,7 @@ func (h *BaseHandler) CreatePlacementHandler(w http.ResponseWriter, r *http.Requ
 					log.Logger.Error("CreatePlacementHandler", "error", err)
 					return
 				}
+				metrics.PlacementResources.WithLabelValues("AwsSandbox", "error").Inc()
 				w.WriteHeader(http.StatusInternalServerError)
 				render.Render(w, r, &v1.Error{
 					Err:            err,
@@ -177,6 +235,7 @@ func (h *BaseHandler) CreatePlacementHandler(w http.ResponseWriter, r *http.Requ
 			// Create the placement in OCP
 			account, err := h.OcpSandboxProvider.Request(
 				placementRequest.ServiceUuid,
+				placementRequest.Reservation,
 				request.CloudSelector,
 				placementRequest.Annotations.Merge(request.Annotations),
 				request.Quota,
@@ -194,6 +253,7 @@ func (h *BaseHandler) CreatePlacementHandler(w http.ResponseWriter, r *http.Requ
 					}
 				}()
 				if strings.Contains(err.Error(), "already exists") {
+					metrics.PlacementResources.WithLabelValues("OcpSandbox", "conflict").Inc()
 					w.WriteHeader(http.StatusConflict)
 					render.Render(w, r, &v1.Error{
 						Err:            err,
@@ -204,7 +264,19 @@ func (h *BaseHandler) CreatePlacementHandler(w http.ResponseWriter, r *http.Requ
 					return
 				}
 
+				if errors.Is(err, models.ErrReservationFull) {
+					metrics.PlacementResources.WithLabelValues("OcpSandbox", "no_capacity").Inc()
+					w.WriteHeader(http.StatusInsufficientStorage)
+					render.Render(w, r, &v1.Error{
+						HTTPStatusCode: http.StatusInsufficientStorage,
+						Message:        "Not enough capacity in the reservation",
+						ErrorMultiline: []string{err.Error()},
+					})
+					return
+				}
+
 				if err == models.ErrNoSchedule {
+					metrics.PlacementResources.WithLabelValues("OcpSandbox", "no_capacity").Inc()
 					w.WriteHeader(http.StatusNotFound)
 					render.Render(w, r, &v1.Error{
 						Err:            err,
@@ -215,6 +287,7 @@ func (h *BaseHandler) CreatePlacementHandler(w http.ResponseWriter, r *http.Requ
 					return
 				}
 
+				metrics.PlacementResources.WithLabelValues("OcpSandbox", "error").Inc()
 				w.WriteHeader(http.StatusInternalServerError)
 				render.Render(w, r, &v1.Error{
 					ErrorMultiline: []string{err.Error()},
@@ -228,6 +301,7 @@ func (h *BaseHandler) CreatePlacementHandler(w http.ResponseWriter, r *http.Requ
 			resources = append(resources, account)
 
 		default:
+			metrics.PlacementResources.WithLabelValues("unknown", "invalid").Inc()
 			w.WriteHeader(http.StatusBadRequest)
 			render.Render(w, r, &v1.Error{
 				HTTPStatusCode: http.StatusBadRequest,
@@ -244,12 +318,17 @@ func (h *BaseHandler) CreatePlacementHandler(w http.ResponseWriter, r *http.Requ
 			Annotations: placementRequest.Annotations,
 			Request:     placementRequest,
 			Resources:   resources,
+			CreatedBy:   client,
+			Tenant:      tenant,
 			DbPool:      h.dbpool,
 		},
 	}
 	placement.Resources = resources
 
 	if err := placement.Create(); err != nil {
+		for _, request := range placementRequest.Resources {
+			metrics.PlacementResources.WithLabelValues(kindLabel(request.Kind), "error").Inc()
+		}
 		log.Logger.Error("Error saving placement", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		render.Render(w, r, &v1.Error{
@@ -260,6 +339,25 @@ func (h *BaseHandler) CreatePlacementHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// AwsSandboxes are ready as soon as they are booked.
+	// OcpSandboxes are ready later, the event is emitted by the resources_webhook trigger.
+	for _, resource := range resources {
+		if account, ok := resource.(models.AwsAccount); ok {
+			if err := models.EmitWebhookEvent(h.dbpool, "resource.ready", map[string]any{
+				"name":         account.Name,
+				"kind":         account.Kind,
+				"service_uuid": placementRequest.ServiceUuid,
+				"status":       "success",
+			}); err != nil {
+				log.Logger.Error("Error emitting webhook event", "error", err, "account", account.Name)
+			}
+		}
+	}
+
+	for _, request := range placementRequest.Resources {
+		metrics.PlacementResources.WithLabelValues(kindLabel(request.Kind), "success").Inc()
+	}
+
 	w.WriteHeader(http.StatusOK)
 	render.Render(w, r, &v1.PlacementResponse{
 		Placement:      placement,
@@ -329,6 +427,9 @@ func (h *BaseHandler) GetPlacementsHandler(w http.ResponseWriter, r *http.Reques
 // Get placement by service uuid
 func (h *BaseHandler) GetPlacementHandler(w http.ResponseWriter, r *http.Request) {
 	serviceUuid := chi.URLParam(r, "uuid")
+	if !serviceUuidInScope(w, r, serviceUuid) {
+		return
+	}
 
 	placement, err := models.GetPlacementByServiceUuid(h.dbpool, serviceUuid)
 	if err != nil {
@@ -350,7 +451,10 @@ func (h *BaseHandler) GetPlacementHandler(w http.ResponseWriter, r *http.Request
 		log.Logger.Error("GetPlacementHandler", "error", err)
 		return
 	}
-	if err := placement.LoadActiveResourcesWithCreds(h.awsAccountProvider, h.OcpSandboxProvider); err != nil {
+	if !placementInScope(w, r, placement) {
+		return
+	}
+	if err := placement.LoadActiveResourcesWithCreds(h.awsAccounts(r.Context()), h.OcpSandboxProvider); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		render.Render(w, r, &v1.Error{
 			Err:            err,
@@ -370,6 +474,9 @@ func (h *BaseHandler) GetPlacementHandler(w http.ResponseWriter, r *http.Request
 // Delete placement by service uuid
 func (h *BaseHandler) DeletePlacementHandler(w http.ResponseWriter, r *http.Request) {
 	serviceUuid := chi.URLParam(r, "uuid")
+	if !serviceUuidInScope(w, r, serviceUuid) {
+		return
+	}
 
 	placement, err := models.GetPlacementByServiceUuid(h.dbpool, serviceUuid)
 	if err != nil {
@@ -391,6 +498,10 @@ func (h *BaseHandler) DeletePlacementHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if !placementInScope(w, r, placement) {
+		return
+	}
+
 	if err := placement.MarkForCleanup(); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		render.Render(w, r, &v1.Error{
@@ -408,7 +519,7 @@ func (h *BaseHandler) DeletePlacementHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 	placement.SetStatus("deleting")
-	go placement.Delete(h.awsAccountProvider, h.OcpSandboxProvider)
+	go placement.Delete(h.awsAccounts(tracing.Detach(r.Context())), h.OcpSandboxProvider)
 
 	w.WriteHeader(http.StatusAccepted)
 	render.Render(w, r, &v1.SimpleMessage{
@@ -420,18 +531,25 @@ func (h *BaseHandler) LifeCyclePlacementHandler(action string) http.HandlerFunc
 	return func(w http.ResponseWriter, r *http.Request) {
 		serviceUuid := chi.URLParam(r, "uuid")
 		reqId := GetReqID(r.Context())
+		if !serviceUuidInScope(w, r, serviceUuid) {
+			return
+		}
 
 		placement, err := models.GetPlacementByServiceUuid(h.dbpool, serviceUuid)
 
 		if err == nil {
+			if !placementInScope(w, r, placement) {
+				return
+			}
 
 			lifecyclePlacementJob := models.LifecyclePlacementJob{
-				PlacementID: placement.ID,
-				Locality:    config.LocalityID,
-				RequestID:   reqId,
-				Action:      action,
-				Status:      "new",
-				DbPool:      h.dbpool,
+				PlacementID:  placement.ID,
+				Locality:     config.LocalityID,
+				RequestID:    reqId,
+				Action:       action,
+				Status:       "new",
+				DbPool:       h.dbpool,
+				TraceContext: tracing.Inject(r.Context()),
 			}
 
 			// Create job in DB
@@ -458,7 +576,7 @@ func (h *BaseHandler) LifeCyclePlacementHandler(action string) http.HandlerFunc
 		if err == pgx.ErrNoRows {
 			// Legacy services don't have a placement, but stop them anyway
 
-			accounts, err := h.awsAccountProvider.FetchAllActiveByServiceUuid(serviceUuid)
+			accounts, err := h.awsAccounts(r.Context()).FetchAllActiveByServiceUuid(serviceUuid)
 			if err != nil {
 				log.Logger.Error("GET accounts", "error", err)
 
@@ -480,6 +598,12 @@ func (h *BaseHandler) LifeCyclePlacementHandler(action string) http.HandlerFunc
 				return
 			}
 
+			for _, account := range accounts {
+				if !resourceInScope(w, r, account.Kind, account.ServiceUuid, account.Annotations) {
+					return
+				}
+			}
+
 			for _, account := range accounts {
 				// Create a new LifecycleResourceJob
 				lifecycleResourceJob := models.LifecycleResourceJob{
@@ -490,6 +614,7 @@ func (h *BaseHandler) LifeCyclePlacementHandler(action string) http.HandlerFunc
 					Action:       action,
 					Status:       "new",
 					DbPool:       h.dbpool,
+					TraceContext: tracing.Inject(r.Context()),
 				}
 
 				// Create job in DB
@@ -529,10 +654,16 @@ func (h *BaseHandler) LifeCyclePlacementHandler(action string) http.HandlerFunc
 
 func (h *BaseHandler) GetStatusPlacementHandler(w http.ResponseWriter, r *http.Request) {
 	serviceUuid := chi.URLParam(r, "uuid")
+	if !serviceUuidInScope(w, r, serviceUuid) {
+		return
+	}
 
 	placement, err := models.GetPlacementByServiceUuid(h.dbpool, serviceUuid)
 
 	if err == nil {
+		if !placementInScope(w, r, placement) {
+			return
+		}
 
 		rjobs, err := placement.GetLastStatus()
 		if err != nil {
@@ -562,7 +693,7 @@ func (h *BaseHandler) GetStatusPlacementHandler(w http.ResponseWriter, r *http.R
 	if err == pgx.ErrNoRows {
 		// Legacy services don't have a placement, but get status using the serviceUUID
 
-		accounts, err := h.awsAccountProvider.FetchAllActiveByServiceUuid(serviceUuid)
+		accounts, err := h.awsAccounts(r.Context()).FetchAllActiveByServiceUuid(serviceUuid)
 		if err != nil {
 			log.Logger.Error("GET accounts", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -582,6 +713,12 @@ func (h *BaseHandler) GetStatusPlacementHandler(w http.ResponseWriter, r *http.R
 			return
 		}
 
+		for _, account := range accounts {
+			if !resourceInScope(w, r, account.Kind, account.ServiceUuid, account.Annotations) {
+				return
+			}
+		}
+
 		statuses := []models.Status{}
 		for _, account := range accounts {
 			job, err := account.GetLastStatus(h.dbpool)
@@ -629,7 +766,7 @@ func (h *BaseHandler) GetStatusPlacementHandler(w http.ResponseWriter, r *http.R
 
 func (h *BaseHandler) GetJWTHandler(w http.ResponseWriter, r *http.Request) {
 
-	tokens, err := models.FetchAllTokens(h.dbpool)
+	tokens, err := models.FetchAllLoginTokens(h.dbpool)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		render.Render(w, r, &v1.Error{
@@ -673,6 +810,49 @@ func (h *AdminHandler) IssueLoginJWTHandler(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
+	if name, ok := request.Claims["name"].(string); ok && strings.HasPrefix(name, oidcNamePrefix) {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusBadRequest,
+			Message:        fmt.Sprintf("Invalid claims, the prefix '%s' of 'name' is reserved to the OIDC users", oidcNamePrefix),
+		})
+		return
+	}
+
+	// Validate the scope restricting the token, if any
+	if _, ok := request.Claims["scope"]; ok {
+		if request.Claims["role"] == "admin" {
+			w.WriteHeader(http.StatusBadRequest)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusBadRequest,
+				Message:        "Invalid claims, 'scope' is not supported for the admin role",
+			})
+			return
+		}
+
+		if _, err := models.ParseTokenScope(request.Claims["scope"]); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusBadRequest,
+				Message:        "Invalid claims, " + err.Error(),
+			})
+			log.Logger.Error("Invalid token request", "error", err)
+			return
+		}
+	}
+
+	// The tenant of the placements created with the token, if any
+	if tenant, ok := request.Claims["tenant"]; ok {
+		if name, isString := tenant.(string); !isString || name == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusBadRequest,
+				Message:        "Invalid claims, 'tenant' must be a non-empty string",
+			})
+			return
+		}
+	}
+
 	// set 'iat'
 	jwtauth.SetIssuedNow(request.Claims)
 
@@ -730,11 +910,11 @@ func (h *AdminHandler) IssueLoginJWTHandler(w http.ResponseWriter, r *http.Reque
 
 // LoginHandler handles the login request
 // User must provide a valid login token
-// The login token is used to generate an access token
+// The login token is used to generate an access token and a refresh token
 // The AdminHandler is required here because it contains the tokenAuth
 func (h *AdminHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	// Grab role from login token
-	_, loginClaims, err := jwtauth.FromContext(r.Context())
+	loginToken, loginClaims, err := jwtauth.FromContext(r.Context())
 	log.Logger.Info("login token", "token", loginClaims)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -746,15 +926,43 @@ func (h *AdminHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate access token
+	loginID, err := strconv.Atoi(loginToken.JwtID())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusBadRequest,
+			Message:        "Invalid login token",
+		})
+		log.Logger.Error("Invalid login token", "error", err)
+		return
+	}
 
-	accessToken, accessTokenString, err := h.tokenAuth.Encode(map[string]interface{}{
-		"name": loginClaims["name"],
-		"kind": "access",
-		"role": loginClaims["role"],
-		"exp":  jwtauth.ExpireIn(time.Hour),
-		"iat":  jwtauth.EpochNow(),
-	})
+	// Store the refresh token in DB, it's rotated on each use
+	refreshClaims := h.refreshClaims(loginClaims, loginToken.JwtID())
+	refreshModel, err := models.CreateToken(refreshClaims)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error creating token",
+		})
+		log.Logger.Error("Error creating token", "error", err)
+		return
+	}
+	refreshModel.ParentID = &loginID
+
+	refreshModel.ID, err = refreshModel.Save(h.dbpool)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error saving token",
+		})
+		log.Logger.Error("Error saving token", "error", err)
+		return
+	}
+
+	response, err := h.issueTokens(refreshClaims, refreshModel.ID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		render.Render(w, r, &v1.Error{
@@ -764,29 +972,10 @@ func (h *AdminHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		log.Logger.Error("Error generating token", "error", err)
 		return
 	}
-	// refreshToken, refreshTokenString, err := h.tokenAuth.Encode(map[string]interface{}{
-	// 	"name": loginClaims["name"],
-	// 	"kind": "refresh",
-	// 	"role": loginClaims["role"],
-	// 	"exp":  jwtauth.ExpireIn(time.Hour * 24 * 7),
-	// 	"iat":  jwtauth.EpochNow(),
-	// })
-	// if err != nil {
-	// 	w.WriteHeader(http.StatusInternalServerError)
-	// 	render.Render(w, r, &v1.Error{
-	// 		HTTPStatusCode: http.StatusInternalServerError,
-	// 		Message:        "Error generating token",
-	// 	})
-	// 	log.Logger.Error("Error generating token", "error", err)
-	// 	return
-	// }
+
 	log.Logger.Info("login", "name", loginClaims["name"], "role", loginClaims["role"])
 	w.WriteHeader(http.StatusOK)
-	ta := accessToken.Expiration()
-	render.Render(w, r, &v1.TokenResponse{
-		AccessToken:    accessTokenString,
-		AccessTokenExp: &ta,
-	})
+	render.Render(w, r, response)
 }
 
 func (h *BaseHandler) InvalidateTokenHandler(w http.ResponseWriter, r *http.Request) {
@@ -867,6 +1056,10 @@ func (h *BaseHandler) GetStatusRequestHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if !h.requestInScope(w, r, RequestID) {
+		return
+	}
+
 	// Get the request from the DB
 	job, err := models.GetLifecyclePlacementJobByRequestID(h.dbpool, RequestID)
 
@@ -934,6 +1127,201 @@ func (h *BaseHandler) GetStatusRequestHandler(w http.ResponseWriter, r *http.Req
 	})
 }
 
+// GetRequestHandler returns the detail of a request: the placement job,
+// the resource jobs and the lifecycle events
+func (h *BaseHandler) GetRequestHandler(w http.ResponseWriter, r *http.Request) {
+	requestID := chi.URLParam(r, "id")
+	if !h.requestInScope(w, r, requestID) {
+		return
+	}
+
+	request, err := models.GetLifecycleRequest(h.dbpool, requestID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusNotFound,
+				Message:        "Request not found",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting request",
+		})
+		log.Logger.Error("GetRequestHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, request)
+}
+
+// CancelRequestHandler cancels an in-flight lifecycle request.
+// The jobs not started yet are not executed, the running ones stop
+// between regions and resources.
+func (h *BaseHandler) CancelRequestHandler(w http.ResponseWriter, r *http.Request) {
+	requestID := chi.URLParam(r, "id")
+	if !h.requestInScope(w, r, requestID) {
+		return
+	}
+
+	if _, err := models.GetLifecycleRequest(h.dbpool, requestID); err != nil {
+		if err == pgx.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusNotFound,
+				Message:        "Request not found",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting request",
+		})
+		log.Logger.Error("CancelRequestHandler", "error", err)
+		return
+	}
+
+	count, err := models.CancelLifecycleRequest(h.dbpool, requestID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error cancelling request",
+		})
+		log.Logger.Error("CancelRequestHandler", "error", err)
+		return
+	}
+
+	if count == 0 {
+		w.WriteHeader(http.StatusConflict)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusConflict,
+			Message:        "Request is already done",
+		})
+		return
+	}
+
+	// Reload the request to complete it with its new status
+	request, err := models.GetLifecycleRequest(h.dbpool, requestID)
+	if err == nil {
+		if request.PlacementJob != nil {
+			err = request.PlacementJob.MarkCompleted()
+		} else {
+			for _, job := range request.ResourceJobs {
+				if err = job.MarkCompleted(); err != nil {
+					break
+				}
+			}
+		}
+	}
+	if err != nil {
+		log.Logger.Error("CancelRequestHandler", "error", err, "request_id", requestID)
+	}
+
+	log.Logger.Info("Request cancelled", "request_id", requestID, "jobs", count)
+
+	w.WriteHeader(http.StatusAccepted)
+	render.Render(w, r, &v1.LifecycleRequestResponse{
+		HTTPStatusCode: http.StatusAccepted,
+		RequestID:      requestID,
+		Status:         "cancelled",
+		Message:        "Request cancelled",
+	})
+}
+
+// GetPlacementJobsHandler returns the lifecycle requests of a placement, most recent first.
+// Query parameters:
+//   - limit: maximum number of requests, default 20
+func (h *BaseHandler) GetPlacementJobsHandler(w http.ResponseWriter, r *http.Request) {
+	serviceUuid := chi.URLParam(r, "uuid")
+	if !serviceUuidInScope(w, r, serviceUuid) {
+		return
+	}
+
+	limit := 20
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > 500 {
+			w.WriteHeader(http.StatusBadRequest)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusBadRequest,
+				Message:        "Invalid limit, must be between 1 and 500",
+			})
+			return
+		}
+	}
+
+	placement, err := models.GetPlacementByServiceUuid(h.dbpool, serviceUuid)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusNotFound,
+				Message:        "Placement not found",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting placement",
+		})
+		log.Logger.Error("GetPlacementJobsHandler", "error", err)
+		return
+	}
+
+	if !placementInScope(w, r, placement) {
+		return
+	}
+
+	placementJobs, err := models.GetLifecyclePlacementJobsByPlacementID(h.dbpool, placement.ID, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting jobs",
+		})
+		log.Logger.Error("GetPlacementJobsHandler", "error", err)
+		return
+	}
+
+	jobs := []models.LifecycleRequest{}
+	for _, job := range placementJobs {
+		detail, err := job.Detail()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			render.Render(w, r, &v1.Error{
+				Err:            err,
+				HTTPStatusCode: http.StatusInternalServerError,
+				Message:        "Error getting jobs",
+			})
+			log.Logger.Error("GetPlacementJobsHandler", "error", err)
+			return
+		}
+		jobs = append(jobs, *detail)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, &v1.LifecycleJobsResponse{
+		HTTPStatusCode: http.StatusOK,
+		ServiceUuid:    serviceUuid,
+		Jobs:           jobs,
+	})
+}
+
 // Regex to ensure a string is alpha-numeric + underscore + dash
 var nameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 
@@ -990,7 +1378,7 @@ func (h *BaseHandler) CreateReservationHandler(w http.ResponseWriter, r *http.Re
 	}
 
 	// Validate the request
-	if message, err := reservationRequest.Validate(h.awsAccountProvider); err != nil {
+	if message, err := reservationRequest.Validate(h.awsAccounts(r.Context()), h.OcpSandboxProvider); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		render.Render(w, r, &v1.Error{
 			Err:            err,
@@ -1008,6 +1396,11 @@ func (h *BaseHandler) CreateReservationHandler(w http.ResponseWriter, r *http.Re
 		Status:  "new",
 	}
 
+	// A reservation starting later is activated by the ReservationScheduler
+	if reservationRequest.Scheduled(time.Now(), h.reservationLeadTime) {
+		reservation.Status = "scheduled"
+	}
+
 	if err := reservation.Save(h.dbpool); err != nil {
 		log.Logger.Error("Error saving reservation", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -1019,6 +1412,16 @@ func (h *BaseHandler) CreateReservationHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	if reservation.Status == "scheduled" {
+		w.WriteHeader(http.StatusAccepted)
+		render.Render(w, r, &v1.ReservationResponse{
+			Reservation:    reservation,
+			Message:        "Reservation scheduled",
+			HTTPStatusCode: http.StatusAccepted,
+		})
+		return
+	}
+
 	// Initialize and construct the reservation.
 	// Here we can use a goroutine as this is an admin endpoint,
 	// we don't need a worker queue to prevent high load, memory exhaustion, or things of the sort.
@@ -1034,7 +1437,7 @@ func (h *BaseHandler) CreateReservationHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	go reservation.Initialize(h.dbpool, h.awsAccountProvider)
+	go reservation.Initialize(h.dbpool, h.awsAccounts(tracing.Detach(r.Context())))
 
 	w.WriteHeader(http.StatusAccepted)
 	render.Render(w, r, &v1.ReservationResponse{
@@ -1081,7 +1484,7 @@ func (h *BaseHandler) DeleteReservationHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	go reservation.Remove(h.dbpool, h.awsAccountProvider)
+	go reservation.Remove(h.dbpool, h.awsAccounts(tracing.Detach(r.Context())))
 
 	w.WriteHeader(http.StatusAccepted)
 	render.Render(w, r, &v1.ReservationResponse{
@@ -1133,7 +1536,8 @@ func (h *BaseHandler) UpdateReservationHandler(w http.ResponseWriter, r *http.Re
 	}
 
 	// Validate the request
-	if message, err := reservationReq.Validate(h.awsAccountProvider); err != nil {
+	reservationReq.Name = name
+	if message, err := reservationReq.Validate(h.awsAccounts(r.Context()), h.OcpSandboxProvider); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		render.Render(w, r, &v1.Error{
 			Err:            err,
@@ -1144,6 +1548,41 @@ func (h *BaseHandler) UpdateReservationHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	// A reservation not holding any resource, scheduled or expired, is
+	// replaced by the new request and activated again when its window starts.
+	if reservation.Status == "scheduled" || reservation.Status == "expired" {
+		reservation.Request = reservationReq
+		reservation.Status = "initializing"
+		if reservationReq.Scheduled(time.Now(), h.reservationLeadTime) {
+			reservation.Status = "scheduled"
+		}
+
+		if err := reservation.Save(h.dbpool); err != nil {
+			log.Logger.Error("Error saving reservation", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			render.Render(w, r, &v1.Error{
+				Err:            err,
+				HTTPStatusCode: http.StatusInternalServerError,
+				Message:        "Error saving reservation",
+			})
+			return
+		}
+
+		message := "Reservation scheduled"
+		if reservation.Status == "initializing" {
+			go reservation.Initialize(h.dbpool, h.awsAccounts(tracing.Detach(r.Context())))
+			message = "Reservation update request created"
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		render.Render(w, r, &v1.ReservationResponse{
+			Reservation:    reservation,
+			Message:        message,
+			HTTPStatusCode: http.StatusAccepted,
+		})
+		return
+	}
+
 	// Update the status
 	if err := reservation.UpdateStatus(h.dbpool, "updating"); err != nil {
 		log.Logger.Error("Error updating reservation status", "error", err)
@@ -1157,7 +1596,7 @@ func (h *BaseHandler) UpdateReservationHandler(w http.ResponseWriter, r *http.Re
 	}
 
 	// Async Update the reservation
-	go reservation.Update(h.dbpool, h.awsAccountProvider, reservationReq)
+	go reservation.Update(h.dbpool, h.awsAccounts(tracing.Detach(r.Context())), reservationReq)
 
 	w.WriteHeader(http.StatusAccepted)
 	render.Render(w, r, &v1.ReservationResponse{
@@ -1167,6 +1606,82 @@ func (h *BaseHandler) UpdateReservationHandler(w http.ResponseWriter, r *http.Re
 	})
 }
 
+// GetReservationsHandler lists the reservations, with the live counts of their
+// AWS accounts. With 'status', only the reservations with this status are listed.
+// With 'orphans=true', the AWS accounts holding the name of a reservation that
+// doesn't exist are listed too.
+func (h *BaseHandler) GetReservationsHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains(models.ReservationStatuses, status) {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusBadRequest,
+			Message:        "Invalid status, must be one of " + strings.Join(models.ReservationStatuses, ", "),
+		})
+		return
+	}
+	orphans := r.URL.Query().Get("orphans") == "true"
+
+	reservations, err := models.FetchReservations(h.dbpool, status)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting reservations",
+		})
+		log.Logger.Error("GetReservationsHandler", "error", err)
+		return
+	}
+
+	// All the accounts at once, then the counts of each reservation
+	accounts, err := h.awsAccounts(r.Context()).FetchAll()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting accounts",
+		})
+		log.Logger.Error("GetReservationsHandler", "error", err)
+		return
+	}
+	counts := models.CountAwsReservations(accounts)
+
+	response := &v1.ReservationsResponse{
+		HTTPStatusCode: http.StatusOK,
+		Reservations:   []models.ReservationSummary{},
+	}
+	for _, reservation := range reservations {
+		response.Reservations = append(response.Reservations, models.ReservationSummary{
+			Reservation: reservation,
+			AwsAccounts: counts[reservation.Name],
+		})
+	}
+	response.Count = len(response.Reservations)
+
+	if orphans {
+		// The orphans are checked against all the reservations, whatever the status filter
+		if status != "" {
+			reservations, err = models.FetchReservations(h.dbpool, "")
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				render.Render(w, r, &v1.Error{
+					Err:            err,
+					HTTPStatusCode: http.StatusInternalServerError,
+					Message:        "Error getting reservations",
+				})
+				log.Logger.Error("GetReservationsHandler", "error", err)
+				return
+			}
+		}
+		response.OrphanAccounts = models.OrphanReservationAccounts(accounts, reservations)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, response)
+}
+
 // GetReservationHandler gets a reservation
 func (h *BaseHandler) GetReservationHandler(w http.ResponseWriter, r *http.Request) {
 	name := chi.URLParam(r, "name")
@@ -1201,6 +1716,119 @@ func (h *BaseHandler) GetReservationHandler(w http.ResponseWriter, r *http.Reque
 	})
 }
 
+// GetReservationEventsHandler returns the last events of a reservation, most
+// recent first: its changes of status and the changes made by the reconciler.
+func (h *BaseHandler) GetReservationEventsHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > 1000 {
+			w.WriteHeader(http.StatusBadRequest)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusBadRequest,
+				Message:        "Invalid limit, must be between 1 and 1000",
+			})
+			return
+		}
+	}
+
+	events, err := models.FetchReservationEvents(h.dbpool, name, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting reservation events",
+		})
+		log.Logger.Error("GetReservationEventsHandler", "error", err)
+		return
+	}
+
+	if len(events) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusNotFound,
+			Message:        "Reservation not found",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, events)
+}
+
+// GetReservationUsageHandler returns the usage of a reservation: the resources
+// reserved, in use and available, the placements consuming them, and the peak
+// usage between 'from' and 'to', the last 24 hours by default.
+func (h *BaseHandler) GetReservationUsageHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	for param, value := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := r.URL.Query().Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				render.Render(w, r, &v1.Error{
+					Err:            err,
+					HTTPStatusCode: http.StatusBadRequest,
+					Message:        "Invalid " + param + ", must be RFC3339",
+				})
+				return
+			}
+			*value = t
+		}
+	}
+	if !from.Before(to) {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusBadRequest,
+			Message:        "Invalid time range, from must be before to",
+		})
+		return
+	}
+
+	reservation, err := models.GetReservationByName(h.dbpool, name)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusNotFound,
+				Message:        "Reservation not found",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting reservation",
+		})
+		log.Logger.Error("GetReservationUsageHandler", "error", err)
+		return
+	}
+
+	usage, err := models.GetReservationUsage(h.dbpool, h.awsAccounts(r.Context()), h.OcpSandboxProvider, reservation, from, to)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting reservation usage",
+		})
+		log.Logger.Error("GetReservationUsageHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, usage)
+}
+
 // GetReservationResourcesHandler gets the resources of a reservation
 func (h *BaseHandler) GetReservationResourcesHandler(w http.ResponseWriter, r *http.Request) {
 	name := chi.URLParam(r, "name")
@@ -1227,7 +1855,7 @@ func (h *BaseHandler) GetReservationResourcesHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	accounts, err := h.awsAccountProvider.FetchAllByReservation(reservation.Name)
+	accounts, err := h.awsAccounts(r.Context()).FetchAllByReservation(reservation.Name)
 
 	if err != nil {
 		log.Logger.Error("GET accounts", "error", err)
@@ -1240,10 +1868,16 @@ func (h *BaseHandler) GetReservationResourcesHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	if len(accounts) == 0 {
-		w.WriteHeader(http.StatusNotFound)
-	} else {
-		w.WriteHeader(http.StatusOK)
+	ocpSandboxes, err := h.OcpSandboxProvider.FetchAllByReservation(reservation.Name)
+	if err != nil {
+		log.Logger.Error("GET OcpSandboxes", "error", err)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error reading OcpSandboxes",
+		})
+		return
 	}
 
 	// Response with accounts
@@ -1251,9 +1885,18 @@ func (h *BaseHandler) GetReservationResourcesHandler(w http.ResponseWriter, r *h
 	for _, account := range accounts {
 		resources = append(resources, account)
 	}
+	for _, sandbox := range ocpSandboxes {
+		resources = append(resources, sandbox)
+	}
+
+	if len(resources) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
 
 	render.Render(w, r, &v1.ResourcesResponse{
-		Count:          len(accounts),
+		Count:          len(resources),
 		Resources:      resources,
 		Message:        "Accounts found",
 		HTTPStatusCode: http.StatusOK,
@@ -7,7 +7,10 @@ import (
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/getkin/kin-openapi/openapi3"
@@ -17,11 +20,14 @@ import (
 	"github.com/go-chi/httplog/v2"
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/rhpds/sandbox/internal/config"
 	sandboxdb "github.com/rhpds/sandbox/internal/dynamodb"
 	"github.com/rhpds/sandbox/internal/log"
 	"github.com/rhpds/sandbox/internal/models"
+	"github.com/rhpds/sandbox/internal/oidc"
+	"github.com/rhpds/sandbox/internal/tracing"
 )
 
 //go:embed assets/swagger.yaml
@@ -65,6 +71,43 @@ func main() {
 		os.Setenv("WORKERS", "5")
 	}
 
+	// Time to drain the HTTP requests, the running jobs and the OCP provisioning on shutdown
+	shutdownTimeout := 25 * time.Second
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Logger.Error("Error parsing SHUTDOWN_TIMEOUT", "error", err)
+			os.Exit(1)
+		}
+		shutdownTimeout = d
+	}
+
+	// Time given to the jobs and the OCP provisioning interrupted at the end of
+	// SHUTDOWN_TIMEOUT to stop and be requeued
+	shutdownGracePeriod := 10 * time.Second
+	if v := os.Getenv("SHUTDOWN_GRACE_PERIOD"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Logger.Error("Error parsing SHUTDOWN_GRACE_PERIOD", "error", err)
+			os.Exit(1)
+		}
+		shutdownGracePeriod = d
+	}
+
+	// runCtx is cancelled on SIGTERM or SIGINT, to start the graceful shutdown
+	runCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	// ---------------------------------------------------------------------
+	// Tracing
+	// ---------------------------------------------------------------------
+	// Spans are exported with OTLP if OTEL_EXPORTER_OTLP_ENDPOINT is set
+	shutdownTracing, err := tracing.Init(ctx, "sandbox-api", Version)
+	if err != nil {
+		log.Logger.Error("Error initializing tracing", "error", err)
+		os.Exit(1)
+	}
+
 	// ---------------------------------------------------------------------
 	// Load OpenAPI document
 	// ---------------------------------------------------------------------
@@ -102,7 +145,25 @@ func main() {
 	}
 	connStr := os.Getenv("DATABASE_URL")
 
-	dbPool, err := pgxpool.Connect(context.Background(), connStr)
+	poolConfig, err := pgxpool.ParseConfig(connStr)
+	if err != nil {
+		log.Logger.Error("Error parsing DATABASE_URL", "error", err)
+		os.Exit(1)
+	}
+	// The placements hold connections for the locks of the clients, tenants
+	// and reservations, the pool must be larger than the default max(4, NumCPU).
+	// Half of the connections at most are used by the locks, see models.LockClient.
+	poolConfig.MaxConns = 32
+	if v := os.Getenv("DB_MAX_CONNS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 4 {
+			log.Logger.Error("Error parsing DB_MAX_CONNS, at least 4 connections are needed", "value", v)
+			os.Exit(1)
+		}
+		poolConfig.MaxConns = int32(n)
+	}
+
+	dbPool, err := pgxpool.ConnectConfig(context.Background(), poolConfig)
 	if err != nil {
 		log.Logger.Error("Error opening database connection", "error", err)
 		os.Exit(1)
@@ -144,6 +205,25 @@ func main() {
 	authSecret := strings.Trim(os.Getenv("JWT_AUTH_SECRET"), "\r\n\t ")
 	tokenAuth := jwtauth.New("HS256", []byte(authSecret), nil)
 
+	// Lifetime of the tokens issued on login, for example "30m" or "168h"
+	accessTokenLifetime := envDuration("ACCESS_TOKEN_LIFETIME", time.Hour)
+	refreshTokenLifetime := envDuration("REFRESH_TOKEN_LIFETIME", 7*24*time.Hour)
+
+	// ---------------------------------------------------------------------
+	// OIDC, optional
+	// ---------------------------------------------------------------------
+	// The operators can authenticate with the tokens of an OIDC issuer,
+	// the groups of the user are mapped to the admin and app roles.
+	var oidcVerifier *oidc.Verifier
+	if oidcConfig := oidcConfigFromEnv(); oidcConfig != nil {
+		oidcVerifier, err = oidc.NewVerifier(runCtx, *oidcConfig)
+		if err != nil {
+			log.Logger.Error("Error configuring OIDC", "issuer", oidcConfig.IssuerURL, "error", err)
+			os.Exit(1)
+		}
+		log.Logger.Info("OIDC authentication enabled", "issuer", oidcConfig.IssuerURL)
+	}
+
 	// ---------------------------------------------------------------------
 	// Handlers
 	// ---------------------------------------------------------------------
@@ -154,11 +234,27 @@ func main() {
 	// implement the same interface.
 	accountHandler := NewAccountHandler(awsAccountProvider, OcpSandboxProvider)
 
+	// Notifier to stream the lifecycle notifications to the clients
+	notifier := NewNotifier(dbPool)
+	go notifier.Listen(runCtx)
+
+	// Revoked tokens, kept up to date with the notifications
+	revocations := models.NewRevocations()
+	if err := revocations.Load(dbPool); err != nil {
+		log.Logger.Error("Error loading the revoked tokens", "error", err)
+		os.Exit(1)
+	}
+	go WatchRevocations(runCtx, dbPool, revocations, notifier)
+	go PurgeTokens(runCtx, dbPool)
+
 	// Factory for handlers which need connections to both databases
-	baseHandler := NewBaseHandler(awsAccountProvider.Svc, dbPool, doc, oaRouter, awsAccountProvider, OcpSandboxProvider)
+	baseHandler := NewBaseHandler(awsAccountProvider.Svc, dbPool, doc, oaRouter, awsAccountProvider, OcpSandboxProvider, notifier, revocations)
+	baseHandler.reservationLeadTime = envDuration("RESERVATION_LEAD_TIME", defaultReservationLeadTime)
+	// Same variables as sandbox-cleaner: CLEANER_LEASE, CLEANER_MAX_RETRIES, ...
+	baseHandler.cleanupPolicy = models.CleanupPolicyFromEnv()
 
 	// Admin handler adds tokenAuth to the baseHandler
-	adminHandler := NewAdminHandler(baseHandler, tokenAuth)
+	adminHandler := NewAdminHandler(baseHandler, tokenAuth, accessTokenLifetime, refreshTokenLifetime)
 
 	// HTTP router
 	router := chi.NewRouter()
@@ -169,7 +265,21 @@ func main() {
 	// Create AWS STS client
 	worker := NewWorker(*baseHandler)
 
-	go worker.WatchLifecycleDBChannels(context.Background())
+	go worker.WatchLifecycleDBChannels(runCtx)
+	go worker.WatchCancellations(runCtx)
+	go worker.SweepPendingJobs(runCtx)
+
+	// Webhooks
+	webhookDispatcher := NewWebhookDispatcher(dbPool, vaultSecret, notifier)
+	go webhookDispatcher.Run(runCtx)
+
+	// Time-windowed reservations
+	reservationScheduler := NewReservationScheduler(baseHandler,
+		envDuration("RESERVATION_RECONCILE_INTERVAL", defaultReservationReconcileInterval))
+	go reservationScheduler.Run(runCtx)
+
+	// OCP provisioning interrupted by the shutdown of a replica
+	go OcpSandboxProvider.ResumeOcpProvisioning(runCtx)
 
 	logLevel := slog.LevelInfo
 	if os.Getenv("DEBUG") == "true" {
@@ -202,6 +312,8 @@ func main() {
 	// ---------------------------------------------------------------------
 	router.Use(middleware.CleanPath)
 	router.Use(ShortRequestID)
+	router.Use(Tracing)
+	router.Use(Metrics)
 	router.Use(httplog.RequestLogger(logger))
 	router.Use(middleware.Heartbeat("/ping"))
 	// Set Content-Type header to application/json for all responses
@@ -216,32 +328,44 @@ func main() {
 		// ---------------------------------
 		// Middlewares
 		// ---------------------------------
-		r.Use(jwtauth.Verifier(tokenAuth))
-		r.Use(AuthenticatorAccess)
+		r.Use(Verifier(tokenAuth, oidcVerifier))
+		r.Use(baseHandler.Audit)
+		r.Use(baseHandler.AuthenticatorAccess)
 		r.Use(baseHandler.OpenAPIValidation)
 
 		// ---------------------------------
 		// Routes
+		// The scope of the token, if any, restricts the actions
 		// ---------------------------------
 		r.Get("/api/v1/health", baseHandler.HealthHandler)
-		r.Get("/api/v1/accounts/{kind}", accountHandler.GetAccountsHandler)
-		r.Get("/api/v1/accounts/{kind}/{account}", accountHandler.GetAccountHandler)
-		r.Put("/api/v1/accounts/{kind}/{account}/cleanup", accountHandler.CleanupAccountHandler)
-		r.Put("/api/v1/accounts/{kind}/{account}/stop", baseHandler.LifeCycleAccountHandler("stop"))
-		r.Put("/api/v1/accounts/{kind}/{account}/start", baseHandler.LifeCycleAccountHandler("start"))
-		r.Put("/api/v1/accounts/{kind}/{account}/status", baseHandler.LifeCycleAccountHandler("status"))
-		r.Get("/api/v1/accounts/{kind}/{account}/status", baseHandler.GetStatusAccountHandler)
-		r.Delete("/api/v1/accounts/{kind}/{account}", baseHandler.DeleteAccountHandler)
-		r.Post("/api/v1/placements", baseHandler.CreatePlacementHandler)
-		r.Get("/api/v1/placements/{uuid}", baseHandler.GetPlacementHandler)
-		r.Delete("/api/v1/placements/{uuid}", baseHandler.DeletePlacementHandler)
-		r.Put("/api/v1/placements/{uuid}/stop", baseHandler.LifeCyclePlacementHandler("stop"))
-		r.Put("/api/v1/placements/{uuid}/start", baseHandler.LifeCyclePlacementHandler("start"))
-		r.Put("/api/v1/placements/{uuid}/status", baseHandler.LifeCyclePlacementHandler("status"))
-		r.Get("/api/v1/placements/{uuid}/status", baseHandler.GetStatusPlacementHandler)
-		r.Get("/api/v1/requests/{id}/status", baseHandler.GetStatusRequestHandler)
-		r.Get("/api/v1/reservations/{name}", baseHandler.GetReservationHandler)
-		r.Get("/api/v1/reservations/{name}/resources", baseHandler.GetReservationResourcesHandler)
+		r.With(RequireAction("read")).Get("/api/v1/accounts/{kind}", accountHandler.GetAccountsHandler)
+		r.With(RequireAction("read")).Get("/api/v1/accounts/{kind}/{account}", accountHandler.GetAccountHandler)
+		r.With(RequireAction("delete")).Put("/api/v1/accounts/{kind}/{account}/cleanup", accountHandler.CleanupAccountHandler)
+		r.With(RequireAction("lifecycle")).Put("/api/v1/accounts/{kind}/{account}/stop", baseHandler.LifeCycleAccountHandler("stop"))
+		r.With(RequireAction("lifecycle")).Put("/api/v1/accounts/{kind}/{account}/start", baseHandler.LifeCycleAccountHandler("start"))
+		r.With(RequireAction("lifecycle")).Put("/api/v1/accounts/{kind}/{account}/status", baseHandler.LifeCycleAccountHandler("status"))
+		r.With(RequireAction("read")).Get("/api/v1/accounts/{kind}/{account}/status", baseHandler.GetStatusAccountHandler)
+		r.With(RequireAction("read")).Get("/api/v1/accounts/{kind}/{account}/cleanups", baseHandler.GetAccountCleanupsHandler)
+		r.With(RequireAction("delete")).Delete("/api/v1/accounts/{kind}/{account}", baseHandler.DeleteAccountHandler)
+		r.With(RequireAction("create")).Post("/api/v1/placements", baseHandler.CreatePlacementHandler)
+		r.With(RequireAction("read")).Get("/api/v1/placements/{uuid}", baseHandler.GetPlacementHandler)
+		r.With(RequireAction("delete")).Delete("/api/v1/placements/{uuid}", baseHandler.DeletePlacementHandler)
+		r.With(RequireAction("lifecycle")).Put("/api/v1/placements/{uuid}/stop", baseHandler.LifeCyclePlacementHandler("stop"))
+		r.With(RequireAction("lifecycle")).Put("/api/v1/placements/{uuid}/start", baseHandler.LifeCyclePlacementHandler("start"))
+		r.With(RequireAction("lifecycle")).Put("/api/v1/placements/{uuid}/status", baseHandler.LifeCyclePlacementHandler("status"))
+		r.With(RequireAction("read")).Get("/api/v1/placements/{uuid}/status", baseHandler.GetStatusPlacementHandler)
+		r.With(RequireAction("read")).Get("/api/v1/placements/{uuid}/jobs", baseHandler.GetPlacementJobsHandler)
+		r.With(RequireAction("read")).Get("/api/v1/cleanups", baseHandler.GetCleanupsHandler)
+		r.With(RequireAction("read")).Get("/api/v1/requests/{id}", baseHandler.GetRequestHandler)
+		r.With(RequireAction("lifecycle")).Delete("/api/v1/requests/{id}", baseHandler.CancelRequestHandler)
+		r.With(RequireAction("read")).Get("/api/v1/requests/{id}/status", baseHandler.GetStatusRequestHandler)
+		r.With(RequireAction("read")).Get("/api/v1/requests/{id}/events", baseHandler.GetEventsRequestHandler)
+		r.With(RequireAction("read")).Get("/api/v1/reservations", baseHandler.GetReservationsHandler)
+		r.With(RequireAction("read")).Get("/api/v1/reservations/{name}", baseHandler.GetReservationHandler)
+		r.With(RequireAction("read")).Get("/api/v1/reservations/{name}/resources", baseHandler.GetReservationResourcesHandler)
+		r.With(RequireAction("read")).Get("/api/v1/reservations/{name}/events", baseHandler.GetReservationEventsHandler)
+		r.With(RequireAction("read")).Get("/api/v1/reservations/{name}/usage", baseHandler.GetReservationUsageHandler)
+		r.With(RequireAction("read")).Get("/api/v1/tenants/{name}/usage", baseHandler.GetTenantUsageHandler)
 	})
 
 	// ---------------------------------------------------------------------
@@ -251,8 +375,9 @@ func main() {
 		// ---------------------------------
 		// Middlewares
 		// ---------------------------------
-		r.Use(jwtauth.Verifier(tokenAuth))
-		r.Use(AuthenticatorAdmin)
+		r.Use(Verifier(tokenAuth, oidcVerifier))
+		r.Use(baseHandler.Audit)
+		r.Use(baseHandler.AuthenticatorAdmin)
 		r.Use(baseHandler.OpenAPIValidation)
 		// ---------------------------------
 		// Routes
@@ -261,6 +386,31 @@ func main() {
 		r.Post("/api/v1/admin/jwt", adminHandler.IssueLoginJWTHandler)
 		r.Get("/api/v1/admin/jwt", baseHandler.GetJWTHandler)
 		r.Put("/api/v1/admin/jwt/{id}/invalidate", baseHandler.InvalidateTokenHandler)
+		r.Put("/api/v1/admin/jwt/access/{jti}/invalidate", adminHandler.RevokeAccessTokenHandler)
+		r.Get("/api/v1/admin/workers", worker.GetWorkerPoolsHandler)
+		r.Get("/api/v1/admin/audit", baseHandler.GetAuditEventsHandler)
+
+		// Client limits
+		r.Get("/api/v1/admin/client-limits", baseHandler.GetClientLimitsHandler)
+		r.Get("/api/v1/admin/client-limits/{name}", baseHandler.GetClientLimitHandler)
+		r.Put("/api/v1/admin/client-limits/{name}", baseHandler.PutClientLimitHandler)
+		r.Delete("/api/v1/admin/client-limits/{name}", baseHandler.DeleteClientLimitHandler)
+
+		// Tenants
+		r.Get("/api/v1/admin/tenants", baseHandler.GetTenantsHandler)
+		r.Get("/api/v1/admin/tenants/{name}", baseHandler.GetTenantHandler)
+		r.Put("/api/v1/admin/tenants/{name}", baseHandler.PutTenantHandler)
+		r.Delete("/api/v1/admin/tenants/{name}", baseHandler.DeleteTenantHandler)
+
+		// Pool policies of the reservations
+		r.Get("/api/v1/admin/pool-policies", baseHandler.GetPoolPoliciesHandler)
+		r.Get("/api/v1/admin/pool-policies/{priority}", baseHandler.GetPoolPolicyHandler)
+		r.Put("/api/v1/admin/pool-policies/{priority}", baseHandler.PutPoolPolicyHandler)
+		r.Delete("/api/v1/admin/pool-policies/{priority}", baseHandler.DeletePoolPolicyHandler)
+
+		// AWS accounts quarantined after too many failed cleanups
+		r.Get("/api/v1/admin/quarantined-accounts", accountHandler.GetQuarantinedAccountsHandler)
+		r.Put("/api/v1/admin/quarantined-accounts/{account}/release", accountHandler.ReleaseQuarantinedAccountHandler)
 
 		// ---------------------------------
 		// Ocp
@@ -276,8 +426,16 @@ func main() {
 
 		// Reservations
 		r.Post("/api/v1/reservations", baseHandler.CreateReservationHandler)
+		r.Post("/api/v1/reservations/dry-run", baseHandler.DryRunReservationHandler)
 		r.Put("/api/v1/reservations/{name}", baseHandler.UpdateReservationHandler)
 		r.Delete("/api/v1/reservations/{name}", baseHandler.DeleteReservationHandler)
+
+		// Webhooks
+		r.Post("/api/v1/webhooks", baseHandler.CreateWebhookSubscriptionHandler)
+		r.Get("/api/v1/webhooks", baseHandler.GetWebhookSubscriptionsHandler)
+		r.Get("/api/v1/webhooks/{name}", baseHandler.GetWebhookSubscriptionHandler)
+		r.Delete("/api/v1/webhooks/{name}", baseHandler.DeleteWebhookSubscriptionHandler)
+		r.Get("/api/v1/webhooks/{name}/deliveries", baseHandler.GetWebhookDeliveriesHandler)
 	})
 
 	// ---------------------------------------------------------------------
@@ -287,8 +445,8 @@ func main() {
 		// ---------------------------------
 		// Admin auth but no OpenAPI validation
 		// ---------------------------------
-		r.Use(jwtauth.Verifier(tokenAuth))
-		r.Use(AuthenticatorAdmin)
+		r.Use(Verifier(tokenAuth, oidcVerifier))
+		r.Use(baseHandler.AuthenticatorAdmin)
 		// Profiling
 		r.Get("/debug/pprof/", pprof.Index)
 		r.Get("/debug/pprof/profile", pprof.Profile)
@@ -310,6 +468,20 @@ func main() {
 		r.Get("/api/v1/login", adminHandler.LoginHandler)
 	})
 
+	// ---------------------------------------------------------------------
+	// Refresh Routes
+	// ---------------------------------------------------------------------
+	router.Group(func(r chi.Router) {
+		// ---------------------------------
+		// Middlewares
+		// ---------------------------------
+		r.Use(jwtauth.Verifier(tokenAuth))
+		r.Use(baseHandler.Audit)
+		r.Use(AuthenticatorRefresh)
+
+		r.Post("/api/v1/login/refresh", adminHandler.RefreshHandler)
+	})
+
 	// ---------------------------------------------------------------------
 	// Public Routes
 	// ---------------------------------------------------------------------
@@ -324,5 +496,81 @@ func main() {
 
 	log.Logger.Info("Instance", "LocalityID", config.LocalityID)
 	log.Logger.Info("Listening on port " + port)
-	log.Err.Fatal(http.ListenAndServe(":"+port, router))
+
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: router,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Err.Fatal(err)
+		}
+	}()
+
+	// ---------------------------------------------------------------------
+	// Metrics
+	// ---------------------------------------------------------------------
+	// Prometheus metrics are served on a separate port, not exposed with the API
+	metricsPort := os.Getenv("METRICS_PORT")
+	if metricsPort == "" {
+		metricsPort = "2112"
+	}
+
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", promhttp.Handler())
+	metricsServer := &http.Server{
+		Addr:    ":" + metricsPort,
+		Handler: metricsMux,
+	}
+
+	log.Logger.Info("Metrics listening on port " + metricsPort)
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Err.Fatal(err)
+		}
+	}()
+
+	<-runCtx.Done()
+	stop()
+
+	// ---------------------------------------------------------------------
+	// Graceful shutdown
+	// ---------------------------------------------------------------------
+	// The workers, the notifier and the webhook dispatcher stopped with runCtx:
+	// no new job is claimed and the event streams are closed.
+	log.Logger.Info("Shutting down", "timeout", shutdownTimeout, "grace_period", shutdownGracePeriod)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Stop accepting HTTP requests and wait for the ones in progress
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Logger.Error("Error shutting down HTTP server", "error", err)
+	}
+
+	// Wait for the running jobs, the ones still running at the deadline are requeued
+	if err := worker.Shutdown(shutdownCtx, shutdownGracePeriod); err != nil {
+		log.Logger.Error("Jobs still running after shutdown", "error", err)
+	}
+
+	// Wait for the OCP sandboxes being provisioned, interrupt and requeue the unfinished ones
+	if err := models.WaitOcpProvisioning(shutdownCtx); err != nil {
+		log.Logger.Warn("OCP provisioning not finished", "error", err)
+
+		graceCtx, cancelGrace := context.WithTimeout(context.Background(), shutdownGracePeriod)
+		if err := models.ReleaseOcpProvisioning(graceCtx); err != nil {
+			log.Logger.Error("OCP provisioning still running after shutdown", "error", err)
+		}
+		cancelGrace()
+	}
+
+	metricsServer.Close()
+
+	// Flush the spans of the shutdown
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Logger.Error("Error flushing spans", "error", err)
+	}
+
+	log.Logger.Info("Shutdown complete")
 }
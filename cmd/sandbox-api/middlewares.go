@@ -5,17 +5,26 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/go-chi/render"
 	"github.com/jackc/pgx/v4"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 	"github.com/matoous/go-nanoid/v2"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/rhpds/sandbox/internal/api/v1"
 	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/metrics"
+	"github.com/rhpds/sandbox/internal/models"
 )
 
 // AllowContentType enforces a whitelist of request Content-Types otherwise responds
@@ -102,7 +111,7 @@ func (h *BaseHandler) OpenAPIValidation(next http.Handler) http.Handler {
 // Verifier middleware request context values. The Authenticator sends a 401 Unauthorized
 // response for any unverified tokens and passes the good ones through.
 // It looks at the role and make sure it is admin.
-func AuthenticatorAdmin(next http.Handler) http.Handler {
+func (h *BaseHandler) AuthenticatorAdmin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token, claims, err := jwtauth.FromContext(r.Context())
 
@@ -143,6 +152,15 @@ func AuthenticatorAdmin(next http.Handler) http.Handler {
 			return
 		}
 
+		if h.accessTokenRevoked(token, claims) {
+			w.WriteHeader(http.StatusUnauthorized)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusUnauthorized,
+				Message:        "Token revoked",
+			})
+			return
+		}
+
 		// Token is authenticated, pass it through
 		next.ServeHTTP(w, r)
 	})
@@ -218,7 +236,7 @@ func (h *BaseHandler) AuthenticatorLogin(next http.Handler) http.Handler {
 // AuthenticatorAccess is a default authentication middleware to enforce access from the
 // Verifier middleware request context values. The Authenticator sends a 401 Unauthorized
 // response for any unverified tokens and passes the good ones through. It's just fine
-func AuthenticatorAccess(next http.Handler) http.Handler {
+func (h *BaseHandler) AuthenticatorAccess(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		token, claims, err := jwtauth.FromContext(ctx)
@@ -250,11 +268,113 @@ func AuthenticatorAccess(next http.Handler) http.Handler {
 			return
 		}
 
+		if h.accessTokenRevoked(token, claims) {
+			w.WriteHeader(http.StatusUnauthorized)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusUnauthorized,
+				Message:        "Token revoked",
+			})
+			return
+		}
+
+		scope, err := models.ParseTokenScope(claims["scope"])
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusUnauthorized,
+				Message:        err.Error(),
+			})
+			return
+		}
+
+		// Token is authenticated, pass it through with its scope
+		ctx = context.WithValue(ctx, TokenScopeKey, scope)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// AuthenticatorRefresh is a authentication middleware to enforce access from the
+// Verifier middleware request context values. The Authenticator sends a 401 Unauthorized
+// response for any unverified tokens and passes the good ones through.
+// It looks at the kind and make sure it is a refresh token. The refresh token
+// is checked against the database when it's rotated.
+func AuthenticatorRefresh(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, claims, err := jwtauth.FromContext(r.Context())
+
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusUnauthorized,
+				Message:        err.Error(),
+			})
+			return
+		}
+
+		if token == nil || jwt.Validate(token) != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusUnauthorized,
+				Message:        http.StatusText(http.StatusUnauthorized),
+			})
+			return
+		}
+
+		if claims["kind"] != "refresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusUnauthorized,
+				Message:        "Wrong token kind, refresh token required",
+			})
+			return
+		}
+
 		// Token is authenticated, pass it through
 		next.ServeHTTP(w, r)
 	})
 }
 
+// accessTokenRevoked returns true if the access token, or the login token it
+// was issued from, is in the revocation list.
+func (h *BaseHandler) accessTokenRevoked(token jwt.Token, claims map[string]interface{}) bool {
+	loginJti, _ := claims["login_jti"].(string)
+	return h.revocations.Revoked(token.JwtID(), loginJti)
+}
+
+// Key to use when setting the scope of the token
+type ctxKeyTokenScope int
+
+// TokenScopeKey is the key that holds the scope of the access token in a request context
+const TokenScopeKey ctxKeyTokenScope = 0
+
+// GetTokenScope returns the scope of the access token from the given context.
+// Returns nil if the token has no scope.
+func GetTokenScope(ctx context.Context) *models.TokenScope {
+	if scope, ok := ctx.Value(TokenScopeKey).(*models.TokenScope); ok {
+		return scope
+	}
+	return nil
+}
+
+// RequireAction is a middleware that sends a 403 Forbidden response if the
+// scope of the access token doesn't allow the action.
+func RequireAction(action string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !GetTokenScope(r.Context()).AllowsAction(action) {
+				w.WriteHeader(http.StatusForbidden)
+				render.Render(w, r, &v1.Error{
+					HTTPStatusCode: http.StatusForbidden,
+					Message:        fmt.Sprintf("Token scope doesn't allow action '%s'", action),
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // RequestID
 
 var RequestIDHeader = "X-Request-Id"
@@ -303,3 +423,46 @@ func ShortRequestID(next http.Handler) http.Handler {
 	}
 	return http.HandlerFunc(fn)
 }
+
+// Metrics is a middleware that records the latency and the status code of the
+// requests, by route pattern. The pattern is used instead of the path to keep
+// the cardinality low, for example /api/v1/placements/{uuid}.
+func Metrics(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		metrics.HTTPRequests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	}
+	return http.HandlerFunc(fn)
+}
+
+// Tracing is a middleware that creates a server span for each request.
+// The trace context of the caller, if any, is read from the traceparent header.
+// Once the request is routed, the span is renamed after the route pattern.
+func Tracing(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		span := trace.SpanFromContext(r.Context())
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+			span.SetAttributes(attribute.String("http.route", rctx.RoutePattern()))
+		}
+		span.SetAttributes(attribute.String("request.id", GetReqID(r.Context())))
+	}
+	return otelhttp.NewHandler(http.HandlerFunc(fn), "http.request")
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/rhpds/sandbox/internal/log"
+)
+
+// Notification is a Postgres notification received on one of the channels
+type Notification struct {
+	Channel string
+	Payload string
+}
+
+// Notifier listens to the lifecycle Postgres channels on a dedicated connection
+// and fans out the notifications to the subscribers, for example the clients
+// of the request events endpoint.
+type Notifier struct {
+	dbpool *pgxpool.Pool
+
+	mu          sync.Mutex
+	subscribers map[chan Notification]subscription
+	// closed is true once the notifier stopped listening
+	closed bool
+}
+
+// subscription is the Postgres channel a subscriber receives the notifications of.
+// The notifications of a channel subscription are never dropped.
+type subscription struct {
+	channel string
+}
+
+// Channels the notifier listens to
+var notifierChannels = []string{
+	"lifecycle_placement_jobs_status_channel",
+	"lifecycle_resource_jobs_status_channel",
+	"lifecycle_events_channel",
+	"webhook_deliveries_channel",
+	"tokens_revocations_channel",
+}
+
+// NewNotifier creates a new notifier. Call Listen to start receiving notifications.
+func NewNotifier(dbpool *pgxpool.Pool) *Notifier {
+	return &Notifier{
+		dbpool:      dbpool,
+		subscribers: map[chan Notification]subscription{},
+	}
+}
+
+// Subscribe returns a channel receiving all the notifications.
+// The caller must call Unsubscribe when done.
+func (n *Notifier) Subscribe() chan Notification {
+	return n.subscribe(subscription{})
+}
+
+// SubscribeChannel returns a channel receiving the notifications of pgChan only.
+// Unlike Subscribe, the notifications are never dropped: the notifier waits for
+// the subscriber, which must keep receiving until the context of Listen is done
+// or it unsubscribed. The caller must call Unsubscribe when done.
+func (n *Notifier) SubscribeChannel(pgChan string) chan Notification {
+	return n.subscribe(subscription{channel: pgChan})
+}
+
+func (n *Notifier) subscribe(sub subscription) chan Notification {
+	c := make(chan Notification, 100)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		// Nothing will ever be received
+		close(c)
+		return c
+	}
+	n.subscribers[c] = sub
+
+	return c
+}
+
+// Unsubscribe removes the subscriber and closes its channel
+func (n *Notifier) Unsubscribe(c chan Notification) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.subscribers[c]; ok {
+		delete(n.subscribers, c)
+		close(c)
+	}
+}
+
+// closeAll removes all the subscribers and closes their channels
+func (n *Notifier) closeAll() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.closed = true
+	for c := range n.subscribers {
+		delete(n.subscribers, c)
+		close(c)
+	}
+}
+
+// publish sends the notification to all the subscribers.
+// If a subscriber is too slow, the notification is dropped for it, except for the
+// subscribers of its channel: publish waits for them until ctx is done.
+func (n *Notifier) publish(ctx context.Context, notification Notification) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for c, sub := range n.subscribers {
+		if sub.channel != "" {
+			if sub.channel != notification.Channel {
+				continue
+			}
+			select {
+			case c <- notification:
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		select {
+		case c <- notification:
+		default:
+			log.Logger.Warn("Subscriber too slow, dropping notification", "channel", notification.Channel, "payload", notification.Payload)
+		}
+	}
+}
+
+// Listen listens to the lifecycle channels and publishes the notifications.
+// It reconnects if the connection is lost, until the context is cancelled.
+// When the context is cancelled, the channels of all the subscribers are closed,
+// which ends the event streams.
+func (n *Notifier) Listen(ctx context.Context) {
+	for {
+		if err := n.listen(ctx); err != nil && ctx.Err() == nil {
+			log.Logger.Error("Notifier stopped listening", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			n.closeAll()
+			return
+		case <-time.After(5 * time.Second):
+			log.Logger.Warn("Restarting notifier")
+		}
+	}
+}
+
+func (n *Notifier) listen(ctx context.Context) error {
+	conn, err := n.dbpool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Don't give back a listening connection to the pool
+		conn.Exec(context.Background(), "UNLISTEN *")
+		conn.Release()
+	}()
+
+	for _, pgChan := range notifierChannels {
+		if _, err := conn.Exec(ctx, fmt.Sprintf("LISTEN %s", pgChan)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		n.publish(ctx, Notification{
+			Channel: notification.Channel,
+			Payload: notification.Payload,
+		})
+	}
+}
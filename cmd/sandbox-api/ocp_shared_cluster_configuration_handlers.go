@@ -7,6 +7,7 @@ import (
 	"github.com/jackc/pgx/v4"
 	"github.com/rhpds/sandbox/internal/api/v1"
 	"github.com/rhpds/sandbox/internal/models"
+	"github.com/rhpds/sandbox/internal/tracing"
 
 	"github.com/go-chi/render"
 )
@@ -49,7 +50,9 @@ func (h *BaseHandler) DisableOcpSharedClusterConfigurationHandler(w http.Respons
 	name := chi.URLParam(r, "name")
 
 	// Get the OCP shared cluster configuration from the database
-	ocpSharedClusterConfiguration, err := h.OcpSandboxProvider.GetOcpSharedClusterConfigurationByName(name)
+	ocpSharedClusterConfiguration, err := tracing.Call(r.Context(), "OcpSandboxProvider.GetOcpSharedClusterConfigurationByName", func() (models.OcpSharedClusterConfiguration, error) {
+		return h.OcpSandboxProvider.GetOcpSharedClusterConfigurationByName(name)
+	})
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			w.WriteHeader(http.StatusNotFound)
@@ -91,7 +94,9 @@ func (h *BaseHandler) HealthOcpSharedClusterConfigurationHandler(w http.Response
 	name := chi.URLParam(r, "name")
 
 	// Get the OCP shared cluster configuration from the database
-	cluster, err := h.OcpSandboxProvider.GetOcpSharedClusterConfigurationByName(name)
+	cluster, err := tracing.Call(r.Context(), "OcpSandboxProvider.GetOcpSharedClusterConfigurationByName", func() (models.OcpSharedClusterConfiguration, error) {
+		return h.OcpSandboxProvider.GetOcpSharedClusterConfigurationByName(name)
+	})
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			w.WriteHeader(http.StatusNotFound)
@@ -128,7 +133,9 @@ func (h *BaseHandler) EnableOcpSharedClusterConfigurationHandler(w http.Response
 	name := chi.URLParam(r, "name")
 
 	// Get the OCP shared cluster configuration from the database
-	ocpSharedClusterConfiguration, err := h.OcpSandboxProvider.GetOcpSharedClusterConfigurationByName(name)
+	ocpSharedClusterConfiguration, err := tracing.Call(r.Context(), "OcpSandboxProvider.GetOcpSharedClusterConfigurationByName", func() (models.OcpSharedClusterConfiguration, error) {
+		return h.OcpSandboxProvider.GetOcpSharedClusterConfigurationByName(name)
+	})
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			w.WriteHeader(http.StatusNotFound)
@@ -167,7 +174,9 @@ func (h *BaseHandler) EnableOcpSharedClusterConfigurationHandler(w http.Response
 
 // GetOcpSharedClusterConfigurationsHandlers returns a list of OCP shared cluster configurations
 func (h *BaseHandler) GetOcpSharedClusterConfigurationsHandler(w http.ResponseWriter, r *http.Request) {
-	ocpSharedClusterConfigurations, err := h.OcpSandboxProvider.GetOcpSharedClusterConfigurations()
+	ocpSharedClusterConfigurations, err := tracing.Call(r.Context(), "OcpSandboxProvider.GetOcpSharedClusterConfigurations", func() (models.OcpSharedClusterConfigurations, error) {
+		return h.OcpSandboxProvider.GetOcpSharedClusterConfigurations()
+	})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		render.Render(w, r, &v1.Error{
@@ -188,7 +197,9 @@ func (h *BaseHandler) GetOcpSharedClusterConfigurationHandler(w http.ResponseWri
 	name := chi.URLParam(r, "name")
 
 	// Get the OCP shared cluster configuration from the database
-	ocpSharedClusterConfiguration, err := h.OcpSandboxProvider.GetOcpSharedClusterConfigurationByName(name)
+	ocpSharedClusterConfiguration, err := tracing.Call(r.Context(), "OcpSandboxProvider.GetOcpSharedClusterConfigurationByName", func() (models.OcpSharedClusterConfiguration, error) {
+		return h.OcpSandboxProvider.GetOcpSharedClusterConfigurationByName(name)
+	})
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			w.WriteHeader(http.StatusNotFound)
@@ -218,7 +229,9 @@ func (h *BaseHandler) DeleteOcpSharedClusterConfigurationHandler(w http.Response
 	name := chi.URLParam(r, "name")
 
 	// Get the OCP shared cluster configuration from the database
-	ocpSharedClusterConfiguration, err := h.OcpSandboxProvider.GetOcpSharedClusterConfigurationByName(name)
+	ocpSharedClusterConfiguration, err := tracing.Call(r.Context(), "OcpSandboxProvider.GetOcpSharedClusterConfigurationByName", func() (models.OcpSharedClusterConfiguration, error) {
+		return h.OcpSandboxProvider.GetOcpSharedClusterConfigurationByName(name)
+	})
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			w.WriteHeader(http.StatusNotFound)
@@ -280,7 +293,9 @@ func (h *BaseHandler) UpdateOcpSharedClusterConfigurationHandler(w http.Response
 	name := chi.URLParam(r, "name")
 
 	// Get the OCP shared cluster configuration from the database
-	ocpSharedClusterConfiguration, err := h.OcpSandboxProvider.GetOcpSharedClusterConfigurationByName(name)
+	ocpSharedClusterConfiguration, err := tracing.Call(r.Context(), "OcpSandboxProvider.GetOcpSharedClusterConfigurationByName", func() (models.OcpSharedClusterConfiguration, error) {
+		return h.OcpSandboxProvider.GetOcpSharedClusterConfigurationByName(name)
+	})
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			w.WriteHeader(http.StatusNotFound)
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+
+	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/oidc"
+)
+
+// oidcConfigFromEnv returns the configuration of the OIDC authentication,
+// or nil if OIDC_ISSUER_URL is not set.
+func oidcConfigFromEnv() *oidc.Config {
+	issuer := os.Getenv("OIDC_ISSUER_URL")
+	if issuer == "" {
+		return nil
+	}
+
+	return &oidc.Config{
+		IssuerURL:     issuer,
+		Audience:      os.Getenv("OIDC_AUDIENCE"),
+		UsernameClaim: os.Getenv("OIDC_USERNAME_CLAIM"),
+		GroupsClaim:   os.Getenv("OIDC_GROUPS_CLAIM"),
+		AdminGroups:   splitList(os.Getenv("OIDC_ADMIN_GROUPS")),
+		AppGroups:     splitList(os.Getenv("OIDC_APP_GROUPS")),
+	}
+}
+
+// splitList splits a comma-separated list, ignoring the empty items
+func splitList(s string) []string {
+	result := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// Verifier is the jwtauth.Verifier middleware accepting also the tokens of
+// the OIDC issuer, if configured.
+// A token that isn't signed by sandbox-api is verified against the JWKS of
+// the issuer and replaced in the context by an access token with the role
+// mapped from the groups of the user, so the authenticators handle both.
+func Verifier(ja *jwtauth.JWTAuth, oidcVerifier *oidc.Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			tokenString := jwtauth.TokenFromHeader(r)
+
+			token, err := jwtauth.VerifyRequest(ja, r, jwtauth.TokenFromHeader, jwtauth.TokenFromCookie)
+			if err != nil && oidcVerifier != nil && tokenString != "" {
+				identity, oidcErr := oidcVerifier.Verify(ctx, tokenString)
+				if oidcErr == nil {
+					token, err = oidcAccessToken(identity)
+				} else {
+					log.Logger.Debug("Not an OIDC token", "error", oidcErr)
+				}
+			}
+
+			ctx = jwtauth.NewContext(ctx, token, err)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// The names of the OIDC users are prefixed, so they can't be mistaken for
+// the names of the API tokens in the client limits, the created_by of the
+// placements or the scopes.
+const oidcNamePrefix = "oidc:"
+
+// oidcAccessToken returns the access token of the user authenticated with OIDC.
+// It's not signed, it only lives in the context of the request.
+// The name is the subject, the username is only informative.
+func oidcAccessToken(identity oidc.Identity) (jwt.Token, error) {
+	claims := map[string]any{
+		jwt.SubjectKey:    identity.Subject,
+		jwt.IssuedAtKey:   identity.IssuedAt,
+		jwt.ExpirationKey: identity.Expiration,
+		"name":            oidcNamePrefix + identity.Subject,
+		"username":        identity.Name,
+		"kind":            "access",
+		"role":            identity.Role,
+		"auth":            "oidc",
+	}
+	// The jti of the OIDC token can be revoked like the jti of an access token
+	if identity.ID != "" {
+		claims[jwt.JwtIDKey] = identity.ID
+	}
+
+	token := jwt.New()
+	for k, v := range claims {
+		if err := token.Set(k, v); err != nil {
+			return nil, err
+		}
+	}
+	return token, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/rhpds/sandbox/internal/api/v1"
+	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/models"
+)
+
+// DryRunReservationHandler returns the number of AWS accounts the reservation
+// can take from the pool right now, and why, without creating it.
+func (h *BaseHandler) DryRunReservationHandler(w http.ResponseWriter, r *http.Request) {
+	reservationRequest := models.ReservationRequest{}
+	if err := render.Bind(r, &reservationRequest); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Render(w, r, &v1.Error{
+			Err:            err,
+			HTTPStatusCode: http.StatusBadRequest,
+			Message:        "Error decoding request body",
+			ErrorMultiline: []string{err.Error()},
+		})
+		log.Logger.Error("DryRunReservationHandler", "error", err)
+		return
+	}
+
+	capacity, err := reservationRequest.PoolCapacity(h.dbpool, h.awsAccounts(r.Context()))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error computing the capacity of the pool",
+		})
+		log.Logger.Error("DryRunReservationHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, &capacity)
+}
+
+// GetPoolPoliciesHandler returns the pool policies of all the priorities
+func (h *BaseHandler) GetPoolPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	policies, err := models.FetchPoolPolicies(h.dbpool)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting pool policies",
+		})
+		log.Logger.Error("GetPoolPoliciesHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, policies)
+}
+
+// GetPoolPolicyHandler returns the pool policy of a priority
+func (h *BaseHandler) GetPoolPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	policy, err := models.GetPoolPolicy(h.dbpool, chi.URLParam(r, "priority"))
+	if err == pgx.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusNotFound,
+			Message:        "Pool policy not found",
+		})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting pool policy",
+		})
+		log.Logger.Error("GetPoolPolicyHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, policy)
+}
+
+// PutPoolPolicyHandler creates or replaces the pool policy of a priority.
+// The priority '*' sets the policy of the reservations without their own policy.
+func (h *BaseHandler) PutPoolPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	policy := &models.PoolPolicy{}
+	if err := render.Bind(r, policy); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusBadRequest,
+			Message:        "Invalid pool policy",
+			ErrorMultiline: []string{err.Error()},
+		})
+		return
+	}
+	policy.Priority = chi.URLParam(r, "priority")
+
+	if err := policy.Save(h.dbpool); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error saving pool policy",
+		})
+		log.Logger.Error("PutPoolPolicyHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, policy)
+}
+
+// DeletePoolPolicyHandler deletes the pool policy of a priority
+func (h *BaseHandler) DeletePoolPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	err := models.DeletePoolPolicy(h.dbpool, chi.URLParam(r, "priority"))
+	if err == pgx.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusNotFound,
+			Message:        "Pool policy not found",
+		})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error deleting pool policy",
+		})
+		log.Logger.Error("DeletePoolPolicyHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, &v1.SimpleMessage{
+		Message: "Pool policy deleted",
+	})
+}
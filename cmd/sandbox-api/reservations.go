@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/models"
+)
+
+// By default the reservations are activated one hour before their start,
+// to leave time to the accounts to be cleaned up.
+const defaultReservationLeadTime = time.Hour
+
+// The pending reservations try to reserve the accounts back from cleanup this often
+const reservationPendingRetry = time.Minute
+
+// The releases that failed are retried this often
+const reservationReleaseRetry = 5 * time.Minute
+
+// The reservations left 'initializing', 'updating' or 'releasing' this long
+// were claimed by a replica that died, they are claimed again
+const reservationStaleClaim = 30 * time.Minute
+
+// By default the active reservations are compared with the resources they hold every 5 minutes
+const defaultReservationReconcileInterval = 5 * time.Minute
+
+// ReservationScheduler activates the scheduled reservations shortly before
+// their start, completes the pending reservations, reconciles the active
+// reservations, releases the reservations past their end and samples the
+// usage of the reservations.
+// Every replica runs a scheduler, the reservations are claimed with SKIP LOCKED.
+type ReservationScheduler struct {
+	h *BaseHandler
+	// The active reservations are reconciled this often
+	reconcileInterval time.Duration
+}
+
+// NewReservationScheduler creates a new scheduler, activating the reservations
+// h.reservationLeadTime before their start.
+func NewReservationScheduler(h *BaseHandler, reconcileInterval time.Duration) *ReservationScheduler {
+	return &ReservationScheduler{h: h, reconcileInterval: reconcileInterval}
+}
+
+// Run activates and releases the reservations every minute until the context is cancelled.
+func (s *ReservationScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		s.schedule(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// schedule activates, completes, reconciles and releases all the reservations due,
+// and samples the usage of the reservations
+func (s *ReservationScheduler) schedule(ctx context.Context) {
+	if _, err := models.RecordUsageSamples(s.h.dbpool, s.h.awsAccounts(ctx)); err != nil {
+		log.Logger.Error("Error recording reservations usage", "error", err)
+	}
+
+	for ctx.Err() == nil {
+		reservation, err := models.ClaimReservationToActivate(s.h.dbpool, s.h.reservationLeadTime)
+		if err != nil {
+			if err != pgx.ErrNoRows {
+				log.Logger.Error("Error claiming reservation to activate", "error", err)
+			}
+			break
+		}
+
+		log.Logger.Info("Activating reservation", "reservation", reservation.Name, "starts_at", reservation.StartsAt)
+		reservation.Initialize(s.h.dbpool, s.h.awsAccounts(ctx))
+	}
+
+	for ctx.Err() == nil {
+		reservation, err := models.ClaimPendingReservation(s.h.dbpool, reservationPendingRetry, reservationStaleClaim)
+		if err != nil {
+			if err != pgx.ErrNoRows {
+				log.Logger.Error("Error claiming pending reservation", "error", err)
+			}
+			break
+		}
+
+		log.Logger.Info("Completing pending reservation", "reservation", reservation.Name,
+			"reserved", reservation.Reserved, "target", reservation.Target)
+		reservation.Initialize(s.h.dbpool, s.h.awsAccounts(ctx))
+	}
+
+	for ctx.Err() == nil {
+		reservation, err := models.ClaimReservationToReconcile(s.h.dbpool, s.reconcileInterval)
+		if err != nil {
+			if err != pgx.ErrNoRows {
+				log.Logger.Error("Error claiming reservation to reconcile", "error", err)
+			}
+			break
+		}
+
+		log.Logger.Debug("Reconciling reservation", "reservation", reservation.Name)
+		reservation.Reconcile(s.h.dbpool, s.h.awsAccounts(ctx))
+	}
+
+	for ctx.Err() == nil {
+		reservation, err := models.ClaimReservationToRelease(s.h.dbpool, reservationReleaseRetry, reservationStaleClaim)
+		if err != nil {
+			if err != pgx.ErrNoRows {
+				log.Logger.Error("Error claiming reservation to release", "error", err)
+			}
+			break
+		}
+
+		log.Logger.Info("Releasing reservation", "reservation", reservation.Name, "ends_at", reservation.EndsAt)
+		reservation.Release(s.h.dbpool, s.h.awsAccounts(ctx))
+	}
+}
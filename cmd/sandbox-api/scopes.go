@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/rhpds/sandbox/internal/api/v1"
+	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/models"
+	"github.com/rhpds/sandbox/internal/tracing"
+)
+
+// The scope of the access token, see models.TokenScope, restricts:
+//   - the actions, checked by the RequireAction middleware on each route
+//   - the placements and resources, checked by the handlers with the helpers below
+
+// forbidden sends a 403 Forbidden response
+func forbidden(w http.ResponseWriter, r *http.Request, message string) {
+	w.WriteHeader(http.StatusForbidden)
+	render.Render(w, r, &v1.Error{
+		HTTPStatusCode: http.StatusForbidden,
+		Message:        message,
+	})
+}
+
+// serviceUuidInScope checks the service_uuid against the scope of the token,
+// before the placement is read. It sends a 403 and returns false if it's out of scope.
+func serviceUuidInScope(w http.ResponseWriter, r *http.Request, serviceUuid string) bool {
+	if GetTokenScope(r.Context()).AllowsServiceUuid(serviceUuid) {
+		return true
+	}
+	forbidden(w, r, "Placement out of the scope of the token")
+	return false
+}
+
+// placementInScope checks the placement against the scope of the token.
+// It sends a 403 and returns false if it's out of scope.
+func placementInScope(w http.ResponseWriter, r *http.Request, placement *models.Placement) bool {
+	if GetTokenScope(r.Context()).Allows(placement.ServiceUuid, placement.Annotations) {
+		return true
+	}
+	forbidden(w, r, "Placement out of the scope of the token")
+	return false
+}
+
+// resourceInScope checks the kind, the service_uuid and the annotations of a
+// resource against the scope of the token.
+// It sends a 403 and returns false if it's out of scope.
+func resourceInScope(w http.ResponseWriter, r *http.Request, kind string, serviceUuid string, annotations map[string]string) bool {
+	scope := GetTokenScope(r.Context())
+	if !scope.AllowsKind(kind) {
+		forbidden(w, r, fmt.Sprintf("Kind '%s' out of the scope of the token", kind))
+		return false
+	}
+	if !scope.Allows(serviceUuid, annotations) {
+		forbidden(w, r, "Resource out of the scope of the token")
+		return false
+	}
+	return true
+}
+
+// kindInScope checks the kind of resources against the scope of the token.
+// It sends a 403 and returns false if it's out of scope.
+func kindInScope(w http.ResponseWriter, r *http.Request, kind string) bool {
+	if GetTokenScope(r.Context()).AllowsKind(kind) {
+		return true
+	}
+	forbidden(w, r, fmt.Sprintf("Kind '%s' out of the scope of the token", kind))
+	return false
+}
+
+// placementRequestInScope checks a new placement against the scope of the token.
+// The annotations of the scope missing in the request are added to the placement,
+// so the resources created with a scoped token can be found with it later.
+// It sends a 403 and returns false if the request is out of scope.
+func placementRequestInScope(w http.ResponseWriter, r *http.Request, placementRequest *v1.PlacementRequest) bool {
+	scope := GetTokenScope(r.Context())
+	if scope == nil {
+		return true
+	}
+
+	if !serviceUuidInScope(w, r, placementRequest.ServiceUuid) {
+		return false
+	}
+
+	for k, v := range scope.Annotations {
+		if _, ok := placementRequest.Annotations[k]; !ok {
+			placementRequest.Annotations[k] = v
+		}
+	}
+	if !scope.AllowsAnnotations(placementRequest.Annotations) {
+		forbidden(w, r, "Annotations out of the scope of the token")
+		return false
+	}
+
+	count := 0
+	for _, request := range placementRequest.Resources {
+		if !kindInScope(w, r, request.Kind) {
+			return false
+		}
+		if !scope.AllowsAnnotations(placementRequest.Annotations.Merge(request.Annotations)) {
+			forbidden(w, r, "Annotations out of the scope of the token")
+			return false
+		}
+		// An OcpSandbox is one namespace, count is optional
+		count += max(request.Count, 1)
+	}
+
+	if !scope.AllowsCount(count) {
+		forbidden(w, r, fmt.Sprintf("Too many resources requested, the scope of the token allows %d", scope.MaxResources))
+		return false
+	}
+
+	return true
+}
+
+// requestInScope checks the placement or the resources targeted by a lifecycle
+// request against the scope of the token.
+// It sends a 403 and returns false if the request is out of scope.
+// A request that doesn't exist is in scope: the handler responds 404.
+func (h *BaseHandler) requestInScope(w http.ResponseWriter, r *http.Request, requestID string) bool {
+	if !GetTokenScope(r.Context()).Restricted() {
+		return true
+	}
+
+	request, err := models.GetLifecycleRequest(h.dbpool, requestID)
+	if err == pgx.ErrNoRows {
+		return true
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting request",
+		})
+		log.Logger.Error("requestInScope", "error", err)
+		return false
+	}
+
+	if request.PlacementJob != nil {
+		placement, err := models.GetPlacement(h.dbpool, request.PlacementJob.PlacementID)
+		if err != nil {
+			// The placement is gone, nothing tells if it was in scope
+			log.Logger.Info("requestInScope", "error", err, "request_id", requestID)
+			forbidden(w, r, "Request out of the scope of the token")
+			return false
+		}
+		return placementInScope(w, r, placement)
+	}
+
+	for _, job := range request.ResourceJobs {
+		if !kindInScope(w, r, job.ResourceType) {
+			return false
+		}
+
+		var serviceUuid string
+		var annotations map[string]string
+		switch job.ResourceType {
+		case "OcpSandbox", "ocp":
+			sandbox, err := tracing.Call(r.Context(), "OcpSandboxProvider.FetchByName", func() (models.OcpSandbox, error) {
+				return h.OcpSandboxProvider.FetchByName(job.ResourceName)
+			})
+			if err != nil {
+				log.Logger.Info("requestInScope", "error", err, "request_id", requestID)
+				forbidden(w, r, "Request out of the scope of the token")
+				return false
+			}
+			serviceUuid, annotations = sandbox.ServiceUuid, sandbox.Annotations
+		default:
+			account, err := h.awsAccounts(r.Context()).FetchByName(job.ResourceName)
+			if err != nil {
+				log.Logger.Info("requestInScope", "error", err, "request_id", requestID)
+				forbidden(w, r, "Request out of the scope of the token")
+				return false
+			}
+			serviceUuid, annotations = account.ServiceUuid, account.Annotations
+		}
+		if !resourceInScope(w, r, job.ResourceType, serviceUuid, annotations) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/go-chi/render"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/rhpds/sandbox/internal/api/v1"
+	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/metrics"
+	"github.com/rhpds/sandbox/internal/models"
+)
+
+// tenantName returns the 'tenant' claim of the token of the request, if any
+func tenantName(r *http.Request) string {
+	_, claims, err := jwtauth.FromContext(r.Context())
+	if err != nil {
+		return ""
+	}
+	tenant, _ := claims["tenant"].(string)
+	return tenant
+}
+
+// placementWithinTenantQuotas checks the placement request against the quotas
+// of the tenant. It sends an error response and returns false if the request
+// would exceed them.
+// If the tenant has quotas, it holds the lock of the tenant until the returned
+// function is called, once the placement is saved.
+func (h *BaseHandler) placementWithinTenantQuotas(w http.ResponseWriter, r *http.Request, placementRequest *v1.PlacementRequest, name string) (func(), bool) {
+	noop := func() {}
+	if name == "" {
+		return noop, true
+	}
+
+	tenant, err := models.GetTenant(h.dbpool, name)
+	if err == pgx.ErrNoRows {
+		return noop, true
+	}
+	if err != nil {
+		// The quotas can't be enforced if they are unknown
+		log.Logger.Error("Error getting tenant", "error", err, "tenant", name)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusServiceUnavailable,
+			Message:        "Tenant quotas unavailable, try again later",
+		})
+		return noop, false
+	}
+
+	requested := models.TenantUsage{Placements: 1}
+	for _, request := range placementRequest.Resources {
+		switch kind, n := models.RequestedResources(request.Kind, request.Count); kind {
+		case "AwsSandbox":
+			requested.AwsAccounts += n
+		case "OcpSandbox":
+			requested.OcpSandboxes += n
+
+			cpu, memory := models.QuotaCpuMemory(*request.Quota)
+			// The quota of the sandbox must be known to be counted
+			if (tenant.MaxCpu != nil && cpu == nil) || (tenant.MaxMemory != nil && memory == nil) {
+				w.WriteHeader(http.StatusBadRequest)
+				render.Render(w, r, &v1.Error{
+					HTTPStatusCode: http.StatusBadRequest,
+					Message:        "The quota of the tenant requires the requests.cpu and requests.memory quota of the OcpSandbox",
+				})
+				return noop, false
+			}
+			if cpu != nil {
+				requested.Cpu.Add(*cpu)
+			}
+			if memory != nil {
+				requested.Memory.Add(*memory)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	release, err := models.LockTenant(ctx, h.dbpool, name)
+	if err != nil {
+		log.Logger.Error("Error locking tenant", "error", err, "tenant", name)
+		tooManyRequests(w, r, time.Second, "Another placement of the tenant is being created")
+		return noop, false
+	}
+
+	usage, err := models.GetTenantUsage(r.Context(), h.dbpool, h.awsAccounts(r.Context()), name)
+	if err != nil {
+		release()
+		log.Logger.Error("Error getting tenant usage", "error", err, "tenant", name)
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error checking tenant quotas",
+		})
+		return noop, false
+	}
+
+	if err := tenant.Allows(usage, requested); err != nil {
+		release()
+		metrics.TenantQuotaRejections.WithLabelValues(name).Inc()
+		tooManyRequests(w, r, clientCapsRetryAfter, err.Error())
+		return noop, false
+	}
+
+	return release, true
+}
+
+// GetTenantsHandler returns the quotas of all the tenants
+func (h *BaseHandler) GetTenantsHandler(w http.ResponseWriter, r *http.Request) {
+	tenants, err := models.FetchTenants(h.dbpool)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting tenants",
+		})
+		log.Logger.Error("GetTenantsHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, tenants)
+}
+
+// GetTenantHandler returns the quotas of a tenant
+func (h *BaseHandler) GetTenantHandler(w http.ResponseWriter, r *http.Request) {
+	tenant, err := models.GetTenant(h.dbpool, chi.URLParam(r, "name"))
+	if err == pgx.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusNotFound,
+			Message:        "Tenant not found",
+		})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting tenant",
+		})
+		log.Logger.Error("GetTenantHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, tenant)
+}
+
+// PutTenantHandler creates or replaces the quotas of a tenant
+func (h *BaseHandler) PutTenantHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := &models.Tenant{}
+	if err := render.Bind(r, tenant); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusBadRequest,
+			Message:        "Invalid tenant",
+			ErrorMultiline: []string{err.Error()},
+		})
+		return
+	}
+	tenant.Name = chi.URLParam(r, "name")
+
+	if err := tenant.Save(h.dbpool); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error saving tenant",
+		})
+		log.Logger.Error("PutTenantHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, tenant)
+}
+
+// DeleteTenantHandler deletes the quotas of a tenant
+func (h *BaseHandler) DeleteTenantHandler(w http.ResponseWriter, r *http.Request) {
+	err := models.DeleteTenant(h.dbpool, chi.URLParam(r, "name"))
+	if err == pgx.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusNotFound,
+			Message:        "Tenant not found",
+		})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error deleting tenant",
+		})
+		log.Logger.Error("DeleteTenantHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, &v1.SimpleMessage{
+		Message: "Tenant deleted",
+	})
+}
+
+// GetTenantUsageHandler returns the resources held by the placements of a
+// tenant and its quotas. An app token can only read the usage of its tenant.
+func (h *BaseHandler) GetTenantUsageHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	if claims["role"] != "admin" && tenantName(r) != name {
+		w.WriteHeader(http.StatusForbidden)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusForbidden,
+			Message:        "Token doesn't belong to the tenant",
+		})
+		return
+	}
+
+	usage, err := models.GetTenantUsage(r.Context(), h.dbpool, h.awsAccounts(r.Context()), name)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting tenant usage",
+		})
+		log.Logger.Error("GetTenantUsageHandler", "error", err)
+		return
+	}
+
+	tenant, err := models.GetTenant(h.dbpool, name)
+	if err != nil && err != pgx.ErrNoRows {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error getting tenant",
+		})
+		log.Logger.Error("GetTenantUsageHandler", "error", err)
+		return
+	}
+	usage.Quotas = tenant
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, &usage)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/go-chi/render"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+
+	"github.com/rhpds/sandbox/internal/api/v1"
+	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/models"
+)
+
+// The revocation list is reloaded periodically from the database, in case
+// a notification was missed while the notifier was reconnecting.
+const revocationsReloadInterval = 5 * time.Minute
+
+// The expired tokens are purged by a single replica at a time
+const tokensPurgeInterval = 5 * time.Minute
+
+// envDuration returns the value of the environment variable as a duration, or def if unset
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Logger.Error("Invalid value, using default", "variable", name, "value", v, "default", def)
+		return def
+	}
+	return d
+}
+
+// WatchRevocations keeps the revocation list up to date with the notifications
+// of the tokens_revocations_channel, until the context is cancelled.
+func WatchRevocations(ctx context.Context, dbpool *pgxpool.Pool, revocations *models.Revocations, notifier *Notifier) {
+	notifications := notifier.SubscribeChannel("tokens_revocations_channel")
+	defer notifier.Unsubscribe(notifications)
+
+	ticker := time.NewTicker(revocationsReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := revocations.Load(dbpool); err != nil {
+				log.Logger.Error("Error loading the revoked tokens", "error", err)
+			}
+		case notification, ok := <-notifications:
+			if !ok {
+				return
+			}
+			if err := revocations.Apply(notification.Payload); err != nil {
+				log.Logger.Error("Error applying revocation", "error", err)
+				continue
+			}
+			log.Logger.Info("Token revoked", "revocation", notification.Payload)
+		}
+	}
+}
+
+// PurgeTokens deletes the expired tokens periodically, until the context is cancelled.
+// Every replica runs it, one purges at a time, see models.PurgeTokens.
+func PurgeTokens(ctx context.Context, dbpool *pgxpool.Pool) {
+	ticker := time.NewTicker(tokensPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := models.PurgeTokens(dbpool); err != nil {
+				log.Logger.Error("Error purging the expired tokens", "error", err)
+			}
+		}
+	}
+}
+
+// refreshClaims returns the claims of a new refresh token, with the same
+// name, role and scope as claims, issued from the login token loginJti.
+func (h *AdminHandler) refreshClaims(claims map[string]any, loginJti string) map[string]any {
+	refreshClaims := map[string]any{
+		"name":      claims["name"],
+		"kind":      "refresh",
+		"role":      claims["role"],
+		"exp":       jwtauth.ExpireIn(h.refreshTokenLifetime),
+		"iat":       jwtauth.EpochNow(),
+		"login_jti": loginJti,
+	}
+
+	// The tokens have the same restrictions and tenant as the login token
+	for _, key := range []string{"scope", "tenant"} {
+		if value, ok := claims[key]; ok {
+			refreshClaims[key] = value
+		}
+	}
+
+	return refreshClaims
+}
+
+// issueTokens signs the refresh token, saved with the id refreshID, and a new
+// access token with the same claims.
+// The access token carries a random jti and the jti of the login token,
+// so it can be revoked alone or with all the tokens of the login token.
+func (h *AdminHandler) issueTokens(refreshClaims map[string]any, refreshID int) (*v1.TokenResponse, error) {
+	jti, err := gonanoid.New()
+	if err != nil {
+		return nil, err
+	}
+
+	accessClaims := map[string]any{
+		"name":      refreshClaims["name"],
+		"kind":      "access",
+		"role":      refreshClaims["role"],
+		"exp":       jwtauth.ExpireIn(h.accessTokenLifetime),
+		"iat":       jwtauth.EpochNow(),
+		"jti":       jti,
+		"login_jti": refreshClaims["login_jti"],
+	}
+	for _, key := range []string{"scope", "tenant"} {
+		if value, ok := refreshClaims[key]; ok {
+			accessClaims[key] = value
+		}
+	}
+
+	accessToken, accessTokenString, err := h.tokenAuth.Encode(accessClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshClaims["jti"] = strconv.Itoa(refreshID)
+	refreshToken, refreshTokenString, err := h.tokenAuth.Encode(refreshClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	ta := accessToken.Expiration()
+	tr := refreshToken.Expiration()
+	return &v1.TokenResponse{
+		AccessToken:     accessTokenString,
+		AccessTokenExp:  &ta,
+		RefreshToken:    refreshTokenString,
+		RefreshTokenExp: &tr,
+	}, nil
+}
+
+// RefreshHandler exchanges a refresh token for a new access token and a new
+// refresh token. The refresh token can be used only once.
+func (h *AdminHandler) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	token, claims, err := jwtauth.FromContext(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusBadRequest,
+			Message:        "Invalid refresh token",
+		})
+		log.Logger.Error("Invalid refresh token", "error", err)
+		return
+	}
+
+	refreshID, err := strconv.Atoi(token.JwtID())
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusUnauthorized,
+			Message:        "Token not found or invalid",
+		})
+		return
+	}
+
+	refreshClaims := h.refreshClaims(claims, "")
+	next, err := models.CreateToken(refreshClaims)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error creating token",
+		})
+		log.Logger.Error("Error creating token", "error", err)
+		return
+	}
+
+	next, err = models.RotateRefreshToken(h.dbpool, refreshID, next)
+	if err != nil {
+		if err == pgx.ErrNoRows || err == models.ErrRefreshTokenReused {
+			message := "Token not found or invalid"
+			if err == models.ErrRefreshTokenReused {
+				message = "Refresh token already used, all the refresh tokens of the login token are invalidated"
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusUnauthorized,
+				Message:        message,
+			})
+			log.Logger.Warn("Refresh token rejected", "error", err, "jti", refreshID, "name", claims["name"])
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error rotating token",
+		})
+		log.Logger.Error("Error rotating token", "error", err)
+		return
+	}
+
+	// The login token is taken from the database, not from the claims
+	refreshClaims["login_jti"] = strconv.Itoa(*next.ParentID)
+
+	response, err := h.issueTokens(refreshClaims, next.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error generating token",
+		})
+		log.Logger.Error("Error generating token", "error", err)
+		return
+	}
+
+	log.Logger.Info("refresh", "name", claims["name"], "role", claims["role"])
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, response)
+}
+
+// RevokeAccessTokenHandler revokes an access token by its jti.
+// The token is rejected by all the replicas until it expires.
+func (h *AdminHandler) RevokeAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	jti := chi.URLParam(r, "jti")
+
+	// The token expires at the latest one access token lifetime from now
+	if err := models.RevokeAccessToken(h.dbpool, jti, time.Now().Add(h.accessTokenLifetime)); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Error revoking token",
+		})
+		log.Logger.Error("Error revoking token", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, &v1.SimpleMessage{
+		Message: "Token successfully revoked",
+	})
+}
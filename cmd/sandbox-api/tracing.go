@@ -0,0 +1,17 @@
+package main
+
+import (
+	"context"
+
+	"github.com/rhpds/sandbox/internal/models"
+)
+
+// awsAccounts returns the AWS account provider tracing its calls in the trace of ctx
+func (h *BaseHandler) awsAccounts(ctx context.Context) models.AwsAccountProvider {
+	return h.awsAccountProvider.WithContext(ctx)
+}
+
+// awsAccounts returns the AWS account provider tracing its calls in the trace of ctx
+func (h *AccountHandler) awsAccounts(ctx context.Context) models.AwsAccountProvider {
+	return h.awsAccountProvider.WithContext(ctx)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/rhpds/sandbox/internal/api/v1"
+	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/models"
+)
+
+// getWebhookSubscription loads the subscription from the URL parameter 'name'.
+// It writes the error response and returns nil if the subscription can't be loaded.
+func (h *BaseHandler) getWebhookSubscription(w http.ResponseWriter, r *http.Request) *models.WebhookSubscription {
+	name := chi.URLParam(r, "name")
+
+	subscription, err := models.GetWebhookSubscriptionByName(h.dbpool, h.OcpSandboxProvider.VaultSecret, name)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusNotFound,
+				Message:        "Webhook subscription not found",
+			})
+			return nil
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Failed to get webhook subscription",
+			ErrorMultiline: []string{err.Error()},
+		})
+		log.Logger.Error("getWebhookSubscription", "error", err)
+		return nil
+	}
+
+	return subscription
+}
+
+// CreateWebhookSubscriptionHandler registers a new webhook subscription
+func (h *BaseHandler) CreateWebhookSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	subscription := models.MakeWebhookSubscription()
+
+	if err := render.Bind(r, subscription); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusBadRequest,
+			Message:        "Invalid request payload",
+			ErrorMultiline: []string{err.Error()},
+		})
+		return
+	}
+
+	_, err := models.GetWebhookSubscriptionByName(h.dbpool, h.OcpSandboxProvider.VaultSecret, subscription.Name)
+	if err != pgx.ErrNoRows {
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusInternalServerError,
+				Message:        "Error checking for existing webhook subscription",
+			})
+			log.Logger.Error("CreateWebhookSubscriptionHandler", "error", err)
+			return
+		}
+
+		w.WriteHeader(http.StatusConflict)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusConflict,
+			Message:        "Webhook subscription already exists",
+		})
+		return
+	}
+
+	subscription.DbPool = h.dbpool
+	subscription.VaultSecret = h.OcpSandboxProvider.VaultSecret
+
+	if err := subscription.Save(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Failed to save webhook subscription",
+			ErrorMultiline: []string{err.Error()},
+		})
+		log.Logger.Error("CreateWebhookSubscriptionHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	render.Render(w, r, &v1.SimpleMessage{
+		Message: "Webhook subscription created",
+	})
+}
+
+// GetWebhookSubscriptionsHandler returns all the webhook subscriptions
+func (h *BaseHandler) GetWebhookSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := models.GetWebhookSubscriptions(h.dbpool, h.OcpSandboxProvider.VaultSecret)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Failed to get webhook subscriptions",
+			ErrorMultiline: []string{err.Error()},
+		})
+		log.Logger.Error("GetWebhookSubscriptionsHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, subscriptions)
+}
+
+// GetWebhookSubscriptionHandler returns a webhook subscription
+func (h *BaseHandler) GetWebhookSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	subscription := h.getWebhookSubscription(w, r)
+	if subscription == nil {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, subscription)
+}
+
+// DeleteWebhookSubscriptionHandler deletes a webhook subscription and its delivery log
+func (h *BaseHandler) DeleteWebhookSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	subscription := h.getWebhookSubscription(w, r)
+	if subscription == nil {
+		return
+	}
+
+	if err := subscription.Delete(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Failed to delete webhook subscription",
+			ErrorMultiline: []string{err.Error()},
+		})
+		log.Logger.Error("DeleteWebhookSubscriptionHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, &v1.SimpleMessage{
+		Message: "Webhook subscription deleted",
+	})
+}
+
+// GetWebhookDeliveriesHandler returns the delivery log of a webhook subscription.
+// Query parameters:
+//   - status: only return the deliveries with that status
+//   - limit: maximum number of deliveries, default 100
+func (h *BaseHandler) GetWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains([]string{"pending", "delivering", "success", "failed"}, status) {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusBadRequest,
+			Message:        "Invalid status, must be one of pending, delivering, success, failed",
+		})
+		return
+	}
+
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > 1000 {
+			w.WriteHeader(http.StatusBadRequest)
+			render.Render(w, r, &v1.Error{
+				HTTPStatusCode: http.StatusBadRequest,
+				Message:        "Invalid limit, must be between 1 and 1000",
+			})
+			return
+		}
+	}
+
+	subscription := h.getWebhookSubscription(w, r)
+	if subscription == nil {
+		return
+	}
+
+	deliveries, err := subscription.GetDeliveries(status, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.Render(w, r, &v1.Error{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        "Failed to get webhook deliveries",
+			ErrorMultiline: []string{err.Error()},
+		})
+		log.Logger.Error("GetWebhookDeliveriesHandler", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.Render(w, r, deliveries)
+}
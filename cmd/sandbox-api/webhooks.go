@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/models"
+)
+
+// WebhookDispatcher delivers the webhook events queued in the webhook_deliveries table.
+// Every replica runs a dispatcher, the deliveries are claimed with SKIP LOCKED.
+type WebhookDispatcher struct {
+	dbpool      *pgxpool.Pool
+	vaultSecret string
+	notifier    *Notifier
+	client      *http.Client
+}
+
+// NewWebhookDispatcher creates a new dispatcher
+func NewWebhookDispatcher(dbpool *pgxpool.Pool, vaultSecret string, notifier *Notifier) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		dbpool:      dbpool,
+		vaultSecret: vaultSecret,
+		notifier:    notifier,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Run delivers the events until the context is cancelled.
+// It wakes up when an event is queued, and every 10 seconds for the retries.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	notifications := d.notifier.Subscribe()
+	defer d.notifier.Unsubscribe(notifications)
+
+	// Drain the notifications in a separate goroutine so the notifier never
+	// has to drop them while a delivery is in progress.
+	wake := make(chan struct{}, 1)
+	go func() {
+		for notification := range notifications {
+			if notification.Channel != "webhook_deliveries_channel" {
+				continue
+			}
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		d.dispatch(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wake:
+		}
+	}
+}
+
+// dispatch delivers all the deliveries due
+func (d *WebhookDispatcher) dispatch(ctx context.Context) {
+	for ctx.Err() == nil {
+		delivery, err := models.ClaimWebhookDelivery(d.dbpool)
+		if err != nil {
+			if err != pgx.ErrNoRows {
+				log.Logger.Error("Error claiming webhook delivery", "error", err)
+			}
+			return
+		}
+
+		statusCode, err := d.deliver(ctx, delivery)
+		if err != nil {
+			log.Logger.Warn("Webhook delivery failed",
+				"delivery", delivery.ID,
+				"event", delivery.EventType,
+				"attempts", delivery.Attempts,
+				"error", err,
+			)
+		}
+
+		if err := delivery.SetResult(statusCode, err); err != nil {
+			log.Logger.Error("Error saving webhook delivery result", "error", err, "delivery", delivery.ID)
+		}
+	}
+}
+
+// deliver posts the payload to the subscription URL.
+// Returns the HTTP status code, and an error if the delivery failed.
+func (d *WebhookDispatcher) deliver(ctx context.Context, delivery *models.WebhookDelivery) (int, error) {
+	subscription, err := models.GetWebhookSubscription(d.dbpool, d.vaultSecret, delivery.SubscriptionID)
+	if err != nil {
+		return 0, err
+	}
+
+	body, err := json.Marshal(delivery.Payload)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sandbox-api/"+Version)
+	req.Header.Set("X-Sandbox-Event", delivery.EventType)
+	req.Header.Set("X-Sandbox-Event-Id", delivery.EventID)
+	req.Header.Set("X-Sandbox-Delivery", strconv.Itoa(delivery.ID))
+	req.Header.Set("X-Sandbox-Signature-256", models.SignWebhookPayload(subscription.Secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
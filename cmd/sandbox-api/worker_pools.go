@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-chi/render"
+
+	"github.com/rhpds/sandbox/internal/api/v1"
+	"github.com/rhpds/sandbox/internal/config"
+	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/metrics"
+)
+
+// Maximum number of jobs waiting in the queue of a pool.
+// When the queue is full, the job stays 'new' in the database.
+const workerPoolQueueSize = 1000
+
+// WorkerPool is a set of goroutines executing one kind of jobs, for example
+// the 'stop' jobs of the AWS accounts. Each kind has its own pool so a slow
+// job doesn't block the other kinds queued behind it.
+type WorkerPool struct {
+	Name    string
+	Workers int
+
+	queue   chan int
+	running atomic.Int64
+}
+
+// Pools of the resource jobs, by resource type and action
+var resourceWorkerPools = []string{
+	"aws_account.start",
+	"aws_account.stop",
+	"aws_account.status",
+	"default",
+}
+
+// resourcePoolName returns the name of the pool executing the jobs of the
+// resource type and action.
+func resourcePoolName(resourceType string, action string) string {
+	switch resourceType {
+	case "AwsSandbox", "AwsAccount", "aws_account":
+		switch action {
+		case "start", "stop", "status":
+			return "aws_account." + action
+		}
+	}
+	return "default"
+}
+
+// envInt returns the value of the environment variable as an int, or def if unset
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		log.Logger.Error("Invalid value, using default", "variable", name, "value", v, "default", def)
+		return def
+	}
+	return i
+}
+
+// NewWorkerPool creates a pool. The number of workers is read from the environment
+// variable WORKERS_<NAME>, for example WORKERS_AWS_ACCOUNT_STATUS, and defaults to WORKERS.
+func NewWorkerPool(name string) *WorkerPool {
+	variable := "WORKERS_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+
+	return &WorkerPool{
+		Name:    name,
+		Workers: envInt(variable, envInt("WORKERS", 5)),
+		queue:   make(chan int, workerPoolQueueSize),
+	}
+}
+
+// Enqueue adds a job to the queue of the pool. It never blocks, returns false
+// if the queue is full.
+func (p *WorkerPool) Enqueue(id int) bool {
+	select {
+	case p.queue <- id:
+		metrics.WorkerPoolQueued.WithLabelValues(p.Name).Set(float64(len(p.queue)))
+		return true
+	default:
+		log.Logger.Warn("Worker pool queue full, job not queued", "pool", p.Name, "job", id)
+		return false
+	}
+}
+
+// Run starts the workers of the pool, they call process for each job queued
+// until the context is cancelled.
+func (p *WorkerPool) Run(ctx context.Context, process func(context.Context, int)) {
+	for i := 0; i < p.Workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id := <-p.queue:
+					if ctx.Err() != nil {
+						// Shutting down, leave the job to another replica
+						return
+					}
+					metrics.WorkerPoolQueued.WithLabelValues(p.Name).Set(float64(len(p.queue)))
+					metrics.WorkerPoolRunning.WithLabelValues(p.Name).Set(float64(p.running.Add(1)))
+					process(ctx, id)
+					metrics.WorkerPoolRunning.WithLabelValues(p.Name).Set(float64(p.running.Add(-1)))
+				}
+			}
+		}()
+	}
+}
+
+// Status returns the current state of the pool
+func (p *WorkerPool) Status() v1.WorkerPoolStatus {
+	return v1.WorkerPoolStatus{
+		Name:    p.Name,
+		Workers: p.Workers,
+		Queued:  len(p.queue),
+		Running: p.running.Load(),
+	}
+}
+
+// GetWorkerPoolsHandler returns the state of the worker pools of this replica:
+// the number of workers, of jobs queued and of jobs running.
+func (w Worker) GetWorkerPoolsHandler(rw http.ResponseWriter, r *http.Request) {
+	pools := []v1.WorkerPoolStatus{w.placementPool.Status()}
+	for _, name := range resourceWorkerPools {
+		pools = append(pools, w.resourcePools[name].Status())
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	render.Render(rw, r, &v1.WorkerPoolsResponse{
+		HTTPStatusCode:    http.StatusOK,
+		Locality:          config.LocalityID,
+		MaxConcurrentJobs: cap(w.limit),
+		Pools:             pools,
+	})
+}
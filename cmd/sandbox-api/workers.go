@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	cc "github.com/rhpds/sandbox/internal/config"
 	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/metrics"
 	"github.com/rhpds/sandbox/internal/models"
+	"github.com/rhpds/sandbox/internal/tracing"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -17,6 +21,8 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-sdk-go-v2/otelaws"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Worker struct {
@@ -28,11 +34,113 @@ type Worker struct {
 
 	// AWS client to manage the accounts
 	StsClient *sts.Client
+
+	// Notifier to receive the cancellations of the jobs
+	Notifier *Notifier
+
+	// Jobs being executed by this process, to cancel them
+	running *runningJobs
+
+	// Pool dispatching the placement jobs
+	placementPool *WorkerPool
+
+	// Pools executing the resource jobs, by resource type and action
+	resourcePools map[string]*WorkerPool
+
+	// Global limit of resource jobs executed at once by this process,
+	// nil if there is no limit
+	limit chan struct{}
+}
+
+// ErrShutdown is the cause of the cancellation of the jobs interrupted by a shutdown
+var ErrShutdown = errors.New("sandbox-api is shutting down")
+
+// The resource jobs left new for this long are notified again, see SweepPendingJobs
+const pendingJobsSweepInterval = time.Minute
+
+// The running jobs are checked for cancellation this often, in case a
+// notification was dropped, see WatchCancellations
+const cancellationsSweepInterval = 30 * time.Second
+
+// runningJobs holds the cancel functions of the resource jobs being executed
+type runningJobs struct {
+	mu      sync.Mutex
+	cancels map[int]context.CancelCauseFunc
+	wg      sync.WaitGroup
+}
+
+func (r *runningJobs) add(id int, cancel context.CancelCauseFunc) {
+	r.wg.Add(1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cancels[id] = cancel
+}
+
+func (r *runningJobs) remove(id int) {
+	r.mu.Lock()
+	delete(r.cancels, id)
+	r.mu.Unlock()
+	r.wg.Done()
+}
+
+// has returns true if the job is running here
+func (r *runningJobs) has(id int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.cancels[id]
+	return ok
+}
+
+// ids returns the ids of the jobs running here
+func (r *runningJobs) ids() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := make([]int, 0, len(r.cancels))
+	for id := range r.cancels {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// cancel cancels the context of the job, returns false if the job is not running here
+func (r *runningJobs) cancel(id int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cancel, ok := r.cancels[id]
+	if ok {
+		cancel(context.Canceled)
+	}
+	return ok
+}
+
+// cancelAll cancels the context of all the running jobs with the cause
+func (r *runningJobs) cancelAll(cause error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, cancel := range r.cancels {
+		cancel(cause)
+	}
+}
+
+// wait waits for all the running jobs to return, or for the context to be done
+func (r *runningJobs) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // AssumeRole gives back a set of temporary credentials to have access to the AWS account
 
-func (w Worker) AssumeRole(account models.AwsAccount) (*sts.AssumeRoleOutput, error) {
+func (w Worker) AssumeRole(ctx context.Context, account models.AwsAccount) (*sts.AssumeRoleOutput, error) {
 
 	// Create the request
 	input := &sts.AssumeRoleInput{
@@ -42,8 +150,9 @@ func (w Worker) AssumeRole(account models.AwsAccount) (*sts.AssumeRoleOutput, er
 	}
 
 	// Send the request and get the response
-	resp, err := w.StsClient.AssumeRole(context.TODO(), input)
+	resp, err := w.StsClient.AssumeRole(ctx, input)
 	if err != nil {
+		metrics.AssumeRoleFailures.Inc()
 		return nil, err
 	}
 
@@ -51,8 +160,9 @@ func (w Worker) AssumeRole(account models.AwsAccount) (*sts.AssumeRoleOutput, er
 }
 
 // Execute executes a LifecycleResourceJob.
-// It checks the resource type and the lifecycle action and execute the appropriate function
-func (w Worker) Execute(j *models.LifecycleResourceJob) error {
+// It checks the resource type and the lifecycle action and execute the appropriate function.
+// When ctx is cancelled, the action stops between regions and resources.
+func (w Worker) Execute(ctx context.Context, j *models.LifecycleResourceJob) error {
 	switch j.ResourceType {
 	case "AwsSandbox", "AwsAccount", "aws_account":
 		// Get the sandbox
@@ -63,15 +173,13 @@ func (w Worker) Execute(j *models.LifecycleResourceJob) error {
 		}
 
 		log.Logger.Debug("Got action", "action", j.Action)
-		assume, err := w.AssumeRole(sandbox)
+		assume, err := w.AssumeRole(ctx, sandbox)
 		if err != nil {
 			return err
 		}
 
 		log.Logger.Debug("assume successful")
 
-		ctx := context.TODO()
-
 		// Add RequestID to context
 		ctx = context.WithValue(ctx, "RequestID", j.RequestID)
 		// If job has a parent, add serviceUUID to context
@@ -95,15 +203,17 @@ func (w Worker) Execute(j *models.LifecycleResourceJob) error {
 			ctx = context.WithValue(ctx, "ServiceUUID", placement.ServiceUuid)
 		}
 
+		j.SetStatus("running")
+		if j.Status == "cancelled" {
+			return context.Canceled
+		}
+
 		switch j.Action {
 		case "start":
-			j.SetStatus("running")
 			return sandbox.Start(ctx, assume.Credentials, j)
 		case "stop":
-			j.SetStatus("running")
 			return sandbox.Stop(ctx, assume.Credentials, j)
 		case "status":
-			j.SetStatus("running")
 			status, err := sandbox.Status(ctx, assume.Credentials, j)
 			if err != nil {
 				j.SetStatus("error")
@@ -117,195 +227,387 @@ func (w Worker) Execute(j *models.LifecycleResourceJob) error {
 	return nil
 }
 
-// consumeChannels is a goroutine that listens to the golang channels and processes the events
-func (w Worker) consumeChannels(ctx context.Context, LifecycleResourceJobsStatusChannel chan string, LifecyclePlacementJobsStatusChannel chan string) {
-WorkerLoop:
+// processResourceJob claims and executes the resource job
+func (w Worker) processResourceJob(ctx context.Context, id int) {
+	job, err := models.GetLifecycleResourceJob(w.Dbpool, id)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			log.Logger.Debug("Resource job not found", "job", id)
+			return
+		}
+		log.Logger.Error("Error getting lifecycle resource job", "error", err)
+		return
+	}
+	log.Logger.Debug("Got lifecycle resource job", "job", job)
+	if job.Locality != cc.LocalityID && job.Locality != "any" {
+		// log
+		log.Logger.Debug("Job not for this locality", "job", job)
+
+		// Sleep 2 seconds to give time to original worker to claim the job
+		time.Sleep(2 * time.Second)
+
+		// Check if it's still new
+
+		job, err = models.GetLifecycleResourceJob(w.Dbpool, id)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				log.Logger.Debug("Resource job not found", "job", id)
+				return
+			}
+			log.Logger.Error("Error getting lifecycle resource job", "error", err)
+			return
+		}
+	}
+
+	if job.Status != "new" {
+		return
+	}
+
+	// Global concurrency limit
+	if w.limit != nil {
+		select {
+		case w.limit <- struct{}{}:
+			defer func() { <-w.limit }()
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	if err := job.Claim(); err != nil {
+		switch err {
+		case models.ErrNoClaim:
+			metrics.JobClaimConflicts.WithLabelValues("resource", "already_claimed").Inc()
+			log.Logger.Debug("Job already claimed", "job", job)
+		case models.ErrResourceBusy:
+			metrics.JobClaimConflicts.WithLabelValues("resource", "resource_busy").Inc()
+			// The job stays new, it's notified again when the other job is done
+			log.Logger.Info("Resource busy with another job, job postponed",
+				"job", job.ID, "resource", job.ResourceName)
+		default:
+			log.Logger.Error("Error claiming job", "error", err)
+		}
+		return
+	}
+	// New job arrived, let's process it
+	start := time.Now()
+	job.SetStatus("initialized")
+
+	// Continue the trace of the request that created the job
+	spanCtx, span := tracing.Start(
+		tracing.Extract(context.Background(), job.TraceContext),
+		"lifecycle.resource_job",
+		attribute.Int("job.id", job.ID),
+		attribute.String("request.id", job.RequestID),
+		attribute.String("resource.type", job.ResourceType),
+		attribute.String("resource.name", job.ResourceName),
+		attribute.String("lifecycle.action", job.Action),
+	)
+
+	// The job context is cancelled when the request is cancelled,
+	// see WatchCancellations, or on shutdown, see Shutdown.
+	jobCtx, cancel := context.WithCancelCause(spanCtx)
+	w.running.add(job.ID, cancel)
+	defer w.running.remove(job.ID)
+
+	err = w.Execute(jobCtx, job)
+	interrupted := errors.Is(context.Cause(jobCtx), ErrShutdown)
+	cancel(nil)
+
+	switch {
+	case err != nil && interrupted:
+		// Give the job back to another replica
+		if err := job.Requeue(); err != nil {
+			log.Logger.Error("Error requeuing job", "error", err, "job", job.ID)
+		} else {
+			log.Logger.Info("Job requeued", "job", job.ID)
+		}
+	case err != nil:
+		job.SetError(err)
+		if job.Status == "cancelled" {
+			log.Logger.Info("Job cancelled", "job", job.ID)
+		} else {
+			log.Logger.Error("Error executing job", "error", err)
+		}
+	default:
+		job.SetStatus("success")
+	}
+
+	if job.Status != "new" {
+		if err := job.MarkCompleted(); err != nil {
+			log.Logger.Error("Error completing job", "error", err, "job", job.ID)
+		}
+	}
+
+	status := job.Status
+	if status == "new" {
+		status = "requeued"
+	}
+	metrics.LifecycleJobDuration.WithLabelValues(
+		resourcePoolName(job.ResourceType, job.Action),
+		status,
+	).Observe(time.Since(start).Seconds())
+	span.SetAttributes(attribute.String("job.status", status))
+	tracing.End(span, err)
+
+	// The resource is free, wake up the jobs waiting for it.
+	// A requeued job is already stopped, it may be claimed again already.
+	if job.Status != "new" {
+		if err := job.Stopped(); err != nil {
+			log.Logger.Error("Error marking job stopped", "error", err, "job", job.ID)
+		}
+	}
+	if err := models.NotifyPendingResourceJobs(w.Dbpool, job.ResourceName); err != nil {
+		log.Logger.Error("Error notifying pending jobs", "error", err, "resource", job.ResourceName)
+	}
+}
+
+// processPlacementJob claims the placement job and dispatches its resource jobs
+func (w Worker) processPlacementJob(ctx context.Context, id int) {
+	job, err := models.GetLifecyclePlacementJob(w.Dbpool, id)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			log.Logger.Debug("Placement job not found", "job", id)
+			return
+		}
+		log.Logger.Error("Error getting lifecycle placement job", "error", err)
+		return
+	}
+
+	log.Logger.Debug("notification placement job received", "job", job)
+	if job.Locality != cc.LocalityID && job.Locality != "any" {
+		// log
+		log.Logger.Debug("Job not for this locality", "job", job)
+
+		// Sleep 2 seconds to give time to original worker to claim the job
+		time.Sleep(2 * time.Second)
+
+		// Check if it's still new
+
+		job, err = models.GetLifecyclePlacementJob(w.Dbpool, id)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				log.Logger.Debug("Placement job not found", "job", id)
+				return
+			}
+			log.Logger.Error("Error getting lifecycle placement job", "error", err)
+			return
+		}
+	}
+
+	if job.Status != "new" {
+		return
+	}
+
+	if err := job.Claim(); err != nil {
+		if err == models.ErrNoClaim {
+			metrics.JobClaimConflicts.WithLabelValues("placement", "already_claimed").Inc()
+			log.Logger.Debug("Job already claimed", "job", job)
+		} else {
+			log.Logger.Error("Error claiming job", "error", err)
+		}
+		return
+	}
+
+	// New job arrived, let's process it
+	spanCtx, span := tracing.Start(
+		tracing.Extract(context.Background(), job.TraceContext),
+		"lifecycle.placement_job",
+		attribute.Int("job.id", job.ID),
+		attribute.String("request.id", job.RequestID),
+		attribute.Int("placement.id", job.PlacementID),
+		attribute.String("lifecycle.action", job.Action),
+	)
+	w.running.wg.Add(1)
+	w.dispatchPlacementJob(spanCtx, job)
+	w.running.wg.Done()
+	span.SetAttributes(attribute.String("job.status", job.Status))
+	span.End()
+}
+
+// WatchCancellations cancels the context of the running jobs when they are cancelled.
+// It runs separately from the workers so a cancellation is handled even if all the
+// workers are busy. The notifications can be dropped, so the running jobs are
+// also checked every cancellationsSweepInterval.
+func (w Worker) WatchCancellations(ctx context.Context) {
+	notifications := w.Notifier.Subscribe()
+	defer w.Notifier.Unsubscribe(notifications)
+
+	ticker := time.NewTicker(cancellationsSweepInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
-			log.Logger.Warn("Context cancelled, exiting consumeChannels worker")
 			return
-		case msg := <-LifecycleResourceJobsStatusChannel:
-			id, err := strconv.Atoi(msg)
+		case <-ticker.C:
+			cancelled, err := models.CancelledResourceJobs(w.Dbpool, w.running.ids())
 			if err != nil {
-				log.Logger.Error("Error converting message to int", "error", err)
-				continue WorkerLoop
+				log.Logger.Error("Error checking the cancelled jobs", "error", err)
+				continue
 			}
-			job, err := models.GetLifecycleResourceJob(w.Dbpool, id)
-			if err != nil {
-				if err == pgx.ErrNoRows {
-					log.Logger.Debug("Resource job not found", "job", job)
-					continue WorkerLoop
+			for _, id := range cancelled {
+				if w.running.cancel(id) {
+					log.Logger.Info("Cancelling running job", "job", id)
 				}
-				log.Logger.Error("Error getting lifecycle resource job", "error", err)
-				continue WorkerLoop
 			}
-			log.Logger.Debug("Got lifecycle resource job", "job", job)
-			if job.Locality != cc.LocalityID && job.Locality != "any" {
-				// log
-				log.Logger.Debug("Job not for this locality", "job", job)
-
-				// Sleep 2 seconds to give time to original worker to claim the job
-				time.Sleep(2 * time.Second)
-
-				// Check if it's still new
-
-				job, err = models.GetLifecycleResourceJob(w.Dbpool, id)
-				if err != nil {
-					if err == pgx.ErrNoRows {
-						log.Logger.Debug("Resource job not found", "job", job)
-						continue WorkerLoop
-					}
-					log.Logger.Error("Error getting lifecycle placement job", "error", err)
-					continue WorkerLoop
-				}
+		case notification, ok := <-notifications:
+			if !ok {
+				return
 			}
-
-			switch job.Status {
-			case "new":
-				if err := job.Claim(); err != nil {
-					if err == models.ErrNoClaim {
-						log.Logger.Debug("Job already claimed", "job", job)
-					} else {
-						log.Logger.Error("Error claiming job", "error", err)
-					}
-					continue WorkerLoop
-				}
-				// New job arrived, let's process it
-				job.SetStatus("initialized")
-
-				err := w.Execute(job)
-				if err != nil {
-					job.SetStatus("error")
-					log.Logger.Error("Error executing job", "error", err)
-					continue WorkerLoop
-				}
-				job.SetStatus("success")
+			if notification.Channel != "lifecycle_resource_jobs_status_channel" {
+				continue
 			}
 
-		case msg := <-LifecyclePlacementJobsStatusChannel:
-			id, err := strconv.Atoi(msg)
+			id, err := strconv.Atoi(notification.Payload)
 			if err != nil {
-				log.Logger.Error("Error converting message to int", "error", err)
-				continue WorkerLoop
+				log.Logger.Error("Error converting payload to int", "error", err, "payload", notification.Payload)
+				continue
 			}
-			job, err := models.GetLifecyclePlacementJob(w.Dbpool, id)
-			if err != nil {
-				if err == pgx.ErrNoRows {
-					log.Logger.Debug("Placement job not found", "job", job)
-					continue WorkerLoop
-				}
-				log.Logger.Error("Error getting lifecycle placement job", "error", err)
-				continue WorkerLoop
+
+			// Most notifications are about jobs running elsewhere, or not cancelled
+			if !w.running.has(id) {
+				continue
 			}
 
-			log.Logger.Debug("notification placement job received", "job", job)
-			if job.Locality != cc.LocalityID && job.Locality != "any" {
-				// log
-				log.Logger.Debug("Job not for this locality", "job", job)
+			job, err := models.GetLifecycleResourceJob(w.Dbpool, id)
+			if err != nil || job.Status != "cancelled" {
+				continue
+			}
 
-				// Sleep 2 seconds to give time to original worker to claim the job
-				time.Sleep(2 * time.Second)
+			if w.running.cancel(id) {
+				log.Logger.Info("Cancelling running job", "job", id, "request_id", job.RequestID)
+			}
+		}
+	}
+}
 
-				// Check if it's still new
+// SweepPendingJobs notifies again, every pendingJobsSweepInterval, the resource
+// jobs left new, until the context is cancelled. A postponed job is notified
+// again when the job holding its resource is done, the sweep covers the jobs
+// whose notification was lost or whose resource was held by a dead job.
+func (w Worker) SweepPendingJobs(ctx context.Context) {
+	ticker := time.NewTicker(pendingJobsSweepInterval)
+	defer ticker.Stop()
 
-				job, err = models.GetLifecyclePlacementJob(w.Dbpool, id)
-				if err != nil {
-					if err == pgx.ErrNoRows {
-						log.Logger.Debug("Placement job not found", "job", job)
-						continue WorkerLoop
-					}
-					log.Logger.Error("Error getting lifecycle placement job", "error", err)
-					continue WorkerLoop
-				}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := models.NotifyStalePendingResourceJobs(w.Dbpool, pendingJobsSweepInterval); err != nil {
+				log.Logger.Error("Error notifying the pending jobs", "error", err)
 			}
+		}
+	}
+}
 
-			switch job.Status {
-			case "new":
-				if err := job.Claim(); err != nil {
-					if err == models.ErrNoClaim {
-						log.Logger.Debug("Job already claimed", "job", job)
-					} else {
-						log.Logger.Error("Error claiming job", "error", err)
-					}
-					continue WorkerLoop
-				}
+// dispatchPlacementJob creates a resource job for each resource of the placement.
+// The resource jobs continue the trace of ctx.
+func (w Worker) dispatchPlacementJob(ctx context.Context, job *models.LifecyclePlacementJob) {
+	job.SetStatus("initialized")
+	placement, err := models.GetPlacement(w.Dbpool, job.PlacementID)
 
-				// New job arrived, let's process it
-				job.SetStatus("initialized")
-				placement, err := models.GetPlacement(w.Dbpool, job.PlacementID)
+	if err != nil {
+		log.Logger.Error("Error getting placement", "error", err)
+		job.SetError(err)
+		w.completePlacementJob(job)
+		return
+	}
 
-				if err != nil {
-					log.Logger.Error("Error getting placement", "error", err)
-					job.SetStatus("error")
-					continue WorkerLoop
-				}
+	// Get all accounts in the placement
+	if err := placement.LoadActiveResources(w.AwsAccountProvider.WithContext(ctx)); err != nil {
+		log.Logger.Error("Error loading resources", "error", err, "placement", placement)
+		job.SetError(err)
+		w.completePlacementJob(job)
+		return
+	}
+	log.Logger.Debug("Got placement", "placement", placement)
+
+ResourceLoop:
+	for _, account := range placement.Resources {
+		// Stop creating resource jobs if the request was cancelled
+		if current, err := models.GetLifecyclePlacementJob(w.Dbpool, job.ID); err == nil && current.Status == "cancelled" {
+			log.Logger.Info("Placement job cancelled", "job", job.ID)
+			job.Status = "cancelled"
+			break ResourceLoop
+		}
 
-				// Get all accounts in the placement
-				if err := placement.LoadActiveResources(w.AwsAccountProvider); err != nil {
-					log.Logger.Error("Error loading resources", "error", err, "placement", placement)
-					job.SetStatus("error")
-					continue WorkerLoop
-				}
-				log.Logger.Debug("Got placement", "placement", placement)
-
-			ResourceLoop:
-				for _, account := range placement.Resources {
-					// Create a new LifecycleResourceJob for each account
-					// Detect type of the resource using reflection
-					switch account.(type) {
-					case models.AwsAccount:
-						awsAccount := account.(models.AwsAccount)
-						log.Logger.Debug("Creating resource job for account", "account", awsAccount)
-
-						lifecycleResourceJob := models.LifecycleResourceJob{
-							ParentID:     job.ID,
-							Locality:     cc.LocalityID,
-							RequestID:    job.RequestID,
-							ResourceType: awsAccount.Kind,
-							ResourceName: awsAccount.Name,
-							Action:       job.Action,
-							Status:       "new",
-							DbPool:       w.Dbpool,
-						}
-
-						if err := lifecycleResourceJob.Create(); err != nil {
-							log.Logger.Error("Error creating lifecycle resource job", "error", err)
-							job.SetStatus("error")
-							continue ResourceLoop
-						}
-						log.Logger.Debug("Created resource job for account", "account", awsAccount, "job", lifecycleResourceJob)
-					}
-				}
-				job.SetStatus("successfully_dispatched")
+		// Create a new LifecycleResourceJob for each account
+		// Detect type of the resource using reflection
+		switch account.(type) {
+		case models.AwsAccount:
+			awsAccount := account.(models.AwsAccount)
+			log.Logger.Debug("Creating resource job for account", "account", awsAccount)
+
+			lifecycleResourceJob := models.LifecycleResourceJob{
+				ParentID:     job.ID,
+				Locality:     cc.LocalityID,
+				RequestID:    job.RequestID,
+				ResourceType: awsAccount.Kind,
+				ResourceName: awsAccount.Name,
+				Action:       job.Action,
+				Status:       "new",
+				DbPool:       w.Dbpool,
+				TraceContext: tracing.Inject(ctx),
+			}
+
+			if err := lifecycleResourceJob.Create(); err != nil {
+				log.Logger.Error("Error creating lifecycle resource job", "error", err)
+				job.SetError(err)
+				continue ResourceLoop
 			}
+			log.Logger.Debug("Created resource job for account", "account", awsAccount, "job", lifecycleResourceJob)
 		}
 	}
+	job.SetStatus("successfully_dispatched")
+	// The resource jobs may be done already, or there may be none
+	w.completePlacementJob(job)
 }
 
-func (w Worker) WatchLifecycleDBChannels(ctx context.Context) error {
+// Shutdown waits for the running jobs to finish. Call it after the context of
+// WatchLifecycleDBChannels is cancelled, so no new job is claimed.
+// When ctx is done, the jobs still running are interrupted and requeued
+// for another replica. gracePeriod is the time given to the interrupted jobs
+// to stop and be requeued.
+func (w Worker) Shutdown(ctx context.Context, gracePeriod time.Duration) error {
+	if err := w.running.wait(ctx); err == nil {
+		return nil
+	}
 
-	// Create channels for resource lifecycle events
-	LifecycleResourceJobsStatusChannel := make(chan string)
-	LifecyclePlacementJobsStatusChannel := make(chan string)
+	log.Logger.Warn("Interrupting running jobs")
+	w.running.cancelAll(ErrShutdown)
 
-	// convert environment variable WORKERS to int
-	workers, err := strconv.Atoi(os.Getenv("WORKERS"))
-	if err != nil {
-		log.Logger.Error("Error converting WORKERS to int", "error", err)
-		return err
+	graceCtx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+
+	return w.running.wait(graceCtx)
+}
+
+// completePlacementJob marks the placement job completed if it's done
+func (w Worker) completePlacementJob(job *models.LifecyclePlacementJob) {
+	if err := job.MarkCompleted(); err != nil {
+		log.Logger.Error("Error completing placement job", "error", err, "job", job.ID)
 	}
+}
 
+func (w Worker) WatchLifecycleDBChannels(ctx context.Context) error {
+	parentCtx := ctx
 	ctx, cancel := context.WithCancel(ctx)
 	// In case this goroutine stop, stop all workers and restart it
 	defer func() {
+		cancel()
+		if parentCtx.Err() != nil {
+			// Shutting down, don't restart
+			log.Logger.Info("Stopped worker WatchLifecycleDBChannels and its workers")
+			return
+		}
+
 		// Log that we are restarting
 		log.Logger.Warn("Restarting worker WatchLifecycleDBChannels and its workers")
-		cancel()
 		// sleep for 5 seconds before restarting
 		time.Sleep(5 * time.Second)
 
-		go w.WatchLifecycleDBChannels(context.Background())
+		go w.WatchLifecycleDBChannels(parentCtx)
 	}()
 
 	conn, err := w.Dbpool.Acquire(context.Background())
@@ -313,7 +615,11 @@ func (w Worker) WatchLifecycleDBChannels(ctx context.Context) error {
 		log.Logger.Error("Error acquiring connection", "error", err)
 		return err
 	}
-	defer conn.Release()
+	defer func() {
+		// Don't give back a listening connection to the pool
+		conn.Exec(context.Background(), "UNLISTEN *")
+		conn.Release()
+	}()
 
 	channels := []string{
 		"lifecycle_placement_jobs_status_channel",
@@ -328,13 +634,14 @@ func (w Worker) WatchLifecycleDBChannels(ctx context.Context) error {
 		log.Logger.Info("Listening to channel", "channel", pgChan)
 	}
 
-	// Create go routines to listen to the Golang channels
-	for i := 0; i < workers; i++ {
-		go w.consumeChannels(ctx, LifecycleResourceJobsStatusChannel, LifecyclePlacementJobsStatusChannel)
+	// Start the workers of each pool
+	w.placementPool.Run(ctx, w.processPlacementJob)
+	for _, pool := range w.resourcePools {
+		pool.Run(ctx, w.processResourceJob)
 	}
 
 	for {
-		notification, err := conn.Conn().WaitForNotification(context.Background())
+		notification, err := conn.Conn().WaitForNotification(ctx)
 		if err != nil {
 			log.Logger.Error("Error while listening to the channel", "error", err)
 			return err
@@ -342,12 +649,31 @@ func (w Worker) WatchLifecycleDBChannels(ctx context.Context) error {
 
 		log.Logger.Debug("Notification received", "PID", notification.PID, "Channel", notification.Channel, "Payload", notification.Payload)
 
+		id, err := strconv.Atoi(notification.Payload)
+		if err != nil {
+			log.Logger.Error("Error converting message to int", "error", err)
+			continue
+		}
+
 		switch notification.Channel {
 		case "lifecycle_placement_jobs_status_channel":
-			LifecyclePlacementJobsStatusChannel <- notification.Payload
+			w.placementPool.Enqueue(id)
 
 		case "lifecycle_resource_jobs_status_channel":
-			LifecycleResourceJobsStatusChannel <- notification.Payload
+			// Route the job to the pool of its resource type and action.
+			// Only the new jobs need a worker, the notification is sent for
+			// every change of status.
+			job, err := models.GetLifecycleResourceJob(w.Dbpool, id)
+			if err != nil {
+				if err != pgx.ErrNoRows {
+					log.Logger.Error("Error getting lifecycle resource job", "error", err)
+				}
+				continue
+			}
+			if job.Status != "new" {
+				continue
+			}
+			w.resourcePools[resourcePoolName(job.ResourceType, job.Action)].Enqueue(id)
 		}
 	}
 }
@@ -362,6 +688,7 @@ func NewWorker(baseHandler BaseHandler) Worker {
 		log.Logger.Error("Error loading config", "error", err)
 		os.Exit(1)
 	}
+	otelaws.AppendMiddlewares(&cfg.APIOptions)
 
 	// Create new STS client
 	stsClient := sts.NewFromConfig(cfg, func(o *sts.Options) {
@@ -372,9 +699,26 @@ func NewWorker(baseHandler BaseHandler) Worker {
 		)
 	})
 
+	resourcePools := map[string]*WorkerPool{}
+	for _, name := range resourceWorkerPools {
+		resourcePools[name] = NewWorkerPool(name)
+	}
+
+	// MAX_CONCURRENT_JOBS limits the number of resource jobs executed at once,
+	// across all the pools. 0 means no limit other than the size of the pools.
+	var limit chan struct{}
+	if max := envInt("MAX_CONCURRENT_JOBS", 0); max > 0 {
+		limit = make(chan struct{}, max)
+	}
+
 	return Worker{
 		Dbpool:             baseHandler.dbpool,
 		AwsAccountProvider: baseHandler.awsAccountProvider,
 		StsClient:          stsClient,
+		Notifier:           baseHandler.notifier,
+		running:            &runningJobs{cancels: map[int]context.CancelCauseFunc{}},
+		placementPool:      NewWorkerPool("placement"),
+		resourcePools:      resourcePools,
+		limit:              limit,
 	}
 }
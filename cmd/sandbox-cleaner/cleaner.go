@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/models"
+)
+
+// Cleaner claims the AWS accounts to cleanup with a lease, cleans them up
+// with its executor and puts them back in the pool.
+// Several cleaners, and conan, can run side by side: an account is cleaned
+// up by only one of them, the one holding its lease.
+type Cleaner struct {
+	provider models.AwsAccountCleanupProvider
+	executor Executor
+	policy   models.CleanupPolicy
+	// The cleanup attempts are recorded in the database of the API, if set
+	dbpool *pgxpool.Pool
+
+	// holder is the name of the cleaner in the leases, the conan_hostname of the accounts
+	holder string
+	// Number of cleanups running in parallel
+	workers int
+	// Pause between each poll of the accounts to cleanup
+	pollInterval time.Duration
+	// Only the accounts matching filter are cleaned up, if set
+	filter *regexp.Regexp
+
+	// Accounts being cleaned up by this cleaner
+	mu      sync.Mutex
+	running map[string]bool
+	wg      sync.WaitGroup
+}
+
+// NewCleaner creates a new cleaner
+func NewCleaner(provider models.AwsAccountCleanupProvider, executor Executor, policy models.CleanupPolicy, dbpool *pgxpool.Pool, holder string, workers int, pollInterval time.Duration, filter *regexp.Regexp) *Cleaner {
+	return &Cleaner{
+		provider:     provider,
+		executor:     executor,
+		policy:       policy,
+		dbpool:       dbpool,
+		holder:       holder,
+		workers:      workers,
+		pollInterval: pollInterval,
+		filter:       filter,
+		running:      map[string]bool{},
+	}
+}
+
+// Run polls the accounts to cleanup until the context is cancelled, then
+// waits for the running cleanups to give their lease back.
+func (c *Cleaner) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		c.poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			log.Logger.Info("Waiting for the running cleanups", "count", c.runningCount())
+			c.wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Cleaner) runningCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.running)
+}
+
+// poll claims the accounts to cleanup, up to the number of workers available
+func (c *Cleaner) poll(ctx context.Context) {
+	accounts, err := c.provider.FetchAllToCleanup()
+	if err != nil {
+		log.Logger.Error("Error fetching the accounts to cleanup", "error", err)
+		return
+	}
+
+	now := time.Now()
+	states := map[string]int{"claimable": 0, "leased": 0, "max_retries": 0, "quarantined": 0}
+	claimable := []models.AwsAccount{}
+	for _, account := range accounts {
+		if c.filter != nil && !c.filter.MatchString(account.Name) {
+			continue
+		}
+		state := models.CleanupState(account, now, c.policy)
+		states[state]++
+		if state == "claimable" {
+			claimable = append(claimable, account)
+		}
+	}
+	for state, count := range states {
+		accountsToCleanup.WithLabelValues(state).Set(float64(count))
+	}
+
+	for _, account := range claimable {
+		if ctx.Err() != nil {
+			return
+		}
+
+		c.mu.Lock()
+		if len(c.running) >= c.workers || c.running[account.Name] {
+			full := len(c.running) >= c.workers
+			c.mu.Unlock()
+			if full {
+				return
+			}
+			continue
+		}
+
+		if err := c.provider.ClaimCleanup(account.Name, c.holder, c.policy); err != nil {
+			c.mu.Unlock()
+			if err == models.ErrCleanupLeaseTaken {
+				claimConflicts.Inc()
+				log.Logger.Debug("Account claimed by another cleaner", "name", account.Name)
+			}
+			continue
+		}
+		c.running[account.Name] = true
+		c.mu.Unlock()
+
+		c.wg.Add(1)
+		go c.cleanup(ctx, account)
+	}
+}
+
+// cleanup cleans up an account claimed, renewing its lease until done
+func (c *Cleaner) cleanup(ctx context.Context, account models.AwsAccount) {
+	defer c.wg.Done()
+	defer func() {
+		c.mu.Lock()
+		delete(c.running, account.Name)
+		c.mu.Unlock()
+	}()
+
+	cleanupsRunning.Inc()
+	defer cleanupsRunning.Dec()
+
+	log.Logger.Info("Cleanup starting", "name", account.Name, "attempt", account.ConanCleanupCount+1)
+	start := time.Now()
+	attempt := c.recordStart(account.Name)
+
+	leaseCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	leaseLost := make(chan struct{})
+	go c.renewLease(leaseCtx, cancel, account.Name, leaseLost)
+
+	err := c.executor.Cleanup(leaseCtx, account)
+	cancel()
+
+	result := "success"
+	// The error recorded with the attempt
+	var cleanupErr error
+	select {
+	case <-leaseLost:
+		// Another cleaner may own the account now, don't touch it
+		result = "lease_lost"
+		log.Logger.Error("Cleanup lease lost", "name", account.Name)
+
+	default:
+		switch {
+		case ctx.Err() != nil:
+			// Shutting down, give the lease back without counting a failure
+			result = "interrupted"
+			log.Logger.Warn("Cleanup interrupted", "name", account.Name)
+			c.release(account.Name, false)
+
+		case err == errNoop:
+			result = "noop"
+			c.release(account.Name, false)
+
+		case err != nil:
+			result = "failed"
+			cleanupErr = err
+			log.Logger.Error("Cleanup failed", "name", account.Name, "error", err,
+				"attempt", account.ConanCleanupCount+1, "max_retries", c.policy.MaxRetries)
+
+			if !c.policy.ShouldQuarantine(account.ConanCleanupCount + 1) {
+				c.release(account.Name, true)
+				break
+			}
+
+			// The lease is given back with the account quarantined, so no other
+			// cleaner can claim it in between
+			result = "quarantined"
+			log.Logger.Warn("Account quarantined", "name", account.Name, "failed_cleanups", account.ConanCleanupCount+1)
+			if err := c.provider.QuarantineCleanup(account.Name, c.holder, models.CleanupErrorSummary(err)); err != nil {
+				providerErrors.WithLabelValues("quarantine").Inc()
+				log.Logger.Error("Error quarantining the account", "name", account.Name, "error", err)
+				if err != models.ErrCleanupLeaseTaken {
+					// Count the failure at least, the account is quarantined on the next one
+					c.release(account.Name, true)
+				}
+			}
+
+		default:
+			if err := c.provider.CompleteCleanup(account.Name, c.holder); err != nil {
+				result = "lease_lost"
+				cleanupErr = err
+				log.Logger.Error("Error putting the account back in the pool", "name", account.Name, "error", err)
+				break
+			}
+			log.Logger.Info("Cleanup done, account back in the pool", "name", account.Name,
+				"reservation", account.Reservation, "duration", time.Since(start).Round(time.Second))
+		}
+	}
+
+	cleanups.WithLabelValues(result).Inc()
+	cleanupDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	c.recordEnd(attempt, result, cleanupErr)
+}
+
+// release gives the lease of the account back, counting a failure if failed
+func (c *Cleaner) release(name string, failed bool) {
+	if err := c.provider.ReleaseCleanup(name, c.holder, failed); err != nil {
+		providerErrors.WithLabelValues("release").Inc()
+		log.Logger.Error("Error releasing the cleanup lease", "name", name, "failed", failed, "error", err)
+	}
+}
+
+// recordStart records the start of a cleanup attempt, if the cleaner has a database.
+// The cleanup runs even if the attempt can't be recorded.
+func (c *Cleaner) recordStart(name string) *models.AwsAccountCleanup {
+	if c.dbpool == nil {
+		return nil
+	}
+	attempt, err := models.StartAwsAccountCleanup(c.dbpool, name, c.holder)
+	if err != nil {
+		log.Logger.Error("Error recording the cleanup attempt", "name", name, "error", err)
+		return nil
+	}
+	return attempt
+}
+
+// recordEnd records the end of a cleanup attempt started by recordStart
+func (c *Cleaner) recordEnd(attempt *models.AwsAccountCleanup, result string, cleanupErr error) {
+	if attempt == nil {
+		return
+	}
+	if err := attempt.End(c.dbpool, result, cleanupErr); err != nil {
+		log.Logger.Error("Error recording the end of the cleanup attempt", "name", attempt.AccountName, "error", err)
+	}
+}
+
+// renewLease renews the lease of the account until ctx is done. If the lease
+// is lost, leaseLost is closed and the cleanup is cancelled.
+func (c *Cleaner) renewLease(ctx context.Context, cancel context.CancelFunc, name string, leaseLost chan struct{}) {
+	ticker := time.NewTicker(c.policy.Lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := c.provider.RenewCleanupLease(name, c.holder); err == models.ErrCleanupLeaseTaken {
+			close(leaseLost)
+			cancel()
+			return
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/models"
+)
+
+// Executor cleans up an AWS account. The context is cancelled when the
+// cleaner loses the lease of the account or shuts down.
+type Executor interface {
+	Cleanup(ctx context.Context, account models.AwsAccount) error
+}
+
+// errNoop is returned by NoopExecutor: the lease is given back, the account
+// stays to cleanup.
+var errNoop = errors.New("noop")
+
+// NoopExecutor doesn't touch the accounts, see the noop option of conan
+type NoopExecutor struct{}
+
+func (e *NoopExecutor) Cleanup(ctx context.Context, account models.AwsAccount) error {
+	log.Logger.Info("Cleanup skipped (noop)", "name", account.Name)
+	return errNoop
+}
+
+// AwsNukeExecutor runs aws-nuke on the account. The configuration of aws-nuke
+// is rendered for each account from a Go template, with the fields of
+// models.AwsAccount, ex: {{ .AccountID }}, {{ .Name }}, {{ .Zone }}.
+// aws-nuke assumes the role RoleName in the account, with the credentials of
+// the environment (the pool manager).
+type AwsNukeExecutor struct {
+	Binary   string
+	Config   *template.Template
+	RoleName string
+	// Appended to the name of the account to build its alias, asked by aws-nuke
+	AliasSuffix string
+	// The configurations and the logs are written there
+	Workdir string
+	// Number of times aws-nuke is run again if it fails
+	Retries int
+}
+
+// NewAwsNukeExecutor loads the configuration template of aws-nuke
+func NewAwsNukeExecutor(binary string, configTemplate string, roleName string, aliasSuffix string, workdir string, retries int) (*AwsNukeExecutor, error) {
+	config, err := template.ParseFiles(configTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(workdir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &AwsNukeExecutor{
+		Binary:      binary,
+		Config:      config,
+		RoleName:    roleName,
+		AliasSuffix: aliasSuffix,
+		Workdir:     workdir,
+		Retries:     retries,
+	}, nil
+}
+
+func (e *AwsNukeExecutor) Cleanup(ctx context.Context, account models.AwsAccount) error {
+	if account.AccountID == "" {
+		return errors.New("account ID missing")
+	}
+
+	var config bytes.Buffer
+	if err := e.Config.Execute(&config, account); err != nil {
+		return err
+	}
+	configFile := filepath.Join(e.Workdir, account.Name+"_nuke-config.yml")
+	if err := os.WriteFile(configFile, config.Bytes(), 0o600); err != nil {
+		return err
+	}
+
+	var err error
+	for attempt := 0; attempt <= e.Retries; attempt++ {
+		if err = e.run(ctx, account, configFile); err == nil || ctx.Err() != nil {
+			return err
+		}
+		log.Logger.Warn("aws-nuke failed", "name", account.Name, "attempt", attempt+1, "error", err)
+	}
+	return err
+}
+
+// run runs aws-nuke once, its output is kept in the workdir to troubleshoot
+func (e *AwsNukeExecutor) run(ctx context.Context, account models.AwsAccount, configFile string) error {
+	logFile := filepath.Join(e.Workdir, "reset_"+account.Name+".log")
+	// Keep the previous log to help troubleshooting
+	os.Rename(logFile, logFile+".1")
+	out, err := os.Create(logFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	cmd := exec.CommandContext(ctx, e.Binary, "nuke",
+		"-c", configFile,
+		"--assume-role-arn", fmt.Sprintf("arn:aws:iam::%s:role/%s", account.AccountID, e.RoleName),
+		"--no-dry-run",
+		"--force",
+	)
+	cmd.Stdin = strings.NewReader(account.Name + e.AliasSuffix + "\n")
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("aws-nuke: %w, see %s", err, logFile)
+	}
+	return nil
+}
+
+// CommandExecutor runs a command with the name of the account as last
+// argument. The command must exit 0 once the account is clean, and leave the
+// account in DynamoDB to the cleaner, which puts it back in the pool.
+type CommandExecutor struct {
+	Command []string
+}
+
+func (e *CommandExecutor) Cleanup(ctx context.Context, account models.AwsAccount) error {
+	args := append(append([]string{}, e.Command[1:]...), account.Name)
+	cmd := exec.CommandContext(ctx, e.Command[0], args...)
+	cmd.Env = append(os.Environ(),
+		"SANDBOX_NAME="+account.Name,
+		"SANDBOX_ACCOUNT_ID="+account.AccountID,
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", e.Command[0], err, lastLines(string(output), 20))
+	}
+	return nil
+}
+
+// lastLines returns the last n lines of s
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
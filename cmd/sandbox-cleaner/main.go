@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	sandboxdb "github.com/rhpds/sandbox/internal/dynamodb"
+	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/models"
+)
+
+// Build info
+var Version = "development"
+var buildTime = "undefined"
+var buildCommit = "HEAD"
+
+// envString returns the environment variable name, or def if not set
+func envString(name string, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// envInt returns the environment variable name as an int, or def if not set or invalid
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		log.Logger.Error("Invalid value, using default", "variable", name, "value", v, "default", def)
+		return def
+	}
+	return i
+}
+
+// envDuration returns the environment variable name as a duration, or def if not set or invalid
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Logger.Error("Invalid value, using default", "variable", name, "value", v, "default", def)
+		return def
+	}
+	return d
+}
+
+func main() {
+	buildInfo := []slog.Attr{
+		slog.String("version", Version),
+		slog.String("buildTime", buildTime),
+		slog.String("buildCommit", buildCommit),
+	}
+	log.InitLoggers(os.Getenv("DEBUG") == "true", buildInfo)
+
+	hostname, _ := os.Hostname()
+	policy := models.CleanupPolicyFromEnv()
+
+	var (
+		debugFlag    bool
+		noop         bool
+		executorName string
+		holder       string
+		workers      int
+		pollInterval time.Duration
+		filter       string
+		workdir      string
+		metricsPort  string
+
+		nukeBinary      string
+		nukeConfig      string
+		nukeRole        string
+		nukeAliasSuffix string
+		nukeRetries     int
+
+		command string
+	)
+
+	flag.BoolVar(&debugFlag, "debug", os.Getenv("DEBUG") == "true", "Debug mode.\nEnvironment variable: DEBUG\n")
+	flag.BoolVar(&noop, "noop", os.Getenv("CLEANER_NOOP") == "true", "Claim the accounts without touching them, they stay to cleanup.\nEnvironment variable: CLEANER_NOOP\n")
+	flag.StringVar(&executorName, "executor", envString("CLEANER_EXECUTOR", "aws-nuke"), "Cleanup executor: aws-nuke or command.\nEnvironment variable: CLEANER_EXECUTOR\n")
+	flag.StringVar(&holder, "holder", envString("CLEANER_HOLDER", hostname), "Name of the cleaner in the leases.\nEnvironment variable: CLEANER_HOLDER\n")
+	flag.IntVar(&workers, "workers", envInt("CLEANER_WORKERS", 12), "Number of cleanups running in parallel.\nEnvironment variable: CLEANER_WORKERS\n")
+	flag.DurationVar(&pollInterval, "poll-interval", envDuration("CLEANER_POLL_INTERVAL", time.Minute), "Pause between each poll of the accounts to cleanup.\nEnvironment variable: CLEANER_POLL_INTERVAL\n")
+	flag.DurationVar(&policy.Lease, "lease", policy.Lease, "The lease of an account expires after this duration if it's not renewed.\nEnvironment variable: CLEANER_LEASE\n")
+	flag.IntVar(&policy.MaxRetries, "max-retries", policy.MaxRetries, "Failed cleanups before pausing the account.\nEnvironment variable: CLEANER_MAX_RETRIES\n")
+	flag.DurationVar(&policy.RetryAfter, "retry-after", policy.RetryAfter, "Pause of the accounts after max-retries failed cleanups.\nEnvironment variable: CLEANER_RETRY_AFTER\n")
+	flag.IntVar(&policy.QuarantineAfter, "quarantine-after", policy.QuarantineAfter, "Failed cleanups before quarantining the account, 0 to disable.\nEnvironment variable: CLEANER_QUARANTINE_AFTER\n")
+	flag.StringVar(&filter, "filter", os.Getenv("CLEANER_FILTER"), "Regular expression, only the accounts matching it are cleaned up.\nEnvironment variable: CLEANER_FILTER\n")
+	flag.StringVar(&workdir, "workdir", envString("CLEANER_WORKDIR", "/tmp/sandbox-cleaner"), "Directory of the aws-nuke configurations and logs.\nEnvironment variable: CLEANER_WORKDIR\n")
+	flag.StringVar(&metricsPort, "metrics-port", envString("METRICS_PORT", "2112"), "Port of the Prometheus metrics.\nEnvironment variable: METRICS_PORT\n")
+
+	flag.StringVar(&nukeBinary, "aws-nuke-binary", envString("AWS_NUKE_BINARY", "aws-nuke"), "Path of aws-nuke.\nEnvironment variable: AWS_NUKE_BINARY\n")
+	flag.StringVar(&nukeConfig, "aws-nuke-config", os.Getenv("AWS_NUKE_CONFIG_TEMPLATE"), "Go template of the aws-nuke configuration.\nEnvironment variable: AWS_NUKE_CONFIG_TEMPLATE\n")
+	flag.StringVar(&nukeRole, "aws-nuke-role", envString("AWS_NUKE_ROLE", "OrganizationAccountAccessRole"), "Role assumed by aws-nuke in the accounts.\nEnvironment variable: AWS_NUKE_ROLE\n")
+	flag.StringVar(&nukeAliasSuffix, "aws-nuke-alias-suffix", envString("AWS_NUKE_ALIAS_SUFFIX", "-gpte"), "Suffix of the alias of the accounts.\nEnvironment variable: AWS_NUKE_ALIAS_SUFFIX\n")
+	flag.IntVar(&nukeRetries, "aws-nuke-retries", envInt("AWS_NUKE_RETRIES", 0), "Number of times aws-nuke is run again if it fails.\nEnvironment variable: AWS_NUKE_RETRIES\n")
+
+	flag.StringVar(&command, "command", os.Getenv("CLEANER_COMMAND"), "Command of the executor 'command', the name of the account is appended.\nEnvironment variable: CLEANER_COMMAND\n")
+	flag.Parse()
+
+	if debugFlag {
+		log.InitLoggers(debugFlag, buildInfo)
+	}
+	if workers < 1 {
+		log.Err.Fatal("workers must be at least 1")
+	}
+
+	sandboxdb.CheckEnv()
+
+	var executor Executor
+	switch {
+	case noop:
+		executor = &NoopExecutor{}
+	case executorName == "aws-nuke":
+		if nukeConfig == "" {
+			log.Err.Fatal("AWS_NUKE_CONFIG_TEMPLATE is required by the executor aws-nuke")
+		}
+		e, err := NewAwsNukeExecutor(nukeBinary, nukeConfig, nukeRole, nukeAliasSuffix, workdir, nukeRetries)
+		if err != nil {
+			log.Err.Fatal(err)
+		}
+		executor = e
+	case executorName == "command":
+		if strings.TrimSpace(command) == "" {
+			log.Err.Fatal("CLEANER_COMMAND is required by the executor command")
+		}
+		executor = &CommandExecutor{Command: strings.Fields(command)}
+	default:
+		log.Err.Fatalf("Unknown executor '%s', must be aws-nuke or command", executorName)
+	}
+
+	// The cleanup attempts are recorded in the database of the API, if any
+	var dbPool *pgxpool.Pool
+	if os.Getenv("DATABASE_URL") != "" {
+		var err error
+		dbPool, err = pgxpool.Connect(context.Background(), os.Getenv("DATABASE_URL"))
+		if err != nil {
+			log.Err.Fatal(err)
+		}
+		defer dbPool.Close()
+	} else {
+		log.Logger.Warn("DATABASE_URL not set, the cleanup attempts are not recorded")
+	}
+
+	var filterRegexp *regexp.Regexp
+	if filter != "" {
+		var err error
+		if filterRegexp, err = regexp.Compile(filter); err != nil {
+			log.Err.Fatalf("Invalid CLEANER_FILTER '%s': %v", filter, err)
+		}
+	}
+
+	go func() {
+		log.Logger.Info("Metrics listening", "port", metricsPort)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		if err := http.ListenAndServe(":"+metricsPort, mux); err != nil {
+			log.Err.Fatal(err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	log.Logger.Info("Cleaner starting", "holder", holder, "executor", executorName, "noop", noop,
+		"workers", workers, "lease", policy.Lease, "max_retries", policy.MaxRetries, "retry_after", policy.RetryAfter,
+		"quarantine_after", policy.QuarantineAfter)
+
+	NewCleaner(
+		sandboxdb.NewAwsAccountDynamoDBProvider(),
+		executor,
+		policy,
+		dbPool,
+		holder,
+		workers,
+		pollInterval,
+		filterRegexp,
+	).Run(ctx)
+
+	log.Logger.Info("Cleaner stopped")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// cleanups counts the cleanups by result: 'success', 'failed', 'quarantined', 'lease_lost', 'interrupted' or 'noop'.
+	// 'quarantined' is a failed cleanup that quarantined the account.
+	cleanups = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sandbox_cleaner_cleanups_total",
+			Help: "Cleanups of AWS accounts by result",
+		},
+		[]string{"result"},
+	)
+
+	// cleanupDuration is the time to cleanup an account, by result
+	cleanupDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "sandbox_cleaner_cleanup_duration_seconds",
+			Help:    "Time to cleanup an AWS account, by result",
+			Buckets: []float64{60, 120, 300, 600, 900, 1200, 1800, 2700, 3600, 5400, 7200},
+		},
+		[]string{"result"},
+	)
+
+	// cleanupsRunning is the number of cleanups running in this cleaner
+	cleanupsRunning = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sandbox_cleaner_cleanups_running",
+		Help: "Cleanups running in this cleaner",
+	})
+
+	// claimConflicts counts the accounts claimed by another cleaner or conan first
+	claimConflicts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sandbox_cleaner_claim_conflicts_total",
+		Help: "Accounts claimed by another cleaner first",
+	})
+
+	// providerErrors counts the errors updating the accounts in DynamoDB, by operation: 'release' or 'quarantine'
+	providerErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sandbox_cleaner_provider_errors_total",
+			Help: "Errors updating the AWS accounts, by operation",
+		},
+		[]string{"operation"},
+	)
+
+	// accountsToCleanup is the number of accounts to cleanup, and how many can be claimed
+	accountsToCleanup = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sandbox_cleaner_accounts_to_cleanup",
+			Help: "AWS accounts to cleanup, by state: 'claimable', 'leased', 'max_retries' or 'quarantined'",
+		},
+		[]string{"state"},
+	)
+)
@@ -19,6 +19,7 @@ var csvFlag bool
 var allFlag bool
 var debugFlag bool
 var toCleanupFlag bool
+var quarantinedFlag bool
 var noHeadersFlag bool
 var padding = 2
 var versionFlag bool
@@ -51,7 +52,9 @@ func (a accountPrint) String() string {
 	var toCleanupString string
 	/* Do not write true | false to not break current scripts that filter
 	   using true|false on the whole line */
-	if a.ToCleanup {
+	if a.Quarantined {
+		toCleanupString = "QUARANTINED"
+	} else if a.ToCleanup {
 		if a.ConanStatus == "cleanup in progress" {
 			toCleanupString = fmt.Sprintf("IN_PROGRESS (%s)", a.ConanHostname)
 		} else {
@@ -118,6 +121,7 @@ func parseFlags() {
 	flag.BoolVar(&csvFlag, "csv", false, "Use CSV format to print accounts.")
 	flag.BoolVar(&allFlag, "all", false, "Just print all sandboxes.")
 	flag.BoolVar(&toCleanupFlag, "to-cleanup", false, "Print all marked for cleanup.")
+	flag.BoolVar(&quarantinedFlag, "quarantined", false, "Print all quarantined, with the reason of the quarantine.")
 	flag.BoolVar(&noHeadersFlag, "no-headers", false, "Don't print headers.")
 	flag.BoolVar(&debugFlag, "debug", false, "Debug mode.\nEnvironment variable: DEBUG\n")
 	flag.BoolVar(&versionFlag, "version", false, "Print build version.")
@@ -210,6 +214,31 @@ func printBroken(accounts []models.AwsAccount) {
 	}
 }
 
+// printQuarantined prints the sandboxes quarantined after too many failed cleanups, see sandbox-cleaner
+func printQuarantined(accounts []models.AwsAccount) {
+	m := []string{}
+	for _, sandbox := range accounts {
+		if !sandbox.Quarantined {
+			continue
+		}
+		var since string
+		if sandbox.QuarantinedAt != nil {
+			since = sandbox.QuarantinedAt.Format("2006-01-02 15:04")
+		}
+		m = append(m, fmt.Sprintf("%v %v (%s)\n", accountPrint(sandbox), sandbox.QuarantineReason, since))
+	}
+	if len(m) > 0 {
+		fmt.Println()
+		fmt.Println("# Quarantined sandboxes")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, padding, ' ', 0)
+		printHeaders(w)
+		for _, line := range m {
+			fmt.Fprint(w, line)
+		}
+		w.Flush()
+	}
+}
+
 func main() {
 	parseFlags()
 	log.InitLoggers(debugFlag, []slog.Attr{
@@ -238,6 +267,11 @@ func main() {
 		if err != nil {
 			log.Err.Fatal(err)
 		}
+	} else if quarantinedFlag {
+		accounts, err = accountProvider.FetchAllQuarantined()
+		if err != nil {
+			log.Err.Fatal(err)
+		}
 	} else {
 		accounts, err = accountProvider.FetchAll()
 		if err != nil {
@@ -255,6 +289,11 @@ func main() {
 		log.Err.Fatal(err)
 	}
 
+	if quarantinedFlag {
+		printQuarantined(accounts)
+		os.Exit(0)
+	}
+
 	if allFlag || toCleanupFlag {
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, padding, ' ', 0)
 		printHeaders(w)
@@ -272,4 +311,5 @@ func main() {
 	printMostRecentlyUsed(accounts)
 	printOldest(accounts)
 	printBroken(accounts)
+	printQuarantined(accounts)
 }
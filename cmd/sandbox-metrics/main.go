@@ -63,6 +63,38 @@ func createMetrics(dbPool *pgxpool.Pool) {
 		[]string{"resource_type", "cluster_name", "to_cleanup", "status"},
 	)
 
+	gaugeReservationAccounts := promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sandbox_reservation_aws_accounts",
+			Help: "AWS accounts of the reservations, by state: reserved, in_use, available, to_cleanup",
+		},
+		[]string{"reservation", "state"},
+	)
+
+	gaugeReservationUtilization := promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sandbox_reservation_utilization",
+			Help: "Ratio of the AWS accounts of the reservations in use",
+		},
+		[]string{"reservation"},
+	)
+
+	gaugeReservationTarget := promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sandbox_reservation_target",
+			Help: "AWS accounts requested by the reservations",
+		},
+		[]string{"reservation", "status"},
+	)
+
+	gaugeReservationOcpSandboxes := promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sandbox_reservation_ocp_sandboxes",
+			Help: "OcpSandboxes of the reservations",
+		},
+		[]string{"reservation"},
+	)
+
 	used := promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "aws_sandbox_total_used",
 		Help: "Total accounts in use",
@@ -73,6 +105,11 @@ func createMetrics(dbPool *pgxpool.Pool) {
 		Help: "Total accounts in the queue to be cleaned up",
 	})
 
+	quarantined := promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "aws_sandbox_total_quarantined",
+		Help: "Total accounts quarantined after too many failed cleanups",
+	})
+
 	total := promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "aws_sandbox_total",
 		Help: "Total accounts",
@@ -93,6 +130,13 @@ func createMetrics(dbPool *pgxpool.Pool) {
 			used.Set(float64(models.CountUsed(accounts)))
 			toCleanup.Set(float64(models.CountToCleanup(accounts)))
 			total.Set(float64(len(accounts)))
+			quarantinedCount := 0
+			for _, sandbox := range accounts {
+				if sandbox.Quarantined {
+					quarantinedCount++
+				}
+			}
+			quarantined.Set(float64(quarantinedCount))
 			gaugeVec.Reset()
 			for _, sandbox := range accounts {
 
@@ -118,6 +162,18 @@ func createMetrics(dbPool *pgxpool.Pool) {
 					toCleanup,
 					sandbox.Reservation).Set(value)
 			}
+
+			gaugeReservationAccounts.Reset()
+			gaugeReservationUtilization.Reset()
+			for reservation, counts := range models.CountAwsReservations(accounts) {
+				gaugeReservationAccounts.WithLabelValues(reservation, "reserved").Set(float64(counts.Reserved))
+				gaugeReservationAccounts.WithLabelValues(reservation, "in_use").Set(float64(counts.InUse))
+				gaugeReservationAccounts.WithLabelValues(reservation, "available").Set(float64(counts.Available))
+				gaugeReservationAccounts.WithLabelValues(reservation, "to_cleanup").Set(float64(counts.ToCleanup))
+				if counts.Reserved > 0 {
+					gaugeReservationUtilization.WithLabelValues(reservation).Set(float64(counts.InUse) / float64(counts.Reserved))
+				}
+			}
 			time.Sleep(interval)
 		}
 	}()
@@ -167,6 +223,20 @@ func createMetrics(dbPool *pgxpool.Pool) {
 				).Set(float64(stat.Count))
 			}
 
+			reservationStats, err := GetReservationStats(dbPool)
+			if err != nil {
+				log.Err.Println(err)
+				time.Sleep(interval)
+				continue
+			}
+
+			gaugeReservationTarget.Reset()
+			gaugeReservationOcpSandboxes.Reset()
+			for _, stat := range reservationStats {
+				gaugeReservationTarget.WithLabelValues(stat.Name, stat.Status).Set(float64(stat.Target))
+				gaugeReservationOcpSandboxes.WithLabelValues(stat.Name).Set(float64(stat.OcpSandboxes))
+			}
+
 			time.Sleep(interval)
 		}
 	}()
@@ -184,8 +254,8 @@ func GetLifecycleInstanceEvents(dbpool *pgxpool.Pool) ([]LifecycleEvent, error)
 	rows, err := dbpool.Query(
 		context.Background(),
 		`SELECT resource_type,
-                event_data->>'region' as iregion,
-                event_data->>'instance_type' as itype,
+                COALESCE(event_data->>'region', '') as iregion,
+                COALESCE(event_data->>'instance_type', '') as itype,
                 event_type, count(*)
          FROM lifecycle_events GROUP BY resource_type, iregion, itype, event_type;`,
 	)
@@ -259,6 +329,50 @@ func GetOcpSandboxStats(dbPool *pgxpool.Pool) ([]OcpSandboxStats, error) {
 	return stats, nil
 }
 
+type ReservationStats struct {
+	Name         string
+	Status       string
+	Target       int
+	OcpSandboxes int
+}
+
+func GetReservationStats(dbPool *pgxpool.Pool) ([]ReservationStats, error) {
+	rows, err := dbPool.Query(
+		context.Background(),
+		`SELECT
+			r.reservation_name,
+			r.status,
+			r.target,
+			(SELECT count(*) FROM resources
+			 WHERE resource_type = 'OcpSandbox'
+				AND resource_data->>'reservation' = r.reservation_name)
+		FROM reservations r;`,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	stats := []ReservationStats{}
+
+	for rows.Next() {
+		var stat ReservationStats
+		err := rows.Scan(
+			&stat.Name,
+			&stat.Status,
+			&stat.Target,
+			&stat.OcpSandboxes)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+
+	return stats, nil
+}
+
 // Build info
 var Version = "development"
 var buildTime = "undefined"
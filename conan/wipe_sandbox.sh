@@ -109,13 +109,14 @@ EOM
     #   or conan_timestamp is older than lock_timeout
     # - conan_cleanup_count is less than max_retries
     #   or conan_timestamp is older than 24h
+    # - the sandbox is not quarantined, see sandbox-cleaner
     if ! "${AWSCLI}" --profile "${dynamodb_profile}" \
         --region "${dynamodb_region}" \
         dynamodb update-item \
         --table-name "${dynamodb_table}" \
         --key "{\"name\": {\"S\": \"${sandbox}\"}}" \
         --update-expression "SET available = :false, conan_status = :st, conan_timestamp = :timestamp, conan_hostname = :host" \
-        --condition-expression "to_cleanup = :true AND (conan_status <> :st OR conan_timestamp < :old) AND (attribute_not_exists(conan_cleanup_count) OR conan_cleanup_count < :maxretries OR conan_timestamp < :old24h)" \
+        --condition-expression "to_cleanup = :true AND (conan_status <> :st OR conan_timestamp < :old) AND (attribute_not_exists(conan_cleanup_count) OR conan_cleanup_count < :maxretries OR conan_timestamp < :old24h) AND (attribute_not_exists(quarantined) OR quarantined = :false)" \
         --expression-attribute-values "${data}" \
         2> "${errlog}"
     then
@@ -0,0 +1,6 @@
+BEGIN;
+DROP TRIGGER IF EXISTS lifecycle_events_insert ON lifecycle_events;
+DROP FUNCTION IF EXISTS lifecycle_events_notify();
+DROP INDEX IF EXISTS lifecycle_events_request_id_idx;
+ALTER TABLE lifecycle_events DROP COLUMN IF EXISTS request_id;
+COMMIT;
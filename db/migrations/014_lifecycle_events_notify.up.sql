@@ -0,0 +1,21 @@
+BEGIN;
+-- Link the lifecycle events to the request that produced them
+ALTER TABLE lifecycle_events ADD COLUMN request_id VARCHAR(128) NULL DEFAULT NULL;
+CREATE INDEX lifecycle_events_request_id_idx ON lifecycle_events (request_id);
+
+-- Notify when a lifecycle event is written, used to stream the events of a request
+CREATE OR REPLACE FUNCTION lifecycle_events_notify()
+	RETURNS trigger AS
+$$
+BEGIN
+	PERFORM pg_notify('lifecycle_events_channel', NEW.id::text);
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER lifecycle_events_insert
+	AFTER INSERT
+	ON lifecycle_events
+	FOR EACH ROW
+EXECUTE PROCEDURE lifecycle_events_notify();
+COMMIT;
@@ -0,0 +1,16 @@
+BEGIN;
+ALTER TABLE lifecycle_resource_jobs DROP COLUMN IF EXISTS completed_at;
+ALTER TABLE lifecycle_placement_jobs DROP COLUMN IF EXISTS completed_at;
+
+DROP TRIGGER IF EXISTS reservations_webhook ON reservations;
+DROP FUNCTION IF EXISTS reservations_webhook();
+DROP TRIGGER IF EXISTS resources_webhook ON resources;
+DROP FUNCTION IF EXISTS resources_webhook();
+DROP TRIGGER IF EXISTS placements_webhook ON placements;
+DROP FUNCTION IF EXISTS placements_webhook();
+DROP FUNCTION IF EXISTS webhook_emit(TEXT, jsonb);
+
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TYPE IF EXISTS webhook_delivery_status;
+DROP TABLE IF EXISTS webhook_subscriptions;
+COMMIT;
@@ -0,0 +1,165 @@
+BEGIN;
+-- Webhook subscriptions: an URL called for each event the subscription is interested in.
+CREATE TABLE webhook_subscriptions (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  name VARCHAR(128) NOT NULL UNIQUE,
+  url TEXT NOT NULL,
+  -- HMAC secret used to sign the payloads, encrypted with pgp_sym_encrypt
+  secret BYTEA NOT NULL,
+  -- Events the subscription receives, empty means all events
+  events TEXT[] NOT NULL DEFAULT '{}',
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at timestamp with time zone NOT NULL DEFAULT (now() at time zone 'utc'),
+  updated_at timestamp with time zone NOT NULL DEFAULT (now() at time zone 'utc')
+);
+
+CREATE TRIGGER webhook_subscriptions_updated_at
+  BEFORE UPDATE ON webhook_subscriptions
+  FOR EACH ROW
+  WHEN (OLD.* IS DISTINCT FROM NEW.*)
+  EXECUTE FUNCTION updated_at_column();
+
+-- Webhook deliveries: one row per event and subscription.
+-- It's both the queue of the deliveries and the delivery log.
+CREATE TYPE webhook_delivery_status AS ENUM ('pending', 'delivering', 'success', 'failed');
+
+CREATE TABLE webhook_deliveries (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  subscription_id BIGINT NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+  event_id uuid NOT NULL,
+  event_type TEXT NOT NULL,
+  payload jsonb NOT NULL DEFAULT '{}',
+  status webhook_delivery_status NOT NULL DEFAULT 'pending',
+  attempts INT NOT NULL DEFAULT 0,
+  next_attempt_at timestamp with time zone NOT NULL DEFAULT (now() at time zone 'utc'),
+  last_attempt_at timestamp with time zone NULL,
+  last_status_code INT NULL,
+  last_error TEXT NULL,
+  locality VARCHAR(64) NULL,
+  created_at timestamp with time zone NOT NULL DEFAULT (now() at time zone 'utc'),
+  updated_at timestamp with time zone NOT NULL DEFAULT (now() at time zone 'utc')
+);
+
+CREATE TRIGGER webhook_deliveries_updated_at
+  BEFORE UPDATE ON webhook_deliveries
+  FOR EACH ROW
+  WHEN (OLD.* IS DISTINCT FROM NEW.*)
+  EXECUTE FUNCTION updated_at_column();
+
+CREATE INDEX webhook_deliveries_queue_idx ON webhook_deliveries (next_attempt_at)
+  WHERE status IN ('pending', 'delivering');
+CREATE INDEX webhook_deliveries_subscription_idx ON webhook_deliveries (subscription_id, id);
+
+-- webhook_emit queues an event for all the enabled subscriptions interested in it.
+-- It's used by the triggers below and by the API.
+CREATE OR REPLACE FUNCTION webhook_emit(p_event_type TEXT, p_data jsonb)
+  RETURNS void AS
+$$
+DECLARE
+  v_event_id uuid := gen_random_uuid();
+BEGIN
+  INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload)
+  SELECT s.id, v_event_id, p_event_type,
+         jsonb_build_object(
+           'id', v_event_id,
+           'type', p_event_type,
+           'created_at', now(),
+           'data', p_data
+         )
+  FROM webhook_subscriptions s
+  WHERE s.enabled
+  AND (cardinality(s.events) = 0 OR p_event_type = ANY(s.events));
+
+  IF FOUND THEN
+    PERFORM pg_notify('webhook_deliveries_channel', p_event_type);
+  END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Placements
+CREATE OR REPLACE FUNCTION placements_webhook()
+  RETURNS trigger AS
+$$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    PERFORM webhook_emit('placement.deleted', jsonb_build_object(
+      'service_uuid', OLD.service_uuid,
+      'annotations', OLD.annotations
+    ));
+    RETURN OLD;
+  END IF;
+
+  PERFORM webhook_emit('placement.created', jsonb_build_object(
+    'service_uuid', NEW.service_uuid,
+    'annotations', NEW.annotations,
+    'status', NEW.status
+  ));
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER placements_webhook
+  AFTER INSERT OR DELETE
+  ON placements
+  FOR EACH ROW
+  EXECUTE FUNCTION placements_webhook();
+
+-- Resources (OcpSandbox): ready or error
+CREATE OR REPLACE FUNCTION resources_webhook()
+  RETURNS trigger AS
+$$
+BEGIN
+  IF TG_OP = 'UPDATE' AND OLD.status = NEW.status THEN
+    RETURN NEW;
+  END IF;
+
+  IF NEW.status = 'success' THEN
+    PERFORM webhook_emit('resource.ready', jsonb_build_object(
+      'name', NEW.resource_name,
+      'kind', NEW.resource_type,
+      'service_uuid', NEW.service_uuid,
+      'status', NEW.status
+    ));
+  ELSIF NEW.status = 'error' THEN
+    PERFORM webhook_emit('resource.error', jsonb_build_object(
+      'name', NEW.resource_name,
+      'kind', NEW.resource_type,
+      'service_uuid', NEW.service_uuid,
+      'status', NEW.status
+    ));
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER resources_webhook
+  AFTER INSERT OR UPDATE OF status
+  ON resources
+  FOR EACH ROW
+  EXECUTE FUNCTION resources_webhook();
+
+-- Reservations
+CREATE OR REPLACE FUNCTION reservations_webhook()
+  RETURNS trigger AS
+$$
+BEGIN
+  PERFORM webhook_emit('reservation.updated', jsonb_build_object(
+    'name', NEW.reservation_name,
+    'status', NEW.status,
+    'request', NEW.request
+  ));
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER reservations_webhook
+  AFTER UPDATE OF status
+  ON reservations
+  FOR EACH ROW
+  WHEN (OLD.status IS DISTINCT FROM NEW.status)
+  EXECUTE FUNCTION reservations_webhook();
+
+-- Lifecycle requests: completed_at is set once, when the request is done.
+ALTER TABLE lifecycle_placement_jobs ADD COLUMN completed_at timestamp with time zone NULL;
+ALTER TABLE lifecycle_resource_jobs ADD COLUMN completed_at timestamp with time zone NULL;
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+DROP INDEX IF EXISTS lifecycle_resource_jobs_parent_id_idx;
+DROP INDEX IF EXISTS lifecycle_placement_jobs_placement_id_idx;
+ALTER TABLE lifecycle_resource_jobs DROP COLUMN IF EXISTS error_message;
+ALTER TABLE lifecycle_placement_jobs DROP COLUMN IF EXISTS error_message;
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+-- Keep the error of the failed jobs, not only in the logs
+ALTER TABLE lifecycle_placement_jobs ADD COLUMN error_message TEXT NULL;
+ALTER TABLE lifecycle_resource_jobs ADD COLUMN error_message TEXT NULL;
+
+CREATE INDEX lifecycle_placement_jobs_placement_id_idx ON lifecycle_placement_jobs (placement_id);
+CREATE INDEX lifecycle_resource_jobs_parent_id_idx ON lifecycle_resource_jobs (parent_id);
+COMMIT;
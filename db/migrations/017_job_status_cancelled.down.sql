@@ -0,0 +1,33 @@
+BEGIN;
+-- Values can't be removed from an enum, recreate the type without 'cancelled'
+UPDATE lifecycle_placement_jobs SET status = 'error' WHERE status = 'cancelled';
+UPDATE lifecycle_resource_jobs SET status = 'error' WHERE status = 'cancelled';
+
+DROP TRIGGER IF EXISTS lifecycle_placement_jobs_status ON lifecycle_placement_jobs;
+DROP TRIGGER IF EXISTS lifecycle_resource_jobs_status ON lifecycle_resource_jobs;
+
+ALTER TABLE lifecycle_placement_jobs ALTER COLUMN status DROP DEFAULT;
+ALTER TABLE lifecycle_resource_jobs ALTER COLUMN status DROP DEFAULT;
+
+ALTER TYPE job_status RENAME TO job_status_old;
+CREATE TYPE job_status AS ENUM ('new', 'initializing', 'initialized', 'running', 'successfully_dispatched', 'success', 'error');
+
+ALTER TABLE lifecycle_placement_jobs ALTER COLUMN status TYPE job_status USING status::text::job_status;
+ALTER TABLE lifecycle_resource_jobs ALTER COLUMN status TYPE job_status USING status::text::job_status;
+DROP TYPE job_status_old;
+
+ALTER TABLE lifecycle_placement_jobs ALTER COLUMN status SET DEFAULT 'new';
+ALTER TABLE lifecycle_resource_jobs ALTER COLUMN status SET DEFAULT 'new';
+
+CREATE TRIGGER lifecycle_placement_jobs_status
+	AFTER INSERT OR UPDATE OF status
+	ON lifecycle_placement_jobs
+	FOR EACH ROW
+EXECUTE PROCEDURE lifecycle_placement_jobs_status_notify();
+
+CREATE TRIGGER lifecycle_resource_jobs_status
+	AFTER INSERT OR UPDATE OF status
+	ON lifecycle_resource_jobs
+	FOR EACH ROW
+EXECUTE PROCEDURE lifecycle_resource_jobs_status_notify();
+COMMIT;
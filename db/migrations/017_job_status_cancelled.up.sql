@@ -0,0 +1,3 @@
+-- A lifecycle request can be cancelled while in progress.
+-- ALTER TYPE ... ADD VALUE can't be used in a transaction block before Postgres 12.
+ALTER TYPE job_status ADD VALUE IF NOT EXISTS 'cancelled';
@@ -0,0 +1,3 @@
+BEGIN;
+DROP INDEX IF EXISTS lifecycle_resource_jobs_resource_name_idx;
+COMMIT;
@@ -0,0 +1,4 @@
+BEGIN;
+-- Find the jobs in progress of a resource when claiming a job
+CREATE INDEX lifecycle_resource_jobs_resource_name_idx ON lifecycle_resource_jobs (resource_name, status);
+COMMIT;
@@ -0,0 +1,4 @@
+BEGIN;
+ALTER TABLE lifecycle_resource_jobs DROP COLUMN IF EXISTS trace_context;
+ALTER TABLE lifecycle_placement_jobs DROP COLUMN IF EXISTS trace_context;
+COMMIT;
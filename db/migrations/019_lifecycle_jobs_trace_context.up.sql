@@ -0,0 +1,5 @@
+BEGIN;
+-- W3C trace context of the request that created the job, to continue the trace in the worker
+ALTER TABLE lifecycle_placement_jobs ADD COLUMN trace_context JSONB;
+ALTER TABLE lifecycle_resource_jobs ADD COLUMN trace_context JSONB;
+COMMIT;
@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE tokens DROP COLUMN IF EXISTS scope;
+COMMIT;
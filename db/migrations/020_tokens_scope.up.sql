@@ -0,0 +1,4 @@
+BEGIN;
+-- Scope of the token as defined in the JWT 'scope' claim, NULL if the token is not restricted
+ALTER TABLE tokens ADD COLUMN scope JSONB;
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+DROP TRIGGER IF EXISTS revoked_access_tokens_insert ON revoked_access_tokens;
+DROP TRIGGER IF EXISTS tokens_invalidate ON tokens;
+DROP FUNCTION IF EXISTS tokens_revocations_notify;
+DROP TABLE IF EXISTS revoked_access_tokens;
+DROP INDEX IF EXISTS tokens_parent_id_idx;
+ALTER TABLE tokens DROP COLUMN IF EXISTS parent_id;
+COMMIT;
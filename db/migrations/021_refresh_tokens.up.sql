@@ -0,0 +1,41 @@
+BEGIN;
+-- Login token a refresh token was issued from.
+-- Invalidating the login token invalidates its refresh tokens.
+ALTER TABLE tokens ADD COLUMN parent_id INTEGER REFERENCES tokens(id) ON DELETE CASCADE;
+CREATE INDEX tokens_parent_id_idx ON tokens (parent_id);
+
+-- Access tokens are not stored, the revoked ones are listed here until they expire
+CREATE TABLE revoked_access_tokens (
+    jti VARCHAR(64) PRIMARY KEY,          -- jti claim of the access token
+    expiration TIMESTAMP WITH TIME ZONE NOT NULL, -- after this time the token is expired anyway
+    created_at timestamp with time zone NOT NULL DEFAULT (now() at time zone 'utc')
+);
+
+-- Notify the revocations, the replicas keep the revocation list in memory.
+-- The payload is 'login:<id>' when a login token is invalidated,
+-- 'access:<jti>' when an access token is revoked.
+CREATE OR REPLACE FUNCTION tokens_revocations_notify()
+	RETURNS trigger AS
+$$
+BEGIN
+	IF TG_TABLE_NAME = 'revoked_access_tokens' THEN
+		PERFORM pg_notify('tokens_revocations_channel', 'access:' || NEW.jti);
+	ELSIF NEW.kind = 'login' AND OLD.valid AND NOT NEW.valid THEN
+		PERFORM pg_notify('tokens_revocations_channel', 'login:' || NEW.id::text);
+	END IF;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER tokens_invalidate
+	AFTER UPDATE OF valid
+	ON tokens
+	FOR EACH ROW
+EXECUTE PROCEDURE tokens_revocations_notify();
+
+CREATE TRIGGER revoked_access_tokens_insert
+	AFTER INSERT
+	ON revoked_access_tokens
+	FOR EACH ROW
+EXECUTE PROCEDURE tokens_revocations_notify();
+COMMIT;
@@ -0,0 +1,24 @@
+BEGIN;
+-- Audit log: one row per POST, PUT and DELETE call of the API
+CREATE TABLE audit_events (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  -- Actor, from the claims of the token
+  actor_name VARCHAR(255) NOT NULL DEFAULT '',
+  actor_role VARCHAR(255) NOT NULL DEFAULT '',
+  actor_jti VARCHAR(255) NOT NULL DEFAULT '',
+  -- Call
+  method VARCHAR(10) NOT NULL,
+  route TEXT NOT NULL,         -- route pattern, e.g. /api/v1/placements/{uuid}
+  path TEXT NOT NULL,
+  path_params JSONB NOT NULL DEFAULT '{}',
+  target TEXT NOT NULL DEFAULT '', -- what the call acted on: uuid, name, account or id
+  body JSONB NULL,             -- request body, the secrets are redacted
+  status_code INT NOT NULL,
+  request_id VARCHAR(128) NOT NULL DEFAULT '',
+  created_at timestamp with time zone NOT NULL DEFAULT (now() at time zone 'utc')
+);
+
+CREATE INDEX audit_events_created_at_idx ON audit_events (created_at);
+CREATE INDEX audit_events_actor_name_idx ON audit_events (actor_name, created_at);
+CREATE INDEX audit_events_target_idx ON audit_events (target, created_at);
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+DROP TABLE IF EXISTS client_rate_buckets;
+DROP TABLE IF EXISTS client_limits;
+DROP INDEX IF EXISTS placements_created_by_idx;
+ALTER TABLE placements DROP COLUMN IF EXISTS created_by;
+COMMIT;
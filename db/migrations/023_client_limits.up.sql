@@ -0,0 +1,31 @@
+BEGIN;
+-- Name of the token that created the placement, used to count the resources held by a client
+ALTER TABLE placements ADD COLUMN created_by VARCHAR(255) NULL;
+CREATE INDEX placements_created_by_idx ON placements (created_by);
+
+-- Limits of the placement creations by client, the name of the token.
+-- The limits of the name '*' apply to the clients without their own limits.
+-- NULL means unlimited.
+CREATE TABLE client_limits (
+  name VARCHAR(255) PRIMARY KEY,
+  placements_per_minute INT NULL,   -- rate of placement creations
+  burst INT NULL,                   -- placements that can be created at once, placements_per_minute by default
+  max_aws_accounts INT NULL,        -- AWS accounts held at once by the placements of the client
+  max_ocp_sandboxes INT NULL,       -- OcpSandboxes held at once by the placements of the client
+  created_at timestamp with time zone NOT NULL DEFAULT (now() at time zone 'utc'),
+  updated_at timestamp with time zone NOT NULL DEFAULT (now() at time zone 'utc')
+);
+
+CREATE TRIGGER client_limits_updated_at
+  BEFORE UPDATE ON client_limits
+  FOR EACH ROW
+  WHEN (OLD.* IS DISTINCT FROM NEW.*)
+  EXECUTE FUNCTION updated_at_column();
+
+-- Token buckets of the rate limits, shared by the replicas
+CREATE TABLE client_rate_buckets (
+  name VARCHAR(255) PRIMARY KEY,
+  tokens DOUBLE PRECISION NOT NULL,
+  refilled_at timestamp with time zone NOT NULL
+);
+COMMIT;
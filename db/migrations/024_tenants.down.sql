@@ -0,0 +1,5 @@
+BEGIN;
+DROP TABLE IF EXISTS tenants;
+DROP INDEX IF EXISTS placements_tenant_idx;
+ALTER TABLE placements DROP COLUMN IF EXISTS tenant;
+COMMIT;
@@ -0,0 +1,23 @@
+BEGIN;
+-- Tenant of the token that created the placement, from the 'tenant' claim
+ALTER TABLE placements ADD COLUMN tenant VARCHAR(255) NULL;
+CREATE INDEX placements_tenant_idx ON placements (tenant);
+
+-- Quotas of the tenants, on the resources held at once by their placements.
+-- NULL means unlimited.
+CREATE TABLE tenants (
+  name VARCHAR(255) PRIMARY KEY,
+  max_aws_accounts INT NULL,
+  max_ocp_sandboxes INT NULL,
+  max_cpu VARCHAR(64) NULL,         -- total requests.cpu of the quotas of the OcpSandboxes, ex: '100'
+  max_memory VARCHAR(64) NULL,      -- total requests.memory of the quotas of the OcpSandboxes, ex: '200Gi'
+  created_at timestamp with time zone NOT NULL DEFAULT (now() at time zone 'utc'),
+  updated_at timestamp with time zone NOT NULL DEFAULT (now() at time zone 'utc')
+);
+
+CREATE TRIGGER tenants_updated_at
+  BEFORE UPDATE ON tenants
+  FOR EACH ROW
+  WHEN (OLD.* IS DISTINCT FROM NEW.*)
+  EXECUTE FUNCTION updated_at_column();
+COMMIT;
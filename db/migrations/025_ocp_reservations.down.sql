@@ -0,0 +1,4 @@
+BEGIN;
+DROP INDEX IF EXISTS resources_ocp_reservation_idx;
+DROP TABLE IF EXISTS ocp_reservation_clusters;
+COMMIT;
@@ -0,0 +1,21 @@
+BEGIN;
+-- OcpSharedClusterConfigurations reserved by a reservation.
+-- A dedicated cluster schedules only the OcpSandboxes of its reservation.
+-- A cluster not dedicated is shared: the reservation gets a slice of it,
+-- limited by the count and quota of its request, and the other placements
+-- are still scheduled on it.
+CREATE TABLE ocp_reservation_clusters (
+  reservation_name VARCHAR(128) NOT NULL REFERENCES reservations (reservation_name) ON DELETE CASCADE,
+  cluster_name VARCHAR(255) NOT NULL REFERENCES ocp_shared_cluster_configurations (name) ON DELETE CASCADE,
+  dedicated BOOLEAN NOT NULL DEFAULT false,
+  created_at timestamp with time zone NOT NULL DEFAULT (now() at time zone 'utc'),
+  PRIMARY KEY (reservation_name, cluster_name)
+);
+
+-- A cluster is dedicated to one reservation at most
+CREATE UNIQUE INDEX ocp_reservation_clusters_dedicated_idx
+  ON ocp_reservation_clusters (cluster_name) WHERE dedicated;
+
+CREATE INDEX resources_ocp_reservation_idx
+  ON resources ((resource_data->>'reservation')) WHERE resource_type = 'OcpSandbox';
+COMMIT;
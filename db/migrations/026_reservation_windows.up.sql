@@ -0,0 +1,15 @@
+-- A reservation can be active only during a time window:
+-- 'scheduled' until it's activated, shortly before starts_at,
+-- 'releasing' then 'expired' once ends_at is past.
+-- ALTER TYPE ... ADD VALUE can't be used in a transaction block before Postgres 12.
+ALTER TYPE reservation_status ADD VALUE IF NOT EXISTS 'scheduled';
+ALTER TYPE reservation_status ADD VALUE IF NOT EXISTS 'releasing';
+ALTER TYPE reservation_status ADD VALUE IF NOT EXISTS 'expired';
+
+BEGIN;
+ALTER TABLE reservations ADD COLUMN starts_at timestamp with time zone NULL;
+ALTER TABLE reservations ADD COLUMN ends_at timestamp with time zone NULL;
+
+CREATE INDEX reservations_starts_at_idx ON reservations (starts_at) WHERE status = 'scheduled';
+CREATE INDEX reservations_ends_at_idx ON reservations (ends_at) WHERE ends_at IS NOT NULL;
+COMMIT;
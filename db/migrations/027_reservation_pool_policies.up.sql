@@ -0,0 +1,20 @@
+BEGIN;
+-- Floor of the shared pool of AWS accounts that the reservations can't take.
+-- The policy of the priority '*' applies to the reservations without their own policy.
+-- Without any policy, the floor is 20% of the accounts.
+CREATE TABLE reservation_pool_policies (
+  priority VARCHAR(255) PRIMARY KEY,
+  -- The floor is either a number of accounts, or a percentage of all the accounts
+  floor_count INT NULL CHECK (floor_count >= 0),
+  floor_percent NUMERIC(5,2) NULL CHECK (floor_percent >= 0 AND floor_percent <= 100),
+  created_at timestamp with time zone NOT NULL DEFAULT (now() at time zone 'utc'),
+  updated_at timestamp with time zone NOT NULL DEFAULT (now() at time zone 'utc'),
+  CHECK ((floor_count IS NULL) <> (floor_percent IS NULL))
+);
+
+CREATE TRIGGER reservation_pool_policies_updated_at
+  BEFORE UPDATE ON reservation_pool_policies
+  FOR EACH ROW
+  WHEN (OLD.* IS DISTINCT FROM NEW.*)
+  EXECUTE FUNCTION updated_at_column();
+COMMIT;
@@ -0,0 +1,39 @@
+BEGIN;
+ALTER TABLE reservations DROP COLUMN IF EXISTS target;
+ALTER TABLE reservations DROP COLUMN IF EXISTS reserved;
+
+-- Values can't be removed from an enum, recreate the type without 'pending'
+UPDATE reservations SET status = 'error' WHERE status = 'pending';
+UPDATE reservations_events SET status = 'error' WHERE status = 'pending';
+
+DROP TRIGGER IF EXISTS reservation_event_update ON reservations;
+DROP TRIGGER IF EXISTS reservations_webhook ON reservations;
+
+ALTER TABLE reservations ALTER COLUMN status DROP DEFAULT;
+ALTER TABLE reservations_events ALTER COLUMN status DROP DEFAULT;
+
+ALTER TYPE reservation_status RENAME TO reservation_status_old;
+CREATE TYPE reservation_status AS ENUM ('new', 'initializing', 'success', 'updating', 'deleting', 'error',
+  'scheduled', 'releasing', 'expired');
+
+ALTER TABLE reservations ALTER COLUMN status TYPE reservation_status USING status::text::reservation_status;
+ALTER TABLE reservations_events ALTER COLUMN status TYPE reservation_status USING status::text::reservation_status;
+DROP TYPE reservation_status_old;
+
+ALTER TABLE reservations ALTER COLUMN status SET DEFAULT 'new';
+ALTER TABLE reservations_events ALTER COLUMN status SET DEFAULT 'new';
+
+CREATE TRIGGER reservation_event_update
+  BEFORE UPDATE OF status
+  ON reservations
+  FOR EACH ROW
+  WHEN (OLD.* IS DISTINCT FROM NEW.*)
+  EXECUTE FUNCTION reservation_event_func_update('reservation_updated');
+
+CREATE TRIGGER reservations_webhook
+  AFTER UPDATE OF status
+  ON reservations
+  FOR EACH ROW
+  WHEN (OLD.status IS DISTINCT FROM NEW.status)
+  EXECUTE FUNCTION reservations_webhook();
+COMMIT;
@@ -0,0 +1,10 @@
+-- A reservation is 'pending' while it holds less AWS accounts than requested,
+-- it reserves the accounts as they come back from cleanup.
+-- ALTER TYPE ... ADD VALUE can't be used in a transaction block before Postgres 12.
+ALTER TYPE reservation_status ADD VALUE IF NOT EXISTS 'pending';
+
+BEGIN;
+-- Progress of the reservation: AWS accounts reserved, out of the target
+ALTER TABLE reservations ADD COLUMN reserved INT NOT NULL DEFAULT 0;
+ALTER TABLE reservations ADD COLUMN target INT NOT NULL DEFAULT 0;
+COMMIT;
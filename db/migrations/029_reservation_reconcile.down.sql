@@ -0,0 +1,5 @@
+BEGIN;
+DROP INDEX IF EXISTS reservations_events_reservation_name_idx;
+ALTER TABLE reservations_events DROP COLUMN IF EXISTS details;
+ALTER TABLE reservations DROP COLUMN IF EXISTS reconciled_at;
+COMMIT;
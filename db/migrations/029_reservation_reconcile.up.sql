@@ -0,0 +1,8 @@
+BEGIN;
+-- Last time the reservation was compared with the resources it holds
+ALTER TABLE reservations ADD COLUMN reconciled_at timestamp with time zone NULL;
+
+-- Details of the events recorded by the reconciler, ex: the account replaced and why
+ALTER TABLE reservations_events ADD COLUMN details jsonb NULL;
+CREATE INDEX reservations_events_reservation_name_idx ON reservations_events (reservation_name);
+COMMIT;
@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE IF EXISTS reservation_usage_samples;
+COMMIT;
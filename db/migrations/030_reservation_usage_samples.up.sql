@@ -0,0 +1,11 @@
+BEGIN;
+-- Usage of the reservations, sampled when they are reconciled, to report the peak usage
+CREATE TABLE reservation_usage_samples (
+  reservation_name VARCHAR(128) NOT NULL REFERENCES reservations(reservation_name) ON DELETE CASCADE,
+  sampled_at timestamp with time zone NOT NULL DEFAULT (now() at time zone 'utc'),
+  reserved INT NOT NULL,       -- AWS accounts reserved
+  in_use INT NOT NULL,         -- AWS accounts used by placements
+  ocp_sandboxes INT NOT NULL,  -- OcpSandboxes of the reservation
+  PRIMARY KEY (reservation_name, sampled_at)
+);
+COMMIT;
@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE IF EXISTS aws_account_cleanups;
+COMMIT;
@@ -0,0 +1,13 @@
+BEGIN;
+-- Cleanup attempts of the AWS accounts, recorded by sandbox-cleaner
+CREATE TABLE aws_account_cleanups (
+  id SERIAL PRIMARY KEY,
+  account_name VARCHAR(255) NOT NULL,
+  holder VARCHAR(255) NOT NULL,  -- the cleaner, conan_hostname of the account
+  started_at timestamp with time zone NOT NULL DEFAULT (now() at time zone 'utc'),
+  ended_at timestamp with time zone NULL,  -- NULL while the cleanup runs
+  outcome VARCHAR(32) NOT NULL DEFAULT 'running',
+  error TEXT NULL  -- summary of the error of a failed cleanup
+);
+CREATE INDEX aws_account_cleanups_account_name_idx ON aws_account_cleanups (account_name, started_at);
+COMMIT;
@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE IF EXISTS ocp_provisioning_requests;
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+-- Provisioning of the OcpSandboxes interrupted by the shutdown of a replica,
+-- to provision them again on any replica
+CREATE TABLE ocp_provisioning_requests (
+  resource_id INT PRIMARY KEY REFERENCES resources(id) ON DELETE CASCADE,
+  request JSONB NOT NULL,  -- cloud_selector, quota and limit_range of the placement request
+  created_at timestamp with time zone NOT NULL DEFAULT (now() at time zone 'utc')
+);
+COMMIT;
@@ -0,0 +1,4 @@
+BEGIN;
+ALTER TABLE ocp_provisioning_requests DROP COLUMN IF EXISTS claimed_at;
+ALTER TABLE ocp_provisioning_requests DROP COLUMN IF EXISTS holder;
+COMMIT;
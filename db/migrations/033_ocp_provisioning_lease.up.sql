@@ -0,0 +1,6 @@
+BEGIN;
+-- The requests are leased by the replica provisioning the sandbox again,
+-- and deleted once the provisioning is done
+ALTER TABLE ocp_provisioning_requests ADD COLUMN holder VARCHAR(128) NULL;  -- LocalityID of the replica
+ALTER TABLE ocp_provisioning_requests ADD COLUMN claimed_at timestamp with time zone NULL;
+COMMIT;
@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE lifecycle_resource_jobs DROP COLUMN IF EXISTS running;
+COMMIT;
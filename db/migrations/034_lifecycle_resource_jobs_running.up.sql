@@ -0,0 +1,5 @@
+BEGIN;
+-- True while a worker executes the job, even once the job is cancelled:
+-- another job of the resource can't start before the worker stopped
+ALTER TABLE lifecycle_resource_jobs ADD COLUMN running BOOLEAN NOT NULL DEFAULT false;
+COMMIT;
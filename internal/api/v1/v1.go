@@ -66,6 +66,27 @@ type LifecycleRequestResponse struct {
 	Status         string `json:"status,omitempty"`
 }
 
+type LifecycleJobsResponse struct {
+	HTTPStatusCode int                       `json:"http_code,omitempty"` // http response status code
+	ServiceUuid    string                    `json:"service_uuid"`
+	Jobs           []models.LifecycleRequest `json:"jobs"`
+}
+
+// WorkerPoolStatus is the state of a worker pool of a replica
+type WorkerPoolStatus struct {
+	Name    string `json:"name"`
+	Workers int    `json:"workers"`
+	Queued  int    `json:"queued"`
+	Running int64  `json:"running"`
+}
+
+type WorkerPoolsResponse struct {
+	HTTPStatusCode    int                `json:"http_code,omitempty"` // http response status code
+	Locality          string             `json:"locality"`
+	MaxConcurrentJobs int                `json:"max_concurrent_jobs"`
+	Pools             []WorkerPoolStatus `json:"pools"`
+}
+
 type AccountStatusResponse struct {
 	HTTPStatusCode int           `json:"http_code,omitempty"` // http response status code
 	Status         models.Status `json:"status,omitempty"`
@@ -98,6 +119,14 @@ func (p *LifecycleRequestResponse) Render(w http.ResponseWriter, r *http.Request
 	return nil
 }
 
+func (p *LifecycleJobsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func (p *WorkerPoolsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
 func (p *PlacementResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
@@ -201,3 +230,50 @@ type UpdateOcpSharedConfigurationRequest struct {
 func (j *UpdateOcpSharedConfigurationRequest) Bind(r *http.Request) error {
 	return nil
 }
+
+type ReservationsResponse struct {
+	HTTPStatusCode int                         `json:"http_code,omitempty"` // http response status code
+	Reservations   []models.ReservationSummary `json:"reservations"`
+	Count          int                         `json:"count"`
+	// AWS accounts holding the name of a reservation that doesn't exist, with orphans=true
+	OrphanAccounts []models.AwsAccount `json:"orphan_accounts,omitempty"`
+}
+
+func (p *ReservationsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type AccountCleanupsResponse struct {
+	HTTPStatusCode int    `json:"http_code,omitempty"` // http response status code
+	Name           string `json:"name"`
+	// State of the account in the cleanup queue, if it is to cleanup
+	Queue    *models.AwsAccountCleanupQueueEntry `json:"queue,omitempty"`
+	Cleanups []models.AwsAccountCleanup          `json:"cleanups"`
+	Count    int                                 `json:"count"`
+}
+
+func (p *AccountCleanupsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type CleanupQueueResponse struct {
+	HTTPStatusCode int                                  `json:"http_code,omitempty"` // http response status code
+	Accounts       []models.AwsAccountCleanupQueueEntry `json:"accounts"`
+	Count          int                                  `json:"count"`
+	// Number of accounts by state
+	States map[string]int `json:"states"`
+}
+
+func (p *CleanupQueueResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type QuarantinedAccountsResponse struct {
+	HTTPStatusCode int                 `json:"http_code,omitempty"` // http response status code
+	Accounts       []models.AwsAccount `json:"accounts"`
+	Count          int                 `json:"count"`
+}
+
+func (p *QuarantinedAccountsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
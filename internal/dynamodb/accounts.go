@@ -1,6 +1,7 @@
 package dynamodb
 
 import (
+	"context"
 	"errors"
 	"os"
 	"sort"
@@ -16,6 +17,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
 	"github.com/rhpds/sandbox/internal/log"
 	"github.com/rhpds/sandbox/internal/models"
+	"github.com/rhpds/sandbox/internal/tracing"
 	vault "github.com/sosedoff/ansible-vault-go"
 )
 
@@ -59,7 +61,12 @@ type AwsAccountDynamoDB struct {
 	ConanTimestamp    string            `json:"conan_timestamp"`
 	ConanHostname     string            `json:"conan_hostname"`
 	ConanCleanupCount int               `json:"conan_cleanup_count"`
+	ToCleanupSince    string            `json:"to_cleanup_since"`
 	Annotations       map[string]string `json:"annotations,omitempty"`
+	// Quarantine, see sandbox-cleaner
+	Quarantined      bool   `json:"quarantined"`
+	QuarantineReason string `json:"quarantine_reason"`
+	QuarantinedAt    string `json:"quarantined_at"`
 }
 
 // buildAccounts returns the list of accounts from dynamodb scan output
@@ -85,6 +92,8 @@ func buildAccounts(r *dynamodb.ScanOutput) []AwsAccountDynamoDB {
 type AwsAccountDynamoDBProvider struct {
 	Svc         *dynamodb.DynamoDB
 	VaultSecret string
+	// ctx is the parent of the spans of the calls, see WithContext
+	ctx context.Context
 }
 
 func NewAwsAccountDynamoDBProvider() *AwsAccountDynamoDBProvider {
@@ -100,6 +109,21 @@ func NewAwsAccountDynamoDBProviderWithSecret(vaultSecret string) *AwsAccountDyna
 	}
 }
 
+// WithContext returns a copy of the provider creating the spans of its calls
+// as children of the span of ctx. The calls are not cancelled with ctx.
+func (a *AwsAccountDynamoDBProvider) WithContext(ctx context.Context) models.AwsAccountProvider {
+	provider := *a
+	provider.ctx = ctx
+	return &provider
+}
+
+func (a *AwsAccountDynamoDBProvider) context() context.Context {
+	if a.ctx == nil {
+		return context.Background()
+	}
+	return a.ctx
+}
+
 // makeAccount creates new models.AwsAccount from AwsAccountDynamoDB
 func makeAccount(account AwsAccountDynamoDB) models.AwsAccount {
 	a := models.AwsAccount{
@@ -113,9 +137,21 @@ func makeAccount(account AwsAccountDynamoDB) models.AwsAccount {
 		ConanHostname:     account.ConanHostname,
 		ConanCleanupCount: account.ConanCleanupCount,
 	}
-	if conanTime, err := time.Parse(time.RFC3339, account.ConanTimestamp); err != nil {
+	if conanTime, err := time.Parse(time.RFC3339, account.ConanTimestamp); err == nil {
 		a.ConanTimestamp = conanTime
 	}
+	if account.ToCleanup {
+		if since, err := time.Parse(time.RFC3339, account.ToCleanupSince); err == nil {
+			a.ToCleanupSince = &since
+		}
+	}
+	if account.Quarantined {
+		a.Quarantined = true
+		a.QuarantineReason = account.QuarantineReason
+		if quarantinedAt, err := time.Parse(time.RFC3339, account.QuarantinedAt); err == nil {
+			a.QuarantinedAt = &quarantinedAt
+		}
+	}
 
 	a.ServiceUuid = account.ServiceUUID
 	a.ToCleanup = account.ToCleanup
@@ -314,6 +350,9 @@ func GetAccounts(svc *dynamodb.DynamoDB, filter expression.ConditionBuilder, bat
 }
 
 func (a *AwsAccountDynamoDBProvider) FetchByName(name string) (models.AwsAccount, error) {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.FetchByName")
+	defer span.End()
+
 	account, err := GetAccount(a.Svc, name)
 	if err != nil {
 		return models.AwsAccount{}, err
@@ -323,6 +362,9 @@ func (a *AwsAccountDynamoDBProvider) FetchByName(name string) (models.AwsAccount
 
 // FetchAll returns the list of all accounts from dynamodb
 func (a *AwsAccountDynamoDBProvider) FetchAll() ([]models.AwsAccount, error) {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.FetchAll")
+	defer span.End()
+
 	filter := expression.Name("name").AttributeExists()
 	accounts, err := GetAccounts(a.Svc, filter, -1)
 	if err != nil {
@@ -333,8 +375,12 @@ func (a *AwsAccountDynamoDBProvider) FetchAll() ([]models.AwsAccount, error) {
 
 // FetchAllAvailable returns the list of available accounts from dynamodb
 func (a *AwsAccountDynamoDBProvider) FetchAllAvailable() ([]models.AwsAccount, error) {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.FetchAllAvailable")
+	defer span.End()
+
 	filter := expression.Name("name").AttributeExists().
-		And(expression.Name("available").Equal(expression.Value(true)))
+		And(expression.Name("available").Equal(expression.Value(true))).
+		And(notQuarantined())
 	accounts, err := GetAccounts(a.Svc, filter, -1)
 	if err != nil {
 		return []models.AwsAccount{}, err
@@ -344,6 +390,9 @@ func (a *AwsAccountDynamoDBProvider) FetchAllAvailable() ([]models.AwsAccount, e
 
 // FetchAllByServiceUuid returns the list of accounts from dynamodb for a specific service uuid
 func (a *AwsAccountDynamoDBProvider) FetchAllByServiceUuid(serviceUuid string) ([]models.AwsAccount, error) {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.FetchAllByServiceUuid")
+	defer span.End()
+
 	filter := expression.Name("service_uuid").Equal(expression.Value(serviceUuid))
 	accounts, err := GetAccounts(a.Svc, filter, -1)
 	if err != nil {
@@ -354,6 +403,9 @@ func (a *AwsAccountDynamoDBProvider) FetchAllByServiceUuid(serviceUuid string) (
 
 // FetchAllActiveByServiceUuid returns the list of accounts from dynamodb for a specific service uuid that are not to cleanup
 func (a *AwsAccountDynamoDBProvider) FetchAllActiveByServiceUuid(serviceUuid string) ([]models.AwsAccount, error) {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.FetchAllActiveByServiceUuid")
+	defer span.End()
+
 	filter := expression.Name("service_uuid").Equal(expression.Value(serviceUuid)).
 		And(expression.Name("to_cleanup").AttributeNotExists().
 			Or(expression.Name("to_cleanup").Equal(expression.Value(false))))
@@ -366,6 +418,9 @@ func (a *AwsAccountDynamoDBProvider) FetchAllActiveByServiceUuid(serviceUuid str
 
 // FetchAllByServiceUuidWithCreds returns the list of accounts from dynamodb for a specific service uuid
 func (a *AwsAccountDynamoDBProvider) FetchAllByServiceUuidWithCreds(serviceUuid string) ([]models.AwsAccountWithCreds, error) {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.FetchAllByServiceUuidWithCreds")
+	defer span.End()
+
 	filter := expression.Name("service_uuid").Equal(expression.Value(serviceUuid))
 	accounts, err := GetAccounts(a.Svc, filter, -1)
 	if err != nil {
@@ -376,6 +431,9 @@ func (a *AwsAccountDynamoDBProvider) FetchAllByServiceUuidWithCreds(serviceUuid
 
 // FetchAllActiveByServiceUuidWithCreds returns the list of accounts from dynamodb for a specific service uuid
 func (a *AwsAccountDynamoDBProvider) FetchAllActiveByServiceUuidWithCreds(serviceUuid string) ([]models.AwsAccountWithCreds, error) {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.FetchAllActiveByServiceUuidWithCreds")
+	defer span.End()
+
 	filter := expression.Name("service_uuid").Equal(expression.Value(serviceUuid)).
 		And(expression.Name("to_cleanup").AttributeNotExists().
 			Or(expression.Name("to_cleanup").Equal(expression.Value(false))))
@@ -388,6 +446,9 @@ func (a *AwsAccountDynamoDBProvider) FetchAllActiveByServiceUuidWithCreds(servic
 
 // FetchAllToCleanup returns the list of accounts from dynamodb
 func (a *AwsAccountDynamoDBProvider) FetchAllToCleanup() ([]models.AwsAccount, error) {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.FetchAllToCleanup")
+	defer span.End()
+
 	filter := expression.Name("to_cleanup").Equal(expression.Value(true))
 	accounts, err := GetAccounts(a.Svc, filter, -1)
 	if err != nil {
@@ -396,8 +457,30 @@ func (a *AwsAccountDynamoDBProvider) FetchAllToCleanup() ([]models.AwsAccount, e
 	return makeAccounts(accounts), nil
 }
 
+// FetchAllQuarantined returns the list of quarantined accounts from dynamodb
+func (a *AwsAccountDynamoDBProvider) FetchAllQuarantined() ([]models.AwsAccount, error) {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.FetchAllQuarantined")
+	defer span.End()
+
+	filter := expression.Name("quarantined").Equal(expression.Value(true))
+	accounts, err := GetAccounts(a.Svc, filter, -1)
+	if err != nil {
+		return []models.AwsAccount{}, err
+	}
+	return makeAccounts(accounts), nil
+}
+
+// notQuarantined is the filter excluding the quarantined accounts
+func notQuarantined() expression.ConditionBuilder {
+	return expression.Name("quarantined").AttributeNotExists().
+		Or(expression.Name("quarantined").Equal(expression.Value(false)))
+}
+
 // FetchAllSorted
 func (a *AwsAccountDynamoDBProvider) FetchAllSorted(by string) ([]models.AwsAccount, error) {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.FetchAllSorted")
+	defer span.End()
+
 	filter := expression.Name("name").AttributeExists()
 	accounts, err := GetAccounts(a.Svc, filter, -1)
 	if err != nil {
@@ -418,6 +501,9 @@ func (a *AwsAccountDynamoDBProvider) FetchAllSorted(by string) ([]models.AwsAcco
 
 // Request reserve accounts for a service
 func (a *AwsAccountDynamoDBProvider) Request(service_uuid string, reservation string, count int, annotations models.Annotations) ([]models.AwsAccountWithCreds, error) {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.Request")
+	defer span.End()
+
 	if count <= 0 {
 		return []models.AwsAccountWithCreds{}, errors.New("count must be > 0")
 	}
@@ -431,7 +517,8 @@ func (a *AwsAccountDynamoDBProvider) Request(service_uuid string, reservation st
 		And(expression.Name("aws_access_key_id").AttributeExists()).
 		And(expression.Name("aws_secret_access_key").AttributeExists()).
 		And(expression.Name("hosted_zone_id").AttributeExists()).
-		And(expression.Name("account_id").AttributeExists())
+		And(expression.Name("account_id").AttributeExists()).
+		And(notQuarantined())
 
 	if reservation != "" {
 		filter = filter.And(expression.Name("reservation").Equal(expression.Value(reservation)))
@@ -454,7 +541,8 @@ func (a *AwsAccountDynamoDBProvider) Request(service_uuid string, reservation st
 			And(expression.Name("aws_access_key_id").AttributeExists()).
 			And(expression.Name("aws_secret_access_key").AttributeExists()).
 			And(expression.Name("hosted_zone_id").AttributeExists()).
-			And(expression.Name("account_id").AttributeExists())
+			And(expression.Name("account_id").AttributeExists()).
+			And(notQuarantined())
 
 		if reservation != "" {
 			filter = filter.And(expression.Name("reservation").Equal(expression.Value(reservation)))
@@ -507,7 +595,7 @@ func (a *AwsAccountDynamoDBProvider) Request(service_uuid string, reservation st
 				"#o": aws.String("owner"),
 				"#c": aws.String("comment"),
 			},
-			ConditionExpression: aws.String("available = :currval"),
+			ConditionExpression: aws.String("available = :currval AND (attribute_not_exists(quarantined) OR quarantined = :av)"),
 			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 				":av": {
 					BOOL: aws.Bool(false),
@@ -576,6 +664,9 @@ func (a *AwsAccountDynamoDBProvider) Request(service_uuid string, reservation st
 // It takes the number of account to reserve.
 // The function iterates over available accounts and update the 'reservation' column
 func (a *AwsAccountDynamoDBProvider) Reserve(reservation string, count int) ([]models.AwsAccount, error) {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.Reserve")
+	defer span.End()
+
 	maxRetries := 5
 
 	result, err := a.FetchAllByReservation(reservation)
@@ -626,7 +717,8 @@ func (a *AwsAccountDynamoDBProvider) reserveInner(reservation string, count int)
 		And(expression.Name("aws_access_key_id").AttributeExists()).
 		And(expression.Name("aws_secret_access_key").AttributeExists()).
 		And(expression.Name("hosted_zone_id").AttributeExists()).
-		And(expression.Name("account_id").AttributeExists())
+		And(expression.Name("account_id").AttributeExists()).
+		And(notQuarantined())
 
 	accounts, err := GetAccounts(a.Svc, filter, count)
 
@@ -655,7 +747,8 @@ func (a *AwsAccountDynamoDBProvider) reserveInner(reservation string, count int)
 			ConditionExpression: aws.String(
 				`available = :t
                  and (attribute_not_exists(reservation) or reservation = :empty)
-                 and (attribute_not_exists(to_cleanup) or to_cleanup = :f)`,
+                 and (attribute_not_exists(to_cleanup) or to_cleanup = :f)
+                 and (attribute_not_exists(quarantined) or quarantined = :f)`,
 			),
 			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 				":rv": {
@@ -706,6 +799,9 @@ func (a *AwsAccountDynamoDBProvider) reserveInner(reservation string, count int)
 // ScaleDownReservation scale down a reservation
 // It removes some of the accounts from the reservation
 func (a *AwsAccountDynamoDBProvider) ScaleDownReservation(reservation string, count int) error {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.ScaleDownReservation")
+	defer span.End()
+
 	accounts, err := a.FetchAllByReservation(reservation)
 
 	if err != nil {
@@ -741,7 +837,9 @@ func (a *AwsAccountDynamoDBProvider) ScaleDownReservation(reservation string, co
 	return nil
 }
 
-func (a *AwsAccountDynamoDBProvider) MarkForCleanup(name string) error {
+// markForCleanup sets to_cleanup, and to_cleanup_since if the account is not
+// to cleanup already, to keep the time it entered the cleanup queue.
+func (a *AwsAccountDynamoDBProvider) markForCleanup(name string) error {
 	_, err := a.Svc.UpdateItem(&dynamodb.UpdateItemInput{
 		TableName: aws.String(os.Getenv("dynamodb_table")),
 		Key: map[string]*dynamodb.AttributeValue{
@@ -749,14 +847,32 @@ func (a *AwsAccountDynamoDBProvider) MarkForCleanup(name string) error {
 				S: aws.String(name),
 			},
 		},
-		UpdateExpression: aws.String("SET to_cleanup = :tc"),
+		UpdateExpression:    aws.String("SET to_cleanup = :tc, to_cleanup_since = :since"),
+		ConditionExpression: aws.String("attribute_not_exists(to_cleanup) OR to_cleanup = :f"),
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":tc": {
 				BOOL: aws.Bool(true),
 			},
+			":f": {
+				BOOL: aws.Bool(false),
+			},
+			":since": {
+				S: aws.String(conanTimestamp(time.Now())),
+			},
 		},
 	})
-	if err != nil {
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		// Already to cleanup
+		return nil
+	}
+	return err
+}
+
+func (a *AwsAccountDynamoDBProvider) MarkForCleanup(name string) error {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.MarkForCleanup")
+	defer span.End()
+
+	if err := a.markForCleanup(name); err != nil {
 		log.Logger.Error("error marking the sandbox for cleanup", "name", name, "error", err)
 		return err
 	}
@@ -764,6 +880,8 @@ func (a *AwsAccountDynamoDBProvider) MarkForCleanup(name string) error {
 }
 
 func (a *AwsAccountDynamoDBProvider) MarkForCleanupByServiceUuid(serviceUuid string) error {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.MarkForCleanupByServiceUuid")
+	defer span.End()
 
 	accounts, err := a.FetchAllByServiceUuid(serviceUuid)
 
@@ -772,22 +890,7 @@ func (a *AwsAccountDynamoDBProvider) MarkForCleanupByServiceUuid(serviceUuid str
 	}
 
 	for _, account := range accounts {
-
-		_, err := a.Svc.UpdateItem(&dynamodb.UpdateItemInput{
-			TableName: aws.String(os.Getenv("dynamodb_table")),
-			Key: map[string]*dynamodb.AttributeValue{
-				"name": {
-					S: aws.String(account.Name),
-				},
-			},
-			UpdateExpression: aws.String("SET to_cleanup = :tc"),
-			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-				":tc": {
-					BOOL: aws.Bool(true),
-				},
-			},
-		})
-		if err != nil {
+		if err := a.markForCleanup(account.Name); err != nil {
 			log.Logger.Error("error marking the sandbox for cleanup", "ServiceUuid", serviceUuid, "error", err)
 			return err
 		}
@@ -797,6 +900,9 @@ func (a *AwsAccountDynamoDBProvider) MarkForCleanupByServiceUuid(serviceUuid str
 }
 
 func (a *AwsAccountDynamoDBProvider) CountAvailable(reservation string) (int, error) {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.CountAvailable")
+	defer span.End()
+
 	var filter expression.ConditionBuilder
 
 	if reservation == "" {
@@ -805,7 +911,8 @@ func (a *AwsAccountDynamoDBProvider) CountAvailable(reservation string) (int, er
 				Or(expression.Name("reservation").Equal(expression.Value("")))).
 			And(expression.Name("available").Equal(expression.Value(true))).
 			And(expression.Name("to_cleanup").AttributeNotExists().
-				Or(expression.Name("to_cleanup").Equal(expression.Value(false))))
+				Or(expression.Name("to_cleanup").Equal(expression.Value(false)))).
+			And(notQuarantined())
 
 	} else {
 		filter = expression.Name("name").AttributeExists().
@@ -813,7 +920,8 @@ func (a *AwsAccountDynamoDBProvider) CountAvailable(reservation string) (int, er
 			And(expression.Name("reservation").Equal(expression.Value(reservation))).
 			And(expression.Name("available").Equal(expression.Value(true))).
 			And(expression.Name("to_cleanup").AttributeNotExists().
-				Or(expression.Name("to_cleanup").Equal(expression.Value(false))))
+				Or(expression.Name("to_cleanup").Equal(expression.Value(false)))).
+			And(notQuarantined())
 
 	}
 	accounts, err := GetAccounts(a.Svc, filter, -1)
@@ -824,6 +932,9 @@ func (a *AwsAccountDynamoDBProvider) CountAvailable(reservation string) (int, er
 }
 
 func (a *AwsAccountDynamoDBProvider) Count() (int, error) {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.Count")
+	defer span.End()
+
 	filter := expression.Name("name").AttributeExists()
 	accounts, err := GetAccounts(a.Svc, filter, -1)
 	if err != nil {
@@ -833,6 +944,9 @@ func (a *AwsAccountDynamoDBProvider) Count() (int, error) {
 }
 
 func (a *AwsAccountDynamoDBProvider) DecryptSecret(encrypted string) (string, error) {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.DecryptSecret")
+	defer span.End()
+
 	str, err := vault.Decrypt(encrypted, a.VaultSecret)
 	if err != nil {
 		return "", err
@@ -843,6 +957,9 @@ func (a *AwsAccountDynamoDBProvider) DecryptSecret(encrypted string) (string, er
 
 // GetAccountsByReservation returns the list of accounts from dynamodb for a specific reservation
 func (a *AwsAccountDynamoDBProvider) FetchAllByReservation(reservation string) ([]models.AwsAccount, error) {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.FetchAllByReservation")
+	defer span.End()
+
 	filter := expression.Name("reservation").Equal(expression.Value(reservation))
 	accounts, err := GetAccounts(a.Svc, filter, -1)
 	if err != nil {
@@ -853,6 +970,9 @@ func (a *AwsAccountDynamoDBProvider) FetchAllByReservation(reservation string) (
 
 // RemoveReservation remove an account from a reservation
 func (a *AwsAccountDynamoDBProvider) RemoveReservation(name string) error {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.RemoveReservation")
+	defer span.End()
+
 	_, err := a.Svc.UpdateItem(&dynamodb.UpdateItemInput{
 		TableName: aws.String(os.Getenv("dynamodb_table")),
 		Key: map[string]*dynamodb.AttributeValue{
@@ -872,6 +992,9 @@ func (a *AwsAccountDynamoDBProvider) RemoveReservation(name string) error {
 
 // Delete deletes an account from dynamodb
 func (a *AwsAccountDynamoDBProvider) Delete(name string) error {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.Delete")
+	defer span.End()
+
 	// Delete the entry from the DynamoDB table
 	_, err := a.Svc.DeleteItem(&dynamodb.DeleteItemInput{
 		TableName: aws.String(os.Getenv("dynamodb_table")),
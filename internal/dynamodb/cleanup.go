@@ -0,0 +1,210 @@
+package dynamodb
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/models"
+	"github.com/rhpds/sandbox/internal/tracing"
+)
+
+// The leases are the same fields as the locks of conan, see conan/wipe_sandbox.sh,
+// so the cleaner and conan can run side by side during the migration.
+
+func conanTimestamp(t time.Time) string {
+	return t.UTC().Format(models.ConanTimestampLayout)
+}
+
+// updateCleanup runs a conditional update on the account.
+// Returns models.ErrCleanupLeaseTaken if the condition fails.
+func (a *AwsAccountDynamoDBProvider) updateCleanup(name string, update string, condition string, values map[string]*dynamodb.AttributeValue) error {
+	_, err := a.Svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(os.Getenv("dynamodb_table")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"name": {
+				S: aws.String(name),
+			},
+		},
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return models.ErrCleanupLeaseTaken
+		}
+		return err
+	}
+	return nil
+}
+
+// ClaimCleanup takes the lease of the account for holder, see models.CleanupClaimable
+func (a *AwsAccountDynamoDBProvider) ClaimCleanup(name string, holder string, policy models.CleanupPolicy) error {
+	now := time.Now()
+	err := a.updateCleanup(
+		name,
+		"SET available = :false, conan_status = :st, conan_timestamp = :timestamp, conan_hostname = :host",
+		`to_cleanup = :true
+		 AND (attribute_not_exists(conan_status) OR conan_status <> :st OR conan_timestamp < :expired)
+		 AND (attribute_not_exists(conan_cleanup_count) OR conan_cleanup_count < :maxretries OR conan_timestamp < :retry)
+		 AND (attribute_not_exists(quarantined) OR quarantined = :false)`,
+		map[string]*dynamodb.AttributeValue{
+			":false":      {BOOL: aws.Bool(false)},
+			":true":       {BOOL: aws.Bool(true)},
+			":st":         {S: aws.String(models.CleanupInProgress)},
+			":timestamp":  {S: aws.String(conanTimestamp(now))},
+			":expired":    {S: aws.String(conanTimestamp(now.Add(-policy.Lease)))},
+			":retry":      {S: aws.String(conanTimestamp(now.Add(-policy.RetryAfter)))},
+			":host":       {S: aws.String(holder)},
+			":maxretries": {N: aws.String(strconv.Itoa(policy.MaxRetries))},
+		},
+	)
+	if err != nil && err != models.ErrCleanupLeaseTaken {
+		log.Logger.Error("error claiming the sandbox for cleanup", "name", name, "error", err)
+	}
+	return err
+}
+
+// RenewCleanupLease extends the lease of holder on the account
+func (a *AwsAccountDynamoDBProvider) RenewCleanupLease(name string, holder string) error {
+	err := a.updateCleanup(
+		name,
+		"SET conan_timestamp = :timestamp",
+		"to_cleanup = :true AND conan_status = :st AND conan_hostname = :host",
+		map[string]*dynamodb.AttributeValue{
+			":true":      {BOOL: aws.Bool(true)},
+			":st":        {S: aws.String(models.CleanupInProgress)},
+			":timestamp": {S: aws.String(conanTimestamp(time.Now()))},
+			":host":      {S: aws.String(holder)},
+		},
+	)
+	if err != nil && err != models.ErrCleanupLeaseTaken {
+		log.Logger.Error("error renewing the cleanup lease", "name", name, "error", err)
+	}
+	return err
+}
+
+// ReleaseCleanup gives the lease of holder back. If failed, conan_cleanup_count
+// is incremented, and conan_timestamp is kept to retry the account later.
+func (a *AwsAccountDynamoDBProvider) ReleaseCleanup(name string, holder string, failed bool) error {
+	update := "SET conan_status = :empty"
+	values := map[string]*dynamodb.AttributeValue{
+		":empty": {S: aws.String("")},
+		":st":    {S: aws.String(models.CleanupInProgress)},
+		":host":  {S: aws.String(holder)},
+	}
+	if failed {
+		update = update + " ADD conan_cleanup_count :one"
+		values[":one"] = &dynamodb.AttributeValue{N: aws.String("1")}
+	}
+
+	err := a.updateCleanup(name, update, "conan_status = :st AND conan_hostname = :host", values)
+	if err != nil && err != models.ErrCleanupLeaseTaken {
+		log.Logger.Error("error releasing the cleanup lease", "name", name, "error", err)
+	}
+	return err
+}
+
+// CompleteCleanup puts the cleaned up account back in the pool: the fields
+// of the placement and of the lease are removed, the reservation is kept.
+func (a *AwsAccountDynamoDBProvider) CompleteCleanup(name string, holder string) error {
+	_, err := a.Svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(os.Getenv("dynamodb_table")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"name": {
+				S: aws.String(name),
+			},
+		},
+		UpdateExpression: aws.String(
+			`SET available = :true, to_cleanup = :false
+			 REMOVE guid, envtype, service_uuid, #o, owner_email, #c, annotations,
+			 conan_status, conan_timestamp, conan_hostname, conan_cleanup_count, to_cleanup_since`,
+		),
+		ExpressionAttributeNames: map[string]*string{
+			"#o": aws.String("owner"),
+			"#c": aws.String("comment"),
+		},
+		ConditionExpression: aws.String("to_cleanup = :true AND conan_status = :st AND conan_hostname = :host"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":true":  {BOOL: aws.Bool(true)},
+			":false": {BOOL: aws.Bool(false)},
+			":st":    {S: aws.String(models.CleanupInProgress)},
+			":host":  {S: aws.String(holder)},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return models.ErrCleanupLeaseTaken
+		}
+		log.Logger.Error("error putting the sandbox back in the pool", "name", name, "error", err)
+		return err
+	}
+	return nil
+}
+
+// QuarantineCleanup gives the lease of holder back, increments conan_cleanup_count,
+// and takes the account out of the cleanups and of the pool, with the reason,
+// until ReleaseQuarantine. The account stays to cleanup.
+func (a *AwsAccountDynamoDBProvider) QuarantineCleanup(name string, holder string, reason string) error {
+	err := a.updateCleanup(
+		name,
+		`SET conan_status = :empty, available = :false,
+		 quarantined = :true, quarantine_reason = :reason, quarantined_at = :timestamp
+		 ADD conan_cleanup_count :one`,
+		"conan_status = :st AND conan_hostname = :host",
+		map[string]*dynamodb.AttributeValue{
+			":empty":     {S: aws.String("")},
+			":true":      {BOOL: aws.Bool(true)},
+			":false":     {BOOL: aws.Bool(false)},
+			":one":       {N: aws.String("1")},
+			":reason":    {S: aws.String(reason)},
+			":timestamp": {S: aws.String(conanTimestamp(time.Now()))},
+			":st":        {S: aws.String(models.CleanupInProgress)},
+			":host":      {S: aws.String(holder)},
+		},
+	)
+	if err != nil && err != models.ErrCleanupLeaseTaken {
+		log.Logger.Error("error quarantining the sandbox", "name", name, "error", err)
+	}
+	return err
+}
+
+// ReleaseQuarantine releases a quarantined account: the count of failed
+// cleanups is reset, and the account is cleaned up again before going back
+// in the pool.
+func (a *AwsAccountDynamoDBProvider) ReleaseQuarantine(name string) error {
+	_, span := tracing.Start(a.context(), "AwsAccountProvider.ReleaseQuarantine")
+	defer span.End()
+
+	_, err := a.Svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(os.Getenv("dynamodb_table")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"name": {
+				S: aws.String(name),
+			},
+		},
+		UpdateExpression: aws.String(
+			`SET to_cleanup = :true, conan_status = :empty
+			 REMOVE quarantined, quarantine_reason, quarantined_at, conan_cleanup_count`,
+		),
+		ConditionExpression: aws.String("quarantined = :true"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":true":  {BOOL: aws.Bool(true)},
+			":empty": {S: aws.String("")},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return models.ErrAccountNotQuarantined
+		}
+		log.Logger.Error("error releasing the sandbox from quarantine", "name", name, "error", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Package metrics holds the Prometheus metrics exported by sandbox-api on /metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// HTTPRequests counts the HTTP requests by route pattern, method and status code
+	HTTPRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sandbox_api_http_requests_total",
+			Help: "HTTP requests by route, method and status code",
+		},
+		[]string{"route", "method", "status"},
+	)
+
+	// HTTPRequestDuration is the latency of the HTTP requests by route pattern and method
+	HTTPRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "sandbox_api_http_request_duration_seconds",
+			Help:    "Latency of the HTTP requests by route and method",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"route", "method"},
+	)
+
+	// PlacementResources counts the resources requested in placements by kind and result.
+	// result is 'success' or the class of the error: 'no_capacity', 'conflict', 'invalid', 'error'.
+	PlacementResources = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sandbox_api_placement_resources_total",
+			Help: "Resources requested in placements by kind and result",
+		},
+		[]string{"kind", "result"},
+	)
+
+	// OcpSchedulingDuration is the time to provision an OCP sandbox, by cluster and result
+	OcpSchedulingDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "sandbox_api_ocp_scheduling_duration_seconds",
+			Help:    "Time to schedule and provision an OCP sandbox, by cluster and result",
+			Buckets: []float64{1, 2.5, 5, 10, 20, 30, 60, 120, 300},
+		},
+		[]string{"cluster", "result"},
+	)
+
+	// ClientLimitRejections counts the placements rejected by the limits of the client.
+	// reason is 'rate' or 'caps'.
+	ClientLimitRejections = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sandbox_api_client_limit_rejections_total",
+			Help: "Placements rejected by the limits of the client, by reason",
+		},
+		[]string{"reason"},
+	)
+
+	// TenantQuotaRejections counts the placements rejected by the quotas of the tenant
+	TenantQuotaRejections = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sandbox_api_tenant_quota_rejections_total",
+			Help: "Placements rejected by the quotas of the tenant, by tenant",
+		},
+		[]string{"tenant"},
+	)
+
+	// LifecycleJobDuration is the time to execute a lifecycle resource job
+	LifecycleJobDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "sandbox_api_lifecycle_job_duration_seconds",
+			Help:    "Time to execute a lifecycle resource job, by pool and final status",
+			Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600, 900},
+		},
+		[]string{"pool", "status"},
+	)
+
+	// WorkerPoolQueued is the number of jobs waiting for a worker, by pool
+	WorkerPoolQueued = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sandbox_api_worker_pool_queued_jobs",
+			Help: "Jobs waiting for a worker, by pool",
+		},
+		[]string{"pool"},
+	)
+
+	// WorkerPoolRunning is the number of jobs being processed, by pool
+	WorkerPoolRunning = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sandbox_api_worker_pool_running_jobs",
+			Help: "Jobs being processed, by pool",
+		},
+		[]string{"pool"},
+	)
+
+	// JobClaimConflicts counts the jobs that couldn't be claimed.
+	// reason is 'already_claimed' or 'resource_busy'.
+	JobClaimConflicts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sandbox_api_job_claim_conflicts_total",
+			Help: "Lifecycle jobs that couldn't be claimed, by job type and reason",
+		},
+		[]string{"job_type", "reason"},
+	)
+
+	// AssumeRoleFailures counts the STS AssumeRole calls that failed
+	AssumeRoleFailures = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sandbox_api_sts_assume_role_failures_total",
+			Help: "STS AssumeRole calls that failed",
+		},
+	)
+)
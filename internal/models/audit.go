@@ -0,0 +1,187 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// AuditEvent records a POST, PUT or DELETE call of the API
+type AuditEvent struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Actor, from the claims of the token
+	ActorName string `json:"actor_name"`
+	ActorRole string `json:"actor_role"`
+	ActorJti  string `json:"actor_jti,omitempty"`
+
+	Method     string            `json:"method"`
+	Route      string            `json:"route"`
+	Path       string            `json:"path"`
+	PathParams map[string]string `json:"path_params"`
+	// What the call acted on, see AuditTarget
+	Target     string          `json:"target,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+	StatusCode int             `json:"status_code"`
+	RequestID  string          `json:"request_id,omitempty"`
+}
+
+type AuditEvents []AuditEvent
+
+func (a AuditEvents) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// AuditEventFilter selects the audit events, the empty fields select everything
+type AuditEventFilter struct {
+	Actor  string
+	Target string
+	From   *time.Time
+	To     *time.Time
+	Limit  int
+}
+
+// The value of the keys containing these words is redacted from the audit log
+var auditSensitiveKeys = []string{
+	"password",
+	"secret",
+	"token",
+	"kubeconfig",
+	"credential",
+	"private",
+	"api_key",
+	"access_key",
+}
+
+// RedactBody returns the JSON body with the value of the sensitive keys replaced
+// by "REDACTED", at any depth. It returns nil if the body is empty or isn't JSON.
+func RedactBody(body []byte) json.RawMessage {
+	var data any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil
+	}
+
+	redacted, err := json.Marshal(redact(data))
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+func redact(data any) any {
+	switch v := data.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if isSensitiveKey(key) {
+				v[key] = "REDACTED"
+			} else {
+				v[key] = redact(value)
+			}
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = redact(value)
+		}
+	}
+	return data
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range auditSensitiveKeys {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditTarget returns what the call acted on: the last path parameter,
+// for example the uuid of a placement, or, for the creations, the
+// service_uuid or the name in the body.
+func AuditTarget(route string, params map[string]string, body []byte) string {
+	// The path parameters in the order of the route
+	target := ""
+	for _, segment := range strings.Split(route, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			target = params[strings.Trim(segment, "{}")]
+		}
+	}
+	if target != "" {
+		return target
+	}
+
+	var fields struct {
+		ServiceUuid string `json:"service_uuid"`
+		Name        string `json:"name"`
+	}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return ""
+	}
+	if fields.ServiceUuid != "" {
+		return fields.ServiceUuid
+	}
+	return fields.Name
+}
+
+// Save inserts the audit event
+func (a *AuditEvent) Save(dbpool *pgxpool.Pool) error {
+	if a.PathParams == nil {
+		a.PathParams = map[string]string{}
+	}
+	// SQL NULL, not JSON null, when there is no body
+	var body []byte
+	if len(a.Body) > 0 {
+		body = a.Body
+	}
+
+	return dbpool.QueryRow(
+		context.Background(),
+		`INSERT INTO audit_events
+		 (actor_name, actor_role, actor_jti, method, route, path, path_params, target, body, status_code, request_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		 RETURNING id, created_at`,
+		a.ActorName, a.ActorRole, a.ActorJti, a.Method, a.Route, a.Path, a.PathParams,
+		a.Target, body, a.StatusCode, a.RequestID,
+	).Scan(&a.ID, &a.CreatedAt)
+}
+
+// FetchAuditEvents returns the audit events matching the filter, most recent first
+func FetchAuditEvents(dbpool *pgxpool.Pool, filter AuditEventFilter) (AuditEvents, error) {
+	rows, err := dbpool.Query(
+		context.Background(),
+		`SELECT id, created_at, actor_name, actor_role, actor_jti, method, route, path,
+		        path_params, target, body, status_code, request_id
+		 FROM audit_events
+		 WHERE ($1 = '' OR actor_name = $1)
+		 AND ($2 = '' OR target = $2)
+		 AND ($3::timestamptz IS NULL OR created_at >= $3)
+		 AND ($4::timestamptz IS NULL OR created_at < $4)
+		 ORDER BY id DESC LIMIT $5`,
+		filter.Actor, filter.Target, filter.From, filter.To, filter.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := AuditEvents{}
+	for rows.Next() {
+		var a AuditEvent
+		var body []byte
+		if err := rows.Scan(&a.ID, &a.CreatedAt, &a.ActorName, &a.ActorRole, &a.ActorJti,
+			&a.Method, &a.Route, &a.Path, &a.PathParams, &a.Target, &body,
+			&a.StatusCode, &a.RequestID); err != nil {
+			return nil, err
+		}
+		a.Body = body
+		events = append(events, a)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRedactBody(t *testing.T) {
+	body := []byte(`{
+		"name": "cluster1",
+		"token": "sha256~abcd",
+		"kubeconfig": "apiVersion: v1",
+		"secret": "0123456789abcdef",
+		"annotations": {"tenant": "foo"},
+		"resources": [{"kind": "OcpSandbox", "credentials": [{"password": "x"}]}]
+	}`)
+
+	var redacted map[string]any
+	if err := json.Unmarshal(RedactBody(body), &redacted); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"token", "kubeconfig", "secret"} {
+		if redacted[key] != "REDACTED" {
+			t.Errorf("%s should be redacted, got %v", key, redacted[key])
+		}
+	}
+	if redacted["name"] != "cluster1" || redacted["annotations"].(map[string]any)["tenant"] != "foo" {
+		t.Errorf("Other keys should be kept, got %v", redacted)
+	}
+	resource := redacted["resources"].([]any)[0].(map[string]any)
+	if resource["credentials"] != "REDACTED" || resource["kind"] != "OcpSandbox" {
+		t.Errorf("Nested keys should be redacted, got %v", resource)
+	}
+
+	if RedactBody(nil) != nil || RedactBody([]byte("not json")) != nil {
+		t.Error("Empty or invalid body should be nil")
+	}
+}
+
+func TestAuditTarget(t *testing.T) {
+	tests := []struct {
+		route  string
+		params map[string]string
+		body   string
+		want   string
+	}{
+		{"/api/v1/placements/{uuid}", map[string]string{"uuid": "1234"}, "", "1234"},
+		{"/api/v1/accounts/{kind}/{account}/stop", map[string]string{"kind": "aws", "account": "sandbox1"}, "", "sandbox1"},
+		{"/api/v1/placements", nil, `{"service_uuid": "5678"}`, "5678"},
+		{"/api/v1/reservations", nil, `{"name": "summit"}`, "summit"},
+		{"/api/v1/admin/jwt", nil, `{"claims": {}}`, ""},
+	}
+
+	for _, test := range tests {
+		if got := AuditTarget(test.route, test.params, []byte(test.body)); got != test.want {
+			t.Errorf("AuditTarget(%s) = %s, want %s", test.route, got, test.want)
+		}
+	}
+}
@@ -10,18 +10,17 @@ import (
 	"strings"
 	"time"
 
-	sconfig "github.com/rhpds/sandbox/internal/config"
 	"github.com/rhpds/sandbox/internal/log"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
-	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/aws-sdk-go-v2/service/organizations"
 	orgtypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
 	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-sdk-go-v2/otelaws"
 )
 
 var ErrNoEnoughAccountsAvailable = errors.New("no enough accounts available")
@@ -41,6 +40,14 @@ type AwsAccount struct {
 	ConanTimestamp    time.Time `json:"conan_timestamp,omitempty"`
 	ConanHostname     string    `json:"conan_hostname,omitempty"`
 	ConanCleanupCount int       `json:"conan_cleanup_count,omitempty"`
+	// When the account was marked for cleanup, if it is to cleanup
+	ToCleanupSince *time.Time `json:"to_cleanup_since,omitempty"`
+
+	// Quarantined accounts failed too many cleanups, they are not cleaned
+	// up nor given until an admin releases them.
+	Quarantined      bool       `json:"quarantined,omitempty"`
+	QuarantineReason string     `json:"quarantine_reason,omitempty"`
+	QuarantinedAt    *time.Time `json:"quarantined_at,omitempty"`
 }
 
 func (a *AwsAccount) Render(w http.ResponseWriter, r *http.Request) error {
@@ -86,14 +93,19 @@ type AwsAccountProvider interface {
 	FetchAllByReservation(reservation string) ([]AwsAccount, error)
 	FetchAllByServiceUuid(serviceUuid string) ([]AwsAccount, error)
 	FetchAllByServiceUuidWithCreds(serviceUuid string) ([]AwsAccountWithCreds, error)
+	FetchAllQuarantined() ([]AwsAccount, error)
 	FetchAllSorted(by string) ([]AwsAccount, error)
 	FetchAllToCleanup() ([]AwsAccount, error)
 	FetchByName(name string) (AwsAccount, error)
 	MarkForCleanup(name string) error
 	MarkForCleanupByServiceUuid(serviceUuid string) error
 	Request(service_uuid string, reservation string, count int, annotations Annotations) ([]AwsAccountWithCreds, error)
+	ReleaseQuarantine(name string) error
+	RemoveReservation(name string) error
 	Reserve(reservation string, count int) ([]AwsAccount, error)
 	ScaleDownReservation(reservation string, count int) error
+	// WithContext returns the provider tracing its calls in the trace of ctx
+	WithContext(ctx context.Context) AwsAccountProvider
 }
 
 type Sortable interface {
@@ -138,15 +150,18 @@ func Sort[T Sortable](accounts []T, by string) []T {
 	return accounts
 }
 
-// Start method starts all the stopped instances in the account
-func (a AwsAccount) Start(ctx context.Context, creds *ststypes.Credentials, job *LifecycleResourceJob) error {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+// sandboxConfig returns the AWS config using the credentials of the sandbox
+// and the list of regions enabled in the account.
+func (a AwsAccount) sandboxConfig(ctx context.Context, creds *ststypes.Credentials) (aws.Config, []string, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
 	if err != nil {
 		log.Logger.Error("Error loading config", "error", err)
-		return err
+		return cfg, nil, err
 	}
+	// Each call to the AWS API is a span in the trace of ctx
+	otelaws.AppendMiddlewares(&cfg.APIOptions)
 
-	sandboxCreds := credentials.StaticCredentialsProvider{
+	cfg.Credentials = credentials.StaticCredentialsProvider{
 		Value: aws.Credentials{
 			AccessKeyID:     *creds.AccessKeyId,
 			SecretAccessKey: *creds.SecretAccessKey,
@@ -154,104 +169,50 @@ func (a AwsAccount) Start(ctx context.Context, creds *ststypes.Credentials, job
 		},
 	}
 
-	// Create new EC2 client
-	ec2Client := ec2.NewFromConfig(
-		cfg,
-		func(o *ec2.Options) {
-			o.Credentials = sandboxCreds
-		},
-	)
 	// Describe all EC2 regions
-	regions, err := ec2Client.DescribeRegions(context.TODO(), &ec2.DescribeRegionsInput{})
+	ec2Client := ec2.NewFromConfig(cfg)
+	regions, err := ec2Client.DescribeRegions(ctx, &ec2.DescribeRegionsInput{})
 
 	if err != nil {
 		log.Logger.Error("Error describing regions", "account", a.Name, "error", err)
-		return err
+		return cfg, nil, err
 	}
 
-	var errR error
-	// For each region, get all running instances
+	result := []string{}
 	for _, region := range regions.Regions {
-		log.Logger.Debug("Looping to start instances", "account", a.Name, "region", *region.RegionName)
-		// Create new EC2 client
-		ec2ClientRegional := ec2.NewFromConfig(
-			cfg,
-			func(o *ec2.Options) {
-				o.Credentials = sandboxCreds
-				o.Region = *region.RegionName
-			},
-		)
+		result = append(result, *region.RegionName)
+	}
 
-		// Describe all EC2 instances
-		instances, err := ec2ClientRegional.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{
-			Filters: []ec2types.Filter{
-				{
-					Name:   aws.String("instance-state-name"),
-					Values: []string{"stopped", "stopping"},
-				},
-			},
-		})
-
-		if err != nil {
-			log.Logger.Error("Error describing instances", "account", a.Name, "error", err)
-			errR = err
-			continue
+	return cfg, result, nil
+}
+
+// Start method starts all the stopped resources in the account.
+// It runs every registered AwsStopper, in each region.
+func (a AwsAccount) Start(ctx context.Context, creds *ststypes.Credentials, job *LifecycleResourceJob) error {
+	cfg, regions, err := a.sandboxConfig(ctx, creds)
+	if err != nil {
+		return err
+	}
+
+	var errR error
+	for _, region := range regions {
+		// The job may be cancelled, stop between regions
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
-		// Start all instances
-		for _, reservation := range instances.Reservations {
-			for _, instance := range reservation.Instances {
-				_, err := ec2ClientRegional.StartInstances(context.TODO(), &ec2.StartInstancesInput{
-					InstanceIds: []string{*instance.InstanceId},
-				})
-
-				if err != nil {
-					log.Logger.Error("Error starting instance", "account", a.Name, "error", err)
-					errR = err
-					continue
-				}
-				log.Logger.Info("Start instance",
-					"account", a.Name,
-					"account_id", a.AccountID,
-					"instance_id", *instance.InstanceId,
-					"instance_type", instance.InstanceType,
-					"region", *region.RegionName,
-					"request_id", ctx.Value("RequestID"),
-					"service_uuid", ctx.Value("ServiceUUID"),
-				)
-
-				// save event as json in DB
-				_, err = job.DbPool.Exec(
-					ctx,
-					`INSERT INTO lifecycle_events (event_type, service_uuid, resource_name, resource_type, event_data)
-					 VALUES ($1, $2, $3, $4, $5)`,
-					"start_instance",
-					ctx.Value("ServiceUUID"),
-					a.Name,
-					a.Kind,
-					// Save cloud provider name, instance id, instance type, region
-					struct {
-						AccountName  string `json:"account_name"`
-						AccountID    string `json:"account_id"`
-						InstanceID   string `json:"instance_id"`
-						InstanceType string `json:"instance_type"`
-						Region       string `json:"region"`
-						Locality     string `json:"locality"`
-					}{
-						AccountName:  a.Name,
-						AccountID:    a.AccountID,
-						InstanceID:   *instance.InstanceId,
-						InstanceType: string(instance.InstanceType),
-						Region:       *region.RegionName,
-						Locality:     sconfig.LocalityID,
-					},
-				)
-
-				if err != nil {
-					log.Logger.Error("Error saving event", "error", err, "event", "start_instance")
-
-				}
+		log.Logger.Debug("Looping to start resources", "account", a.Name, "region", region)
+		regional := cfg.Copy()
+		regional.Region = region
 
+		for _, stopper := range AwsStoppers() {
+			if err := stopper.Start(ctx, regional, a, job); err != nil {
+				log.Logger.Error("Error starting resources",
+					"account", a.Name,
+					"service", stopper.Name(),
+					"region", region,
+					"error", err)
+				errR = err
 			}
 		}
 	}
@@ -259,122 +220,44 @@ func (a AwsAccount) Start(ctx context.Context, creds *ststypes.Credentials, job
 	return errR
 }
 
-// Stop method stops all the running instances in the account
+// Stop method stops all the running resources in the account.
+// It runs every registered AwsStopper, in reverse order, in each region.
 func (a AwsAccount) Stop(ctx context.Context, creds *ststypes.Credentials, job *LifecycleResourceJob) error {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	cfg, regions, err := a.sandboxConfig(ctx, creds)
 	if err != nil {
-		log.Logger.Error("Error loading config", "error", err)
 		return err
 	}
 
-	sandboxCreds := credentials.StaticCredentialsProvider{
-		Value: aws.Credentials{
-			AccessKeyID:     *creds.AccessKeyId,
-			SecretAccessKey: *creds.SecretAccessKey,
-			SessionToken:    *creds.SessionToken,
-		},
-	}
-
-	// Create new EC2 client
-	ec2Client := ec2.NewFromConfig(cfg, func(o *ec2.Options) { o.Credentials = sandboxCreds })
-	// Describe all EC2 regions
-	regions, err := ec2Client.DescribeRegions(context.TODO(), &ec2.DescribeRegionsInput{})
-
-	if err != nil {
-		log.Logger.Error("Error describing regions", "account", a.Name, "error", err)
-		return err
-	}
+	stoppers := AwsStoppers()
 
 	var errR error
-	// For each region, get all running instances
-	for _, region := range regions.Regions {
-		log.Logger.Debug("Looping to stop instances", "account", a.Name, "region", *region.RegionName)
-		// Create new EC2 client
-		ec2ClientRegional := ec2.NewFromConfig(
-			cfg,
-			func(o *ec2.Options) {
-				o.Credentials = sandboxCreds
-				o.Region = *region.RegionName
-			},
-		)
-
-		// Describe all EC2 instances
-		instances, err := ec2ClientRegional.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{
-			Filters: []ec2types.Filter{
-				{
-					Name:   aws.String("instance-state-name"),
-					Values: []string{"running", "pending"},
-				},
-			},
-		})
-
-		if err != nil {
-			log.Logger.Error("Error describing instances", "account", a.Name, "error", err)
-			errR = err
-			continue
+	for _, region := range regions {
+		// The job may be cancelled, stop between regions
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
-		// Start all instances
-		for _, reservation := range instances.Reservations {
-			for _, instance := range reservation.Instances {
-				_, err := ec2ClientRegional.StopInstances(context.TODO(), &ec2.StopInstancesInput{
-					InstanceIds: []string{*instance.InstanceId},
-				})
-
-				if err != nil {
-					log.Logger.Error("Error stopping instance", "account", a.Name, "error", err)
-					errR = err
-					continue
-				}
-				log.Logger.Info("Stop instance",
+		log.Logger.Debug("Looping to stop resources", "account", a.Name, "region", region)
+		regional := cfg.Copy()
+		regional.Region = region
+
+		for i := len(stoppers) - 1; i >= 0; i-- {
+			if err := stoppers[i].Stop(ctx, regional, a, job); err != nil {
+				log.Logger.Error("Error stopping resources",
 					"account", a.Name,
-					"account_id", a.AccountID,
-					"instance_id", *instance.InstanceId,
-					"instance_type", instance.InstanceType,
-					"region", *region.RegionName,
-					"request_id", ctx.Value("RequestID"),
-					"service_uuid", ctx.Value("ServiceUUID"),
-				)
-
-				// save event as json in DB
-				_, err = job.DbPool.Exec(
-					ctx,
-					`INSERT INTO lifecycle_events (event_type, service_uuid, resource_name, resource_type, event_data)
-					 VALUES ($1, $2, $3, $4, $5)`,
-					"stop_instance",
-					ctx.Value("ServiceUUID"),
-					a.Name,
-					a.Kind,
-					// Save cloud provider name, instance id, instance type, region
-					struct {
-						AccountName  string `json:"account_name"`
-						AccountID    string `json:"account_id"`
-						InstanceID   string `json:"instance_id"`
-						InstanceType string `json:"instance_type"`
-						Region       string `json:"region"`
-						Locality     string `json:"locality"`
-					}{
-						AccountName:  a.Name,
-						AccountID:    a.AccountID,
-						InstanceID:   *instance.InstanceId,
-						InstanceType: string(instance.InstanceType),
-						Region:       *region.RegionName,
-						Locality:     sconfig.LocalityID,
-					},
-				)
-
-				if err != nil {
-					log.Logger.Error("Error saving event", "error", err, "event", "stop_instance")
-				}
+					"service", stoppers[i].Name(),
+					"region", region,
+					"error", err)
+				errR = err
 			}
 		}
 	}
 
 	return errR
-
 }
 
 type Instance struct {
+	Service      string `json:"service,omitempty"` // ec2, rds, autoscaling, eks, sagemaker
 	InstanceId   string `json:"instance_id,omitempty"`
 	InstanceName string `json:"instance_name,omitempty"`
 	InstanceType string `json:"instance_type,omitempty"`
@@ -406,66 +289,33 @@ func MakeStatus(job *LifecycleResourceJob) Status {
 	return status
 }
 
-// Status method returns the status of all the instances in the account
+// Status method returns the status of all the resources in the account
 func (a AwsAccount) Status(ctx context.Context, creds *ststypes.Credentials, job *LifecycleResourceJob) (Status, error) {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		log.Logger.Error("Error loading config", "error", err)
-		return Status{}, err
-	}
-
-	sandboxCreds := credentials.StaticCredentialsProvider{
-		Value: aws.Credentials{
-			AccessKeyID:     *creds.AccessKeyId,
-			SecretAccessKey: *creds.SecretAccessKey,
-			SessionToken:    *creds.SessionToken,
-		},
-	}
-
-	// Create new EC2 client
-	ec2Client := ec2.NewFromConfig(cfg, func(o *ec2.Options) { o.Credentials = sandboxCreds })
-	// Describe all EC2 regions
-	regions, err := ec2Client.DescribeRegions(context.TODO(), &ec2.DescribeRegionsInput{})
-
+	cfg, regions, err := a.sandboxConfig(ctx, creds)
 	if err != nil {
-		log.Logger.Error("Error describing regions", "account", a.Name, "error", err)
 		return Status{}, err
 	}
 
 	var errR error
 	var status Status
 	instances := make([]Instance, 0)
-	// For each region, get all running instances
-	for _, region := range regions.Regions {
-		log.Logger.Debug("Looping to get instances status", "account", a.Name, "region", *region.RegionName)
-		// Create new EC2 client
-		ec2ClientRegional := ec2.NewFromConfig(
-			cfg,
-			func(o *ec2.Options) {
-				o.Credentials = sandboxCreds
-				o.Region = *region.RegionName
-			},
-		)
-
-		// Describe all EC2 instances
-		ec2Instances, err := ec2ClientRegional.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{})
-
-		if err != nil {
-			log.Logger.Error("Error describing instances", "account", a.Name, "error", err)
-			errR = err
-			continue
-		}
-
-		// Build instances
-		for _, reservation := range ec2Instances.Reservations {
-			for _, instance := range reservation.Instances {
-				instances = append(instances, Instance{
-					InstanceId:   *instance.InstanceId,
-					InstanceType: string(instance.InstanceType),
-					Region:       *region.RegionName,
-					State:        string(instance.State.Name),
-				})
+	for _, region := range regions {
+		log.Logger.Debug("Looping to get resources status", "account", a.Name, "region", region)
+		regional := cfg.Copy()
+		regional.Region = region
+
+		for _, stopper := range AwsStoppers() {
+			result, err := stopper.Status(ctx, regional, a)
+			if err != nil {
+				log.Logger.Error("Error getting resources status",
+					"account", a.Name,
+					"service", stopper.Name(),
+					"region", region,
+					"error", err)
+				errR = err
+				continue
 			}
+			instances = append(instances, result...)
 		}
 	}
 
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rhpds/sandbox/internal/log"
+)
+
+// CleanupInProgress is the conan_status of the accounts leased for cleanup.
+// The value is shared with conan, so both can run side by side.
+const CleanupInProgress = "cleanup in progress"
+
+// ConanTimestampLayout is the format of conan_timestamp, as written by
+// `date -uIs` in conan. The timestamps are compared as strings in DynamoDB.
+const ConanTimestampLayout = "2006-01-02T15:04:05+00:00"
+
+// ErrCleanupLeaseTaken is returned when the lease of an account can't be
+// taken or kept: another cleaner holds it, or the account is not to cleanup anymore.
+var ErrCleanupLeaseTaken = errors.New("cleanup lease taken")
+
+// ErrAccountNotQuarantined is returned when releasing an account that is not quarantined
+var ErrAccountNotQuarantined = errors.New("account not quarantined")
+
+// AwsAccountCleanupProvider leases the AWS accounts to cleanup.
+// The lease is the conan_status, conan_hostname and conan_timestamp of the account.
+type AwsAccountCleanupProvider interface {
+	FetchAllToCleanup() ([]AwsAccount, error)
+	// ClaimCleanup takes the lease of the account for holder, see CleanupClaimable.
+	// Returns ErrCleanupLeaseTaken if the account can't be claimed.
+	ClaimCleanup(name string, holder string, policy CleanupPolicy) error
+	// RenewCleanupLease extends the lease of holder.
+	// Returns ErrCleanupLeaseTaken if holder lost the lease.
+	RenewCleanupLease(name string, holder string) error
+	// ReleaseCleanup gives the lease back. If failed, conan_cleanup_count is incremented.
+	ReleaseCleanup(name string, holder string, failed bool) error
+	// CompleteCleanup puts the account back in the pool, in its reservation if any.
+	// Returns ErrCleanupLeaseTaken if holder lost the lease.
+	CompleteCleanup(name string, holder string) error
+	// QuarantineCleanup gives the lease back, counting a failure, and takes the
+	// account out of the cleanups until an admin releases it, in one update.
+	// Returns ErrCleanupLeaseTaken if holder lost the lease.
+	QuarantineCleanup(name string, holder string, reason string) error
+}
+
+// CleanupPolicy is when an account to cleanup can be claimed
+type CleanupPolicy struct {
+	// The lease of a cleaner expires after Lease if it's not renewed
+	Lease time.Duration
+	// After MaxRetries failed cleanups, the account is retried after RetryAfter
+	MaxRetries int
+	RetryAfter time.Duration
+	// After QuarantineAfter failed cleanups, the account is quarantined. 0 disables the quarantine.
+	QuarantineAfter int
+}
+
+// DefaultCleanupPolicy is the policy of conan: 2h lock, 2 retries, then 24h pause.
+// Accounts are quarantined after 6 failed cleanups: 2 the first day, then 1 per day.
+func DefaultCleanupPolicy() CleanupPolicy {
+	return CleanupPolicy{
+		Lease:           2 * time.Hour,
+		MaxRetries:      2,
+		RetryAfter:      24 * time.Hour,
+		QuarantineAfter: 6,
+	}
+}
+
+// CleanupPolicyFromEnv returns the default policy, overridden by the environment
+// variables of sandbox-cleaner: CLEANER_LEASE, CLEANER_MAX_RETRIES, CLEANER_RETRY_AFTER
+// and CLEANER_QUARANTINE_AFTER. The API reads the same variables to report the
+// states of the cleanup queue as the cleaners see them.
+// Invalid values are logged and ignored.
+func CleanupPolicyFromEnv() CleanupPolicy {
+	policy := DefaultCleanupPolicy()
+
+	for name, d := range map[string]*time.Duration{
+		"CLEANER_LEASE":       &policy.Lease,
+		"CLEANER_RETRY_AFTER": &policy.RetryAfter,
+	} {
+		if v := os.Getenv(name); v != "" {
+			if parsed, err := time.ParseDuration(v); err == nil && parsed > 0 {
+				*d = parsed
+			} else {
+				log.Logger.Error("Invalid value, using default", "variable", name, "value", v, "default", *d)
+			}
+		}
+	}
+	for name, i := range map[string]*int{
+		"CLEANER_MAX_RETRIES":      &policy.MaxRetries,
+		"CLEANER_QUARANTINE_AFTER": &policy.QuarantineAfter,
+	} {
+		if v := os.Getenv(name); v != "" {
+			if parsed, err := strconv.Atoi(v); err == nil && parsed >= 0 {
+				*i = parsed
+			} else {
+				log.Logger.Error("Invalid value, using default", "variable", name, "value", v, "default", *i)
+			}
+		}
+	}
+	return policy
+}
+
+// ShouldQuarantine returns true if the account must be quarantined after failedCleanups
+func (p CleanupPolicy) ShouldQuarantine(failedCleanups int) bool {
+	return p.QuarantineAfter > 0 && failedCleanups >= p.QuarantineAfter
+}
+
+// CleanupState returns the state of an account to cleanup at now:
+//   - "quarantined": it is quarantined, it can't be claimed until released
+//   - "leased": a cleaner holds its lease, and the lease has not expired
+//   - "max_retries": it failed MaxRetries times, and the last attempt is more recent than RetryAfter
+//   - "claimable": it can be claimed
+//
+// Returns "" if the account is not to cleanup.
+func CleanupState(account AwsAccount, now time.Time, policy CleanupPolicy) string {
+	switch {
+	case !account.ToCleanup:
+		return ""
+	case account.Quarantined:
+		return "quarantined"
+	case account.ConanStatus == CleanupInProgress && account.ConanTimestamp.After(now.Add(-policy.Lease)):
+		return "leased"
+	case account.ConanCleanupCount >= policy.MaxRetries && account.ConanTimestamp.After(now.Add(-policy.RetryAfter)):
+		return "max_retries"
+	}
+	return "claimable"
+}
+
+// CleanupClaimable returns true if the account can be claimed for cleanup at now.
+// DynamoDB checks the same condition atomically when the account is claimed.
+func CleanupClaimable(account AwsAccount, now time.Time, policy CleanupPolicy) bool {
+	return CleanupState(account, now, policy) == "claimable"
+}
@@ -0,0 +1,182 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// The cleanup attempts are kept 180 days
+const awsAccountCleanupRetention = 180 * 24 * time.Hour
+
+// AwsAccountCleanup is a cleanup attempt of an AWS account, recorded by sandbox-cleaner
+type AwsAccountCleanup struct {
+	ID          int        `json:"id"`
+	AccountName string     `json:"account_name"`
+	Holder      string     `json:"holder"`
+	StartedAt   time.Time  `json:"started_at"`
+	EndedAt     *time.Time `json:"ended_at,omitempty"`
+	// 'running' until the attempt ends, then the result of the cleaner:
+	// 'success', 'failed', 'quarantined', 'lease_lost', 'interrupted' or 'noop'
+	Outcome string `json:"outcome"`
+	// Duration of the attempt, so far if it's running
+	DurationSeconds int64  `json:"duration_seconds"`
+	Error           string `json:"error,omitempty"`
+}
+
+// CleanupErrorSummary returns the end of the error of a cleanup, where the
+// cause usually is, truncated to be stored and displayed comfortably.
+func CleanupErrorSummary(err error) string {
+	if err == nil {
+		return ""
+	}
+	summary := err.Error()
+	if len(summary) > 1024 {
+		summary = "..." + summary[len(summary)-1024:]
+	}
+	return summary
+}
+
+// StartAwsAccountCleanup records the start of a cleanup attempt of the account by holder.
+// The attempts of the account still running, left by a cleaner that died, end as 'lease_lost'.
+func StartAwsAccountCleanup(dbpool *pgxpool.Pool, name string, holder string) (*AwsAccountCleanup, error) {
+	if _, err := dbpool.Exec(
+		context.Background(),
+		`UPDATE aws_account_cleanups SET outcome = 'lease_lost', ended_at = now()
+		 WHERE account_name = $1 AND ended_at IS NULL`,
+		name,
+	); err != nil {
+		return nil, err
+	}
+
+	c := &AwsAccountCleanup{
+		AccountName: name,
+		Holder:      holder,
+		Outcome:     "running",
+	}
+	if err := dbpool.QueryRow(
+		context.Background(),
+		`INSERT INTO aws_account_cleanups (account_name, holder)
+		 VALUES ($1, $2) RETURNING id, started_at`,
+		name, holder,
+	).Scan(&c.ID, &c.StartedAt); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// End records the end of the cleanup attempt with its outcome, and the
+// summary of its error if any. The oldest attempts of the account are deleted.
+func (c *AwsAccountCleanup) End(dbpool *pgxpool.Pool, outcome string, cleanupErr error) error {
+	var summary *string
+	if cleanupErr != nil {
+		s := CleanupErrorSummary(cleanupErr)
+		summary = &s
+	}
+
+	if err := dbpool.QueryRow(
+		context.Background(),
+		`UPDATE aws_account_cleanups SET outcome = $1, error = $2, ended_at = now()
+		 WHERE id = $3 RETURNING ended_at`,
+		outcome, summary, c.ID,
+	).Scan(&c.EndedAt); err != nil {
+		return err
+	}
+	c.Outcome = outcome
+	c.Error = CleanupErrorSummary(cleanupErr)
+
+	_, err := dbpool.Exec(
+		context.Background(),
+		`DELETE FROM aws_account_cleanups
+		 WHERE account_name = $1 AND started_at < now() - make_interval(secs => $2)`,
+		c.AccountName, awsAccountCleanupRetention.Seconds(),
+	)
+	return err
+}
+
+// FetchAwsAccountCleanups returns the last cleanup attempts of the account, most recent first
+func FetchAwsAccountCleanups(dbpool *pgxpool.Pool, name string, limit int) ([]AwsAccountCleanup, error) {
+	rows, err := dbpool.Query(
+		context.Background(),
+		`SELECT id, account_name, holder, started_at, ended_at, outcome, COALESCE(error, '')
+		 FROM aws_account_cleanups
+		 WHERE account_name = $1
+		 ORDER BY started_at DESC
+		 LIMIT $2`,
+		name, limit,
+	)
+	if err != nil {
+		return []AwsAccountCleanup{}, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	cleanups := []AwsAccountCleanup{}
+	for rows.Next() {
+		var c AwsAccountCleanup
+		if err := rows.Scan(&c.ID, &c.AccountName, &c.Holder, &c.StartedAt, &c.EndedAt, &c.Outcome, &c.Error); err != nil {
+			return []AwsAccountCleanup{}, err
+		}
+		end := now
+		if c.EndedAt != nil {
+			end = *c.EndedAt
+		}
+		c.DurationSeconds = int64(end.Sub(c.StartedAt).Seconds())
+		cleanups = append(cleanups, c)
+	}
+	return cleanups, rows.Err()
+}
+
+// AwsAccountCleanupQueueEntry is an AWS account waiting in the cleanup queue
+type AwsAccountCleanupQueueEntry struct {
+	Name        string `json:"name"`
+	Reservation string `json:"reservation,omitempty"`
+	// See CleanupState: 'claimable', 'leased', 'max_retries' or 'quarantined'
+	State string `json:"state"`
+	// The cleaner holding the lease, or the last one
+	Holder         string `json:"holder,omitempty"`
+	FailedCleanups int    `json:"failed_cleanups"`
+	// When the account was marked for cleanup, unknown for the accounts
+	// marked before the field was recorded
+	QueuedSince        *time.Time `json:"queued_since,omitempty"`
+	TimeInQueueSeconds int64      `json:"time_in_queue_seconds,omitempty"`
+	QuarantineReason   string     `json:"quarantine_reason,omitempty"`
+}
+
+// AwsAccountCleanupQueue returns the accounts to cleanup at now, the longest in the queue first
+func AwsAccountCleanupQueue(accounts []AwsAccount, now time.Time, policy CleanupPolicy) []AwsAccountCleanupQueueEntry {
+	queue := []AwsAccountCleanupQueueEntry{}
+	for _, account := range accounts {
+		state := CleanupState(account, now, policy)
+		if state == "" {
+			continue
+		}
+
+		entry := AwsAccountCleanupQueueEntry{
+			Name:             account.Name,
+			Reservation:      account.Reservation,
+			State:            state,
+			Holder:           account.ConanHostname,
+			FailedCleanups:   account.ConanCleanupCount,
+			QueuedSince:      account.ToCleanupSince,
+			QuarantineReason: account.QuarantineReason,
+		}
+		if account.ToCleanupSince != nil {
+			entry.TimeInQueueSeconds = int64(now.Sub(*account.ToCleanupSince).Seconds())
+		}
+		queue = append(queue, entry)
+	}
+
+	sort.SliceStable(queue, func(i, j int) bool {
+		switch {
+		case queue[i].QueuedSince == nil:
+			return false
+		case queue[j].QueuedSince == nil:
+			return true
+		}
+		return queue[i].QueuedSince.Before(*queue[j].QueuedSince)
+	})
+	return queue
+}
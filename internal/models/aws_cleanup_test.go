@@ -0,0 +1,133 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCleanupState(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	policy := DefaultCleanupPolicy()
+
+	account := func(status string, timestamp time.Time, count int) AwsAccount {
+		a := AwsAccount{Name: "sandbox1", ConanStatus: status, ConanTimestamp: timestamp, ConanCleanupCount: count}
+		a.ToCleanup = true
+		return a
+	}
+	quarantined := account("", now.Add(-25*time.Hour), 6)
+	quarantined.Quarantined = true
+
+	testCases := []struct {
+		name     string
+		account  AwsAccount
+		expected string
+	}{
+		{"not to cleanup", AwsAccount{Name: "sandbox1"}, ""},
+		{"never cleaned up", account("", time.Time{}, 0), "claimable"},
+		{"lease held", account(CleanupInProgress, now.Add(-time.Hour), 0), "leased"},
+		{"lease expired", account(CleanupInProgress, now.Add(-3*time.Hour), 0), "claimable"},
+		{"failed once", account("", now.Add(-time.Hour), 1), "claimable"},
+		{"max retries", account("", now.Add(-time.Hour), 2), "max_retries"},
+		{"max retries, lease expired", account(CleanupInProgress, now.Add(-3*time.Hour), 2), "max_retries"},
+		{"max retries, retry after", account("", now.Add(-25*time.Hour), 2), "claimable"},
+		{"quarantined", quarantined, "quarantined"},
+	}
+
+	for _, tc := range testCases {
+		if state := CleanupState(tc.account, now, policy); state != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.expected, state)
+		}
+		if claimable := CleanupClaimable(tc.account, now, policy); claimable != (tc.expected == "claimable") {
+			t.Errorf("%s: expected claimable %v, got %v", tc.name, tc.expected == "claimable", claimable)
+		}
+	}
+}
+
+func TestCleanupPolicyShouldQuarantine(t *testing.T) {
+	policy := DefaultCleanupPolicy()
+	if policy.ShouldQuarantine(policy.QuarantineAfter - 1) {
+		t.Error("account quarantined before QuarantineAfter failed cleanups")
+	}
+	if !policy.ShouldQuarantine(policy.QuarantineAfter) {
+		t.Error("account not quarantined after QuarantineAfter failed cleanups")
+	}
+
+	policy.QuarantineAfter = 0
+	if policy.ShouldQuarantine(100) {
+		t.Error("account quarantined with the quarantine disabled")
+	}
+}
+
+func TestAwsAccountCleanupQueue(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	policy := DefaultCleanupPolicy()
+
+	account := func(name string, queuedSince *time.Time) AwsAccount {
+		a := AwsAccount{Name: name, ToCleanupSince: queuedSince}
+		a.ToCleanup = queuedSince != nil || name == "unknown"
+		return a
+	}
+	hourAgo := now.Add(-time.Hour)
+	dayAgo := now.Add(-24 * time.Hour)
+	leased := account("leased", &hourAgo)
+	leased.ConanStatus = CleanupInProgress
+	leased.ConanHostname = "cleaner-0"
+	leased.ConanTimestamp = now.Add(-10 * time.Minute)
+
+	queue := AwsAccountCleanupQueue([]AwsAccount{
+		account("unknown", nil),
+		leased,
+		account("in use", nil),
+		account("oldest", &dayAgo),
+	}, now, policy)
+
+	if len(queue) != 3 {
+		t.Fatalf("expected 3 accounts in the queue, got %d", len(queue))
+	}
+	for i, name := range []string{"oldest", "leased", "unknown"} {
+		if queue[i].Name != name {
+			t.Errorf("expected %s at position %d, got %s", name, i, queue[i].Name)
+		}
+	}
+	if queue[0].TimeInQueueSeconds != 24*3600 || queue[0].State != "claimable" {
+		t.Errorf("unexpected oldest entry: %+v", queue[0])
+	}
+	if queue[1].State != "leased" || queue[1].Holder != "cleaner-0" {
+		t.Errorf("unexpected leased entry: %+v", queue[1])
+	}
+	if queue[2].QueuedSince != nil || queue[2].TimeInQueueSeconds != 0 {
+		t.Errorf("unexpected entry without queued_since: %+v", queue[2])
+	}
+}
+
+func TestCleanupErrorSummary(t *testing.T) {
+	if summary := CleanupErrorSummary(nil); summary != "" {
+		t.Errorf("expected empty summary, got %q", summary)
+	}
+	if summary := CleanupErrorSummary(errors.New("aws-nuke failed")); summary != "aws-nuke failed" {
+		t.Errorf("unexpected summary %q", summary)
+	}
+
+	long := strings.Repeat("x", 2000) + "the cause"
+	summary := CleanupErrorSummary(errors.New(long))
+	if len(summary) != 1027 || !strings.HasSuffix(summary, "the cause") || !strings.HasPrefix(summary, "...") {
+		t.Errorf("unexpected summary of a long error, length %d", len(summary))
+	}
+}
+
+func TestCleanupPolicyFromEnv(t *testing.T) {
+	t.Setenv("CLEANER_LEASE", "30m")
+	t.Setenv("CLEANER_MAX_RETRIES", "5")
+	t.Setenv("CLEANER_QUARANTINE_AFTER", "0")
+
+	policy := CleanupPolicyFromEnv()
+	expected := DefaultCleanupPolicy()
+	expected.Lease = 30 * time.Minute
+	expected.MaxRetries = 5
+	expected.QuarantineAfter = 0
+	if policy != expected {
+		t.Errorf("expected %+v, got %+v", expected, policy)
+	}
+}
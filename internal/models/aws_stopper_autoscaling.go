@@ -0,0 +1,219 @@
+package models
+
+import (
+	"context"
+
+	"github.com/rhpds/sandbox/internal/log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	asgtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+)
+
+// autoscalingStopper scales Auto Scaling Groups down to zero and restores
+// their previous capacity on start.
+// ASGs of EKS managed node groups are skipped, they are handled by the eks stopper.
+type autoscalingStopper struct{}
+
+// asgState is the capacity of an ASG saved in lifecycle_events when it is stopped
+type asgState struct {
+	AsgName         string `json:"asg_name"`
+	MinSize         int32  `json:"min_size"`
+	MaxSize         int32  `json:"max_size"`
+	DesiredCapacity int32  `json:"desired_capacity"`
+}
+
+func (s autoscalingStopper) Name() string {
+	return "autoscaling"
+}
+
+// managedByEks returns true if the ASG belongs to an EKS managed node group
+func managedByEks(group asgtypes.AutoScalingGroup) bool {
+	for _, tag := range group.Tags {
+		if tag.Key != nil && *tag.Key == "eks:nodegroup-name" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s autoscalingStopper) groups(ctx context.Context, client *autoscaling.Client) ([]asgtypes.AutoScalingGroup, error) {
+	groups := []asgtypes.AutoScalingGroup{}
+	paginator := autoscaling.NewDescribeAutoScalingGroupsPaginator(client, &autoscaling.DescribeAutoScalingGroupsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return groups, err
+		}
+		for _, group := range page.AutoScalingGroups {
+			if managedByEks(group) {
+				continue
+			}
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
+}
+
+func (s autoscalingStopper) Stop(ctx context.Context, cfg aws.Config, a AwsAccount, job *LifecycleResourceJob) error {
+	client := autoscaling.NewFromConfig(cfg)
+
+	groups, err := s.groups(ctx, client)
+	if err != nil {
+		if isServiceUnavailable(err) {
+			return nil
+		}
+		log.Logger.Error("Error describing autoscaling groups", "account", a.Name, "error", err)
+		return err
+	}
+
+	var errR error
+	for _, group := range groups {
+		// The job may be cancelled, stop between resources
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if aws.ToInt32(group.DesiredCapacity) == 0 && aws.ToInt32(group.MinSize) == 0 {
+			continue
+		}
+
+		state := asgState{
+			AsgName:         aws.ToString(group.AutoScalingGroupName),
+			MinSize:         aws.ToInt32(group.MinSize),
+			MaxSize:         aws.ToInt32(group.MaxSize),
+			DesiredCapacity: aws.ToInt32(group.DesiredCapacity),
+		}
+
+		// Save the capacity before scaling down, start needs it to restore the ASG.
+		if err := saveLifecycleEvent(ctx, job, a, "stop_asg", cfg.Region, map[string]any{
+			"asg_name":         state.AsgName,
+			"min_size":         state.MinSize,
+			"max_size":         state.MaxSize,
+			"desired_capacity": state.DesiredCapacity,
+		}); err != nil {
+			errR = err
+			continue
+		}
+
+		_, err := client.UpdateAutoScalingGroup(ctx, &autoscaling.UpdateAutoScalingGroupInput{
+			AutoScalingGroupName: group.AutoScalingGroupName,
+			MinSize:              aws.Int32(0),
+			DesiredCapacity:      aws.Int32(0),
+		})
+		if err != nil {
+			log.Logger.Error("Error scaling down autoscaling group", "account", a.Name, "asg", state.AsgName, "error", err)
+			errR = err
+			continue
+		}
+
+		log.Logger.Info("Stop autoscaling group",
+			"account", a.Name,
+			"account_id", a.AccountID,
+			"asg", state.AsgName,
+			"desired_capacity", state.DesiredCapacity,
+			"region", cfg.Region,
+			"request_id", ctx.Value("RequestID"),
+			"service_uuid", ctx.Value("ServiceUUID"),
+		)
+	}
+
+	return errR
+}
+
+func (s autoscalingStopper) Start(ctx context.Context, cfg aws.Config, a AwsAccount, job *LifecycleResourceJob) error {
+	client := autoscaling.NewFromConfig(cfg)
+
+	groups, err := s.groups(ctx, client)
+	if err != nil {
+		if isServiceUnavailable(err) {
+			return nil
+		}
+		log.Logger.Error("Error describing autoscaling groups", "account", a.Name, "error", err)
+		return err
+	}
+
+	var errR error
+	for _, group := range groups {
+		// The job may be cancelled, stop between resources
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if aws.ToInt32(group.DesiredCapacity) != 0 {
+			continue
+		}
+
+		name := aws.ToString(group.AutoScalingGroupName)
+		// The ASG scaled down since the last start was not stopped by us
+		if !stoppedByLifecycle(ctx, job, a, "stop_asg", "start_asg", cfg.Region, "asg_name", name) {
+			continue
+		}
+		var state asgState
+		if err := lastLifecycleEvent(ctx, job, a, "stop_asg", cfg.Region, "asg_name", name, &state); err != nil {
+			// No saved state: the ASG was not stopped by us
+			continue
+		}
+
+		_, err := client.UpdateAutoScalingGroup(ctx, &autoscaling.UpdateAutoScalingGroupInput{
+			AutoScalingGroupName: group.AutoScalingGroupName,
+			MinSize:              aws.Int32(state.MinSize),
+			MaxSize:              aws.Int32(state.MaxSize),
+			DesiredCapacity:      aws.Int32(state.DesiredCapacity),
+		})
+		if err != nil {
+			log.Logger.Error("Error restoring autoscaling group", "account", a.Name, "asg", name, "error", err)
+			errR = err
+			continue
+		}
+
+		log.Logger.Info("Start autoscaling group",
+			"account", a.Name,
+			"account_id", a.AccountID,
+			"asg", name,
+			"desired_capacity", state.DesiredCapacity,
+			"region", cfg.Region,
+			"request_id", ctx.Value("RequestID"),
+			"service_uuid", ctx.Value("ServiceUUID"),
+		)
+
+		saveLifecycleEvent(ctx, job, a, "start_asg", cfg.Region, map[string]any{
+			"asg_name":         name,
+			"min_size":         state.MinSize,
+			"max_size":         state.MaxSize,
+			"desired_capacity": state.DesiredCapacity,
+		})
+	}
+
+	return errR
+}
+
+func (s autoscalingStopper) Status(ctx context.Context, cfg aws.Config, a AwsAccount) ([]Instance, error) {
+	client := autoscaling.NewFromConfig(cfg)
+
+	groups, err := s.groups(ctx, client)
+	if err != nil {
+		if isServiceUnavailable(err) {
+			return []Instance{}, nil
+		}
+		log.Logger.Error("Error describing autoscaling groups", "account", a.Name, "error", err)
+		return nil, err
+	}
+
+	instances := []Instance{}
+	for _, group := range groups {
+		state := "running"
+		if aws.ToInt32(group.DesiredCapacity) == 0 {
+			state = "stopped"
+		}
+		instances = append(instances, Instance{
+			Service:      s.Name(),
+			InstanceId:   aws.ToString(group.AutoScalingGroupName),
+			InstanceName: aws.ToString(group.AutoScalingGroupName),
+			Region:       cfg.Region,
+			State:        state,
+		})
+	}
+
+	return instances, nil
+}
@@ -0,0 +1,187 @@
+package models
+
+import (
+	"context"
+
+	"github.com/rhpds/sandbox/internal/log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// ec2Stopper stops and starts EC2 instances
+type ec2Stopper struct{}
+
+func (s ec2Stopper) Name() string {
+	return "ec2"
+}
+
+// managedByAutoscaling returns true if the instance belongs to an Auto Scaling Group.
+// Those instances are handled by the autoscaling stopper.
+func managedByAutoscaling(instance ec2types.Instance) bool {
+	for _, tag := range instance.Tags {
+		if tag.Key != nil && *tag.Key == "aws:autoscaling:groupName" {
+			return true
+		}
+	}
+	return false
+}
+
+// instances returns the EC2 instances in one of the states, all of them if states is empty
+func (s ec2Stopper) instances(ctx context.Context, client *ec2.Client, states ...string) ([]ec2types.Instance, error) {
+	input := &ec2.DescribeInstancesInput{}
+	if len(states) > 0 {
+		input.Filters = []ec2types.Filter{
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: states,
+			},
+		}
+	}
+
+	instances := []ec2types.Instance{}
+	paginator := ec2.NewDescribeInstancesPaginator(client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return instances, err
+		}
+		for _, reservation := range page.Reservations {
+			instances = append(instances, reservation.Instances...)
+		}
+	}
+	return instances, nil
+}
+
+func (s ec2Stopper) Stop(ctx context.Context, cfg aws.Config, a AwsAccount, job *LifecycleResourceJob) error {
+	client := ec2.NewFromConfig(cfg)
+
+	instances, err := s.instances(ctx, client, "running", "pending")
+
+	if err != nil {
+		log.Logger.Error("Error describing instances", "account", a.Name, "error", err)
+		return err
+	}
+
+	var errR error
+	// Stop all instances
+	for _, instance := range instances {
+		// The job may be cancelled, stop between resources
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if managedByAutoscaling(instance) {
+			continue
+		}
+
+		_, err := client.StopInstances(ctx, &ec2.StopInstancesInput{
+			InstanceIds: []string{*instance.InstanceId},
+		})
+
+		if err != nil {
+			log.Logger.Error("Error stopping instance", "account", a.Name, "error", err)
+			errR = err
+			continue
+		}
+		log.Logger.Info("Stop instance",
+			"account", a.Name,
+			"account_id", a.AccountID,
+			"instance_id", *instance.InstanceId,
+			"instance_type", instance.InstanceType,
+			"region", cfg.Region,
+			"request_id", ctx.Value("RequestID"),
+			"service_uuid", ctx.Value("ServiceUUID"),
+		)
+
+		// save event as json in DB
+		saveLifecycleEvent(ctx, job, a, "stop_instance", cfg.Region, map[string]any{
+			"instance_id":   *instance.InstanceId,
+			"instance_type": string(instance.InstanceType),
+		})
+	}
+
+	return errR
+}
+
+func (s ec2Stopper) Start(ctx context.Context, cfg aws.Config, a AwsAccount, job *LifecycleResourceJob) error {
+	client := ec2.NewFromConfig(cfg)
+
+	instances, err := s.instances(ctx, client, "stopped", "stopping")
+
+	if err != nil {
+		log.Logger.Error("Error describing instances", "account", a.Name, "error", err)
+		return err
+	}
+
+	var errR error
+	// Start the instances stopped by the lifecycle
+	for _, instance := range instances {
+		// The job may be cancelled, stop between resources
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// The instances of the ASGs are started by the autoscaling stopper
+		if managedByAutoscaling(instance) {
+			continue
+		}
+
+		if !stoppedByLifecycle(ctx, job, a, "stop_instance", "start_instance", cfg.Region, "instance_id", *instance.InstanceId) {
+			continue
+		}
+
+		_, err := client.StartInstances(ctx, &ec2.StartInstancesInput{
+			InstanceIds: []string{*instance.InstanceId},
+		})
+
+		if err != nil {
+			log.Logger.Error("Error starting instance", "account", a.Name, "error", err)
+			errR = err
+			continue
+		}
+		log.Logger.Info("Start instance",
+			"account", a.Name,
+			"account_id", a.AccountID,
+			"instance_id", *instance.InstanceId,
+			"instance_type", instance.InstanceType,
+			"region", cfg.Region,
+			"request_id", ctx.Value("RequestID"),
+			"service_uuid", ctx.Value("ServiceUUID"),
+		)
+
+		// save event as json in DB
+		saveLifecycleEvent(ctx, job, a, "start_instance", cfg.Region, map[string]any{
+			"instance_id":   *instance.InstanceId,
+			"instance_type": string(instance.InstanceType),
+		})
+	}
+
+	return errR
+}
+
+func (s ec2Stopper) Status(ctx context.Context, cfg aws.Config, a AwsAccount) ([]Instance, error) {
+	client := ec2.NewFromConfig(cfg)
+
+	// Describe all EC2 instances
+	ec2Instances, err := s.instances(ctx, client)
+
+	if err != nil {
+		log.Logger.Error("Error describing instances", "account", a.Name, "error", err)
+		return nil, err
+	}
+
+	instances := []Instance{}
+	for _, instance := range ec2Instances {
+		instances = append(instances, Instance{
+			Service:      s.Name(),
+			InstanceId:   *instance.InstanceId,
+			InstanceType: string(instance.InstanceType),
+			Region:       cfg.Region,
+			State:        string(instance.State.Name),
+		})
+	}
+
+	return instances, nil
+}
@@ -0,0 +1,248 @@
+package models
+
+import (
+	"context"
+	"strings"
+
+	"github.com/rhpds/sandbox/internal/log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+)
+
+// eksStopper scales EKS managed node groups down to zero and restores
+// their previous scaling configuration on start.
+type eksStopper struct{}
+
+// nodegroupState is the scaling configuration of a node group saved in lifecycle_events
+type nodegroupState struct {
+	Nodegroup   string `json:"nodegroup"` // cluster/nodegroup
+	MinSize     int32  `json:"min_size"`
+	MaxSize     int32  `json:"max_size"`
+	DesiredSize int32  `json:"desired_size"`
+}
+
+func (s eksStopper) Name() string {
+	return "eks"
+}
+
+// nodegroups returns all the managed node groups of all the EKS clusters in the region
+func (s eksStopper) nodegroups(ctx context.Context, client *eks.Client) ([]ekstypes.Nodegroup, error) {
+	result := []ekstypes.Nodegroup{}
+
+	clusters := eks.NewListClustersPaginator(client, &eks.ListClustersInput{})
+	for clusters.HasMorePages() {
+		page, err := clusters.NextPage(ctx)
+		if err != nil {
+			return result, err
+		}
+
+		for _, cluster := range page.Clusters {
+			nodegroups := eks.NewListNodegroupsPaginator(client, &eks.ListNodegroupsInput{
+				ClusterName: aws.String(cluster),
+			})
+			for nodegroups.HasMorePages() {
+				ngPage, err := nodegroups.NextPage(ctx)
+				if err != nil {
+					return result, err
+				}
+
+				for _, name := range ngPage.Nodegroups {
+					out, err := client.DescribeNodegroup(ctx, &eks.DescribeNodegroupInput{
+						ClusterName:   aws.String(cluster),
+						NodegroupName: aws.String(name),
+					})
+					if err != nil {
+						return result, err
+					}
+					if out.Nodegroup.ScalingConfig == nil {
+						continue
+					}
+					result = append(result, *out.Nodegroup)
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func nodegroupID(ng ekstypes.Nodegroup) string {
+	return aws.ToString(ng.ClusterName) + "/" + aws.ToString(ng.NodegroupName)
+}
+
+func (s eksStopper) Stop(ctx context.Context, cfg aws.Config, a AwsAccount, job *LifecycleResourceJob) error {
+	client := eks.NewFromConfig(cfg)
+
+	nodegroups, err := s.nodegroups(ctx, client)
+	if err != nil {
+		if isServiceUnavailable(err) {
+			return nil
+		}
+		log.Logger.Error("Error describing node groups", "account", a.Name, "error", err)
+		return err
+	}
+
+	var errR error
+	for _, ng := range nodegroups {
+		// The job may be cancelled, stop between resources
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		scaling := ng.ScalingConfig
+		if aws.ToInt32(scaling.DesiredSize) == 0 && aws.ToInt32(scaling.MinSize) == 0 {
+			continue
+		}
+
+		state := nodegroupState{
+			Nodegroup:   nodegroupID(ng),
+			MinSize:     aws.ToInt32(scaling.MinSize),
+			MaxSize:     aws.ToInt32(scaling.MaxSize),
+			DesiredSize: aws.ToInt32(scaling.DesiredSize),
+		}
+
+		// Save the scaling configuration before scaling down, start needs it.
+		if err := saveLifecycleEvent(ctx, job, a, "stop_nodegroup", cfg.Region, map[string]any{
+			"nodegroup":     state.Nodegroup,
+			"instance_type": strings.Join(ng.InstanceTypes, ","),
+			"min_size":      state.MinSize,
+			"max_size":      state.MaxSize,
+			"desired_size":  state.DesiredSize,
+		}); err != nil {
+			errR = err
+			continue
+		}
+
+		// MaxSize must stay >= 1
+		_, err := client.UpdateNodegroupConfig(ctx, &eks.UpdateNodegroupConfigInput{
+			ClusterName:   ng.ClusterName,
+			NodegroupName: ng.NodegroupName,
+			ScalingConfig: &ekstypes.NodegroupScalingConfig{
+				MinSize:     aws.Int32(0),
+				MaxSize:     scaling.MaxSize,
+				DesiredSize: aws.Int32(0),
+			},
+		})
+		if err != nil {
+			log.Logger.Error("Error scaling down node group", "account", a.Name, "nodegroup", state.Nodegroup, "error", err)
+			errR = err
+			continue
+		}
+
+		log.Logger.Info("Stop node group",
+			"account", a.Name,
+			"account_id", a.AccountID,
+			"nodegroup", state.Nodegroup,
+			"desired_size", state.DesiredSize,
+			"region", cfg.Region,
+			"request_id", ctx.Value("RequestID"),
+			"service_uuid", ctx.Value("ServiceUUID"),
+		)
+	}
+
+	return errR
+}
+
+func (s eksStopper) Start(ctx context.Context, cfg aws.Config, a AwsAccount, job *LifecycleResourceJob) error {
+	client := eks.NewFromConfig(cfg)
+
+	nodegroups, err := s.nodegroups(ctx, client)
+	if err != nil {
+		if isServiceUnavailable(err) {
+			return nil
+		}
+		log.Logger.Error("Error describing node groups", "account", a.Name, "error", err)
+		return err
+	}
+
+	var errR error
+	for _, ng := range nodegroups {
+		// The job may be cancelled, stop between resources
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if aws.ToInt32(ng.ScalingConfig.DesiredSize) != 0 {
+			continue
+		}
+
+		id := nodegroupID(ng)
+		// The node group scaled down since the last start was not stopped by us
+		if !stoppedByLifecycle(ctx, job, a, "stop_nodegroup", "start_nodegroup", cfg.Region, "nodegroup", id) {
+			continue
+		}
+		var state nodegroupState
+		if err := lastLifecycleEvent(ctx, job, a, "stop_nodegroup", cfg.Region, "nodegroup", id, &state); err != nil {
+			// No saved state: the node group was not stopped by us
+			continue
+		}
+
+		_, err := client.UpdateNodegroupConfig(ctx, &eks.UpdateNodegroupConfigInput{
+			ClusterName:   ng.ClusterName,
+			NodegroupName: ng.NodegroupName,
+			ScalingConfig: &ekstypes.NodegroupScalingConfig{
+				MinSize:     aws.Int32(state.MinSize),
+				MaxSize:     aws.Int32(state.MaxSize),
+				DesiredSize: aws.Int32(state.DesiredSize),
+			},
+		})
+		if err != nil {
+			log.Logger.Error("Error restoring node group", "account", a.Name, "nodegroup", id, "error", err)
+			errR = err
+			continue
+		}
+
+		log.Logger.Info("Start node group",
+			"account", a.Name,
+			"account_id", a.AccountID,
+			"nodegroup", id,
+			"desired_size", state.DesiredSize,
+			"region", cfg.Region,
+			"request_id", ctx.Value("RequestID"),
+			"service_uuid", ctx.Value("ServiceUUID"),
+		)
+
+		saveLifecycleEvent(ctx, job, a, "start_nodegroup", cfg.Region, map[string]any{
+			"nodegroup":     id,
+			"instance_type": strings.Join(ng.InstanceTypes, ","),
+			"min_size":      state.MinSize,
+			"max_size":      state.MaxSize,
+			"desired_size":  state.DesiredSize,
+		})
+	}
+
+	return errR
+}
+
+func (s eksStopper) Status(ctx context.Context, cfg aws.Config, a AwsAccount) ([]Instance, error) {
+	client := eks.NewFromConfig(cfg)
+
+	nodegroups, err := s.nodegroups(ctx, client)
+	if err != nil {
+		if isServiceUnavailable(err) {
+			return []Instance{}, nil
+		}
+		log.Logger.Error("Error describing node groups", "account", a.Name, "error", err)
+		return nil, err
+	}
+
+	instances := []Instance{}
+	for _, ng := range nodegroups {
+		state := "running"
+		if aws.ToInt32(ng.ScalingConfig.DesiredSize) == 0 {
+			state = "stopped"
+		}
+		instances = append(instances, Instance{
+			Service:      s.Name(),
+			InstanceId:   nodegroupID(ng),
+			InstanceName: aws.ToString(ng.NodegroupName),
+			InstanceType: strings.Join(ng.InstanceTypes, ","),
+			Region:       cfg.Region,
+			State:        state,
+		})
+	}
+
+	return instances, nil
+}
@@ -0,0 +1,219 @@
+package models
+
+import (
+	"context"
+
+	"github.com/rhpds/sandbox/internal/log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+)
+
+// rdsStopper stops and starts Aurora clusters and RDS instances.
+// Instances that belong to a cluster are stopped and started with their cluster.
+type rdsStopper struct{}
+
+func (s rdsStopper) Name() string {
+	return "rds"
+}
+
+func (s rdsStopper) clusters(ctx context.Context, client *rds.Client) ([]rdstypes.DBCluster, error) {
+	clusters := []rdstypes.DBCluster{}
+	paginator := rds.NewDescribeDBClustersPaginator(client, &rds.DescribeDBClustersInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return clusters, err
+		}
+		clusters = append(clusters, page.DBClusters...)
+	}
+	return clusters, nil
+}
+
+// instances returns the DB instances that are not part of a cluster
+func (s rdsStopper) instances(ctx context.Context, client *rds.Client) ([]rdstypes.DBInstance, error) {
+	instances := []rdstypes.DBInstance{}
+	paginator := rds.NewDescribeDBInstancesPaginator(client, &rds.DescribeDBInstancesInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return instances, err
+		}
+		for _, instance := range page.DBInstances {
+			if instance.DBClusterIdentifier != nil {
+				continue
+			}
+			instances = append(instances, instance)
+		}
+	}
+	return instances, nil
+}
+
+func (s rdsStopper) Stop(ctx context.Context, cfg aws.Config, a AwsAccount, job *LifecycleResourceJob) error {
+	return s.toggle(ctx, cfg, a, job, "stop")
+}
+
+func (s rdsStopper) Start(ctx context.Context, cfg aws.Config, a AwsAccount, job *LifecycleResourceJob) error {
+	return s.toggle(ctx, cfg, a, job, "start")
+}
+
+// toggle stops the available clusters and instances, or starts the ones
+// stopped by the lifecycle, depending on the action.
+func (s rdsStopper) toggle(ctx context.Context, cfg aws.Config, a AwsAccount, job *LifecycleResourceJob, action string) error {
+	client := rds.NewFromConfig(cfg)
+
+	fromStatus := "available"
+	if action == "start" {
+		fromStatus = "stopped"
+	}
+
+	clusters, err := s.clusters(ctx, client)
+	if err != nil {
+		if isServiceUnavailable(err) {
+			return nil
+		}
+		log.Logger.Error("Error describing DB clusters", "account", a.Name, "error", err)
+		return err
+	}
+
+	var errR error
+	for _, cluster := range clusters {
+		// The job may be cancelled, stop between resources
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if aws.ToString(cluster.Status) != fromStatus {
+			continue
+		}
+		if action == "start" && !stoppedByLifecycle(ctx, job, a, "stop_db_cluster", "start_db_cluster", cfg.Region, "instance_id", aws.ToString(cluster.DBClusterIdentifier)) {
+			continue
+		}
+
+		if action == "start" {
+			_, err = client.StartDBCluster(ctx, &rds.StartDBClusterInput{
+				DBClusterIdentifier: cluster.DBClusterIdentifier,
+			})
+		} else {
+			_, err = client.StopDBCluster(ctx, &rds.StopDBClusterInput{
+				DBClusterIdentifier: cluster.DBClusterIdentifier,
+			})
+		}
+		if err != nil {
+			log.Logger.Error("Error on DB cluster", "action", action, "account", a.Name, "cluster", aws.ToString(cluster.DBClusterIdentifier), "error", err)
+			errR = err
+			continue
+		}
+
+		log.Logger.Info(action+" DB cluster",
+			"account", a.Name,
+			"account_id", a.AccountID,
+			"cluster", aws.ToString(cluster.DBClusterIdentifier),
+			"engine", aws.ToString(cluster.Engine),
+			"region", cfg.Region,
+			"request_id", ctx.Value("RequestID"),
+			"service_uuid", ctx.Value("ServiceUUID"),
+		)
+
+		saveLifecycleEvent(ctx, job, a, action+"_db_cluster", cfg.Region, map[string]any{
+			"instance_id": aws.ToString(cluster.DBClusterIdentifier),
+			"engine":      aws.ToString(cluster.Engine),
+		})
+	}
+
+	instances, err := s.instances(ctx, client)
+	if err != nil {
+		log.Logger.Error("Error describing DB instances", "account", a.Name, "error", err)
+		return err
+	}
+
+	for _, instance := range instances {
+		// The job may be cancelled, stop between resources
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if aws.ToString(instance.DBInstanceStatus) != fromStatus {
+			continue
+		}
+		if action == "start" && !stoppedByLifecycle(ctx, job, a, "stop_db_instance", "start_db_instance", cfg.Region, "instance_id", aws.ToString(instance.DBInstanceIdentifier)) {
+			continue
+		}
+
+		if action == "start" {
+			_, err = client.StartDBInstance(ctx, &rds.StartDBInstanceInput{
+				DBInstanceIdentifier: instance.DBInstanceIdentifier,
+			})
+		} else {
+			_, err = client.StopDBInstance(ctx, &rds.StopDBInstanceInput{
+				DBInstanceIdentifier: instance.DBInstanceIdentifier,
+			})
+		}
+		if err != nil {
+			log.Logger.Error("Error on DB instance", "action", action, "account", a.Name, "instance", aws.ToString(instance.DBInstanceIdentifier), "error", err)
+			errR = err
+			continue
+		}
+
+		log.Logger.Info(action+" DB instance",
+			"account", a.Name,
+			"account_id", a.AccountID,
+			"instance_id", aws.ToString(instance.DBInstanceIdentifier),
+			"instance_type", aws.ToString(instance.DBInstanceClass),
+			"region", cfg.Region,
+			"request_id", ctx.Value("RequestID"),
+			"service_uuid", ctx.Value("ServiceUUID"),
+		)
+
+		saveLifecycleEvent(ctx, job, a, action+"_db_instance", cfg.Region, map[string]any{
+			"instance_id":   aws.ToString(instance.DBInstanceIdentifier),
+			"instance_type": aws.ToString(instance.DBInstanceClass),
+			"engine":        aws.ToString(instance.Engine),
+		})
+	}
+
+	return errR
+}
+
+func (s rdsStopper) Status(ctx context.Context, cfg aws.Config, a AwsAccount) ([]Instance, error) {
+	client := rds.NewFromConfig(cfg)
+
+	clusters, err := s.clusters(ctx, client)
+	if err != nil {
+		if isServiceUnavailable(err) {
+			return []Instance{}, nil
+		}
+		log.Logger.Error("Error describing DB clusters", "account", a.Name, "error", err)
+		return nil, err
+	}
+
+	result := []Instance{}
+	for _, cluster := range clusters {
+		result = append(result, Instance{
+			Service:      s.Name(),
+			InstanceId:   aws.ToString(cluster.DBClusterIdentifier),
+			InstanceType: aws.ToString(cluster.DBClusterInstanceClass),
+			Region:       cfg.Region,
+			State:        aws.ToString(cluster.Status),
+		})
+	}
+
+	instances, err := s.instances(ctx, client)
+	if err != nil {
+		log.Logger.Error("Error describing DB instances", "account", a.Name, "error", err)
+		return nil, err
+	}
+
+	for _, instance := range instances {
+		result = append(result, Instance{
+			Service:      s.Name(),
+			InstanceId:   aws.ToString(instance.DBInstanceIdentifier),
+			InstanceType: aws.ToString(instance.DBInstanceClass),
+			Region:       cfg.Region,
+			State:        aws.ToString(instance.DBInstanceStatus),
+		})
+	}
+
+	return result, nil
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"context"
+
+	"github.com/rhpds/sandbox/internal/log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sagemaker"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/sagemaker/types"
+)
+
+// sagemakerStopper stops and starts SageMaker notebook instances
+type sagemakerStopper struct{}
+
+func (s sagemakerStopper) Name() string {
+	return "sagemaker"
+}
+
+func (s sagemakerStopper) notebooks(ctx context.Context, client *sagemaker.Client) ([]smtypes.NotebookInstanceSummary, error) {
+	notebooks := []smtypes.NotebookInstanceSummary{}
+	paginator := sagemaker.NewListNotebookInstancesPaginator(client, &sagemaker.ListNotebookInstancesInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return notebooks, err
+		}
+		notebooks = append(notebooks, page.NotebookInstances...)
+	}
+	return notebooks, nil
+}
+
+func (s sagemakerStopper) Stop(ctx context.Context, cfg aws.Config, a AwsAccount, job *LifecycleResourceJob) error {
+	return s.toggle(ctx, cfg, a, job, "stop")
+}
+
+func (s sagemakerStopper) Start(ctx context.Context, cfg aws.Config, a AwsAccount, job *LifecycleResourceJob) error {
+	return s.toggle(ctx, cfg, a, job, "start")
+}
+
+// toggle stops the notebooks in service, or starts the ones stopped by the
+// lifecycle, depending on the action.
+func (s sagemakerStopper) toggle(ctx context.Context, cfg aws.Config, a AwsAccount, job *LifecycleResourceJob, action string) error {
+	client := sagemaker.NewFromConfig(cfg)
+
+	fromStatus := smtypes.NotebookInstanceStatusInService
+	if action == "start" {
+		fromStatus = smtypes.NotebookInstanceStatusStopped
+	}
+
+	notebooks, err := s.notebooks(ctx, client)
+	if err != nil {
+		if isServiceUnavailable(err) {
+			return nil
+		}
+		log.Logger.Error("Error listing notebook instances", "account", a.Name, "error", err)
+		return err
+	}
+
+	var errR error
+	for _, notebook := range notebooks {
+		// The job may be cancelled, stop between resources
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if notebook.NotebookInstanceStatus != fromStatus {
+			continue
+		}
+		if action == "start" && !stoppedByLifecycle(ctx, job, a, "stop_notebook", "start_notebook", cfg.Region, "instance_id", aws.ToString(notebook.NotebookInstanceName)) {
+			continue
+		}
+
+		if action == "start" {
+			_, err = client.StartNotebookInstance(ctx, &sagemaker.StartNotebookInstanceInput{
+				NotebookInstanceName: notebook.NotebookInstanceName,
+			})
+		} else {
+			_, err = client.StopNotebookInstance(ctx, &sagemaker.StopNotebookInstanceInput{
+				NotebookInstanceName: notebook.NotebookInstanceName,
+			})
+		}
+		if err != nil {
+			log.Logger.Error("Error on notebook instance", "action", action, "account", a.Name, "notebook", aws.ToString(notebook.NotebookInstanceName), "error", err)
+			errR = err
+			continue
+		}
+
+		log.Logger.Info(action+" notebook instance",
+			"account", a.Name,
+			"account_id", a.AccountID,
+			"instance_id", aws.ToString(notebook.NotebookInstanceName),
+			"instance_type", notebook.InstanceType,
+			"region", cfg.Region,
+			"request_id", ctx.Value("RequestID"),
+			"service_uuid", ctx.Value("ServiceUUID"),
+		)
+
+		saveLifecycleEvent(ctx, job, a, action+"_notebook", cfg.Region, map[string]any{
+			"instance_id":   aws.ToString(notebook.NotebookInstanceName),
+			"instance_type": string(notebook.InstanceType),
+		})
+	}
+
+	return errR
+}
+
+func (s sagemakerStopper) Status(ctx context.Context, cfg aws.Config, a AwsAccount) ([]Instance, error) {
+	client := sagemaker.NewFromConfig(cfg)
+
+	notebooks, err := s.notebooks(ctx, client)
+	if err != nil {
+		if isServiceUnavailable(err) {
+			return []Instance{}, nil
+		}
+		log.Logger.Error("Error listing notebook instances", "account", a.Name, "error", err)
+		return nil, err
+	}
+
+	instances := []Instance{}
+	for _, notebook := range notebooks {
+		instances = append(instances, Instance{
+			Service:      s.Name(),
+			InstanceId:   aws.ToString(notebook.NotebookInstanceName),
+			InstanceName: aws.ToString(notebook.NotebookInstanceName),
+			InstanceType: string(notebook.InstanceType),
+			Region:       cfg.Region,
+			State:        string(notebook.NotebookInstanceStatus),
+		})
+	}
+
+	return instances, nil
+}
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+
+	sconfig "github.com/rhpds/sandbox/internal/config"
+	"github.com/rhpds/sandbox/internal/log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/jackc/pgx/v4"
+)
+
+// AwsStopper is implemented by every AWS service that takes part in the
+// lifecycle (stop, start, status) of an AwsSandbox.
+// The aws.Config passed to the methods is already set with the credentials
+// of the sandbox and the region to act on.
+type AwsStopper interface {
+	// Name of the service, for example "ec2" or "rds"
+	Name() string
+	Stop(ctx context.Context, cfg aws.Config, a AwsAccount, job *LifecycleResourceJob) error
+	Start(ctx context.Context, cfg aws.Config, a AwsAccount, job *LifecycleResourceJob) error
+	Status(ctx context.Context, cfg aws.Config, a AwsAccount) ([]Instance, error)
+}
+
+// awsStoppers is the registry of stoppers.
+// The order is the start order: databases first, then compute.
+// Stop runs the registry in the reverse order.
+var awsStoppers = []AwsStopper{}
+
+// RegisterAwsStopper adds a stopper to the registry
+func RegisterAwsStopper(s AwsStopper) {
+	awsStoppers = append(awsStoppers, s)
+}
+
+// AwsStoppers returns the registered stoppers in start order
+func AwsStoppers() []AwsStopper {
+	return awsStoppers
+}
+
+func init() {
+	RegisterAwsStopper(rdsStopper{})
+	RegisterAwsStopper(ec2Stopper{})
+	RegisterAwsStopper(autoscalingStopper{})
+	RegisterAwsStopper(eksStopper{})
+	RegisterAwsStopper(sagemakerStopper{})
+}
+
+// saveLifecycleEvent saves an event in the lifecycle_events table.
+// data is stored as json in event_data. The region and locality are added to it.
+func saveLifecycleEvent(ctx context.Context, job *LifecycleResourceJob, a AwsAccount, eventType string, region string, data map[string]any) error {
+	data["account_name"] = a.Name
+	data["account_id"] = a.AccountID
+	data["region"] = region
+	data["locality"] = sconfig.LocalityID
+
+	// The event must be saved even if the job is cancelled, the resource is already
+	// stopped and the event is needed to start it again.
+	_, err := job.DbPool.Exec(
+		context.WithoutCancel(ctx),
+		`INSERT INTO lifecycle_events (event_type, service_uuid, resource_name, resource_type, event_data, request_id)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		eventType,
+		ctx.Value("ServiceUUID"),
+		a.Name,
+		a.Kind,
+		data,
+		job.RequestID,
+	)
+
+	if err != nil {
+		log.Logger.Error("Error saving event", "error", err, "event", eventType)
+	}
+
+	return err
+}
+
+// lastLifecycleEvent loads the event_data of the most recent event of type eventType
+// for the account, in the region, whose event_data key equals value.
+// It is used by the stoppers to restore the state saved when stopping.
+// Returns pgx.ErrNoRows if no event is found.
+func lastLifecycleEvent(ctx context.Context, job *LifecycleResourceJob, a AwsAccount, eventType string, region string, key string, value string, dest any) error {
+	var raw []byte
+	err := job.DbPool.QueryRow(
+		ctx,
+		`SELECT event_data FROM lifecycle_events
+		 WHERE event_type = $1
+		 AND resource_name = $2 AND resource_type = $3
+		 AND event_data->>'region' = $4
+		 AND event_data->>$5 = $6
+		 ORDER BY id DESC LIMIT 1`,
+		eventType, a.Name, a.Kind, region, key, value,
+	).Scan(&raw)
+
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			log.Logger.Error("Error loading event", "error", err, "event", eventType)
+		}
+		return err
+	}
+
+	return json.Unmarshal(raw, dest)
+}
+
+// stoppedByLifecycle returns true if the last stop or start event of the
+// resource, in the region, whose event_data key equals value, is stopEvent.
+// Start only starts the resources stopped by a lifecycle stop: the resources
+// already stopped before are left stopped.
+func stoppedByLifecycle(ctx context.Context, job *LifecycleResourceJob, a AwsAccount, stopEvent string, startEvent string, region string, key string, value string) bool {
+	var eventType string
+	err := job.DbPool.QueryRow(
+		ctx,
+		`SELECT event_type FROM lifecycle_events
+		 WHERE event_type IN ($1, $2)
+		 AND resource_name = $3 AND resource_type = $4
+		 AND event_data->>'region' = $5
+		 AND event_data->>$6 = $7
+		 ORDER BY id DESC LIMIT 1`,
+		stopEvent, startEvent, a.Name, a.Kind, region, key, value,
+	).Scan(&eventType)
+
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			log.Logger.Error("Error loading event", "error", err, "event", stopEvent)
+		}
+		return false
+	}
+
+	return eventType == stopEvent
+}
+
+// isServiceUnavailable returns true if the error means the service has no endpoint
+// in the region. Not every service is available in all the regions enabled
+// in the account.
+func isServiceUnavailable(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
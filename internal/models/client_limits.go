@@ -0,0 +1,411 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	v1 "k8s.io/api/core/v1"
+)
+
+// ClientLimit limits the placement creations of a client, identified by the
+// name of its token. The limits of the name '*' apply to the clients without
+// their own limits. A nil field means unlimited.
+type ClientLimit struct {
+	Name string `json:"name"`
+
+	// Rate of placement creations, and placements that can be created at once
+	PlacementsPerMinute *int `json:"placements_per_minute"`
+	Burst               *int `json:"burst,omitempty"`
+
+	// Resources held at once by the placements of the client
+	MaxAwsAccounts  *int `json:"max_aws_accounts"`
+	MaxOcpSandboxes *int `json:"max_ocp_sandboxes"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type ClientLimits []ClientLimit
+
+// DefaultClientLimitName is the name of the limits of the clients without their own limits
+const DefaultClientLimitName = "*"
+
+// ErrClientLimitExceeded is returned, wrapped, when a placement would exceed the limits of the client
+var ErrClientLimitExceeded = errors.New("client limit exceeded")
+
+func (l *ClientLimit) Bind(r *http.Request) error {
+	for name, value := range map[string]*int{
+		"placements_per_minute": l.PlacementsPerMinute,
+		"max_aws_accounts":      l.MaxAwsAccounts,
+		"max_ocp_sandboxes":     l.MaxOcpSandboxes,
+	} {
+		if value != nil && *value < 0 {
+			return fmt.Errorf("%s must be positive", name)
+		}
+	}
+
+	if l.Burst != nil && *l.Burst < 1 {
+		return errors.New("burst must be at least 1")
+	}
+
+	return nil
+}
+
+func (l *ClientLimit) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func (l ClientLimits) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// burst returns the size of the token bucket
+func (l *ClientLimit) burst() int {
+	if l.Burst != nil {
+		return *l.Burst
+	}
+	if l.PlacementsPerMinute != nil && *l.PlacementsPerMinute > 0 {
+		return *l.PlacementsPerMinute
+	}
+	return 1
+}
+
+// HasCaps returns true if the resources held by the client are limited
+func (l *ClientLimit) HasCaps() bool {
+	return l.MaxAwsAccounts != nil || l.MaxOcpSandboxes != nil
+}
+
+// AllowsResources returns an error wrapping ErrClientLimitExceeded if the
+// resources requested, added to the resources held, exceed the caps.
+func (l *ClientLimit) AllowsResources(heldAws, heldOcp, aws, ocp int) error {
+	if l.MaxAwsAccounts != nil && aws > 0 && heldAws+aws > *l.MaxAwsAccounts {
+		return fmt.Errorf("%w: %d AWS accounts held, %d requested, max_aws_accounts is %d",
+			ErrClientLimitExceeded, heldAws, aws, *l.MaxAwsAccounts)
+	}
+	if l.MaxOcpSandboxes != nil && ocp > 0 && heldOcp+ocp > *l.MaxOcpSandboxes {
+		return fmt.Errorf("%w: %d OcpSandboxes held, %d requested, max_ocp_sandboxes is %d",
+			ErrClientLimitExceeded, heldOcp, ocp, *l.MaxOcpSandboxes)
+	}
+	return nil
+}
+
+const clientLimitColumns = `name, placements_per_minute, burst, max_aws_accounts, max_ocp_sandboxes, created_at, updated_at`
+
+func scanClientLimit(row interface{ Scan(...any) error }) (ClientLimit, error) {
+	var l ClientLimit
+	err := row.Scan(&l.Name, &l.PlacementsPerMinute, &l.Burst, &l.MaxAwsAccounts, &l.MaxOcpSandboxes, &l.CreatedAt, &l.UpdatedAt)
+	return l, err
+}
+
+// GetClientLimit returns the limits set for the name.
+// Returns pgx.ErrNoRows if there are none.
+func GetClientLimit(dbpool *pgxpool.Pool, name string) (*ClientLimit, error) {
+	l, err := scanClientLimit(dbpool.QueryRow(
+		context.Background(),
+		"SELECT "+clientLimitColumns+" FROM client_limits WHERE name = $1",
+		name,
+	))
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// GetEffectiveClientLimit returns the limits of the client: its own limits or
+// the default limits. Returns nil if the client is not limited.
+func GetEffectiveClientLimit(dbpool *pgxpool.Pool, name string) (*ClientLimit, error) {
+	l, err := scanClientLimit(dbpool.QueryRow(
+		context.Background(),
+		"SELECT "+clientLimitColumns+` FROM client_limits
+		 WHERE name = $1 OR name = $2
+		 ORDER BY name = $2 LIMIT 1`,
+		name, DefaultClientLimitName,
+	))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// FetchClientLimits returns all the client limits
+func FetchClientLimits(dbpool *pgxpool.Pool) (ClientLimits, error) {
+	rows, err := dbpool.Query(
+		context.Background(),
+		"SELECT "+clientLimitColumns+" FROM client_limits ORDER BY name",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	limits := ClientLimits{}
+	for rows.Next() {
+		l, err := scanClientLimit(rows)
+		if err != nil {
+			return nil, err
+		}
+		limits = append(limits, l)
+	}
+
+	return limits, rows.Err()
+}
+
+// Save creates or replaces the limits of the client
+func (l *ClientLimit) Save(dbpool *pgxpool.Pool) error {
+	return dbpool.QueryRow(
+		context.Background(),
+		`INSERT INTO client_limits (name, placements_per_minute, burst, max_aws_accounts, max_ocp_sandboxes)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (name) DO UPDATE SET
+		   placements_per_minute = EXCLUDED.placements_per_minute,
+		   burst = EXCLUDED.burst,
+		   max_aws_accounts = EXCLUDED.max_aws_accounts,
+		   max_ocp_sandboxes = EXCLUDED.max_ocp_sandboxes
+		 RETURNING created_at, updated_at`,
+		l.Name, l.PlacementsPerMinute, l.Burst, l.MaxAwsAccounts, l.MaxOcpSandboxes,
+	).Scan(&l.CreatedAt, &l.UpdatedAt)
+}
+
+// DeleteClientLimit deletes the limits of the client, and its rate bucket.
+// Returns pgx.ErrNoRows if the client has no limits.
+func DeleteClientLimit(dbpool *pgxpool.Pool, name string) error {
+	tag, err := dbpool.Exec(context.Background(), "DELETE FROM client_limits WHERE name = $1", name)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	_, err = dbpool.Exec(context.Background(), "DELETE FROM client_rate_buckets WHERE name = $1", name)
+	return err
+}
+
+// refillRateBucket returns the tokens in the bucket after elapsed time.
+// The bucket gains perMinute tokens per minute, up to burst.
+func refillRateBucket(tokens float64, elapsed time.Duration, perMinute int, burst int) float64 {
+	if elapsed > 0 {
+		tokens += elapsed.Minutes() * float64(perMinute)
+	}
+	return math.Min(tokens, float64(burst))
+}
+
+// rateRetryAfter returns the time until the bucket has one token
+func rateRetryAfter(tokens float64, perMinute int) time.Duration {
+	if perMinute <= 0 {
+		return time.Minute
+	}
+	missing := 1 - tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(missing / float64(perMinute) * 60 * float64(time.Second)))
+}
+
+// TakeClientRateToken takes a token from the rate bucket of the client.
+// The bucket is in the database so the rate is shared by all the replicas.
+// It returns 0 if the placement can be created, or the time to wait before retrying.
+func TakeClientRateToken(dbpool *pgxpool.Pool, name string, limit *ClientLimit) (time.Duration, error) {
+	if limit == nil || limit.PlacementsPerMinute == nil {
+		return 0, nil
+	}
+	perMinute := *limit.PlacementsPerMinute
+	burst := limit.burst()
+
+	ctx := context.Background()
+	tx, err := dbpool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO client_rate_buckets (name, tokens, refilled_at) VALUES ($1, $2, now())
+		 ON CONFLICT (name) DO NOTHING`,
+		name, float64(burst)); err != nil {
+		return 0, err
+	}
+
+	var tokens float64
+	var refilledAt, now time.Time
+	if err := tx.QueryRow(ctx,
+		"SELECT tokens, refilled_at, now() FROM client_rate_buckets WHERE name = $1 FOR UPDATE",
+		name).Scan(&tokens, &refilledAt, &now); err != nil {
+		return 0, err
+	}
+
+	tokens = refillRateBucket(tokens, now.Sub(refilledAt), perMinute, burst)
+	retryAfter := rateRetryAfter(tokens, perMinute)
+	if retryAfter == 0 {
+		tokens--
+	}
+
+	if _, err := tx.Exec(ctx,
+		"UPDATE client_rate_buckets SET tokens = $2, refilled_at = $3 WHERE name = $1",
+		name, tokens, now); err != nil {
+		return 0, err
+	}
+
+	return retryAfter, tx.Commit(ctx)
+}
+
+// LockClient takes a lock on the client, shared by all the replicas, so its
+// placements are created one at a time and the caps can't be exceeded by
+// concurrent requests. The returned function releases the lock.
+func LockClient(ctx context.Context, dbpool *pgxpool.Pool, name string) (func(), error) {
+	return advisoryLock(ctx, dbpool, "client_limits:"+name)
+}
+
+// lockConnections limits the connections held by the advisory locks to half
+// of the pool, so the queries run under the locks always get a connection.
+var lockConnections struct {
+	once  sync.Once
+	slots chan struct{}
+}
+
+// advisoryLock takes the Postgres advisory lock of the key on a dedicated
+// connection. The returned function releases the lock and the connection.
+// It waits, until ctx is done, for a connection if the locks hold already
+// half of the pool.
+func advisoryLock(ctx context.Context, dbpool *pgxpool.Pool, key string) (func(), error) {
+	lockConnections.once.Do(func() {
+		lockConnections.slots = make(chan struct{}, max(dbpool.Config().MaxConns/2, 1))
+	})
+	select {
+	case lockConnections.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	conn, err := dbpool.Acquire(ctx)
+	if err != nil {
+		<-lockConnections.slots
+		return nil, err
+	}
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock(hashtext($1))", key); err != nil {
+		conn.Release()
+		<-lockConnections.slots
+		return nil, err
+	}
+
+	return func() {
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", key); err != nil {
+			// Don't give back a connection holding the lock to the pool
+			conn.Conn().Close(context.Background())
+		}
+		conn.Release()
+		<-lockConnections.slots
+	}, nil
+}
+
+// RequestedResources returns the kind, 'AwsSandbox' or 'OcpSandbox', and the number
+// of resources created by a resource request of a placement: count AWS accounts,
+// at least one, and a single OcpSandbox. The kind is empty if it's unknown.
+func RequestedResources(kind string, count int) (string, int) {
+	switch kind = canonicalKind(kind); kind {
+	case "AwsSandbox":
+		return kind, max(count, 1)
+	case "OcpSandbox":
+		return kind, 1
+	}
+	return "", 0
+}
+
+// HeldResources returns the number of AWS accounts and OcpSandboxes held by the
+// placements created by the client, see placementsUsage.
+func HeldResources(ctx context.Context, dbpool *pgxpool.Pool, accountProvider AwsAccountProvider, name string) (aws int, ocp int, err error) {
+	usage, err := placementsUsage(ctx, dbpool, accountProvider, "created_by", name)
+	return usage.AwsAccounts, usage.OcpSandboxes, err
+}
+
+// placementsUsage returns the resources actually held by the placements whose
+// column, created_by or tenant, is name. The placements being deleted don't
+// count, nor do the OcpSandboxes in error. The AWS accounts are counted from
+// the account provider, the OcpSandboxes and their quota from the resources.
+func placementsUsage(ctx context.Context, dbpool *pgxpool.Pool, accountProvider AwsAccountProvider, column string, name string) (TenantUsage, error) {
+	usage := TenantUsage{Name: name}
+
+	rows, err := dbpool.Query(
+		ctx,
+		fmt.Sprintf("SELECT service_uuid FROM placements WHERE %s = $1 AND status != 'deleting'", column),
+		name,
+	)
+	if err != nil {
+		return usage, err
+	}
+	serviceUuids := map[string]bool{}
+	for rows.Next() {
+		var serviceUuid string
+		if err := rows.Scan(&serviceUuid); err != nil {
+			rows.Close()
+			return usage, err
+		}
+		serviceUuids[serviceUuid] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return usage, err
+	}
+
+	usage.Placements = len(serviceUuids)
+	if usage.Placements == 0 {
+		return usage, nil
+	}
+
+	accounts, err := accountProvider.FetchAll()
+	if err != nil {
+		return usage, err
+	}
+	for _, account := range accounts {
+		if serviceUuids[account.ServiceUuid] {
+			usage.AwsAccounts++
+		}
+	}
+
+	rows, err = dbpool.Query(
+		ctx,
+		fmt.Sprintf(`SELECT COALESCE(r.resource_data->'quota', '{}'::jsonb)
+		 FROM resources r JOIN placements p ON p.id = r.placement_id
+		 WHERE p.%s = $1 AND p.status != 'deleting'
+		 AND r.resource_type = 'OcpSandbox' AND r.status != 'error'`, column),
+		name,
+	)
+	if err != nil {
+		return usage, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var quotaJSON []byte
+		if err := rows.Scan(&quotaJSON); err != nil {
+			return usage, err
+		}
+		quota := v1.ResourceList{}
+		if err := json.Unmarshal(quotaJSON, &quota); err != nil {
+			return usage, err
+		}
+
+		usage.OcpSandboxes++
+		cpu, memory := QuotaCpuMemory(quota)
+		if cpu != nil {
+			usage.Cpu.Add(*cpu)
+		}
+		if memory != nil {
+			usage.Memory.Add(*memory)
+		}
+	}
+
+	return usage, rows.Err()
+}
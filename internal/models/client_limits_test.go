@@ -0,0 +1,60 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateBucket(t *testing.T) {
+	// 6 placements per minute, one every 10 seconds
+	if tokens := refillRateBucket(0, 10*time.Second, 6, 3); tokens != 1 {
+		t.Errorf("Bucket should gain 1 token in 10s, got %v", tokens)
+	}
+	if tokens := refillRateBucket(2, time.Hour, 6, 3); tokens != 3 {
+		t.Errorf("Bucket should be capped to the burst, got %v", tokens)
+	}
+
+	if retry := rateRetryAfter(1.5, 6); retry != 0 {
+		t.Errorf("Bucket with tokens should not wait, got %v", retry)
+	}
+	if retry := rateRetryAfter(0.5, 6); retry != 5*time.Second {
+		t.Errorf("Half a token should wait 5s, got %v", retry)
+	}
+	if retry := rateRetryAfter(0, 0); retry != time.Minute {
+		t.Errorf("No rate should wait a minute, got %v", retry)
+	}
+}
+
+func TestClientLimitAllowsResources(t *testing.T) {
+	two := 2
+	limit := ClientLimit{MaxAwsAccounts: &two}
+
+	if err := limit.AllowsResources(1, 0, 1, 0); err != nil {
+		t.Errorf("2 accounts should be allowed, got %v", err)
+	}
+	if err := limit.AllowsResources(1, 0, 2, 0); !errors.Is(err, ErrClientLimitExceeded) {
+		t.Errorf("3 accounts should exceed the limit, got %v", err)
+	}
+	if err := limit.AllowsResources(5, 100, 0, 10); err != nil {
+		t.Errorf("OcpSandboxes are not limited, got %v", err)
+	}
+	if limit.burst() != 1 || !limit.HasCaps() {
+		t.Error("Wrong defaults")
+	}
+}
+
+func TestRequestedResources(t *testing.T) {
+	if kind, n := RequestedResources("aws_account", 3); kind != "AwsSandbox" || n != 3 {
+		t.Errorf("3 aws_account should be 3 AwsSandbox, got %v %v", n, kind)
+	}
+	if kind, n := RequestedResources("AwsSandbox", 0); kind != "AwsSandbox" || n != 1 {
+		t.Errorf("No count should be 1 AwsSandbox, got %v %v", n, kind)
+	}
+	if kind, n := RequestedResources("OcpSandbox", 3); kind != "OcpSandbox" || n != 1 {
+		t.Errorf("An OcpSandbox request should be 1 OcpSandbox, got %v %v", n, kind)
+	}
+	if kind, _ := RequestedResources("Unknown", 1); kind != "" {
+		t.Errorf("Unknown kind should be empty, got %v", kind)
+	}
+}
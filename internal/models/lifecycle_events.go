@@ -0,0 +1,77 @@
+package models
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// LifecycleEvent is an event recorded while executing a lifecycle job,
+// for example an instance that was stopped.
+type LifecycleEvent struct {
+	Model
+
+	EventType    string         `json:"event_type"`
+	ServiceUuid  string         `json:"service_uuid"`
+	ResourceName string         `json:"resource_name"`
+	ResourceType string         `json:"resource_type"`
+	RequestID    string         `json:"request_id,omitempty"`
+	EventData    map[string]any `json:"event_data"`
+}
+
+const lifecycleEventColumns = `id, created_at, updated_at, event_type, service_uuid::text,
+	resource_name, resource_type, COALESCE(request_id, ''), event_data`
+
+func scanLifecycleEvent(row interface{ Scan(...any) error }) (LifecycleEvent, error) {
+	var e LifecycleEvent
+	err := row.Scan(
+		&e.ID,
+		&e.CreatedAt,
+		&e.UpdatedAt,
+		&e.EventType,
+		&e.ServiceUuid,
+		&e.ResourceName,
+		&e.ResourceType,
+		&e.RequestID,
+		&e.EventData,
+	)
+	return e, err
+}
+
+// GetLifecycleEvent returns a LifecycleEvent by ID
+func GetLifecycleEvent(dbpool *pgxpool.Pool, id int) (*LifecycleEvent, error) {
+	e, err := scanLifecycleEvent(dbpool.QueryRow(
+		context.Background(),
+		"SELECT "+lifecycleEventColumns+" FROM lifecycle_events WHERE id = $1",
+		id,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// GetLifecycleEventsByRequestID returns the events recorded for a request, oldest first
+func GetLifecycleEventsByRequestID(dbpool *pgxpool.Pool, requestID string) ([]LifecycleEvent, error) {
+	rows, err := dbpool.Query(
+		context.Background(),
+		"SELECT "+lifecycleEventColumns+" FROM lifecycle_events WHERE request_id = $1 ORDER BY id",
+		requestID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []LifecycleEvent{}
+	for rows.Next() {
+		e, err := scanLifecycleEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
@@ -3,10 +3,13 @@ package models
 import (
 	"context"
 	"errors"
+	"net/http"
+	"time"
 
 	"github.com/rhpds/sandbox/internal/config"
 	"github.com/rhpds/sandbox/internal/log"
 
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
@@ -23,18 +26,26 @@ type LifecycleResourceJob struct {
 	DbPool       *pgxpool.Pool `json:"-"`
 	Result       Status        `json:"lifecycle_result,omitempty"`
 	Locality     string        `json:"locality,omitempty"`
+	ErrorMessage string        `json:"error_message,omitempty"`
+	CompletedAt  *time.Time    `json:"completed_at,omitempty"`
+	// W3C trace context of the request that created the job
+	TraceContext map[string]string `json:"-"`
 }
 
 type LifecyclePlacementJob struct {
 	Model
 
-	PlacementID int           `json:"placement_id"`
-	Status      string        `json:"status"`
-	Action      string        `json:"lifecycle_action"`
-	Request     any           `json:"request,omitempty"`
-	RequestID   string        `json:"request_id,omitempty"`
-	DbPool      *pgxpool.Pool `json:"-"`
-	Locality    string        `json:"locality,omitempty"`
+	PlacementID  int           `json:"placement_id"`
+	Status       string        `json:"status"`
+	Action       string        `json:"lifecycle_action"`
+	Request      any           `json:"request,omitempty"`
+	RequestID    string        `json:"request_id,omitempty"`
+	DbPool       *pgxpool.Pool `json:"-"`
+	Locality     string        `json:"locality,omitempty"`
+	ErrorMessage string        `json:"error_message,omitempty"`
+	CompletedAt  *time.Time    `json:"completed_at,omitempty"`
+	// W3C trace context of the request that created the job
+	TraceContext map[string]string `json:"-"`
 }
 
 // GetLifecycleResourceJob returns a LifecycleResourceJob by ID
@@ -43,9 +54,14 @@ func GetLifecycleResourceJob(dbpool *pgxpool.Pool, id int) (*LifecycleResourceJo
 
 	err := dbpool.QueryRow(
 		context.Background(),
-		"SELECT id, COALESCE(parent_id, 0), resource_name, resource_type, status, request_id, request, lifecycle_result, lifecycle_action, updated_at, locality FROM lifecycle_resource_jobs WHERE id = $1",
+		`SELECT id, COALESCE(parent_id, 0), resource_name, resource_type, status, COALESCE(request_id, ''),
+		 request, lifecycle_result, lifecycle_action, created_at, updated_at, locality,
+		 COALESCE(error_message, ''), completed_at, COALESCE(trace_context, '{}'::jsonb)
+		 FROM lifecycle_resource_jobs WHERE id = $1`,
 		id,
-	).Scan(&j.ID, &j.ParentID, &j.ResourceName, &j.ResourceType, &j.Status, &j.RequestID, &j.Request, &j.Result, &j.Action, &j.UpdatedAt, &j.Locality)
+	).Scan(&j.ID, &j.ParentID, &j.ResourceName, &j.ResourceType, &j.Status, &j.RequestID,
+		&j.Request, &j.Result, &j.Action, &j.CreatedAt, &j.UpdatedAt, &j.Locality,
+		&j.ErrorMessage, &j.CompletedAt, &j.TraceContext)
 
 	if err != nil {
 		return nil, err
@@ -74,15 +90,77 @@ func GetLifecycleResourceJobByRequestID(dbpool *pgxpool.Pool, requestID string)
 	return &j, nil
 }
 
+// getLifecycleResourceJobs returns the resource jobs whose id is returned by the query
+func getLifecycleResourceJobs(dbpool *pgxpool.Pool, query string, args ...any) ([]LifecycleResourceJob, error) {
+	rows, err := dbpool.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	jobs := []LifecycleResourceJob{}
+	for _, id := range ids {
+		job, err := GetLifecycleResourceJob(dbpool, id)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+
+	return jobs, nil
+}
+
+// GetLifecycleResourceJobsByRequestID returns all the resource jobs of a request.
+// For a placement request, those are the children of the placement job.
+func GetLifecycleResourceJobsByRequestID(dbpool *pgxpool.Pool, requestID string) ([]LifecycleResourceJob, error) {
+	return getLifecycleResourceJobs(
+		dbpool,
+		"SELECT id FROM lifecycle_resource_jobs WHERE request_id = $1 ORDER BY id",
+		requestID,
+	)
+}
+
+// GetLifecycleResourceJobsByParentID returns the children of a placement job
+func GetLifecycleResourceJobsByParentID(dbpool *pgxpool.Pool, parentID int) ([]LifecycleResourceJob, error) {
+	return getLifecycleResourceJobs(
+		dbpool,
+		"SELECT id FROM lifecycle_resource_jobs WHERE parent_id = $1 ORDER BY id",
+		parentID,
+	)
+}
+
+const lifecyclePlacementJobColumns = `id, placement_id, status, COALESCE(request_id, ''), request,
+	lifecycle_action, locality, created_at, updated_at, COALESCE(error_message, ''), completed_at,
+	COALESCE(trace_context, '{}'::jsonb)`
+
+// scanFields returns the fields to scan for lifecyclePlacementJobColumns
+func (j *LifecyclePlacementJob) scanFields() []any {
+	return []any{&j.ID, &j.PlacementID, &j.Status, &j.RequestID, &j.Request,
+		&j.Action, &j.Locality, &j.CreatedAt, &j.UpdatedAt, &j.ErrorMessage, &j.CompletedAt,
+		&j.TraceContext}
+}
+
 // GetLifecyclePlacementJob returns a LifecyclePlacementJob by ID
 func GetLifecyclePlacementJob(dbpool *pgxpool.Pool, id int) (*LifecyclePlacementJob, error) {
 	var j LifecyclePlacementJob
 
 	err := dbpool.QueryRow(
 		context.Background(),
-		"SELECT id, placement_id, status, request_id, request, lifecycle_action, locality FROM lifecycle_placement_jobs WHERE id = $1",
+		`SELECT `+lifecyclePlacementJobColumns+` FROM lifecycle_placement_jobs WHERE id = $1`,
 		id,
-	).Scan(&j.ID, &j.PlacementID, &j.Status, &j.RequestID, &j.Request, &j.Action, &j.Locality)
+	).Scan(j.scanFields()...)
 	if err != nil {
 		return nil, err
 	}
@@ -92,15 +170,15 @@ func GetLifecyclePlacementJob(dbpool *pgxpool.Pool, id int) (*LifecyclePlacement
 	return &j, nil
 }
 
-// GetLifecyclePlacementJob returns a LifecyclePlacementJob by ID
+// GetLifecyclePlacementJobByRequestID returns a LifecyclePlacementJob by request ID
 func GetLifecyclePlacementJobByRequestID(dbpool *pgxpool.Pool, requestID string) (*LifecyclePlacementJob, error) {
 	var j LifecyclePlacementJob
 
 	err := dbpool.QueryRow(
 		context.Background(),
-		"SELECT id, placement_id, status, request_id, request, lifecycle_action, locality FROM lifecycle_placement_jobs WHERE request_id = $1",
+		`SELECT `+lifecyclePlacementJobColumns+` FROM lifecycle_placement_jobs WHERE request_id = $1`,
 		requestID,
-	).Scan(&j.ID, &j.PlacementID, &j.Status, &j.RequestID, &j.Request, &j.Action, &j.Locality)
+	).Scan(j.scanFields()...)
 	if err != nil {
 		return nil, err
 	}
@@ -110,28 +188,156 @@ func GetLifecyclePlacementJobByRequestID(dbpool *pgxpool.Pool, requestID string)
 	return &j, nil
 }
 
+// GetLifecyclePlacementJobsByPlacementID returns the jobs of a placement, most recent first
+func GetLifecyclePlacementJobsByPlacementID(dbpool *pgxpool.Pool, placementID int, limit int) ([]LifecyclePlacementJob, error) {
+	rows, err := dbpool.Query(
+		context.Background(),
+		`SELECT `+lifecyclePlacementJobColumns+` FROM lifecycle_placement_jobs
+		 WHERE placement_id = $1 ORDER BY id DESC LIMIT $2`,
+		placementID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []LifecyclePlacementJob{}
+	for rows.Next() {
+		var j LifecyclePlacementJob
+		if err := rows.Scan(j.scanFields()...); err != nil {
+			return nil, err
+		}
+		j.DbPool = dbpool
+		jobs = append(jobs, j)
+	}
+
+	return jobs, rows.Err()
+}
+
 var ErrNoClaim = errors.New("no claim")
 
-// ClaimResourceJob claims a resource job by setting the status to initializing
+// ErrResourceBusy is returned when claiming a job whose resource is used by another job
+var ErrResourceBusy = errors.New("resource busy")
+
+// ClaimResourceJob claims a resource job by setting the status to initializing.
+// Two jobs never act on the same resource at once: if another job of the resource
+// is in progress, ErrResourceBusy is returned and the job stays new.
 func (j *LifecycleResourceJob) Claim() error {
-	ct, err := j.DbPool.Exec(
-		context.Background(),
-		`UPDATE lifecycle_resource_jobs SET status = 'initializing', locality = $2
+	ctx := context.Background()
+	tx, err := j.DbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Serialize the claims of the jobs of the same resource, across all replicas.
+	// The lock is released at the end of the transaction.
+	if _, err := tx.Exec(
+		ctx,
+		"SELECT pg_advisory_xact_lock(hashtext('lifecycle_resource_jobs:' || $1))",
+		j.ResourceName,
+	); err != nil {
+		return err
+	}
+
+	// A job in progress for more than an hour is considered dead, the AWS
+	// credentials of a job expire after 15 minutes anyway.
+	// A cancelled job is in progress until its worker stopped.
+	var busy bool
+	if err := tx.QueryRow(
+		ctx,
+		`SELECT EXISTS (SELECT 1 FROM lifecycle_resource_jobs
+		 WHERE resource_name = $1 AND id <> $2
+		 AND (status IN ('initializing', 'initialized', 'running') OR running)
+		 AND updated_at > now() - interval '1 hour')`,
+		j.ResourceName,
+		j.ID,
+	).Scan(&busy); err != nil {
+		return err
+	}
+
+	if busy {
+		return ErrResourceBusy
+	}
+
+	ct, err := tx.Exec(
+		ctx,
+		`UPDATE lifecycle_resource_jobs SET status = 'initializing', locality = $2, running = true
      	 WHERE id = (SELECT id FROM lifecycle_resource_jobs
-         WHERE status = 'new' AND id=$1 FOR UPDATE SKIP LOCKED)`,
+         WHERE status = 'new' AND id=$1
+         AND (parent_id IS NULL OR parent_id NOT IN
+           (SELECT id FROM lifecycle_placement_jobs WHERE status = 'cancelled'))
+         FOR UPDATE SKIP LOCKED)`,
 		j.ID,
 		config.LocalityID,
 	)
+	if err != nil {
+		return err
+	}
 
 	if ct.RowsAffected() == 0 {
 		return ErrNoClaim
 	}
 
+	return tx.Commit(ctx)
+}
+
+// NotifyPendingResourceJobs notifies again the new jobs of a resource, so the workers
+// try to claim them. It's called when a job of the resource is done, for the jobs
+// postponed because the resource was busy.
+func NotifyPendingResourceJobs(dbpool *pgxpool.Pool, resourceName string) error {
+	_, err := dbpool.Exec(
+		context.Background(),
+		`SELECT pg_notify('lifecycle_resource_jobs_status_channel', id::text)
+		 FROM lifecycle_resource_jobs
+		 WHERE resource_name = $1 AND status = 'new'`,
+		resourceName,
+	)
+	return err
+}
+
+// CancelledResourceJobs returns the ids, among ids, of the resource jobs cancelled
+func CancelledResourceJobs(dbpool *pgxpool.Pool, ids []int) ([]int, error) {
+	cancelled := []int{}
+	if len(ids) == 0 {
+		return cancelled, nil
+	}
+
+	rows, err := dbpool.Query(
+		context.Background(),
+		"SELECT id FROM lifecycle_resource_jobs WHERE id = ANY($1) AND status = 'cancelled'",
+		ids,
+	)
 	if err != nil {
-		return err
+		return cancelled, err
 	}
+	defer rows.Close()
 
-	return nil
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return cancelled, err
+		}
+		cancelled = append(cancelled, id)
+	}
+	return cancelled, rows.Err()
+}
+
+// NotifyStalePendingResourceJobs notifies again the new jobs not updated for
+// olderThan, so the workers try to claim them again. A job postponed because
+// its resource was busy is not notified again if the job holding the resource
+// died, the claim then succeeds once that job is considered dead.
+func NotifyStalePendingResourceJobs(dbpool *pgxpool.Pool, olderThan time.Duration) error {
+	_, err := dbpool.Exec(
+		context.Background(),
+		`SELECT pg_notify('lifecycle_resource_jobs_status_channel', id::text)
+		 FROM lifecycle_resource_jobs
+		 WHERE status = 'new' AND updated_at < now() - make_interval(secs => $1)
+		 AND (parent_id IS NULL OR parent_id NOT IN
+		   (SELECT id FROM lifecycle_placement_jobs WHERE status = 'cancelled'))`,
+		olderThan.Seconds(),
+	)
+	return err
 }
 
 // ClaimPlacementJob claims a placement job by setting the status to initializing
@@ -157,8 +363,8 @@ func (j *LifecycleResourceJob) Create() error {
 	err := j.DbPool.QueryRow(
 		context.Background(),
 		`INSERT INTO lifecycle_resource_jobs
-        (parent_id, resource_name, resource_type, status, request, request_id, lifecycle_action, locality)
-        VALUES (NULLIF($1, 0), $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+        (parent_id, resource_name, resource_type, status, request, request_id, lifecycle_action, locality, trace_context)
+        VALUES (NULLIF($1, 0), $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
 		j.ParentID,
 		j.ResourceName,
 		j.ResourceType,
@@ -167,6 +373,7 @@ func (j *LifecycleResourceJob) Create() error {
 		j.RequestID,
 		j.Action,
 		j.Locality,
+		j.TraceContext,
 	).Scan(&j.ID)
 
 	return err
@@ -177,14 +384,15 @@ func (j *LifecyclePlacementJob) Create() error {
 	err := j.DbPool.QueryRow(
 		context.Background(),
 		`INSERT INTO lifecycle_placement_jobs
-		(placement_id, status, request, request_id, lifecycle_action, locality)
-		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		(placement_id, status, request, request_id, lifecycle_action, locality, trace_context)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
 		j.PlacementID,
 		j.Status,
 		j.Request,
 		j.RequestID,
 		j.Action,
 		j.Locality,
+		j.TraceContext,
 	).Scan(&j.ID)
 
 	return err
@@ -192,28 +400,196 @@ func (j *LifecyclePlacementJob) Create() error {
 
 // SetLifecycleResourceJobStatus sets the status of a LifecycleResourceJob
 func (j *LifecycleResourceJob) SetStatus(status string) error {
-	_, err := j.DbPool.Exec(
+	ct, err := j.DbPool.Exec(
 		context.Background(),
-		"UPDATE lifecycle_resource_jobs SET status = $1 WHERE id = $2",
+		"UPDATE lifecycle_resource_jobs SET status = $1 WHERE id = $2 AND status <> 'cancelled'",
 		status,
 		j.ID,
 	)
+	if err == nil {
+		j.Status = status
+		if ct.RowsAffected() == 0 {
+			// The job was cancelled, it stays cancelled
+			j.Status = "cancelled"
+		}
+	}
 
 	return err
 }
 
 // SetLifecycleResourceJobStatus sets the status of a LifecycleResourceJob
 func (j *LifecyclePlacementJob) SetStatus(status string) error {
-	_, err := j.DbPool.Exec(
+	ct, err := j.DbPool.Exec(
 		context.Background(),
-		"UPDATE lifecycle_placement_jobs SET status = $1 WHERE id = $2",
+		"UPDATE lifecycle_placement_jobs SET status = $1 WHERE id = $2 AND status <> 'cancelled'",
 		status,
 		j.ID,
 	)
+	if err == nil {
+		j.Status = status
+		if ct.RowsAffected() == 0 {
+			// The job was cancelled, it stays cancelled
+			j.Status = "cancelled"
+		}
+	}
 
 	return err
 }
 
+// Stopped records that the worker executing the job stopped, the resource
+// is free for the other jobs. See Claim.
+func (j *LifecycleResourceJob) Stopped() error {
+	_, err := j.DbPool.Exec(
+		context.Background(),
+		"UPDATE lifecycle_resource_jobs SET running = false WHERE id = $1",
+		j.ID,
+	)
+	return err
+}
+
+// Requeue puts back the job in the 'new' status, for any replica to claim it.
+// It's used when the job is interrupted by a shutdown.
+func (j *LifecycleResourceJob) Requeue() error {
+	ct, err := j.DbPool.Exec(
+		context.Background(),
+		"UPDATE lifecycle_resource_jobs SET status = 'new', locality = 'any', running = false WHERE id = $1 AND status <> 'cancelled'",
+		j.ID,
+	)
+	if err == nil {
+		j.Status = "new"
+		j.Locality = "any"
+		if ct.RowsAffected() == 0 {
+			// The job was cancelled, it stays cancelled
+			j.Status = "cancelled"
+		}
+	}
+
+	return err
+}
+
+// SetError sets the status of the job to error and saves the error message
+func (j *LifecycleResourceJob) SetError(jobErr error) error {
+	ct, err := j.DbPool.Exec(
+		context.Background(),
+		"UPDATE lifecycle_resource_jobs SET status = 'error', error_message = $1 WHERE id = $2 AND status <> 'cancelled'",
+		jobErr.Error(),
+		j.ID,
+	)
+	if err == nil {
+		j.Status = "error"
+		j.ErrorMessage = jobErr.Error()
+		if ct.RowsAffected() == 0 {
+			// The job was cancelled, it stays cancelled
+			j.Status = "cancelled"
+		}
+	}
+
+	return err
+}
+
+// SetError sets the status of the job to error and saves the error message
+func (j *LifecyclePlacementJob) SetError(jobErr error) error {
+	ct, err := j.DbPool.Exec(
+		context.Background(),
+		"UPDATE lifecycle_placement_jobs SET status = 'error', error_message = $1 WHERE id = $2 AND status <> 'cancelled'",
+		jobErr.Error(),
+		j.ID,
+	)
+	if err == nil {
+		j.Status = "error"
+		j.ErrorMessage = jobErr.Error()
+		if ct.RowsAffected() == 0 {
+			// The job was cancelled, it stays cancelled
+			j.Status = "cancelled"
+		}
+	}
+
+	return err
+}
+
+// LifecycleDone returns true if the status of a lifecycle job or request is final
+func LifecycleDone(status string) bool {
+	return status == "success" || status == "error" || status == "cancelled"
+}
+
+// MarkCompleted sets completed_at and emits the lifecycle.completed webhook event
+// once the request is done, meaning all its resource jobs are done.
+// It can be called several times and concurrently, the event is emitted only once.
+func (j *LifecyclePlacementJob) MarkCompleted() error {
+	status, err := j.GlobalStatus()
+	if err != nil {
+		return err
+	}
+
+	if !LifecycleDone(status) {
+		return nil
+	}
+
+	ct, err := j.DbPool.Exec(
+		context.Background(),
+		"UPDATE lifecycle_placement_jobs SET completed_at = now() WHERE id = $1 AND completed_at IS NULL",
+		j.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if ct.RowsAffected() == 0 {
+		// Already completed
+		return nil
+	}
+
+	serviceUuid := ""
+	if placement, err := GetPlacement(j.DbPool, j.PlacementID); err == nil {
+		serviceUuid = placement.ServiceUuid
+	}
+
+	return EmitWebhookEvent(j.DbPool, "lifecycle.completed", map[string]any{
+		"request_id":       j.RequestID,
+		"service_uuid":     serviceUuid,
+		"lifecycle_action": j.Action,
+		"status":           status,
+	})
+}
+
+// MarkCompleted sets completed_at and emits the lifecycle.completed webhook event
+// once the job is done. If the job has a parent, the parent request is completed
+// instead, when all its resource jobs are done.
+func (j *LifecycleResourceJob) MarkCompleted() error {
+	if j.ParentID != 0 {
+		parent, err := GetLifecyclePlacementJob(j.DbPool, j.ParentID)
+		if err != nil {
+			return err
+		}
+		return parent.MarkCompleted()
+	}
+
+	if !LifecycleDone(j.Status) {
+		return nil
+	}
+
+	ct, err := j.DbPool.Exec(
+		context.Background(),
+		"UPDATE lifecycle_resource_jobs SET completed_at = now() WHERE id = $1 AND completed_at IS NULL",
+		j.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if ct.RowsAffected() == 0 {
+		return nil
+	}
+
+	return EmitWebhookEvent(j.DbPool, "lifecycle.completed", map[string]any{
+		"request_id":       j.RequestID,
+		"resource_name":    j.ResourceName,
+		"resource_type":    j.ResourceType,
+		"lifecycle_action": j.Action,
+		"status":           j.Status,
+	})
+}
+
 // GlobalStatus returns the status of a LifecyclePlacementJob considering all it's children
 func (j *LifecyclePlacementJob) GlobalStatus() (string, error) {
 
@@ -262,9 +638,14 @@ func (j *LifecyclePlacementJob) GlobalStatus() (string, error) {
 			return "initializing", nil
 		case "initialized":
 			return "initialized", nil
+		case "cancelled":
+			// Cancelled wins over success, the request didn't complete
+			status = "cancelled"
 		case "success":
 			// Save as the last status and move to the next job
-			status = "success"
+			if status != "cancelled" {
+				status = "success"
+			}
 		}
 	}
 
@@ -280,3 +661,128 @@ func (j *LifecyclePlacementJob) GlobalStatus() (string, error) {
 	}
 	return status, nil
 }
+
+// LifecycleRequest is the detail of a lifecycle request: the placement job if any,
+// the resource jobs and the lifecycle events they produced.
+type LifecycleRequest struct {
+	RequestID    string                 `json:"request_id"`
+	Action       string                 `json:"lifecycle_action"`
+	Status       string                 `json:"status"`
+	PlacementJob *LifecyclePlacementJob `json:"placement_job,omitempty"`
+	ResourceJobs []LifecycleResourceJob `json:"resource_jobs"`
+	Events       []LifecycleEvent       `json:"lifecycle_events"`
+}
+
+func (r *LifecycleRequest) Render(w http.ResponseWriter, req *http.Request) error {
+	return nil
+}
+
+// Detail returns the detail of the request of the placement job
+func (j *LifecyclePlacementJob) Detail() (*LifecycleRequest, error) {
+	status, err := j.GlobalStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	resourceJobs, err := GetLifecycleResourceJobsByParentID(j.DbPool, j.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []LifecycleEvent{}
+	if j.RequestID != "" {
+		events, err = GetLifecycleEventsByRequestID(j.DbPool, j.RequestID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &LifecycleRequest{
+		RequestID:    j.RequestID,
+		Action:       j.Action,
+		Status:       status,
+		PlacementJob: j,
+		ResourceJobs: resourceJobs,
+		Events:       events,
+	}, nil
+}
+
+// GetLifecycleRequest returns the detail of a request.
+// The request is either a placement request or a request on a single resource.
+// Returns pgx.ErrNoRows if the request doesn't exist.
+func GetLifecycleRequest(dbpool *pgxpool.Pool, requestID string) (*LifecycleRequest, error) {
+	placementJob, err := GetLifecyclePlacementJobByRequestID(dbpool, requestID)
+	if err == nil {
+		return placementJob.Detail()
+	}
+
+	if err != pgx.ErrNoRows {
+		return nil, err
+	}
+
+	// No placement request found, try any resource request
+	resourceJobs, err := GetLifecycleResourceJobsByRequestID(dbpool, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resourceJobs) == 0 {
+		return nil, pgx.ErrNoRows
+	}
+
+	events, err := GetLifecycleEventsByRequestID(dbpool, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LifecycleRequest{
+		RequestID:    requestID,
+		Action:       resourceJobs[0].Action,
+		Status:       resourceJobs[0].Status,
+		ResourceJobs: resourceJobs,
+		Events:       events,
+	}, nil
+}
+
+// CancelLifecycleRequest cancels the jobs of a request that are not done yet.
+// The resource jobs not started yet won't be claimed, the running ones are
+// stopped by the worker executing them.
+// Returns the number of jobs cancelled.
+func CancelLifecycleRequest(dbpool *pgxpool.Pool, requestID string) (int64, error) {
+	tx, err := dbpool.Begin(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(context.Background())
+
+	// The placement job is done only when all its resource jobs are, so a
+	// dispatched placement job is cancelled until it's completed.
+	placementCt, err := tx.Exec(
+		context.Background(),
+		`UPDATE lifecycle_placement_jobs SET status = 'cancelled'
+		 WHERE request_id = $1
+		 AND status NOT IN ('success', 'error', 'cancelled')
+		 AND completed_at IS NULL`,
+		requestID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	resourceCt, err := tx.Exec(
+		context.Background(),
+		`UPDATE lifecycle_resource_jobs SET status = 'cancelled'
+		 WHERE request_id = $1
+		 AND status IN ('new', 'initializing', 'initialized', 'running')`,
+		requestID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return 0, err
+	}
+
+	return placementCt.RowsAffected() + resourceCt.RowsAffected(), nil
+}
@@ -0,0 +1,292 @@
+package models
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/rhpds/sandbox/internal/config"
+	"github.com/rhpds/sandbox/internal/log"
+	v1 "k8s.io/api/core/v1"
+)
+
+// OcpProvisioningRequest is the part of the placement request needed to provision
+// an OcpSandbox. It's saved when the provisioning is interrupted by a shutdown,
+// so another replica can provision the sandbox again.
+type OcpProvisioningRequest struct {
+	CloudSelector map[string]string `json:"cloud_selector,omitempty"`
+	Quota         *v1.ResourceList  `json:"quota,omitempty"`
+	LimitRange    *v1.LimitRange    `json:"limit_range,omitempty"`
+}
+
+// A requeued provisioning request is leased by the replica provisioning the
+// sandbox again. The lease is renewed every minute, if the replica dies the
+// request is claimed again by another replica once the lease expired.
+const ocpProvisioningLease = 10 * time.Minute
+
+type ocpProvisioningEntry struct {
+	sandbox *OcpSandboxWithCreds
+	request OcpProvisioningRequest
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// ocpProvisioning tracks the OCP sandboxes being provisioned asynchronously
+// by this process, so the provisioning can be drained or interrupted on shutdown.
+var ocpProvisioning = struct {
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	running map[*OcpSandboxWithCreds]*ocpProvisioningEntry
+	// The provisioning stopped after being cancelled, to requeue
+	interrupted []*ocpProvisioningEntry
+}{
+	running: map[*OcpSandboxWithCreds]*ocpProvisioningEntry{},
+}
+
+// trackOcpProvisioning tracks the provisioning of the sandbox and returns
+// its context, cancelled by ReleaseOcpProvisioning.
+func trackOcpProvisioning(ctx context.Context, sandbox *OcpSandboxWithCreds, request OcpProvisioningRequest) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+	ocpProvisioning.wg.Add(1)
+	ocpProvisioning.mu.Lock()
+	defer ocpProvisioning.mu.Unlock()
+	ocpProvisioning.running[sandbox] = &ocpProvisioningEntry{
+		sandbox: sandbox,
+		request: request,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	return ctx
+}
+
+// untrackOcpProvisioning is called when the provisioning goroutine of the sandbox returns
+func untrackOcpProvisioning(sandbox *OcpSandboxWithCreds) {
+	ocpProvisioning.mu.Lock()
+	entry := ocpProvisioning.running[sandbox]
+	delete(ocpProvisioning.running, sandbox)
+	if entry.ctx.Err() != nil && sandbox.Status != "success" {
+		ocpProvisioning.interrupted = append(ocpProvisioning.interrupted, entry)
+	}
+	entry.cancel()
+	ocpProvisioning.mu.Unlock()
+	ocpProvisioning.wg.Done()
+}
+
+// WaitOcpProvisioning waits for the OCP sandboxes being provisioned to be done.
+// Returns the context error if the context is done first.
+func WaitOcpProvisioning(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		ocpProvisioning.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ReleaseOcpProvisioning interrupts the OCP sandboxes still being provisioned and
+// waits, until ctx is done, for their provisioning to stop. The interrupted sandboxes
+// are put back 'initializing' and requeued, for any replica to provision them again,
+// see ResumeOcpProvisioning. The sandboxes still being provisioned when ctx is done
+// are left as they are.
+func ReleaseOcpProvisioning(ctx context.Context) error {
+	ocpProvisioning.mu.Lock()
+	for _, entry := range ocpProvisioning.running {
+		entry.cancel()
+	}
+	ocpProvisioning.mu.Unlock()
+
+	errWait := WaitOcpProvisioning(ctx)
+
+	ocpProvisioning.mu.Lock()
+	interrupted := ocpProvisioning.interrupted
+	ocpProvisioning.interrupted = nil
+	for _, entry := range ocpProvisioning.running {
+		log.Logger.Error("OCP sandbox provisioning not stopped",
+			"name", entry.sandbox.Name, "service_uuid", entry.sandbox.ServiceUuid)
+	}
+	ocpProvisioning.mu.Unlock()
+
+	for _, entry := range interrupted {
+		log.Logger.Warn("Requeuing unfinished OCP sandbox",
+			"name", entry.sandbox.Name, "service_uuid", entry.sandbox.ServiceUuid)
+		if err := entry.sandbox.requeueProvisioning(entry.request); err != nil {
+			log.Logger.Error("Error requeuing OCP sandbox", "error", err, "name", entry.sandbox.Name)
+			entry.sandbox.SetStatus("error")
+		}
+	}
+
+	return errWait
+}
+
+// requeueProvisioning puts back the sandbox 'initializing' and saves its
+// provisioning request, for any replica to provision it again.
+func (a *OcpSandboxWithCreds) requeueProvisioning(request OcpProvisioningRequest) error {
+	if err := a.SetStatus("initializing"); err != nil {
+		return err
+	}
+
+	_, err := a.Provider.DbPool.Exec(
+		context.Background(),
+		`INSERT INTO ocp_provisioning_requests (resource_id, request) VALUES ($1, $2)
+		 ON CONFLICT (resource_id) DO UPDATE
+		 SET request = EXCLUDED.request, created_at = now(), holder = NULL, claimed_at = NULL`,
+		a.ID, request,
+	)
+	return err
+}
+
+// ResumeOcpProvisioning provisions again the OCP sandboxes requeued by the replicas
+// shut down during their provisioning, every minute until ctx is cancelled.
+// Every replica runs it, the requests are leased with SKIP LOCKED.
+func (a *OcpSandboxProvider) ResumeOcpProvisioning(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil {
+			resumed, err := a.resumeNextOcpProvisioning()
+			if err != nil {
+				log.Logger.Error("Error resuming OCP provisioning", "error", err)
+			}
+			if !resumed {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resumeNextOcpProvisioning leases the oldest requeued provisioning request not
+// leased, or whose lease expired, and provisions its sandbox asynchronously.
+// The request is deleted once the provisioning is done.
+// Returns false if there is none.
+func (a *OcpSandboxProvider) resumeNextOcpProvisioning() (bool, error) {
+	var id int
+	var request OcpProvisioningRequest
+	err := a.DbPool.QueryRow(
+		context.Background(),
+		`UPDATE ocp_provisioning_requests SET holder = $1, claimed_at = now()
+		 WHERE resource_id = (
+			 SELECT resource_id FROM ocp_provisioning_requests
+			 WHERE claimed_at IS NULL OR claimed_at < now() - make_interval(secs => $2)
+			 ORDER BY created_at
+			 LIMIT 1
+			 FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING resource_id, request`,
+		config.LocalityID, ocpProvisioningLease.Seconds(),
+	).Scan(&id, &request)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	sandbox, err := a.FetchById(id)
+	if err == pgx.ErrNoRows {
+		// The sandbox was deleted, the request with it
+		return true, nil
+	}
+	if err != nil {
+		// The lease expires, try the next one
+		return true, err
+	}
+
+	rnew := &OcpSandboxWithCreds{
+		OcpSandbox: sandbox,
+		Provider:   a,
+	}
+	log.Logger.Info("Resuming OCP sandbox provisioning",
+		"name", rnew.Name, "service_uuid", rnew.ServiceUuid)
+
+	provisionCtx := trackOcpProvisioning(context.Background(), rnew, request)
+	go func() {
+		defer untrackOcpProvisioning(rnew)
+
+		renewCtx, stopRenew := context.WithCancel(provisionCtx)
+		go a.renewOcpProvisioningLease(renewCtx, id)
+		a.reprovision(provisionCtx, rnew, request)
+		stopRenew()
+
+		// Interrupted again, the request is requeued by ReleaseOcpProvisioning
+		if provisionCtx.Err() != nil {
+			return
+		}
+		if _, err := a.DbPool.Exec(
+			context.Background(),
+			"DELETE FROM ocp_provisioning_requests WHERE resource_id = $1 AND holder = $2",
+			id, config.LocalityID,
+		); err != nil {
+			log.Logger.Error("Error deleting OCP provisioning request", "error", err, "name", rnew.Name)
+		}
+	}()
+	return true, nil
+}
+
+// renewOcpProvisioningLease renews the lease of the request every minute, until ctx is done
+func (a *OcpSandboxProvider) renewOcpProvisioningLease(ctx context.Context, id int) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := a.DbPool.Exec(
+				context.Background(),
+				"UPDATE ocp_provisioning_requests SET claimed_at = now() WHERE resource_id = $1 AND holder = $2",
+				id, config.LocalityID,
+			); err != nil {
+				log.Logger.Error("Error renewing OCP provisioning lease", "error", err, "resource_id", id)
+			}
+		}
+	}
+}
+
+// reprovision provisions again an OcpSandbox whose provisioning was interrupted.
+// The namespace created by the interrupted provisioning, if any, is deleted first.
+func (a *OcpSandboxProvider) reprovision(ctx context.Context, rnew *OcpSandboxWithCreds, request OcpProvisioningRequest) {
+	if rnew.Namespace != "" {
+		if err := rnew.deleteNamespace(); err != nil {
+			log.Logger.Error("Error deleting the namespace of the interrupted provisioning",
+				"error", err, "name", rnew.Name)
+			rnew.SetStatus("error")
+			return
+		}
+	}
+
+	rnew.Namespace = ""
+	rnew.OcpSharedClusterConfigurationName = ""
+	rnew.OcpApiUrl = ""
+	rnew.OcpIngressDomain = ""
+	rnew.Status = "initializing"
+	if err := rnew.Save(); err != nil {
+		log.Logger.Error("Error saving OCP account", "error", err)
+		rnew.SetStatus("error")
+		return
+	}
+
+	candidateClusters, err := a.GetSchedulableClusters(request.CloudSelector, rnew.Reservation)
+	if err != nil || len(candidateClusters) == 0 {
+		log.Logger.Error("No OCP shared cluster configuration found",
+			"error", err, "cloud_selector", request.CloudSelector, "name", rnew.Name)
+		rnew.SetStatus("error")
+		return
+	}
+
+	a.provision(ctx, rnew, candidateClusters, request)
+}
@@ -0,0 +1,301 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ErrReservationFull is returned, wrapped, when the OcpSandboxes of a
+// reservation reached the count or the quota of the reservation.
+var ErrReservationFull = errors.New("reservation is full")
+
+// ocpReservationClusters returns the names of the clusters the OcpSandbox
+// resource of the reservation asks for: the clusters listed or the valid
+// clusters matching the cloud_selector.
+func ocpReservationClusters(dbpool *pgxpool.Pool, request ResourceRequest) ([]string, error) {
+	rows, err := dbpool.Query(
+		context.Background(),
+		`SELECT name FROM ocp_shared_cluster_configurations
+		 WHERE (cardinality($1::text[]) > 0 AND name = ANY($1))
+		 OR (cardinality($1::text[]) = 0 AND annotations @> $2 AND valid = true)
+		 ORDER BY name`,
+		request.Clusters, request.CloudSelector,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// validateOcpReservation checks the OcpSandbox resource of the reservation
+// and returns a message for the user if it's invalid.
+func validateOcpReservation(dbpool *pgxpool.Pool, reservationName string, request ResourceRequest) (string, error) {
+	if len(request.Clusters) == 0 && len(request.CloudSelector) == 0 {
+		return "OcpSandbox requires clusters or cloud_selector", errors.New("invalid OcpSandbox reservation")
+	}
+	if len(request.Clusters) > 0 && len(request.CloudSelector) > 0 {
+		return "OcpSandbox accepts either clusters or cloud_selector, not both", errors.New("invalid OcpSandbox reservation")
+	}
+
+	for key, q := range request.Quota {
+		if q.Sign() < 0 {
+			return fmt.Sprintf("Quota %s must be positive", key), errors.New("invalid quota")
+		}
+	}
+
+	clusters, err := ocpReservationClusters(dbpool, request)
+	if err != nil {
+		return "", err
+	}
+	if len(clusters) == 0 {
+		return "No OCP shared cluster configuration found", ErrNoSchedule
+	}
+	if len(request.Clusters) > 0 && len(clusters) != len(request.Clusters) {
+		return fmt.Sprintf("Unknown OCP shared cluster configuration in %v", request.Clusters), ErrNoSchedule
+	}
+
+	// A dedicated cluster can't be reserved by another reservation
+	var other, cluster string
+	err = dbpool.QueryRow(
+		context.Background(),
+		`SELECT reservation_name, cluster_name FROM ocp_reservation_clusters
+		 WHERE cluster_name = ANY($1) AND reservation_name <> $2 AND (dedicated OR $3)
+		 LIMIT 1`,
+		clusters, reservationName, request.Dedicated,
+	).Scan(&other, &cluster)
+	if err == nil {
+		return fmt.Sprintf("Cluster %s is already reserved by %s", cluster, other), errors.New("cluster already reserved")
+	}
+	if err != pgx.ErrNoRows {
+		return "", err
+	}
+
+	return "", nil
+}
+
+// reserveOcpClusters replaces the clusters of the reservation by the clusters
+// the OcpSandbox resource asks for.
+func reserveOcpClusters(dbpool *pgxpool.Pool, reservationName string, request ResourceRequest) error {
+	clusters, err := ocpReservationClusters(dbpool, request)
+	if err != nil {
+		return err
+	}
+	if len(clusters) == 0 {
+		return ErrNoSchedule
+	}
+
+	ctx := context.Background()
+	tx, err := dbpool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		"DELETE FROM ocp_reservation_clusters WHERE reservation_name = $1",
+		reservationName); err != nil {
+		return err
+	}
+
+	for _, cluster := range clusters {
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO ocp_reservation_clusters (reservation_name, cluster_name, dedicated)
+			 VALUES ($1, $2, $3)`,
+			reservationName, cluster, request.Dedicated); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// releaseOcpClusters releases the clusters of the reservation.
+// The OcpSandboxes already scheduled on them are kept.
+func releaseOcpClusters(dbpool *pgxpool.Pool, reservationName string) error {
+	_, err := dbpool.Exec(
+		context.Background(),
+		"DELETE FROM ocp_reservation_clusters WHERE reservation_name = $1",
+		reservationName,
+	)
+	return err
+}
+
+// ocpReservationAllows returns an error wrapping ErrReservationFull if one more
+// OcpSandbox with the requested quota exceeds the count or the quota of the
+// reservation. held is the number of OcpSandboxes of the reservation and
+// heldQuota the total of their quotas.
+func ocpReservationAllows(request ResourceRequest, held int, heldQuota v1.ResourceList, requested v1.ResourceList) error {
+	if held+1 > request.Count {
+		return fmt.Errorf("%w: %d OcpSandboxes, count is %d", ErrReservationFull, held, request.Count)
+	}
+
+	maxCpu, maxMemory := QuotaCpuMemory(request.Quota)
+	heldCpu, heldMemory := QuotaCpuMemory(heldQuota)
+	cpu, memory := QuotaCpuMemory(requested)
+
+	for _, check := range []struct {
+		name                 string
+		max, held, requested *resource.Quantity
+	}{
+		{"requests.cpu", maxCpu, heldCpu, cpu},
+		{"requests.memory", maxMemory, heldMemory, memory},
+	} {
+		if check.max == nil {
+			continue
+		}
+		if check.requested == nil {
+			return fmt.Errorf("%w: the quota of the reservation requires %s in the quota of the OcpSandbox",
+				ErrReservationFull, check.name)
+		}
+		total := check.requested.DeepCopy()
+		if check.held != nil {
+			total.Add(*check.held)
+		}
+		if total.Cmp(*check.max) > 0 {
+			return fmt.Errorf("%w: %s of the OcpSandboxes would be %s, quota is %s",
+				ErrReservationFull, check.name, total.String(), check.max.String())
+		}
+	}
+
+	return nil
+}
+
+// checkOcpReservation returns an error if the reservation can't take one more
+// OcpSandbox with the requested quota. The caller holds the lock of the reservation.
+func (a *OcpSandboxProvider) checkOcpReservation(ctx context.Context, reservationName string, requested v1.ResourceList) error {
+	reservation, err := scanReservation(a.DbPool.QueryRow(
+		ctx,
+		"SELECT "+reservationColumns+" FROM reservations WHERE reservation_name = $1",
+		reservationName,
+	))
+	if err == pgx.ErrNoRows {
+		return ErrNoSchedule
+	}
+	if err != nil {
+		return err
+	}
+
+	var ocpRequest *ResourceRequest
+	for i := range reservation.Request.Resources {
+		if reservation.Request.Resources[i].Kind == "OcpSandbox" {
+			ocpRequest = &reservation.Request.Resources[i]
+		}
+	}
+	if ocpRequest == nil {
+		return ErrNoSchedule
+	}
+
+	rows, err := a.DbPool.Query(
+		ctx,
+		`SELECT COALESCE(resource_data->'quota', '{}'::jsonb) FROM resources
+		 WHERE resource_type = 'OcpSandbox' AND resource_data->>'reservation' = $1`,
+		reservationName,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	held := 0
+	heldQuota := v1.ResourceList{}
+	for rows.Next() {
+		var quotaJSON []byte
+		if err := rows.Scan(&quotaJSON); err != nil {
+			return err
+		}
+		quota := v1.ResourceList{}
+		if err := json.Unmarshal(quotaJSON, &quota); err != nil {
+			return err
+		}
+
+		held++
+		cpu, memory := QuotaCpuMemory(quota)
+		for key, q := range map[v1.ResourceName]*resource.Quantity{
+			v1.ResourceRequestsCPU:    cpu,
+			v1.ResourceRequestsMemory: memory,
+		} {
+			if q == nil {
+				continue
+			}
+			total := heldQuota[key]
+			total.Add(*q)
+			heldQuota[key] = total
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return ocpReservationAllows(*ocpRequest, held, heldQuota, requested)
+}
+
+// LockReservation takes a lock on the reservation, shared by all the replicas,
+// so its count and quota can't be exceeded by concurrent placements.
+// The returned function releases the lock.
+func LockReservation(ctx context.Context, dbpool *pgxpool.Pool, name string) (func(), error) {
+	return advisoryLock(ctx, dbpool, "reservations:"+name)
+}
+
+// FetchAllByReservation returns the OcpSandboxes of the reservation
+func (a *OcpSandboxProvider) FetchAllByReservation(reservationName string) ([]OcpSandbox, error) {
+	accounts := []OcpSandbox{}
+	rows, err := a.DbPool.Query(
+		context.Background(),
+		`SELECT
+			r.resource_data,
+			r.id,
+			r.resource_name,
+			r.resource_type,
+			r.service_uuid,
+			r.created_at,
+			r.updated_at,
+			r.status,
+			r.cleanup_count
+		FROM resources r
+		WHERE r.resource_type = 'OcpSandbox' AND r.resource_data->>'reservation' = $1
+		ORDER BY r.id`,
+		reservationName,
+	)
+	if err != nil {
+		return accounts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var account OcpSandbox
+		if err := rows.Scan(
+			&account,
+			&account.ID,
+			&account.Name,
+			&account.Kind,
+			&account.ServiceUuid,
+			&account.CreatedAt,
+			&account.UpdatedAt,
+			&account.Status,
+			&account.CleanupCount,
+		); err != nil {
+			return accounts, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, rows.Err()
+}
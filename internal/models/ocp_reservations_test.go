@@ -0,0 +1,37 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestOcpReservationAllows(t *testing.T) {
+	request := ResourceRequest{
+		Kind:  "OcpSandbox",
+		Count: 3,
+		Quota: v1.ResourceList{v1.ResourceRequestsCPU: resource.MustParse("10")},
+	}
+	held := v1.ResourceList{v1.ResourceRequestsCPU: resource.MustParse("6")}
+
+	if err := ocpReservationAllows(request, 2, held, v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}); err != nil {
+		t.Errorf("3rd OcpSandbox with 4 cpu should be allowed, got %v", err)
+	}
+	if err := ocpReservationAllows(request, 3, held, v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}); !errors.Is(err, ErrReservationFull) {
+		t.Errorf("4th OcpSandbox should exceed the count, got %v", err)
+	}
+	if err := ocpReservationAllows(request, 1, held, v1.ResourceList{v1.ResourceRequestsCPU: resource.MustParse("4500m")}); !errors.Is(err, ErrReservationFull) {
+		t.Errorf("10.5 cpu should exceed the quota, got %v", err)
+	}
+	if err := ocpReservationAllows(request, 1, held, v1.ResourceList{}); !errors.Is(err, ErrReservationFull) {
+		t.Errorf("OcpSandbox without cpu quota should not be allowed, got %v", err)
+	}
+
+	// No quota, only the count
+	request.Quota = nil
+	if err := ocpReservationAllows(request, 2, v1.ResourceList{}, v1.ResourceList{}); err != nil {
+		t.Errorf("OcpSandbox without quota should be allowed, got %v", err)
+	}
+}
@@ -13,6 +13,10 @@ import (
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/rhpds/sandbox/internal/log"
+	"github.com/rhpds/sandbox/internal/metrics"
+	"github.com/rhpds/sandbox/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	v1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -101,6 +105,7 @@ type OcpSandbox struct {
 	ToCleanup                         bool              `json:"to_cleanup"`
 	Quota                             v1.ResourceList   `json:"quota,omitempty"`
 	LimitRange                        *v1.LimitRange    `json:"limit_range,omitempty"`
+	Reservation                       string            `json:"reservation,omitempty"`
 }
 
 type OcpSandboxWithCreds struct {
@@ -791,13 +796,24 @@ func (a *OcpSandboxProvider) FetchAllByServiceUuidWithCreds(serviceUuid string)
 
 var ErrNoSchedule error = errors.New("No OCP shared cluster configuration found")
 
-func (a *OcpSandboxProvider) GetSchedulableClusters(cloud_selector map[string]string) (OcpSharedClusterConfigurations, error) {
+// GetSchedulableClusters returns the valid clusters matching the cloud_selector.
+// With a reservation, only the clusters reserved by the reservation are returned,
+// otherwise the clusters dedicated to a reservation are excluded.
+func (a *OcpSandboxProvider) GetSchedulableClusters(cloud_selector map[string]string, reservation string) (OcpSharedClusterConfigurations, error) {
 	clusters := OcpSharedClusterConfigurations{}
 	// Get resource from 'ocp_shared_cluster_configurations' table
 	rows, err := a.DbPool.Query(
 		context.Background(),
-		`SELECT name FROM ocp_shared_cluster_configurations WHERE annotations @> $1 and valid=true ORDER BY random()`,
-		cloud_selector,
+		`SELECT name FROM ocp_shared_cluster_configurations oc
+		 WHERE annotations @> $1 and valid=true
+		 AND (
+		   ($2 = '' AND NOT EXISTS (
+		     SELECT 1 FROM ocp_reservation_clusters rc WHERE rc.cluster_name = oc.name AND rc.dedicated))
+		   OR ($2 <> '' AND EXISTS (
+		     SELECT 1 FROM ocp_reservation_clusters rc WHERE rc.cluster_name = oc.name AND rc.reservation_name = $2))
+		 )
+		 ORDER BY random()`,
+		cloud_selector, reservation,
 	)
 
 	if err != nil {
@@ -824,17 +840,29 @@ func (a *OcpSandboxProvider) GetSchedulableClusters(cloud_selector map[string]st
 }
 
 func (a *OcpSharedClusterConfiguration) CreateRestConfig() (*rest.Config, error) {
+	var config *rest.Config
 	if a.Token != "" {
-		return &rest.Config{
+		config = &rest.Config{
 			Host:        a.ApiUrl,
 			BearerToken: a.Token,
 			TLSClientConfig: rest.TLSClientConfig{
 				Insecure: true,
 			},
-		}, nil
+		}
+	} else {
+		var err error
+		config, err = clientcmd.RESTConfigFromKubeConfig([]byte(a.Kubeconfig))
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return clientcmd.RESTConfigFromKubeConfig([]byte(a.Kubeconfig))
+	// Each call to the API of the cluster is a span in the trace of the context passed to the client
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return tracing.Transport(rt, "kubernetes")
+	})
+
+	return config, nil
 }
 
 func (a *OcpSharedClusterConfiguration) TestConnection() error {
@@ -902,9 +930,12 @@ func anySchedulableNodes(nodes []v1.Node) bool {
 	return false
 }
 
-func (a *OcpSandboxProvider) Request(serviceUuid string, cloud_selector map[string]string, annotations map[string]string, requestedQuota *v1.ResourceList, requestedLimitRange *v1.LimitRange, multiple bool, ctx context.Context) (OcpSandboxWithCreds, error) {
-	var selectedCluster OcpSharedClusterConfiguration
-	var selectedClusterMemoryUsage float64 = -1
+// Request schedules an OcpSandbox on one of the clusters matching the
+// cloud_selector. With a reservation, the OcpSandbox is scheduled only on the
+// clusters of the reservation, within its count and quota.
+func (a *OcpSandboxProvider) Request(serviceUuid string, reservation string, cloud_selector map[string]string, annotations map[string]string, requestedQuota *v1.ResourceList, requestedLimitRange *v1.LimitRange, multiple bool, ctx context.Context) (OcpSandboxWithCreds, error) {
+	ctx, span := tracing.Start(ctx, "OcpSandboxProvider.Request", attribute.String("service_uuid", serviceUuid))
+	defer span.End()
 
 	// Ensure annotation has guid
 	if _, exists := annotations["guid"]; !exists {
@@ -912,7 +943,7 @@ func (a *OcpSandboxProvider) Request(serviceUuid string, cloud_selector map[stri
 	}
 
 	// Version with OcpSharedClusterConfiguration methods
-	candidateClusters, err := a.GetSchedulableClusters(cloud_selector)
+	candidateClusters, err := a.GetSchedulableClusters(cloud_selector, reservation)
 	if err != nil {
 		log.Logger.Error("Error getting schedulable clusters", "error", err)
 		return OcpSandboxWithCreds{}, err
@@ -922,6 +953,25 @@ func (a *OcpSandboxProvider) Request(serviceUuid string, cloud_selector map[stri
 		return OcpSandboxWithCreds{}, ErrNoSchedule
 	}
 
+	// The count and quota of the reservation are checked and the OcpSandbox
+	// saved under the lock of the reservation, so they can't be exceeded
+	if reservation != "" {
+		release, err := LockReservation(ctx, a.DbPool, reservation)
+		if err != nil {
+			return OcpSandboxWithCreds{}, err
+		}
+		defer release()
+
+		requested := v1.ResourceList{}
+		if requestedQuota != nil {
+			requested = *requestedQuota
+		}
+		if err := a.checkOcpReservation(ctx, reservation, requested); err != nil {
+			log.Logger.Info("OcpSandbox not allowed by the reservation", "reservation", reservation, "error", err)
+			return OcpSandboxWithCreds{}, err
+		}
+	}
+
 	// Determine guid, auto increment the guid if there are multiple resources
 	// for a serviceUuid
 	guid, err := guessNextGuid(annotations["guid"], serviceUuid, a.DbPool, multiple, ctx)
@@ -937,6 +987,7 @@ func (a *OcpSandboxProvider) Request(serviceUuid string, cloud_selector map[stri
 			Annotations: annotations,
 			ServiceUuid: serviceUuid,
 			Status:      "initializing",
+			Reservation: reservation,
 		},
 		Provider: a,
 	}
@@ -944,6 +995,11 @@ func (a *OcpSandboxProvider) Request(serviceUuid string, cloud_selector map[stri
 	rnew.Resource.CreatedAt = time.Now()
 	rnew.Resource.UpdatedAt = time.Now()
 
+	// Until the quota is applied, the requested quota counts in the quota of the reservation
+	if reservation != "" && requestedQuota != nil {
+		rnew.Quota = *requestedQuota
+	}
+
 	if err := rnew.Save(); err != nil {
 		log.Logger.Error("Error saving OCP account", "error", err)
 		return OcpSandboxWithCreds{}, err
@@ -951,311 +1007,399 @@ func (a *OcpSandboxProvider) Request(serviceUuid string, cloud_selector map[stri
 
 	//--------------------------------------------------
 	// The following is async
+	request := OcpProvisioningRequest{
+		CloudSelector: cloud_selector,
+		Quota:         requestedQuota,
+		LimitRange:    requestedLimitRange,
+	}
+	// The provisioning joins the trace of the request but outlives it
+	provisionCtx := trackOcpProvisioning(tracing.Detach(ctx), &rnew, request)
+	result := rnew
 	go func() {
-	providerLoop:
-		for _, cluster := range candidateClusters {
-			rnew.SetStatus("scheduling")
-
-			log.Logger.Info("Cluster",
-				"name", cluster.Name,
-				"ApiUrl", cluster.ApiUrl)
-
-			config, err := cluster.CreateRestConfig()
-			if err != nil {
-				log.Logger.Error("Error creating OCP config", "error", err)
-				rnew.SetStatus("error")
-				continue providerLoop
-			}
-
-			clientset, err := kubernetes.NewForConfig(config)
-			if err != nil {
-				log.Logger.Error("Error creating OCP client", "error", err)
-				rnew.SetStatus("error")
-				continue providerLoop
-			}
-
-			clientsetMetrics, err := metricsv.NewForConfig(config)
-			if err != nil {
-				log.Logger.Error("Error creating OCP metrics client", "error", err)
-				rnew.SetStatus("error")
-				continue providerLoop
-			}
-
-			nodes, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: cluster.UsageNodeSelector})
-			if err != nil {
-				log.Logger.Error("Error listing OCP nodes", "error", err)
-				rnew.SetStatus("error")
-				continue providerLoop
-			}
-
-			var totalAllocatableCpu, totalAllocatableMemory int64
-			var totalUsageCpu, totalUsageMemory int64
-
-			if !anySchedulableNodes(nodes.Items) {
-				log.Logger.Info("No schedulable/ready nodes found",
-					"cluster", cluster.Name,
-					"serviceUuid", rnew.ServiceUuid,
-				)
-				continue providerLoop
-			}
-
-			for _, node := range nodes.Items {
-
-				if include, reason := includeNodeInUsageCalculation(node); !include {
-					log.Logger.Info("Node not included in calculation",
-						"node",
-						node.Name,
-						"reason", reason,
-					)
-					continue
-				}
-
-				allocatableCpu := node.Status.Allocatable.Cpu().MilliValue()
-				allocatableMemory := node.Status.Allocatable.Memory().Value()
-
-				totalAllocatableCpu += allocatableCpu
-				totalAllocatableMemory += allocatableMemory
-
-				nodeMetric, err := clientsetMetrics.MetricsV1beta1().
-					NodeMetricses().
-					Get(context.Background(), node.Name, metav1.GetOptions{})
-
-				if err != nil {
-					log.Logger.Error(
-						"Error Get OCP node metrics v1beta1, ignore the node",
-						"node", node.Name,
-						"error", err)
-					continue
-				}
+		defer untrackOcpProvisioning(&rnew)
+		a.provision(provisionCtx, &rnew, candidateClusters, request)
+	}()
+	//--------------------------------------------------
 
-				mem, _ := nodeMetric.Usage.Memory().AsInt64()
-				cpu := nodeMetric.Usage.Cpu().MilliValue()
+	return result, nil
+}
 
-				totalUsageCpu += cpu
-				totalUsageMemory += mem
-			}
+// provision elects a cluster for the OcpSandbox, and creates its namespace,
+// quota, limit range and service account. It runs asynchronously, until the
+// status of the sandbox is 'success' or 'error', or until ctx is cancelled.
+func (a *OcpSandboxProvider) provision(ctx context.Context, rnew *OcpSandboxWithCreds, candidateClusters OcpSharedClusterConfigurations, request OcpProvisioningRequest) {
+	var selectedCluster OcpSharedClusterConfiguration
+	var selectedClusterMemoryUsage float64 = -1
 
-			// Calculate total usage for the cluster
-			clusterCpuUsage := (float64(totalUsageCpu) / float64(totalAllocatableCpu)) * 100
-			clusterMemoryUsage := (float64(totalUsageMemory) / float64(totalAllocatableMemory)) * 100
-			log.Logger.Info(
-				"Cluster Usage",
-				"Cluster", cluster.Name,
-				"CPU% Usage", clusterCpuUsage,
-				"Memory% Usage", clusterMemoryUsage,
-			)
-			log.Logger.Info("selectedMemory", "value", selectedClusterMemoryUsage)
-			log.Logger.Info("selectedMemory", "value", selectedClusterMemoryUsage)
-			if clusterMemoryUsage < cluster.MaxMemoryUsagePercentage && clusterCpuUsage < cluster.MaxCpuUsagePercentage  && (selectedClusterMemoryUsage == -1 || clusterMemoryUsage < selectedClusterMemoryUsage) {
-				selectedCluster = cluster
-				selectedClusterMemoryUsage = clusterMemoryUsage
-			}
+	serviceUuid := rnew.ServiceUuid
+	annotations := rnew.Annotations
+	guid := strings.TrimSuffix(rnew.Name, "-"+serviceUuid)
+	requestedQuota := request.Quota
+	requestedLimitRange := request.LimitRange
+	cloud_selector := request.CloudSelector
+
+	provisionCtx, provisionSpan := tracing.Start(ctx, "OcpSandboxProvider.provision",
+		attribute.String("sandbox", rnew.Name))
+	start := time.Now()
+	defer func() {
+		cluster := selectedCluster.Name
+		if cluster == "" {
+			cluster = "none"
 		}
-
-		log.Logger.Info("selectedCluster", "cluster", selectedCluster.Name)
-		if selectedCluster.Name == "" {
-			log.Logger.Error("Error electing cluster",
-				"name", rnew.Name,
-				"serviceUuid", rnew.ServiceUuid,
-				"reason", "no cluster available")
-			rnew.SetStatus("error")
-			return
+		result := "error"
+		if rnew.Status == "success" {
+			result = "success"
 		}
+		metrics.OcpSchedulingDuration.WithLabelValues(cluster, result).Observe(time.Since(start).Seconds())
 
-		rnew.OcpApiUrl = selectedCluster.ApiUrl
-		rnew.OcpSharedClusterConfigurationName = selectedCluster.Name
-		rnew.OcpIngressDomain = selectedCluster.IngressDomain
-
-		if err := rnew.Save(); err != nil {
-			log.Logger.Error("Error saving OCP account", "error", err)
-			rnew.SetStatus("error")
-			return
+		provisionSpan.SetAttributes(attribute.String("cluster", cluster))
+		if result != "success" {
+			provisionSpan.SetStatus(codes.Error, "OCP sandbox not provisioned")
 		}
+		provisionSpan.End()
+	}()
+providerLoop:
+	for _, cluster := range candidateClusters {
+		rnew.SetStatus("scheduling")
 
-		config, err := selectedCluster.CreateRestConfig()
+		log.Logger.Info("Cluster",
+			"name", cluster.Name,
+			"ApiUrl", cluster.ApiUrl)
+
+		config, err := cluster.CreateRestConfig()
 		if err != nil {
 			log.Logger.Error("Error creating OCP config", "error", err)
 			rnew.SetStatus("error")
-			return
+			continue providerLoop
 		}
 
-		// Create an OpenShift client
 		clientset, err := kubernetes.NewForConfig(config)
 		if err != nil {
 			log.Logger.Error("Error creating OCP client", "error", err)
 			rnew.SetStatus("error")
-			return
+			continue providerLoop
 		}
 
-		// Create an dynamic OpenShift client for non regular objects
-		dynclientset, err := dynamic.NewForConfig(config)
+		clientsetMetrics, err := metricsv.NewForConfig(config)
 		if err != nil {
-			log.Logger.Error("Error creating OCP client", "error", err)
+			log.Logger.Error("Error creating OCP metrics client", "error", err)
 			rnew.SetStatus("error")
-			return
+			continue providerLoop
 		}
 
-		serviceAccountName := "sandbox"
-		suffix := annotations["namespace_suffix"]
-		if suffix == "" {
-			suffix = serviceUuid
+		nodes, err := clientset.CoreV1().Nodes().List(provisionCtx, metav1.ListOptions{LabelSelector: cluster.UsageNodeSelector})
+		if err != nil {
+			log.Logger.Error("Error listing OCP nodes", "error", err)
+			rnew.SetStatus("error")
+			continue providerLoop
 		}
 
-		namespaceName := "sandbox-" + guid + "-" + suffix
-		namespaceName = namespaceName[:min(63, len(namespaceName))] // truncate to 63
+		var totalAllocatableCpu, totalAllocatableMemory int64
+		var totalUsageCpu, totalUsageMemory int64
 
-		delay := time.Second
-		// Loop to wait for the namespace to be deleted
-		for {
-			// Create the Namespace
-			// Add serviceUuid as label to the namespace
+		if !anySchedulableNodes(nodes.Items) {
+			log.Logger.Info("No schedulable/ready nodes found",
+				"cluster", cluster.Name,
+				"serviceUuid", rnew.ServiceUuid,
+			)
+			continue providerLoop
+		}
 
-			_, err = clientset.CoreV1().Namespaces().Create(context.TODO(), &v1.Namespace{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: namespaceName,
-					Labels: map[string]string{
-						"mutatepods.kubemacpool.io":            "ignore",
-						"mutatevirtualmachines.kubemacpool.io": "ignore",
-						"serviceUuid":                          serviceUuid,
-						"guid":                                 annotations["guid"],
-					},
-				},
-			}, metav1.CreateOptions{})
+		for _, node := range nodes.Items {
 
-			if err != nil {
-				if strings.Contains(err.Error(), "object is being deleted: namespace") {
-					log.Logger.Warn("Error creating OCP namespace", "error", err)
-					time.Sleep(delay)
-					delay = delay * 2
-					if delay > 60*time.Second {
-						rnew.SetStatus("error")
-						return
-					}
+			if include, reason := includeNodeInUsageCalculation(node); !include {
+				log.Logger.Info("Node not included in calculation",
+					"node",
+					node.Name,
+					"reason", reason,
+				)
+				continue
+			}
 
-					continue
-				}
+			allocatableCpu := node.Status.Allocatable.Cpu().MilliValue()
+			allocatableMemory := node.Status.Allocatable.Memory().Value()
 
-				log.Logger.Error("Error creating OCP namespace", "error", err)
-				rnew.SetStatus("error")
-				return
-			}
+			totalAllocatableCpu += allocatableCpu
+			totalAllocatableMemory += allocatableMemory
 
-			rnew.Namespace = namespaceName
-			if err := rnew.Save(); err != nil {
-				log.Logger.Error("Error saving OCP account", "error", err)
-				rnew.SetStatus("error")
-				return
+			nodeMetric, err := clientsetMetrics.MetricsV1beta1().
+				NodeMetricses().
+				Get(provisionCtx, node.Name, metav1.GetOptions{})
+
+			if err != nil {
+				log.Logger.Error(
+					"Error Get OCP node metrics v1beta1, ignore the node",
+					"node", node.Name,
+					"error", err)
+				continue
 			}
-			break
+
+			mem, _ := nodeMetric.Usage.Memory().AsInt64()
+			cpu := nodeMetric.Usage.Cpu().MilliValue()
+
+			totalUsageCpu += cpu
+			totalUsageMemory += mem
 		}
 
-		if !selectedCluster.SkipQuota {
-			// Create Quota for the Namespace
-			// First calculate the quota using the requested_quota from the PlacementRequest and
-			// the options from the OcpSharedClusterConfiguration
-			requested := &v1.ResourceQuota{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "sandbox-requested-quota",
-				},
-				Spec: v1.ResourceQuotaSpec{
-					Hard: *requestedQuota,
+		// Calculate total usage for the cluster
+		clusterCpuUsage := (float64(totalUsageCpu) / float64(totalAllocatableCpu)) * 100
+		clusterMemoryUsage := (float64(totalUsageMemory) / float64(totalAllocatableMemory)) * 100
+		log.Logger.Info(
+			"Cluster Usage",
+			"Cluster", cluster.Name,
+			"CPU% Usage", clusterCpuUsage,
+			"Memory% Usage", clusterMemoryUsage,
+		)
+		log.Logger.Info("selectedMemory", "value", selectedClusterMemoryUsage)
+		log.Logger.Info("selectedMemory", "value", selectedClusterMemoryUsage)
+		if clusterMemoryUsage < cluster.MaxMemoryUsagePercentage && clusterCpuUsage < cluster.MaxCpuUsagePercentage  && (selectedClusterMemoryUsage == -1 || clusterMemoryUsage < selectedClusterMemoryUsage) {
+			selectedCluster = cluster
+			selectedClusterMemoryUsage = clusterMemoryUsage
+		}
+	}
+
+	log.Logger.Info("selectedCluster", "cluster", selectedCluster.Name)
+	if selectedCluster.Name == "" {
+		log.Logger.Error("Error electing cluster",
+			"name", rnew.Name,
+			"serviceUuid", rnew.ServiceUuid,
+			"reason", "no cluster available")
+		rnew.SetStatus("error")
+		return
+	}
+
+	rnew.OcpApiUrl = selectedCluster.ApiUrl
+	rnew.OcpSharedClusterConfigurationName = selectedCluster.Name
+	rnew.OcpIngressDomain = selectedCluster.IngressDomain
+
+	if err := rnew.Save(); err != nil {
+		log.Logger.Error("Error saving OCP account", "error", err)
+		rnew.SetStatus("error")
+		return
+	}
+
+	config, err := selectedCluster.CreateRestConfig()
+	if err != nil {
+		log.Logger.Error("Error creating OCP config", "error", err)
+		rnew.SetStatus("error")
+		return
+	}
+
+	// Create an OpenShift client
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Logger.Error("Error creating OCP client", "error", err)
+		rnew.SetStatus("error")
+		return
+	}
+
+	// Create an dynamic OpenShift client for non regular objects
+	dynclientset, err := dynamic.NewForConfig(config)
+	if err != nil {
+		log.Logger.Error("Error creating OCP client", "error", err)
+		rnew.SetStatus("error")
+		return
+	}
+
+	serviceAccountName := "sandbox"
+	suffix := annotations["namespace_suffix"]
+	if suffix == "" {
+		suffix = serviceUuid
+	}
+
+	namespaceName := "sandbox-" + guid + "-" + suffix
+	namespaceName = namespaceName[:min(63, len(namespaceName))] // truncate to 63
+
+	delay := time.Second
+	// Loop to wait for the namespace to be deleted
+	for {
+		// Create the Namespace
+		// Add serviceUuid as label to the namespace
+
+		_, err = clientset.CoreV1().Namespaces().Create(provisionCtx, &v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: namespaceName,
+				Labels: map[string]string{
+					"mutatepods.kubemacpool.io":            "ignore",
+					"mutatevirtualmachines.kubemacpool.io": "ignore",
+					"serviceUuid":                          serviceUuid,
+					"guid":                                 annotations["guid"],
 				},
-			}
+			},
+		}, metav1.CreateOptions{})
 
-			if selectedCluster.QuotaRequired {
-				// Check if the requested quota is provided and not empty
-				if requestedQuota == nil || len(*requestedQuota) == 0 {
-					log.Logger.Error("Error creating OCP quota", "error", "requested quota is required")
-					rnew.ErrorMessage = "Quota is required for this cluster and should be specified in the request"
-					if err := rnew.Save(); err != nil {
-						log.Logger.Error("Error saving OCP account", "error", err)
-					}
+		if err != nil {
+			if strings.Contains(err.Error(), "object is being deleted: namespace") {
+				log.Logger.Warn("Error creating OCP namespace", "error", err)
+				select {
+				case <-provisionCtx.Done():
+					// Interrupted, see ReleaseOcpProvisioning
+					return
+				case <-time.After(delay):
+				}
+				delay = delay * 2
+				if delay > 60*time.Second {
 					rnew.SetStatus("error")
 					return
 				}
+
+				continue
 			}
 
-			quota := ApplyQuota(requested,
-				selectedCluster.DefaultSandboxQuota,
-				selectedCluster.StrictDefaultSandboxQuota,
-			)
+			log.Logger.Error("Error creating OCP namespace", "error", err)
+			rnew.SetStatus("error")
+			return
+		}
 
-			rnew.Quota = quota.Spec.Hard
+		rnew.Namespace = namespaceName
+		if err := rnew.Save(); err != nil {
+			log.Logger.Error("Error saving OCP account", "error", err)
+			rnew.SetStatus("error")
+			return
+		}
+		break
+	}
 
-			// Troubleshooting output the quota
-			log.Logger.Debug("Quota", "quota", quota, "selectedCluster", selectedCluster,
-				"requestedQuota", requestedQuota)
+	if !selectedCluster.SkipQuota {
+		// Create Quota for the Namespace
+		// First calculate the quota using the requested_quota from the PlacementRequest and
+		// the options from the OcpSharedClusterConfiguration
+		requested := &v1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "sandbox-requested-quota",
+			},
+			Spec: v1.ResourceQuotaSpec{
+				Hard: *requestedQuota,
+			},
+		}
 
-			if err := rnew.Save(); err != nil {
-				log.Logger.Error("Error saving OCP account", "error", err)
+		if selectedCluster.QuotaRequired {
+			// Check if the requested quota is provided and not empty
+			if requestedQuota == nil || len(*requestedQuota) == 0 {
+				log.Logger.Error("Error creating OCP quota", "error", "requested quota is required")
+				rnew.ErrorMessage = "Quota is required for this cluster and should be specified in the request"
+				if err := rnew.Save(); err != nil {
+					log.Logger.Error("Error saving OCP account", "error", err)
+				}
 				rnew.SetStatus("error")
 				return
 			}
+		}
+
+		quota := ApplyQuota(requested,
+			selectedCluster.DefaultSandboxQuota,
+			selectedCluster.StrictDefaultSandboxQuota,
+		)
+
+		rnew.Quota = quota.Spec.Hard
+
+		// Troubleshooting output the quota
+		log.Logger.Debug("Quota", "quota", quota, "selectedCluster", selectedCluster,
+			"requestedQuota", requestedQuota)
 
-			_, err = clientset.CoreV1().ResourceQuotas(namespaceName).Create(context.TODO(), quota, metav1.CreateOptions{})
+		if err := rnew.Save(); err != nil {
+			log.Logger.Error("Error saving OCP account", "error", err)
+			rnew.SetStatus("error")
+			return
+		}
+
+		_, err = clientset.CoreV1().ResourceQuotas(namespaceName).Create(provisionCtx, quota, metav1.CreateOptions{})
+		if err != nil {
+			log.Logger.Error("Error creating OCP quota", "error", err)
+			if err := clientset.CoreV1().Namespaces().Delete(provisionCtx, namespaceName, metav1.DeleteOptions{}); err != nil {
+				log.Logger.Error("Error cleaning up the namespace", "error", err)
+			}
+			rnew.SetStatus("error")
+			return
+		}
+
+		limitRange := &v1.LimitRange{}
+		if requestedLimitRange != nil {
+			limitRange = requestedLimitRange
+		} else {
+			limitRange = selectedCluster.LimitRange
+		}
+
+		// Create the limit range
+		if limitRange.Name != "" {
+			_, err = clientset.CoreV1().LimitRanges(namespaceName).Create(provisionCtx, limitRange, metav1.CreateOptions{})
 			if err != nil {
-				log.Logger.Error("Error creating OCP quota", "error", err)
-				if err := clientset.CoreV1().Namespaces().Delete(context.TODO(), namespaceName, metav1.DeleteOptions{}); err != nil {
+				log.Logger.Error("Error creating OCP limit range",
+					"error", err,
+					"limit range", limitRange)
+				if err := clientset.CoreV1().Namespaces().Delete(provisionCtx, namespaceName, metav1.DeleteOptions{}); err != nil {
 					log.Logger.Error("Error cleaning up the namespace", "error", err)
 				}
 				rnew.SetStatus("error")
 				return
 			}
 
-			limitRange := &v1.LimitRange{}
-			if requestedLimitRange != nil {
-				limitRange = requestedLimitRange
-			} else {
-				limitRange = selectedCluster.LimitRange
+			rnew.LimitRange = limitRange
+			if err := rnew.Save(); err != nil {
+				log.Logger.Error("Error saving OCP account", "error", err)
+				rnew.SetStatus("error")
+				return
 			}
+		}
+	}
 
-			// Create the limit range
-			if limitRange.Name != "" {
-				_, err = clientset.CoreV1().LimitRanges(namespaceName).Create(context.TODO(), limitRange, metav1.CreateOptions{})
-				if err != nil {
-					log.Logger.Error("Error creating OCP limit range",
-						"error", err,
-						"limit range", limitRange)
-					if err := clientset.CoreV1().Namespaces().Delete(context.TODO(), namespaceName, metav1.DeleteOptions{}); err != nil {
-						log.Logger.Error("Error cleaning up the namespace", "error", err)
-					}
-					rnew.SetStatus("error")
-					return
-				}
+	_, err = clientset.CoreV1().ServiceAccounts(namespaceName).Create(provisionCtx, &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: serviceAccountName,
+			Labels: map[string]string{
+				"serviceUuid": serviceUuid,
+				"guid":        annotations["guid"],
+			},
+		},
+	}, metav1.CreateOptions{})
 
-				rnew.LimitRange = limitRange
-				if err := rnew.Save(); err != nil {
-					log.Logger.Error("Error saving OCP account", "error", err)
-					rnew.SetStatus("error")
-					return
-				}
-			}
+	if err != nil {
+		log.Logger.Error("Error creating OCP service account", "error", err)
+		// Delete the namespace
+		if err := clientset.CoreV1().Namespaces().Delete(provisionCtx, namespaceName, metav1.DeleteOptions{}); err != nil {
+			log.Logger.Error("Error cleaning up the namespace", "error", err)
 		}
+		rnew.SetStatus("error")
+		return
+	}
 
-		_, err = clientset.CoreV1().ServiceAccounts(namespaceName).Create(context.TODO(), &v1.ServiceAccount{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: serviceAccountName,
-				Labels: map[string]string{
-					"serviceUuid": serviceUuid,
-					"guid":        annotations["guid"],
-				},
+	// Create RoleBind for the Service Account in the Namespace
+	_, err = clientset.RbacV1().RoleBindings(namespaceName).Create(provisionCtx, &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: serviceAccountName,
+			Labels: map[string]string{
+				"serviceUuid": serviceUuid,
+				"guid":        annotations["guid"],
 			},
-		}, metav1.CreateOptions{})
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     "admin",
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      "ServiceAccount",
+				Name:      serviceAccountName,
+				Namespace: namespaceName,
+			},
+		},
+	}, metav1.CreateOptions{})
 
-		if err != nil {
-			log.Logger.Error("Error creating OCP service account", "error", err)
-			// Delete the namespace
-			if err := clientset.CoreV1().Namespaces().Delete(context.TODO(), namespaceName, metav1.DeleteOptions{}); err != nil {
-				log.Logger.Error("Error cleaning up the namespace", "error", err)
-			}
-			rnew.SetStatus("error")
-			return
+	if err != nil {
+		log.Logger.Error("Error creating OCP RoleBind", "error", err)
+		if err := clientset.CoreV1().Namespaces().Delete(provisionCtx, namespaceName, metav1.DeleteOptions{}); err != nil {
+			log.Logger.Error("Error cleaning up the namespace", "error", err)
 		}
+		rnew.SetStatus("error")
+		return
+	}
 
-		// Create RoleBind for the Service Account in the Namespace
-		_, err = clientset.RbacV1().RoleBindings(namespaceName).Create(context.TODO(), &rbacv1.RoleBinding{
+	// Assign ClusterRole sandbox-hcp (created with gitops) to the SA if hcp option was selected
+	if value, exists := cloud_selector["hcp"]; exists && (value == "yes" || value == "true") {
+		_, err = clientset.RbacV1().RoleBindings(namespaceName).Create(provisionCtx, &rbacv1.RoleBinding{
 			ObjectMeta: metav1.ObjectMeta{
-				Name: serviceAccountName,
+				Name: serviceAccountName + "-hcp",
 				Labels: map[string]string{
 					"serviceUuid": serviceUuid,
 					"guid":        annotations["guid"],
@@ -1264,7 +1408,7 @@ func (a *OcpSandboxProvider) Request(serviceUuid string, cloud_selector map[stri
 			RoleRef: rbacv1.RoleRef{
 				APIGroup: rbacv1.GroupName,
 				Kind:     "ClusterRole",
-				Name:     "admin",
+				Name:     serviceAccountName + "-hcp",
 			},
 			Subjects: []rbacv1.Subject{
 				{
@@ -1277,237 +1421,205 @@ func (a *OcpSandboxProvider) Request(serviceUuid string, cloud_selector map[stri
 
 		if err != nil {
 			log.Logger.Error("Error creating OCP RoleBind", "error", err)
-			if err := clientset.CoreV1().Namespaces().Delete(context.TODO(), namespaceName, metav1.DeleteOptions{}); err != nil {
+			if err := clientset.CoreV1().Namespaces().Delete(provisionCtx, namespaceName, metav1.DeleteOptions{}); err != nil {
 				log.Logger.Error("Error cleaning up the namespace", "error", err)
 			}
 			rnew.SetStatus("error")
 			return
 		}
+	}
 
-		// Assign ClusterRole sandbox-hcp (created with gitops) to the SA if hcp option was selected
-		if value, exists := cloud_selector["hcp"]; exists && (value == "yes" || value == "true") {
-			_, err = clientset.RbacV1().RoleBindings(namespaceName).Create(context.TODO(), &rbacv1.RoleBinding{
+	// TODO: parameterize this, or detect when to execute it, otherwise it'll fail
+	// // Create RoleBind for the Service Account in the Namespace for kubevirt
+	// _, err = clientset.RbacV1().RoleBindings(namespaceName).Create(provisionCtx, &rbacv1.RoleBinding{
+	// 	ObjectMeta: metav1.ObjectMeta{
+	// 		Name: "kubevirt-" + namespaceName[:min(53, len(namespaceName))],
+	// 		Labels: map[string]string{
+	// 			"serviceUuid": serviceUuid,
+	// 			"guid":        annotations["guid"],
+	// 		},
+	// 	},
+	// 	RoleRef: rbacv1.RoleRef{
+	// 		APIGroup: rbacv1.GroupName,
+	// 		Kind:     "ClusterRole",
+	// 		Name:     "kubevirt.io:admin",
+	// 	},
+	// 	Subjects: []rbacv1.Subject{
+	// 		{
+	// 			Kind:      "ServiceAccount",
+	// 			Name:      serviceAccountName,
+	// 			Namespace: namespaceName,
+	// 		},
+	// 	},
+	// }, metav1.CreateOptions{})
+
+	// if err != nil {
+	// 	log.Logger.Error("Error creating OCP RoleBind", "error", err)
+	// 	if err := clientset.CoreV1().Namespaces().Delete(provisionCtx, namespaceName, metav1.DeleteOptions{}); err != nil {
+	// 		log.Logger.Error("Error cleaning up the namespace", "error", err)
+	// 	}
+	// 	rnew.SetStatus("error")
+	// 	return
+	// }
+
+	// if cloud_selector has enabled the virt flag, then we give permission to cnv-images namespace
+	if value, exists := cloud_selector["virt"]; exists && (value == "yes" || value == "true") {
+		// Look if namespace 'cnv-images' exists
+		if _, err := clientset.CoreV1().Namespaces().Get(provisionCtx, "cnv-images", metav1.GetOptions{}); err == nil {
+
+			rb := &rbacv1.RoleBinding{
 				ObjectMeta: metav1.ObjectMeta{
-					Name: serviceAccountName + "-hcp",
+					Name:      "allow-clone-" + namespaceName[:min(51, len(namespaceName))],
+					Namespace: "cnv-images",
 					Labels: map[string]string{
 						"serviceUuid": serviceUuid,
 						"guid":        annotations["guid"],
 					},
 				},
-				RoleRef: rbacv1.RoleRef{
-					APIGroup: rbacv1.GroupName,
-					Kind:     "ClusterRole",
-					Name:     serviceAccountName + "-hcp",
-				},
 				Subjects: []rbacv1.Subject{
 					{
 						Kind:      "ServiceAccount",
-						Name:      serviceAccountName,
+						Name:      "default",
 						Namespace: namespaceName,
 					},
 				},
-			}, metav1.CreateOptions{})
-
-			if err != nil {
-				log.Logger.Error("Error creating OCP RoleBind", "error", err)
-				if err := clientset.CoreV1().Namespaces().Delete(context.TODO(), namespaceName, metav1.DeleteOptions{}); err != nil {
-					log.Logger.Error("Error cleaning up the namespace", "error", err)
-				}
-				rnew.SetStatus("error")
-				return
+				RoleRef: rbacv1.RoleRef{
+					Kind:     "ClusterRole",
+					Name:     "datavolume-cloner",
+					APIGroup: "rbac.authorization.k8s.io",
+				},
 			}
-		}
 
-		// TODO: parameterize this, or detect when to execute it, otherwise it'll fail
-		// // Create RoleBind for the Service Account in the Namespace for kubevirt
-		// _, err = clientset.RbacV1().RoleBindings(namespaceName).Create(context.TODO(), &rbacv1.RoleBinding{
-		// 	ObjectMeta: metav1.ObjectMeta{
-		// 		Name: "kubevirt-" + namespaceName[:min(53, len(namespaceName))],
-		// 		Labels: map[string]string{
-		// 			"serviceUuid": serviceUuid,
-		// 			"guid":        annotations["guid"],
-		// 		},
-		// 	},
-		// 	RoleRef: rbacv1.RoleRef{
-		// 		APIGroup: rbacv1.GroupName,
-		// 		Kind:     "ClusterRole",
-		// 		Name:     "kubevirt.io:admin",
-		// 	},
-		// 	Subjects: []rbacv1.Subject{
-		// 		{
-		// 			Kind:      "ServiceAccount",
-		// 			Name:      serviceAccountName,
-		// 			Namespace: namespaceName,
-		// 		},
-		// 	},
-		// }, metav1.CreateOptions{})
-
-		// if err != nil {
-		// 	log.Logger.Error("Error creating OCP RoleBind", "error", err)
-		// 	if err := clientset.CoreV1().Namespaces().Delete(context.TODO(), namespaceName, metav1.DeleteOptions{}); err != nil {
-		// 		log.Logger.Error("Error cleaning up the namespace", "error", err)
-		// 	}
-		// 	rnew.SetStatus("error")
-		// 	return
-		// }
-
-		// if cloud_selector has enabled the virt flag, then we give permission to cnv-images namespace
-		if value, exists := cloud_selector["virt"]; exists && (value == "yes" || value == "true") {
-			// Look if namespace 'cnv-images' exists
-			if _, err := clientset.CoreV1().Namespaces().Get(context.TODO(), "cnv-images", metav1.GetOptions{}); err == nil {
-
-				rb := &rbacv1.RoleBinding{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      "allow-clone-" + namespaceName[:min(51, len(namespaceName))],
-						Namespace: "cnv-images",
-						Labels: map[string]string{
-							"serviceUuid": serviceUuid,
-							"guid":        annotations["guid"],
-						},
-					},
-					Subjects: []rbacv1.Subject{
-						{
-							Kind:      "ServiceAccount",
-							Name:      "default",
-							Namespace: namespaceName,
-						},
-					},
-					RoleRef: rbacv1.RoleRef{
-						Kind:     "ClusterRole",
-						Name:     "datavolume-cloner",
-						APIGroup: "rbac.authorization.k8s.io",
-					},
-				}
-
-				_, err = clientset.RbacV1().RoleBindings("cnv-images").Create(context.TODO(), rb, metav1.CreateOptions{})
-				if err != nil {
-					if !strings.Contains(err.Error(), "already exists") {
-						log.Logger.Error("Error creating rolebinding on cnv-images", "error", err)
+			_, err = clientset.RbacV1().RoleBindings("cnv-images").Create(provisionCtx, rb, metav1.CreateOptions{})
+			if err != nil {
+				if !strings.Contains(err.Error(), "already exists") {
+					log.Logger.Error("Error creating rolebinding on cnv-images", "error", err)
 
-						if err := clientset.CoreV1().Namespaces().Delete(context.TODO(), namespaceName, metav1.DeleteOptions{}); err != nil {
-							log.Logger.Error("Error cleaning up the namespace", "error", err)
-						}
-						rnew.SetStatus("error")
-						return
+					if err := clientset.CoreV1().Namespaces().Delete(provisionCtx, namespaceName, metav1.DeleteOptions{}); err != nil {
+						log.Logger.Error("Error cleaning up the namespace", "error", err)
 					}
+					rnew.SetStatus("error")
+					return
 				}
 			}
-			// TODO: decide if we want another flag to configure the RadosNamespace
-			// Define the CephBlockPoolRadosNamespace GroupVersionResource
-			cephBlockPoolRadosNamespaceGVR := schema.GroupVersionResource{
-				Group:    "ceph.rook.io",
-				Version:  "v1",
-				Resource: "cephblockpoolradosnamespaces",
-			}
-			// Create the CephBlockPoolRadosNamespace object as an unstructured object
-			cephBlockPoolRadosNamespace := &unstructured.Unstructured{
-				Object: map[string]any{
-					"apiVersion": "ceph.rook.io/v1",
-					"kind":       "CephBlockPoolRadosNamespace",
-					"metadata": map[string]any{
-						"name":      namespaceName,
-						"namespace": "openshift-storage",
-					},
-					"spec": map[string]any{
-						"blockPoolName": "ocpv-tenants",
-					},
+		}
+		// TODO: decide if we want another flag to configure the RadosNamespace
+		// Define the CephBlockPoolRadosNamespace GroupVersionResource
+		cephBlockPoolRadosNamespaceGVR := schema.GroupVersionResource{
+			Group:    "ceph.rook.io",
+			Version:  "v1",
+			Resource: "cephblockpoolradosnamespaces",
+		}
+		// Create the CephBlockPoolRadosNamespace object as an unstructured object
+		cephBlockPoolRadosNamespace := &unstructured.Unstructured{
+			Object: map[string]any{
+				"apiVersion": "ceph.rook.io/v1",
+				"kind":       "CephBlockPoolRadosNamespace",
+				"metadata": map[string]any{
+					"name":      namespaceName,
+					"namespace": "openshift-storage",
 				},
-			}
-			_, err = dynclientset.Resource(cephBlockPoolRadosNamespaceGVR).Namespace("openshift-storage").Create(context.TODO(), cephBlockPoolRadosNamespace, metav1.CreateOptions{})
-			if err != nil {
-				log.Logger.Error("Error creating CephBlockPoolRadosNamespace", "error", err)
-			}
-
-			log.Logger.Debug("CephBlockPoolRadosNamespace created successfully")
+				"spec": map[string]any{
+					"blockPoolName": "ocpv-tenants",
+				},
+			},
+		}
+		_, err = dynclientset.Resource(cephBlockPoolRadosNamespaceGVR).Namespace("openshift-storage").Create(provisionCtx, cephBlockPoolRadosNamespace, metav1.CreateOptions{})
+		if err != nil {
+			log.Logger.Error("Error creating CephBlockPoolRadosNamespace", "error", err)
 		}
 
-		// Create secret to generate a token, for the clusters without image registry and for future versions of OCP
-		secret := &v1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      serviceAccountName + "-token",
-				Namespace: namespaceName,
-				Annotations: map[string]string{
-					"kubernetes.io/service-account.name": serviceAccountName,
-				},
+		log.Logger.Debug("CephBlockPoolRadosNamespace created successfully")
+	}
+
+	// Create secret to generate a token, for the clusters without image registry and for future versions of OCP
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceAccountName + "-token",
+			Namespace: namespaceName,
+			Annotations: map[string]string{
+				"kubernetes.io/service-account.name": serviceAccountName,
 			},
-			Type: v1.SecretTypeServiceAccountToken,
+		},
+		Type: v1.SecretTypeServiceAccountToken,
+	}
+	_, err = clientset.CoreV1().Secrets(namespaceName).Create(provisionCtx, secret, metav1.CreateOptions{})
+
+	if err != nil {
+		log.Logger.Error("Error creating secret for SA", "error", err)
+		// Delete the namespace
+		if err := clientset.CoreV1().Namespaces().Delete(provisionCtx, namespaceName, metav1.DeleteOptions{}); err != nil {
+			log.Logger.Error("Error creating OCP secret for SA", "error", err)
 		}
-		_, err = clientset.CoreV1().Secrets(namespaceName).Create(context.TODO(), secret, metav1.CreateOptions{})
+		rnew.SetStatus("error")
+		return
+	}
 
+	maxRetries := 5
+	retryCount := 0
+	sleepDuration := time.Second * 5
+	var saSecret *v1.Secret
+	// Loop till token exists
+	for {
+		secrets, err := clientset.CoreV1().Secrets(namespaceName).List(provisionCtx, metav1.ListOptions{})
 		if err != nil {
-			log.Logger.Error("Error creating secret for SA", "error", err)
+			log.Logger.Error("Error listing OCP secrets", "error", err)
 			// Delete the namespace
-			if err := clientset.CoreV1().Namespaces().Delete(context.TODO(), namespaceName, metav1.DeleteOptions{}); err != nil {
-				log.Logger.Error("Error creating OCP secret for SA", "error", err)
+			if err := clientset.CoreV1().Namespaces().Delete(provisionCtx, namespaceName, metav1.DeleteOptions{}); err != nil {
+				log.Logger.Error("Error creating OCP service account", "error", err)
 			}
 			rnew.SetStatus("error")
 			return
 		}
 
-		maxRetries := 5
-		retryCount := 0
-		sleepDuration := time.Second * 5
-		var saSecret *v1.Secret
-		// Loop till token exists
-		for {
-			secrets, err := clientset.CoreV1().Secrets(namespaceName).List(context.TODO(), metav1.ListOptions{})
-			if err != nil {
-				log.Logger.Error("Error listing OCP secrets", "error", err)
-				// Delete the namespace
-				if err := clientset.CoreV1().Namespaces().Delete(context.TODO(), namespaceName, metav1.DeleteOptions{}); err != nil {
-					log.Logger.Error("Error creating OCP service account", "error", err)
-				}
-				rnew.SetStatus("error")
-				return
-			}
-
-			for _, secret := range secrets.Items {
-				if val, exists := secret.ObjectMeta.Annotations["kubernetes.io/service-account.name"]; exists {
-					if _, exists := secret.Data["token"]; exists {
-						if val == serviceAccountName {
-							saSecret = &secret
-							break
-						}
+		for _, secret := range secrets.Items {
+			if val, exists := secret.ObjectMeta.Annotations["kubernetes.io/service-account.name"]; exists {
+				if _, exists := secret.Data["token"]; exists {
+					if val == serviceAccountName {
+						saSecret = &secret
+						break
 					}
 				}
 			}
-			if saSecret != nil {
-				break
-			}
-			// Retry logic
-			retryCount++
-			if retryCount >= maxRetries {
-				log.Logger.Error("Max retries reached, service account secret not found")
-				rnew.SetStatus("error")
-				return
-			}
-
-			// Sleep before retrying
-			time.Sleep(sleepDuration)
 		}
-		creds := []any{
-			OcpServiceAccount{
-				Kind:  "ServiceAccount",
-				Name:  serviceAccountName,
-				Token: string(saSecret.Data["token"]),
-			},
+		if saSecret != nil {
+			break
+		}
+		// Retry logic
+		retryCount++
+		if retryCount >= maxRetries {
+			log.Logger.Error("Max retries reached, service account secret not found")
+			rnew.SetStatus("error")
+			return
 		}
-		rnew.Credentials = creds
-		rnew.Status = "success"
 
-		if err := rnew.Save(); err != nil {
-			log.Logger.Error("Error saving OCP account", "error", err)
-			log.Logger.Info("Trying to cleanup OCP account")
-			if err := rnew.Delete(); err != nil {
-				log.Logger.Error("Error cleaning up OCP account", "error", err)
-			}
+		// Sleep before retrying
+		select {
+		case <-provisionCtx.Done():
+			// Interrupted, see ReleaseOcpProvisioning
+			return
+		case <-time.After(sleepDuration):
 		}
-		log.Logger.Info("Ocp sandbox booked", "account", rnew.Name, "service_uuid", rnew.ServiceUuid,
-			"cluster", rnew.OcpSharedClusterConfigurationName, "namespace", rnew.Namespace)
-	}()
-	//--------------------------------------------------
+	}
+	creds := []any{
+		OcpServiceAccount{
+			Kind:  "ServiceAccount",
+			Name:  serviceAccountName,
+			Token: string(saSecret.Data["token"]),
+		},
+	}
+	rnew.Credentials = creds
+	rnew.Status = "success"
 
-	return rnew, nil
+	if err := rnew.Save(); err != nil {
+		log.Logger.Error("Error saving OCP account", "error", err)
+		log.Logger.Info("Trying to cleanup OCP account")
+		if err := rnew.Delete(); err != nil {
+			log.Logger.Error("Error cleaning up OCP account", "error", err)
+		}
+	}
+	log.Logger.Info("Ocp sandbox booked", "account", rnew.Name, "service_uuid", rnew.ServiceUuid,
+		"cluster", rnew.OcpSharedClusterConfigurationName, "namespace", rnew.Namespace)
 }
 
 func guessNextGuid(origGuid string, serviceUuid string, dbpool *pgxpool.Pool, multiple bool, ctx context.Context) (string, error) {
@@ -1727,19 +1839,33 @@ func (account *OcpSandboxWithCreds) Delete() error {
 		return err
 	}
 
-	// Get the OCP shared cluster configuration from the resources.resource_data column
+	if err := account.deleteNamespace(); err != nil {
+		account.SetStatus("error")
+		return err
+	}
+
+	_, err := account.Provider.DbPool.Exec(
+		context.Background(),
+		"DELETE FROM resources WHERE id = $1",
+		account.ID,
+	)
+	return err
+}
 
+// deleteNamespace deletes the namespace of the sandbox on its cluster, with
+// the role binding and the CephBlockPoolRadosNamespace created for it.
+// A namespace not found is considered deleted.
+func (account *OcpSandboxWithCreds) deleteNamespace() error {
+	// Get the OCP shared cluster configuration from the resources.resource_data column
 	cluster, err := account.Provider.GetOcpSharedClusterConfigurationByName(account.OcpSharedClusterConfigurationName)
 	if err != nil {
 		log.Logger.Error("Error getting OCP shared cluster configuration", "error", err)
-		account.SetStatus("error")
 		return err
 	}
 
 	config, err := cluster.CreateRestConfig()
 	if err != nil {
 		log.Logger.Error("Error creating OCP config", "error", err, "name", account.Name)
-		account.SetStatus("error")
 		return err
 	}
 
@@ -1747,7 +1873,6 @@ func (account *OcpSandboxWithCreds) Delete() error {
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		log.Logger.Error("Error creating OCP client", "error", err, "name", account.Name)
-		account.SetStatus("error")
 		return err
 	}
 
@@ -1755,7 +1880,6 @@ func (account *OcpSandboxWithCreds) Delete() error {
 	dynclientset, err := dynamic.NewForConfig(config)
 	if err != nil {
 		log.Logger.Error("Error creating OCP client", "error", err, "name", account.Name)
-		account.SetStatus("error")
 		return err
 	}
 
@@ -1765,23 +1889,16 @@ func (account *OcpSandboxWithCreds) Delete() error {
 		// if error ends with 'not found', consider deletion a success
 		if strings.Contains(err.Error(), "not found") {
 			log.Logger.Info("Namespace not found, consider deletion a success", "name", account.Name)
-			_, err = account.Provider.DbPool.Exec(
-				context.Background(),
-				"DELETE FROM resources WHERE id = $1",
-				account.ID,
-			)
-			return err
+			return nil
 		}
 
 		log.Logger.Error("Error getting OCP namespace", "error", err, "name", account.Name)
-		account.SetStatus("error")
 		return err
 	}
 	// Delete the Namespace
 	err = clientset.CoreV1().Namespaces().Delete(context.TODO(), account.Namespace, metav1.DeleteOptions{})
 	if err != nil {
 		log.Logger.Error("Error deleting OCP namespace", "error", err, "name", account.Name)
-		account.SetStatus("error")
 		return err
 	}
 
@@ -1796,7 +1913,6 @@ func (account *OcpSandboxWithCreds) Delete() error {
 	if _, err := clientset.RbacV1().RoleBindings("cnv-images").Get(context.TODO(), rbName, metav1.GetOptions{}); err == nil {
 		if err := clientset.RbacV1().RoleBindings("cnv-images").Delete(context.TODO(), rbName, metav1.DeleteOptions{}); err != nil {
 			log.Logger.Error("Error deleting rolebinding on cnv-images", "error", err)
-			account.SetStatus("error")
 			return err
 		}
 	}
@@ -1811,17 +1927,11 @@ func (account *OcpSandboxWithCreds) Delete() error {
 	if _, err := dynclientset.Resource(cephBlockPoolRadosNamespaceGVR).Namespace("openshift-storage").Get(context.TODO(), account.Namespace, metav1.GetOptions{}); err == nil {
 		if err := dynclientset.Resource(cephBlockPoolRadosNamespaceGVR).Namespace("openshift-storage").Delete(context.TODO(), account.Namespace, metav1.DeleteOptions{}); err != nil {
 			log.Logger.Error("Error deleting rolebinding on CephBlockPoolRadosNamespace", "error", err)
-			account.SetStatus("error")
 			return err
 		}
 	}
 
-	_, err = account.Provider.DbPool.Exec(
-		context.Background(),
-		"DELETE FROM resources WHERE id = $1",
-		account.ID,
-	)
-	return err
+	return nil
 }
 
 func (p *OcpSandboxProvider) FetchByName(name string) (OcpSandbox, error) {
@@ -20,6 +20,8 @@ type Placement struct {
 	Annotations  map[string]string `json:"annotations"`
 	Resources    []any             `json:"resources,omitempty"`
 	Request      any               `json:"request"`
+	CreatedBy    string            `json:"created_by,omitempty"` // name of the token
+	Tenant       string            `json:"tenant,omitempty"`     // 'tenant' claim of the token
 	DbPool       *pgxpool.Pool     `json:"-"`
 	FailOnDelete bool              `json:"-"` // plumbing for testing
 }
@@ -212,9 +214,9 @@ func (p *Placement) Create() error {
 		err = p.DbPool.QueryRow(
 			context.Background(),
 			`INSERT INTO placements
-			 (service_uuid, request, annotations)
-			 VALUES ($1, $2, $3) RETURNING id`,
-			p.ServiceUuid, p.Request, p.Annotations,
+			 (service_uuid, request, annotations, created_by, tenant)
+			 VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, '')) RETURNING id`,
+			p.ServiceUuid, p.Request, p.Annotations, p.CreatedBy, p.Tenant,
 		).Scan(&id)
 
 		if err != nil {
@@ -358,6 +360,8 @@ func GetPlacement(dbpool *pgxpool.Pool, id int) (*Placement, error) {
 			annotations,
 			status,
 			to_cleanup,
+			COALESCE(created_by, ''),
+			COALESCE(tenant, ''),
 			created_at,
 			updated_at
 		FROM placements WHERE id = $1`,
@@ -369,6 +373,8 @@ func GetPlacement(dbpool *pgxpool.Pool, id int) (*Placement, error) {
 		&p.Annotations,
 		&p.Status,
 		&p.ToCleanup,
+		&p.CreatedBy,
+		&p.Tenant,
 		&p.CreatedAt,
 		&p.UpdatedAt)
 
@@ -393,6 +399,8 @@ func GetAllPlacements(dbpool *pgxpool.Pool) (Placements, error) {
 			annotations,
 			status,
 			to_cleanup,
+			COALESCE(created_by, ''),
+			COALESCE(tenant, ''),
 			created_at,
 			updated_at
 		FROM placements`,
@@ -415,6 +423,8 @@ func GetAllPlacements(dbpool *pgxpool.Pool) (Placements, error) {
 			&p.Annotations,
 			&p.Status,
 			&p.ToCleanup,
+			&p.CreatedBy,
+			&p.Tenant,
 			&p.CreatedAt,
 			&p.UpdatedAt)
 		if err != nil {
@@ -441,6 +451,8 @@ func GetPlacementByServiceUuid(dbpool *pgxpool.Pool, serviceUuid string) (*Place
 			annotations,
 			status,
 			to_cleanup,
+			COALESCE(created_by, ''),
+			COALESCE(tenant, ''),
 			created_at,
 			updated_at
 		FROM
@@ -454,6 +466,8 @@ func GetPlacementByServiceUuid(dbpool *pgxpool.Pool, serviceUuid string) (*Place
 		&p.Annotations,
 		&p.Status,
 		&p.ToCleanup,
+		&p.CreatedBy,
+		&p.Tenant,
 		&p.CreatedAt,
 		&p.UpdatedAt)
 
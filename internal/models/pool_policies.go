@@ -0,0 +1,234 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// PoolPolicy is the floor of the shared pool of AWS accounts: the number of
+// accounts that the reservations of a priority can't take. The floor is either
+// an absolute count, or a percentage of all the accounts.
+// The policy of the priority '*' applies to the reservations without their own policy.
+type PoolPolicy struct {
+	Priority string `json:"priority"`
+
+	FloorCount   *int     `json:"floor_count,omitempty"`
+	FloorPercent *float64 `json:"floor_percent,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type PoolPolicies []PoolPolicy
+
+// DefaultPoolPolicyPriority is the priority of the policy of the reservations without their own policy
+const DefaultPoolPolicyPriority = "*"
+
+// defaultPoolFloorPercent is the floor when no policy is set
+const defaultPoolFloorPercent = 20.0
+
+// DefaultPoolPolicy returns the policy applied when no policy is set: 20% of the accounts
+func DefaultPoolPolicy() PoolPolicy {
+	percent := defaultPoolFloorPercent
+	return PoolPolicy{
+		Priority:     DefaultPoolPolicyPriority,
+		FloorPercent: &percent,
+	}
+}
+
+func (p *PoolPolicy) Bind(r *http.Request) error {
+	if (p.FloorCount == nil) == (p.FloorPercent == nil) {
+		return errors.New("either floor_count or floor_percent must be set")
+	}
+	if p.FloorCount != nil && *p.FloorCount < 0 {
+		return errors.New("floor_count must be positive")
+	}
+	if p.FloorPercent != nil && (*p.FloorPercent < 0 || *p.FloorPercent > 100) {
+		return errors.New("floor_percent must be between 0 and 100")
+	}
+	return nil
+}
+
+func (p *PoolPolicy) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func (p PoolPolicies) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Floor returns the number of accounts kept in the pool, out of total accounts
+func (p *PoolPolicy) Floor(total int) int {
+	if p.FloorCount != nil {
+		return *p.FloorCount
+	}
+	if p.FloorPercent != nil {
+		return int(float64(total) * *p.FloorPercent / 100)
+	}
+	return 0
+}
+
+// String describes the floor of the policy, ex: "20%" or "100 accounts"
+func (p *PoolPolicy) String() string {
+	if p.FloorCount != nil {
+		return fmt.Sprintf("%d accounts", *p.FloorCount)
+	}
+	if p.FloorPercent != nil {
+		return strconv.FormatFloat(*p.FloorPercent, 'f', -1, 64) + "%"
+	}
+	return "no floor"
+}
+
+// PoolCapacity is the maximum number of AWS accounts a reservation can take
+// from the pool, and why. The accounts being cleaned up come back to the pool:
+// a reservation asking for more than Max, up to MaxPending, is pending until
+// they are back.
+type PoolCapacity struct {
+	Priority string `json:"priority"`
+	// Priority of the policy applied, '*' for the default policy
+	Policy   string `json:"policy"`
+	Override bool   `json:"override"`
+
+	Total     int `json:"total"`
+	Available int `json:"available"`
+	Cleanup   int `json:"cleanup"`
+	Floor     int `json:"floor"`
+	// Accounts that can be reserved right now, and once the cleanups are done
+	Max        int `json:"max"`
+	MaxPending int `json:"max_pending"`
+
+	Requested int    `json:"requested"`
+	Allowed   bool   `json:"allowed"`
+	Pending   bool   `json:"pending"`
+	Reason    string `json:"reason"`
+}
+
+func (c *PoolCapacity) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// NewPoolCapacity computes the capacity of the pool for a reservation
+// requesting accounts. With override, the floor of the policy is ignored.
+func NewPoolCapacity(policy PoolPolicy, priority string, available int, cleanup int, total int, requested int, override bool) PoolCapacity {
+	c := PoolCapacity{
+		Priority:  priority,
+		Policy:    policy.Priority,
+		Override:  override,
+		Total:     total,
+		Available: available,
+		Cleanup:   cleanup,
+		Requested: requested,
+	}
+
+	if !override {
+		c.Floor = policy.Floor(total)
+	}
+	c.Max = max(available-c.Floor, 0)
+	c.MaxPending = max(available+cleanup-c.Floor, 0)
+	c.Allowed = c.MaxPending > 0 && requested <= c.MaxPending
+	c.Pending = c.Allowed && requested > c.Max
+
+	if override {
+		c.Reason = fmt.Sprintf("%d accounts available and %d being cleaned up, the floor of the pool is overridden",
+			available, cleanup)
+	} else {
+		c.Reason = fmt.Sprintf("%d accounts available and %d being cleaned up out of %d, %d kept in the pool by the policy '%s' (%s)",
+			available, cleanup, total, c.Floor, policy.Priority, policy.String())
+	}
+	return c
+}
+
+const poolPolicyColumns = `priority, floor_count, floor_percent, created_at, updated_at`
+
+func scanPoolPolicy(row interface{ Scan(...any) error }) (PoolPolicy, error) {
+	var p PoolPolicy
+	err := row.Scan(&p.Priority, &p.FloorCount, &p.FloorPercent, &p.CreatedAt, &p.UpdatedAt)
+	return p, err
+}
+
+// GetPoolPolicy returns the policy of the priority.
+// Returns pgx.ErrNoRows if there is none.
+func GetPoolPolicy(dbpool *pgxpool.Pool, priority string) (*PoolPolicy, error) {
+	p, err := scanPoolPolicy(dbpool.QueryRow(
+		context.Background(),
+		"SELECT "+poolPolicyColumns+" FROM reservation_pool_policies WHERE priority = $1",
+		priority,
+	))
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetEffectivePoolPolicy returns the policy of the priority, or the policy
+// of the priority '*', or the default policy.
+func GetEffectivePoolPolicy(dbpool *pgxpool.Pool, priority string) (PoolPolicy, error) {
+	p, err := scanPoolPolicy(dbpool.QueryRow(
+		context.Background(),
+		"SELECT "+poolPolicyColumns+` FROM reservation_pool_policies
+		 WHERE priority = $1 OR priority = $2
+		 ORDER BY priority = $2 LIMIT 1`,
+		priority, DefaultPoolPolicyPriority,
+	))
+	if err == pgx.ErrNoRows {
+		return DefaultPoolPolicy(), nil
+	}
+	return p, err
+}
+
+// FetchPoolPolicies returns all the pool policies
+func FetchPoolPolicies(dbpool *pgxpool.Pool) (PoolPolicies, error) {
+	rows, err := dbpool.Query(
+		context.Background(),
+		"SELECT "+poolPolicyColumns+" FROM reservation_pool_policies ORDER BY priority",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := PoolPolicies{}
+	for rows.Next() {
+		p, err := scanPoolPolicy(rows)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+
+	return policies, rows.Err()
+}
+
+// Save creates or replaces the policy of the priority
+func (p *PoolPolicy) Save(dbpool *pgxpool.Pool) error {
+	return dbpool.QueryRow(
+		context.Background(),
+		`INSERT INTO reservation_pool_policies (priority, floor_count, floor_percent)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (priority) DO UPDATE SET
+		   floor_count = EXCLUDED.floor_count,
+		   floor_percent = EXCLUDED.floor_percent
+		 RETURNING created_at, updated_at`,
+		p.Priority, p.FloorCount, p.FloorPercent,
+	).Scan(&p.CreatedAt, &p.UpdatedAt)
+}
+
+// DeletePoolPolicy deletes the policy of the priority.
+// Returns pgx.ErrNoRows if there is none.
+func DeletePoolPolicy(dbpool *pgxpool.Pool, priority string) error {
+	tag, err := dbpool.Exec(context.Background(), "DELETE FROM reservation_pool_policies WHERE priority = $1", priority)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
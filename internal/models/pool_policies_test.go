@@ -0,0 +1,51 @@
+package models
+
+import "testing"
+
+func TestPoolCapacity(t *testing.T) {
+	// Default policy: 20% of the accounts
+	c := NewPoolCapacity(DefaultPoolPolicy(), "", 300, 0, 1000, 50, false)
+	if c.Floor != 200 || c.Max != 100 || !c.Allowed || c.Pending {
+		t.Errorf("Wrong capacity with the default policy: %+v", c)
+	}
+
+	// The accounts being cleaned up come back to the pool
+	c = NewPoolCapacity(DefaultPoolPolicy(), "", 300, 100, 1000, 150, false)
+	if c.Max != 100 || c.MaxPending != 200 || !c.Allowed || !c.Pending {
+		t.Errorf("150 accounts should be pending: %+v", c)
+	}
+
+	count := 250
+	policy := PoolPolicy{Priority: "low", FloorCount: &count}
+	c = NewPoolCapacity(policy, "low", 300, 0, 1000, 100, false)
+	if c.Floor != 250 || c.Max != 50 || c.Allowed {
+		t.Errorf("100 accounts should exceed the capacity: %+v", c)
+	}
+
+	c = NewPoolCapacity(policy, "low", 200, 0, 1000, 1, false)
+	if c.Max != 0 || c.Allowed {
+		t.Errorf("Pool below the floor should have no capacity: %+v", c)
+	}
+
+	c = NewPoolCapacity(policy, "low", 200, 0, 1000, 150, true)
+	if c.Floor != 0 || c.Max != 200 || !c.Allowed {
+		t.Errorf("Override should ignore the floor: %+v", c)
+	}
+}
+
+func TestPoolPolicyBind(t *testing.T) {
+	count, percent, tooMuch := 10, 10.0, 120.0
+
+	if err := (&PoolPolicy{}).Bind(nil); err == nil {
+		t.Error("Policy without floor should be invalid")
+	}
+	if err := (&PoolPolicy{FloorCount: &count, FloorPercent: &percent}).Bind(nil); err == nil {
+		t.Error("Policy with both floors should be invalid")
+	}
+	if err := (&PoolPolicy{FloorPercent: &tooMuch}).Bind(nil); err == nil {
+		t.Error("Policy above 100% should be invalid")
+	}
+	if err := (&PoolPolicy{FloorPercent: &percent}).Bind(nil); err != nil {
+		t.Errorf("Policy should be valid, got %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+package models
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/rhpds/sandbox/internal/log"
+)
+
+// ReservationEvent is an entry of the log of a reservation: a change of
+// status, or a change made by the reconciler.
+type ReservationEvent struct {
+	ID              int64          `json:"id"`
+	EventType       string         `json:"event_type"`
+	ReservationName string         `json:"reservation_name"`
+	Status          string         `json:"status"`
+	Details         map[string]any `json:"details,omitempty"`
+	CreatedAt       time.Time      `json:"created_at"`
+}
+
+type ReservationEvents []ReservationEvent
+
+func (e ReservationEvents) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// RecordEvent adds an event to the log of the reservation
+func (r *Reservation) RecordEvent(dbpool *pgxpool.Pool, eventType string, details map[string]any) error {
+	_, err := dbpool.Exec(
+		context.Background(),
+		`INSERT INTO reservations_events (event_type, reservation_id, reservation_name, request, status, details)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		eventType, r.ID, r.Name, r.Request, r.Status, details,
+	)
+	return err
+}
+
+// FetchReservationEvents returns the last events of the reservation, the most recent first
+func FetchReservationEvents(dbpool *pgxpool.Pool, name string, limit int) (ReservationEvents, error) {
+	rows, err := dbpool.Query(
+		context.Background(),
+		`SELECT id, event_type, reservation_name, status, details, created_at
+		 FROM reservations_events WHERE reservation_name = $1
+		 ORDER BY id DESC LIMIT $2`,
+		name, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := ReservationEvents{}
+	for rows.Next() {
+		var e ReservationEvent
+		if err := rows.Scan(&e.ID, &e.EventType, &e.ReservationName, &e.Status, &e.Details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// ClaimReservationToReconcile marks as reconciled an active reservation not
+// reconciled for interval, and returns it.
+// The replicas claim different reservations with SKIP LOCKED.
+// Returns pgx.ErrNoRows if there is none.
+func ClaimReservationToReconcile(dbpool *pgxpool.Pool, interval time.Duration) (*Reservation, error) {
+	return scanReservation(dbpool.QueryRow(
+		context.Background(),
+		`UPDATE reservations SET reconciled_at = now()
+		 WHERE id = (
+		   SELECT id FROM reservations
+		   WHERE status IN ('success', 'error')
+		   AND (ends_at IS NULL OR ends_at > now())
+		   AND (reconciled_at IS NULL OR reconciled_at <= now() - make_interval(secs => $1))
+		   ORDER BY reconciled_at NULLS FIRST LIMIT 1
+		   FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING `+reservationColumns,
+		interval.Seconds(),
+	))
+}
+
+// reservedAccountDrift returns why an AWS account held by a reservation must
+// be replaced, or "" if it can stay in the reservation.
+func reservedAccountDrift(account AwsAccount) string {
+	switch {
+	case account.ToCleanup:
+		return "marked for cleanup"
+	case account.AccountID == "":
+		return "account ID missing"
+	case account.Zone == "":
+		return "zone missing"
+	case account.HostedZoneID == "":
+		return "hosted zone ID missing"
+	}
+	return ""
+}
+
+// Reconcile compares the resources held by the reservation with its request.
+// It replaces the AWS accounts broken or marked for cleanup, reserves the
+// missing accounts, releases the accounts in excess and follows the OCP
+// clusters matching the request. Each change is recorded as an event.
+// The reservation is then 'pending' if accounts are missing, 'success' if
+// everything is in sync, or 'error' if something went wrong.
+// It runs under the lock of the reservation, and is skipped if the reservation
+// is being updated, deleted or released meanwhile.
+func (r *Reservation) Reconcile(dbpool *pgxpool.Pool, a AwsAccountProvider) {
+	release, err := LockReservation(context.Background(), dbpool, r.Name)
+	if err != nil {
+		log.Logger.Error("Error locking reservation", "error", err, "reservation", r.Name)
+		return
+	}
+	defer release()
+
+	// The reservation claimed may have changed before the lock was taken
+	current, err := GetReservationByName(dbpool, r.Name)
+	if err != nil {
+		log.Logger.Error("Error getting reservation", "error", err, "reservation", r.Name)
+		return
+	}
+	if current.Status != r.Status {
+		log.Logger.Info("Reservation changed, not reconciled", "reservation", r.Name, "status", current.Status)
+		return
+	}
+	*r = current
+
+	status := "success"
+	fail := func(step string, err error) {
+		log.Logger.Error("Error reconciling reservation", "error", err, "reservation", r.Name, "step", step)
+		r.RecordEvent(dbpool, "reconcile_error", map[string]any{"step": step, "error": err.Error()})
+		status = "error"
+	}
+
+	for _, resource := range r.Request.Resources {
+		switch resource.Kind {
+		case "AwsSandbox", "AwsAccount", "aws_account":
+			pending, err := r.reconcileAwsAccounts(dbpool, a, resource.Count)
+			if err != nil {
+				fail("aws_accounts", err)
+				continue
+			}
+			if pending && status == "success" {
+				status = "pending"
+			}
+
+		case "OcpSandbox":
+			if err := r.reconcileOcpClusters(dbpool, resource); err != nil {
+				fail("ocp_clusters", err)
+			}
+		}
+	}
+
+	if status != r.Status {
+		from := r.Status
+		updated, err := r.CompareAndUpdateStatus(dbpool, from, status)
+		switch {
+		case err != nil:
+			log.Logger.Error("Error updating reservation status", "error", err, "reservation", r.Name)
+		case !updated:
+			// Updated, deleted or released meanwhile, the status is theirs
+			log.Logger.Info("Reservation changed, status not updated", "reservation", r.Name, "status", status)
+		default:
+			log.Logger.Info("Reservation reconciled", "reservation", r.Name, "from", from, "to", status)
+		}
+	}
+}
+
+// reconcileAwsAccounts replaces the accounts of the reservation that drifted
+// and scales the reservation to count. Returns true if accounts are missing.
+func (r *Reservation) reconcileAwsAccounts(dbpool *pgxpool.Pool, a AwsAccountProvider, count int) (bool, error) {
+	accounts, err := a.FetchAllByReservation(r.Name)
+	if err != nil {
+		return false, err
+	}
+
+	held := 0
+	for _, account := range accounts {
+		reason := reservedAccountDrift(account)
+		if reason == "" {
+			held++
+			continue
+		}
+
+		if err := a.RemoveReservation(account.Name); err != nil {
+			return false, err
+		}
+		log.Logger.Info("Account removed from the reservation", "reservation", r.Name, "name", account.Name, "reason", reason)
+		r.RecordEvent(dbpool, "reconcile_account_removed", map[string]any{"account": account.Name, "reason": reason})
+	}
+
+	if held > count {
+		if err := a.ScaleDownReservation(r.Name, count); err != nil {
+			return false, err
+		}
+		r.RecordEvent(dbpool, "reconcile_scaled_down", map[string]any{"from": held, "to": count})
+		return false, r.UpdateProgress(dbpool, count, count)
+	}
+
+	if held == count {
+		if r.Reserved != held || r.Target != count {
+			return false, r.UpdateProgress(dbpool, held, count)
+		}
+		return false, nil
+	}
+
+	reserved, err := r.reserveAwsAccounts(dbpool, a, count)
+	if err != nil {
+		return false, err
+	}
+	if reserved > held {
+		r.RecordEvent(dbpool, "reconcile_scaled_up", map[string]any{"from": held, "to": reserved, "target": count})
+	}
+	return reserved < count, nil
+}
+
+// reconcileOcpClusters reserves the clusters matching the request again if
+// they changed, for example a cluster deleted or a new cluster matching the
+// cloud_selector.
+func (r *Reservation) reconcileOcpClusters(dbpool *pgxpool.Pool, request ResourceRequest) error {
+	desired, err := ocpReservationClusters(dbpool, request)
+	if err != nil {
+		return err
+	}
+
+	rows, err := dbpool.Query(
+		context.Background(),
+		"SELECT cluster_name FROM ocp_reservation_clusters WHERE reservation_name = $1 ORDER BY cluster_name",
+		r.Name,
+	)
+	if err != nil {
+		return err
+	}
+	held := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		held = append(held, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if slices.Equal(desired, held) {
+		return nil
+	}
+
+	if err := reserveOcpClusters(dbpool, r.Name, request); err != nil {
+		return err
+	}
+	return r.RecordEvent(dbpool, "reconcile_ocp_clusters", map[string]any{"from": held, "to": desired})
+}
@@ -0,0 +1,321 @@
+package models
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// The usage samples of the reservations are kept 90 days
+const reservationUsageRetention = 90 * 24 * time.Hour
+
+// AwsReservationCounts counts the AWS accounts of a reservation
+type AwsReservationCounts struct {
+	Reserved int `json:"reserved"`
+	// Used by placements
+	InUse int `json:"in_use"`
+	// Ready to be used by placements
+	Available int `json:"available"`
+	// Being cleaned up, they are available again after
+	ToCleanup int `json:"to_cleanup"`
+}
+
+// ReservationUsage is the usage of the resources of a reservation
+type ReservationUsage struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+
+	// AWS accounts of the reservation, out of the target
+	AwsReservationCounts
+	Target int `json:"target"`
+
+	// OcpSandboxes of the reservation, out of the count of the request
+	OcpSandboxes    int `json:"ocp_sandboxes"`
+	MaxOcpSandboxes int `json:"max_ocp_sandboxes"`
+
+	Peak       ReservationUsagePeak   `json:"peak"`
+	Placements []ReservationPlacement `json:"placements"`
+}
+
+// ReservationUsagePeak is the maximum usage of a reservation over a time range
+type ReservationUsagePeak struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	InUse   int        `json:"in_use"`
+	InUseAt *time.Time `json:"in_use_at,omitempty"`
+
+	OcpSandboxes   int        `json:"ocp_sandboxes"`
+	OcpSandboxesAt *time.Time `json:"ocp_sandboxes_at,omitempty"`
+}
+
+// ReservationPlacement is a placement consuming the resources of a reservation
+type ReservationPlacement struct {
+	ServiceUuid  string    `json:"service_uuid"`
+	Status       string    `json:"status"`
+	CreatedBy    string    `json:"created_by,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	AwsAccounts  int       `json:"aws_accounts"`
+	OcpSandboxes int       `json:"ocp_sandboxes"`
+}
+
+func (u *ReservationUsage) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// CountAwsReservations counts the AWS accounts of each reservation.
+// The accounts without reservation are ignored.
+func CountAwsReservations(accounts []AwsAccount) map[string]AwsReservationCounts {
+	counts := map[string]AwsReservationCounts{}
+	for _, account := range accounts {
+		if account.Reservation == "" {
+			continue
+		}
+
+		c := counts[account.Reservation]
+		c.Reserved++
+		switch {
+		case account.ToCleanup:
+			c.ToCleanup++
+		case account.Available:
+			c.Available++
+		default:
+			c.InUse++
+		}
+		counts[account.Reservation] = c
+	}
+	return counts
+}
+
+// RecordUsageSamples records the current usage of all the reservations holding
+// resources, to report their peak usage, and deletes the samples past the
+// retention. The reservations are sampled once a minute: every replica calls it
+// every minute, the first one takes the sample of the minute and the others
+// return false without sampling.
+func RecordUsageSamples(dbpool *pgxpool.Pool, a AwsAccountProvider) (bool, error) {
+	ctx := context.Background()
+	tx, err := dbpool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var locked, sampled bool
+	if err := tx.QueryRow(
+		ctx,
+		`SELECT pg_try_advisory_xact_lock(hashtext('reservation_usage_samples')),
+		        EXISTS (SELECT 1 FROM reservation_usage_samples WHERE sampled_at = date_trunc('minute', now()))`,
+	).Scan(&locked, &sampled); err != nil {
+		return false, err
+	}
+	if !locked || sampled {
+		return false, nil
+	}
+
+	// The reservations holding or reserving resources, whatever their status
+	rows, err := tx.Query(
+		ctx,
+		"SELECT reservation_name FROM reservations WHERE status NOT IN ('new', 'scheduled', 'expired')",
+	)
+	if err != nil {
+		return false, err
+	}
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return false, err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	if len(names) > 0 {
+		// All the accounts at once, then the counts of each reservation
+		accounts, err := a.FetchAll()
+		if err != nil {
+			return false, err
+		}
+		counts := CountAwsReservations(accounts)
+
+		ocpSandboxes := map[string]int{}
+		rows, err := tx.Query(
+			ctx,
+			`SELECT resource_data->>'reservation', count(*) FROM resources
+			 WHERE resource_type = 'OcpSandbox' AND resource_data->>'reservation' != ''
+			 GROUP BY 1`,
+		)
+		if err != nil {
+			return false, err
+		}
+		for rows.Next() {
+			var name string
+			var count int
+			if err := rows.Scan(&name, &count); err != nil {
+				rows.Close()
+				return false, err
+			}
+			ocpSandboxes[name] = count
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return false, err
+		}
+
+		for _, name := range names {
+			if _, err := tx.Exec(
+				ctx,
+				`INSERT INTO reservation_usage_samples (reservation_name, sampled_at, reserved, in_use, ocp_sandboxes)
+				 VALUES ($1, date_trunc('minute', now()), $2, $3, $4) ON CONFLICT DO NOTHING`,
+				name, counts[name].Reserved, counts[name].InUse, ocpSandboxes[name],
+			); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if _, err := tx.Exec(
+		ctx,
+		"DELETE FROM reservation_usage_samples WHERE sampled_at < now() - make_interval(secs => $1)",
+		reservationUsageRetention.Seconds(),
+	); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit(ctx)
+}
+
+// getReservationUsagePeak returns the peak usage of the reservation sampled between from and to
+func getReservationUsagePeak(dbpool *pgxpool.Pool, name string, from time.Time, to time.Time) (ReservationUsagePeak, error) {
+	peak := ReservationUsagePeak{From: from, To: to}
+
+	for _, column := range []struct {
+		name  string
+		value *int
+		at    **time.Time
+	}{
+		{"in_use", &peak.InUse, &peak.InUseAt},
+		{"ocp_sandboxes", &peak.OcpSandboxes, &peak.OcpSandboxesAt},
+	} {
+		var at time.Time
+		err := dbpool.QueryRow(
+			context.Background(),
+			`SELECT `+column.name+`, sampled_at FROM reservation_usage_samples
+			 WHERE reservation_name = $1 AND sampled_at BETWEEN $2 AND $3
+			 ORDER BY `+column.name+` DESC, sampled_at DESC LIMIT 1`,
+			name, from, to,
+		).Scan(column.value, &at)
+		if err == pgx.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return peak, err
+		}
+		*column.at = &at
+	}
+
+	return peak, nil
+}
+
+// GetReservationUsage returns the usage of the reservation: its resources,
+// the placements consuming them, and the peak usage between from and to.
+func GetReservationUsage(dbpool *pgxpool.Pool, a AwsAccountProvider, o OcpSandboxProvider, r Reservation, from time.Time, to time.Time) (*ReservationUsage, error) {
+	usage := &ReservationUsage{
+		Name:       r.Name,
+		Status:     r.Status,
+		Target:     r.Target,
+		Placements: []ReservationPlacement{},
+	}
+	for _, resource := range r.Request.Resources {
+		if resource.Kind == "OcpSandbox" {
+			usage.MaxOcpSandboxes = resource.Count
+		}
+	}
+
+	accounts, err := a.FetchAllByReservation(r.Name)
+	if err != nil {
+		return nil, err
+	}
+	usage.AwsReservationCounts = CountAwsReservations(accounts)[r.Name]
+
+	ocpSandboxes, err := o.FetchAllByReservation(r.Name)
+	if err != nil {
+		return nil, err
+	}
+	usage.OcpSandboxes = len(ocpSandboxes)
+
+	if usage.Peak, err = getReservationUsagePeak(dbpool, r.Name, from, to); err != nil {
+		return nil, err
+	}
+	// The current usage is the last sample
+	if now := time.Now(); !to.Before(now) {
+		if usage.InUse > usage.Peak.InUse || usage.Peak.InUseAt == nil {
+			usage.Peak.InUse, usage.Peak.InUseAt = usage.InUse, &now
+		}
+		if usage.OcpSandboxes > usage.Peak.OcpSandboxes || usage.Peak.OcpSandboxesAt == nil {
+			usage.Peak.OcpSandboxes, usage.Peak.OcpSandboxesAt = usage.OcpSandboxes, &now
+		}
+	}
+
+	// Placements consuming the reservation
+	rows, err := dbpool.Query(
+		context.Background(),
+		`SELECT service_uuid, status, COALESCE(created_by, ''), created_at FROM placements
+		 WHERE request->>'reservation' = $1 ORDER BY created_at`,
+		r.Name,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p ReservationPlacement
+		if err := rows.Scan(&p.ServiceUuid, &p.Status, &p.CreatedBy, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		for _, account := range accounts {
+			if account.ServiceUuid == p.ServiceUuid {
+				p.AwsAccounts++
+			}
+		}
+		for _, sandbox := range ocpSandboxes {
+			if sandbox.ServiceUuid == p.ServiceUuid {
+				p.OcpSandboxes++
+			}
+		}
+		usage.Placements = append(usage.Placements, p)
+	}
+
+	return usage, rows.Err()
+}
+
+// ReservationSummary is a reservation with the live counts of its AWS accounts
+type ReservationSummary struct {
+	Reservation
+	AwsAccounts AwsReservationCounts `json:"aws_accounts"`
+}
+
+// OrphanReservationAccounts returns the AWS accounts holding the name of a
+// reservation that doesn't exist, for example left behind by a failed delete.
+func OrphanReservationAccounts(accounts []AwsAccount, reservations []Reservation) []AwsAccount {
+	names := map[string]bool{}
+	for _, reservation := range reservations {
+		names[reservation.Name] = true
+	}
+
+	orphans := []AwsAccount{}
+	for _, account := range accounts {
+		if account.Reservation != "" && !names[account.Reservation] {
+			orphans = append(orphans, account)
+		}
+	}
+	return orphans
+}
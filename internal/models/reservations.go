@@ -5,11 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/rhpds/sandbox/internal/log"
 
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	v1 "k8s.io/api/core/v1"
 )
 
 type Reservation struct {
@@ -18,25 +20,119 @@ type Reservation struct {
 	Name    string             `json:"name"`
 	Status  string             `json:"status"`
 	Request ReservationRequest `json:"request"`
+
+	// Time window of the reservation, see ReservationRequest
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+
+	// Progress of the reservation: AWS accounts reserved, out of the target.
+	// The reservation is 'pending' until the target is reached.
+	Reserved int `json:"reserved"`
+	Target   int `json:"target"`
 }
 
 type ResourceRequest struct {
 	Kind  string `json:"kind"`
 	Count int    `json:"count"`
+
+	// OcpSandbox only.
+	// The clusters reserved, by name or by cloud_selector.
+	Clusters      []string          `json:"clusters,omitempty"`
+	CloudSelector map[string]string `json:"cloud_selector,omitempty"`
+	// Dedicated clusters schedule only the OcpSandboxes of the reservation.
+	// Otherwise the clusters are shared and the reservation gets a slice of
+	// them: Count OcpSandboxes, with a total quota up to Quota.
+	Dedicated bool            `json:"dedicated,omitempty"`
+	Quota     v1.ResourceList `json:"quota,omitempty"`
 }
 
 type ReservationRequest struct {
 	Name      string            `json:"name"`
 	Resources []ResourceRequest `json:"resources"`
+
+	// The reservation is activated shortly before StartsAt, and released
+	// after EndsAt. Without them, it's activated at once and held until deleted.
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+
+	// The AWS accounts reserved can't take the pool below the floor of the
+	// policy of the priority, unless OverridePoolFloor is set.
+	Priority          string `json:"priority,omitempty"`
+	OverridePoolFloor bool   `json:"override_pool_floor,omitempty"`
 }
 
 func (r *ReservationRequest) Bind(r2 *http.Request) error {
 	return nil
 }
 
-func (r *ReservationRequest) Validate(h AwsAccountProvider) (string, error) {
+// validateWindow checks the time window of the reservation
+func (r *ReservationRequest) validateWindow(now time.Time) (string, error) {
+	if r.EndsAt == nil {
+		return "", nil
+	}
+	if !r.EndsAt.After(now) {
+		return "ends_at must be in the future", errors.New("invalid window")
+	}
+	if r.StartsAt != nil && !r.EndsAt.After(*r.StartsAt) {
+		return "ends_at must be after starts_at", errors.New("invalid window")
+	}
+	return "", nil
+}
+
+// Scheduled returns true if the reservation must wait to be activated:
+// its start, minus the lead time, is in the future.
+func (r *ReservationRequest) Scheduled(now time.Time, lead time.Duration) bool {
+	return r.StartsAt != nil && r.StartsAt.Add(-lead).After(now)
+}
+
+// PoolCapacity returns the number of AWS accounts the reservation can take
+// from the pool right now, according to the pool policy of its priority.
+func (r *ReservationRequest) PoolCapacity(dbpool *pgxpool.Pool, h AwsAccountProvider) (PoolCapacity, error) {
+	policy, err := GetEffectivePoolPolicy(dbpool, r.Priority)
+	if err != nil {
+		return PoolCapacity{}, err
+	}
+
+	// Get the current number of total and available accounts
+	available, err := h.CountAvailable("")
+	if err != nil {
+		return PoolCapacity{}, err
+	}
+	total, err := h.Count()
+	if err != nil {
+		return PoolCapacity{}, err
+	}
+
+	// The accounts being cleaned up, out of any reservation, come back to the pool
+	toCleanup, err := h.FetchAllToCleanup()
+	if err != nil {
+		return PoolCapacity{}, err
+	}
+	cleanup := 0
+	for _, account := range toCleanup {
+		if account.Reservation == "" {
+			cleanup++
+		}
+	}
+
+	requested := 0
+	for _, resource := range r.Resources {
+		switch resource.Kind {
+		case "AwsSandbox", "AwsAccount", "aws_account":
+			requested += resource.Count
+		}
+	}
+
+	return NewPoolCapacity(policy, r.Priority, available, cleanup, total, requested, r.OverridePoolFloor), nil
+}
+
+func (r *ReservationRequest) Validate(h AwsAccountProvider, o OcpSandboxProvider) (string, error) {
 	done := make(map[string]bool)
 
+	if message, err := r.validateWindow(time.Now()); err != nil {
+		return message, err
+	}
+
 	for _, resource := range r.Resources {
 		if resource.Count < 1 {
 			return "Count must be >= 1", errors.New("invalid count")
@@ -51,44 +147,38 @@ func (r *ReservationRequest) Validate(h AwsAccountProvider) (string, error) {
 
 			done["AwsSandbox"] = true
 
-			// Get the current number of total and available accounts
-			available, err := h.CountAvailable("")
+			capacity, err := r.PoolCapacity(o.DbPool, h)
 			if err != nil {
 				return "", err
 			}
-			total, err := h.Count()
-			if err != nil {
-				return "", err
+
+			// We don't want to allow a reservation that would put the available
+			// accounts below the floor of the pool policy, unless it's overridden.
+			// The accounts being cleaned up are counted: the reservation is
+			// pending until they are back.
+			if capacity.MaxPending <= 0 {
+				log.Logger.Info("Not enough available resources", "capacity", capacity)
+				return "Not enough available resources: " + capacity.Reason, errors.New("not enough available resources")
+			}
+
+			if resource.Count > capacity.MaxPending {
+				log.Logger.Info("Not enough available resources", "capacity", capacity)
+				return fmt.Sprintf("You can only reserve up to %d accounts: %s", capacity.MaxPending, capacity.Reason), errors.New("not enough available resources")
 			}
 
-			// We don't want to allow a reservation that would put the total amount of
-			// available accounts below 20% of the total amount of accounts.
-
-			// formula:
-			// Let x the maximal request count
-			// (available - x) / total > 0.2
-			// available - x >= total * 0.2
-			// x <= available - total * 0.2
-			max := available - int(float64(total)*0.2)
-			// Validate Count
-			if max <= 0 {
-				log.Logger.Info(
-					"Not enough available resources",
-					"available", available,
-					"total", total,
-					"20% of total", int(float64(total)*0.2),
-					"max", max)
-				return "Not enough available resources", errors.New("not enough available resources")
+			if capacity.Override {
+				log.Logger.Warn("Reservation overrides the floor of the pool", "reservation", r.Name, "capacity", capacity)
 			}
 
-			if resource.Count > max {
-				log.Logger.Info(
-					"Not enough available resources",
-					"available", available,
-					"total", total,
-					"20% of total", int(float64(total)*0.2),
-					"max", max)
-				return fmt.Sprintf("You can only reserve up to %d accounts", max), errors.New("not enough available resources")
+		case "OcpSandbox":
+			if _, ok := done["OcpSandbox"]; ok {
+				return "Kind OcpSandbox is defined more than once", errors.New("invalid kind")
+			}
+
+			done["OcpSandbox"] = true
+
+			if message, err := validateOcpReservation(o.DbPool, r.Name, resource); err != nil {
+				return message, err
 			}
 
 		default:
@@ -114,6 +204,7 @@ func (o Reservations) Render(w http.ResponseWriter, r *http.Request) error {
 // This function is low level and doesn't deal with async operations.
 // For that, see the Initialize and Synchronize methods
 func (r *Reservation) Save(dbpool *pgxpool.Pool) error {
+	r.StartsAt, r.EndsAt = r.Request.StartsAt, r.Request.EndsAt
 
 	var id int
 
@@ -135,8 +226,8 @@ func (r *Reservation) Save(dbpool *pgxpool.Pool) error {
 
 		ct, err := dbpool.Exec(
 			context.Background(),
-			"UPDATE reservations SET status = $1, request = $2 WHERE id = $3",
-			r.Status, r.Request, r.ID,
+			"UPDATE reservations SET status = $1, request = $2, starts_at = $3, ends_at = $4 WHERE id = $5",
+			r.Status, r.Request, r.StartsAt, r.EndsAt, r.ID,
 		)
 		if err != nil {
 			return err
@@ -150,8 +241,8 @@ func (r *Reservation) Save(dbpool *pgxpool.Pool) error {
 	// New reservation we create it
 	err = dbpool.QueryRow(
 		context.Background(),
-		"INSERT INTO reservations (reservation_name, status, request) VALUES ($1, $2, $3) RETURNING id",
-		r.Name, r.Status, r.Request,
+		"INSERT INTO reservations (reservation_name, status, request, starts_at, ends_at) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		r.Name, r.Status, r.Request, r.StartsAt, r.EndsAt,
 	).Scan(&id)
 
 	if err != nil {
@@ -180,27 +271,49 @@ func (r *Reservation) Delete(dbpool *pgxpool.Pool) error {
 // GetReservationByName fetches a reservation by its name
 // returns the reservation and an error
 func GetReservationByName(dbpool *pgxpool.Pool, name string) (Reservation, error) {
-	var reservation Reservation
-
-	err := dbpool.QueryRow(
+	reservation, err := scanReservation(dbpool.QueryRow(
 		context.Background(),
-		`SELECT id,
-                reservation_name,
-				status,
-                request,
-				created_at,
- 				updated_at FROM reservations WHERE reservation_name = $1`,
+		"SELECT "+reservationColumns+" FROM reservations WHERE reservation_name = $1",
 		name,
-	).Scan(
-		&reservation.ID,
-		&reservation.Name,
-		&reservation.Status,
-		&reservation.Request,
-		&reservation.CreatedAt,
-		&reservation.UpdatedAt,
+	))
+	if err != nil {
+		return Reservation{}, err
+	}
+
+	return *reservation, nil
+}
+
+// ReservationStatuses are the values of the enum reservation_status
+var ReservationStatuses = []string{
+	"new", "initializing", "success", "updating", "deleting", "error",
+	"scheduled", "releasing", "expired", "pending",
+}
+
+// FetchReservations returns all the reservations, or the reservations with
+// the status if it's not empty, ordered by name.
+func FetchReservations(dbpool *pgxpool.Pool, status string) ([]Reservation, error) {
+	rows, err := dbpool.Query(
+		context.Background(),
+		"SELECT "+reservationColumns+` FROM reservations
+		 WHERE $1 = '' OR status::text = $1
+		 ORDER BY reservation_name`,
+		status,
 	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	return reservation, err
+	reservations := []Reservation{}
+	for rows.Next() {
+		reservation, err := scanReservation(rows)
+		if err != nil {
+			return nil, err
+		}
+		reservations = append(reservations, *reservation)
+	}
+
+	return reservations, rows.Err()
 }
 
 // UpdateStatus updates the status of a reservation
@@ -221,6 +334,37 @@ func (r *Reservation) UpdateStatus(dbpool *pgxpool.Pool, status string) error {
 	return err
 }
 
+// CompareAndUpdateStatus sets the status of the reservation to status only if
+// it's still from. Returns false if the status changed meanwhile.
+func (r *Reservation) CompareAndUpdateStatus(dbpool *pgxpool.Pool, from string, status string) (bool, error) {
+	tag, err := dbpool.Exec(
+		context.Background(),
+		"UPDATE reservations SET status = $1 WHERE id = $2 AND status = $3",
+		status, r.ID, from,
+	)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+	r.Status = status
+	return true, nil
+}
+
+// lock takes the lock of the reservation, see LockReservation, so its resources
+// are changed by one of Initialize, Update, Remove, Release and Reconcile at a time.
+// If the lock can't be taken, the reservation is marked as 'error'.
+func (r *Reservation) lock(dbpool *pgxpool.Pool) (func(), bool) {
+	release, err := LockReservation(context.Background(), dbpool, r.Name)
+	if err != nil {
+		log.Logger.Error("Error locking reservation", "error", err, "reservation", r.Name)
+		r.UpdateStatus(dbpool, "error")
+		return nil, false
+	}
+	return release, true
+}
+
 // Initialize initializes a reservation
 // It's an async operation that takes care of keeping the resources reserved Status up to date
 // and also update the resources to match what's defined in Request.
@@ -230,21 +374,87 @@ func (r *Reservation) Initialize(dbpool *pgxpool.Pool, a AwsAccountProvider) {
 	// At this point, the Request has been validated already using the
 	// ReservationRequest.Validate method.
 	// Also the reservation has been saved to the database and its status is 'initializing'
+	release, ok := r.lock(dbpool)
+	if !ok {
+		return
+	}
+	defer release()
 
 	// Loop through the Request.Resources and try to update the resources
 	// and put some of them inside the new reservation
+	pending := false
 	for _, resource := range r.Request.Resources {
 		switch resource.Kind {
 		case "AwsSandbox", "AwsAccount", "aws_account":
-			_, err := a.Reserve(r.Name, resource.Count)
+			reserved, err := r.reserveAwsAccounts(dbpool, a, resource.Count)
 			if err != nil {
+				log.Logger.Error("Error reserving AWS accounts", "error", err, "reservation", r.Name)
+				r.UpdateStatus(dbpool, "error")
+				return
+			}
+			pending = reserved < resource.Count
+		case "OcpSandbox":
+			if err := reserveOcpClusters(dbpool, r.Name, resource); err != nil {
+				log.Logger.Error("Error reserving OCP clusters", "error", err, "reservation", r.Name)
 				r.UpdateStatus(dbpool, "error")
 				return
 			}
+		}
+	}
+
+	if pending {
+		r.UpdateStatus(dbpool, "pending")
+		return
+	}
+	r.UpdateStatus(dbpool, "success")
+}
+
+// reserveAwsAccounts reserves AWS accounts for the reservation, up to count,
+// as many as the pool policy allows right now. It records the progress of the
+// reservation and returns the number of accounts reserved.
+func (r *Reservation) reserveAwsAccounts(dbpool *pgxpool.Pool, a AwsAccountProvider, count int) (int, error) {
+	held, err := a.FetchAllByReservation(r.Name)
+	if err != nil {
+		return 0, err
+	}
+	reserved := len(held)
 
-			r.UpdateStatus(dbpool, "success")
+	if reserved < count {
+		capacity, err := r.Request.PoolCapacity(dbpool, a)
+		if err != nil {
+			return 0, err
+		}
+
+		if target := min(count, reserved+capacity.Max); target > reserved {
+			accounts, err := a.Reserve(r.Name, target)
+			switch {
+			case err == nil:
+				reserved = len(accounts)
+			case errors.Is(err, ErrNoEnoughAccountsAvailable):
+				// Taken by another placement or reservation meanwhile, retried later
+				log.Logger.Info("Not enough accounts to reserve", "reservation", r.Name, "target", target)
+			default:
+				return 0, err
+			}
 		}
 	}
+
+	if err := r.UpdateProgress(dbpool, min(reserved, count), count); err != nil {
+		return 0, err
+	}
+	return reserved, nil
+}
+
+// UpdateProgress records the AWS accounts reserved, out of the target
+func (r *Reservation) UpdateProgress(dbpool *pgxpool.Pool, reserved int, target int) error {
+	r.Reserved, r.Target = reserved, target
+
+	_, err := dbpool.Exec(
+		context.Background(),
+		"UPDATE reservations SET reserved = $1, target = $2 WHERE id = $3",
+		r.Reserved, r.Target, r.ID,
+	)
+	return err
 }
 
 // Delete deletes a reservation
@@ -253,6 +463,12 @@ func (r *Reservation) Initialize(dbpool *pgxpool.Pool, a AwsAccountProvider) {
 // Then the reservation is deleted from the DB is all goes well.
 // If something goes wrong, the reservation is marked as 'error'
 func (r *Reservation) Remove(dbpool *pgxpool.Pool, a AwsAccountProvider) {
+	release, ok := r.lock(dbpool)
+	if !ok {
+		return
+	}
+	defer release()
+
 	// Loop through the Request.Resources and try to update the resources
 	// by removing the reservation.
 	for _, resource := range r.Request.Resources {
@@ -262,43 +478,226 @@ func (r *Reservation) Remove(dbpool *pgxpool.Pool, a AwsAccountProvider) {
 				r.UpdateStatus(dbpool, "error")
 				return
 			}
-
-			if err := r.Delete(dbpool); err != nil {
-				r.UpdateStatus(dbpool, "error")
-				return
-			}
 		}
 	}
+
+	// The reserved OCP clusters are released with the reservation
+	if err := r.Delete(dbpool); err != nil {
+		r.UpdateStatus(dbpool, "error")
+		return
+	}
 }
 
 // Update is an async operation to update a reservation from a reservationRequest
 func (r *Reservation) Update(dbpool *pgxpool.Pool, a AwsAccountProvider, req ReservationRequest) {
+	release, ok := r.lock(dbpool)
+	if !ok {
+		return
+	}
+	defer release()
+
 	r.UpdateStatus(dbpool, "updating")
+	r.Request.StartsAt, r.Request.EndsAt = req.StartsAt, req.EndsAt
+	if err := r.Save(dbpool); err != nil {
+		log.Logger.Error("Error saving reservation window", "error", err, "reservation", r.Name)
+		r.UpdateStatus(dbpool, "error")
+		return
+	}
+
+	// The OCP clusters are replaced by the clusters of the new request
+	if err := r.updateOcp(dbpool, req); err != nil {
+		log.Logger.Error("Error updating OCP clusters", "error", err, "reservation", r.Name)
+		r.UpdateStatus(dbpool, "error")
+		return
+	}
+
 	// Loop through the Request.Resources and try to update the resources
 	// by removing the reservation.
+	// A failed step marks the reservation as 'error', the reconciler retries it.
+	pending, failed := false, false
 	for i, resource := range r.Request.Resources {
+		if resource.Kind == "OcpSandbox" {
+			continue
+		}
 		for _, reqResource := range req.Resources {
 			if reqResource.Kind == resource.Kind {
 				// Determine if it's a scale up or a scale down
 				if resource.Count <= reqResource.Count {
-					// scale up
-					if _, err := a.Reserve(r.Name, reqResource.Count); err != nil {
-						r.UpdateStatus(dbpool, "error")
+					// scale up, the reservation is pending until the accounts are available
+					reserved, err := r.reserveAwsAccounts(dbpool, a, reqResource.Count)
+					if err != nil {
+						log.Logger.Error("Error scaling up reservation", "error", err, "reservation", r.Name)
+						failed = true
 					} else {
+						pending = reserved < reqResource.Count
 						r.Request.Resources[i].Count = reqResource.Count
 						r.Save(dbpool)
 					}
 				} else {
 					// scale down
 					if err := a.ScaleDownReservation(r.Name, reqResource.Count); err != nil {
-						r.UpdateStatus(dbpool, "error")
+						log.Logger.Error("Error scaling down reservation", "error", err, "reservation", r.Name)
+						failed = true
 					} else {
 						r.Request.Resources[i].Count = reqResource.Count
 						r.Save(dbpool)
+						r.UpdateProgress(dbpool, reqResource.Count, reqResource.Count)
 					}
 				}
 			}
 		}
 	}
-	r.UpdateStatus(dbpool, "success")
+
+	switch {
+	case failed:
+		r.UpdateStatus(dbpool, "error")
+	case pending:
+		r.UpdateStatus(dbpool, "pending")
+	default:
+		r.UpdateStatus(dbpool, "success")
+	}
+}
+
+// updateOcp reserves the OCP clusters of the request, or releases the clusters
+// of the reservation if the request has no OcpSandbox, and saves the
+// OcpSandbox resource of the request.
+func (r *Reservation) updateOcp(dbpool *pgxpool.Pool, req ReservationRequest) error {
+	var ocpRequest *ResourceRequest
+	for i := range req.Resources {
+		if req.Resources[i].Kind == "OcpSandbox" {
+			ocpRequest = &req.Resources[i]
+		}
+	}
+
+	resources := []ResourceRequest{}
+	for _, resource := range r.Request.Resources {
+		if resource.Kind != "OcpSandbox" {
+			resources = append(resources, resource)
+		}
+	}
+
+	if ocpRequest == nil {
+		if len(resources) == len(r.Request.Resources) {
+			// No OcpSandbox before and after
+			return nil
+		}
+		if err := releaseOcpClusters(dbpool, r.Name); err != nil {
+			return err
+		}
+	} else {
+		if err := reserveOcpClusters(dbpool, r.Name, *ocpRequest); err != nil {
+			return err
+		}
+		resources = append(resources, *ocpRequest)
+	}
+
+	r.Request.Resources = resources
+	return r.Save(dbpool)
+}
+
+const reservationColumns = `id, reservation_name, status, request, starts_at, ends_at, reserved, target, created_at, updated_at`
+
+func scanReservation(row pgx.Row) (*Reservation, error) {
+	var r Reservation
+	if err := row.Scan(&r.ID, &r.Name, &r.Status, &r.Request, &r.StartsAt, &r.EndsAt, &r.Reserved, &r.Target, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ClaimReservationToActivate marks 'initializing' a scheduled reservation
+// starting within the lead time, and returns it.
+// The replicas claim different reservations with SKIP LOCKED.
+// Returns pgx.ErrNoRows if there is none.
+func ClaimReservationToActivate(dbpool *pgxpool.Pool, lead time.Duration) (*Reservation, error) {
+	return scanReservation(dbpool.QueryRow(
+		context.Background(),
+		`UPDATE reservations SET status = 'initializing'
+		 WHERE id = (
+		   SELECT id FROM reservations
+		   WHERE status = 'scheduled' AND starts_at <= now() + make_interval(secs => $1)
+		   ORDER BY starts_at LIMIT 1
+		   FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING `+reservationColumns,
+		lead.Seconds(),
+	))
+}
+
+// ClaimPendingReservation marks 'initializing' a pending reservation that
+// didn't change for retry, and returns it. Initialize reserves the accounts
+// back from cleanup, and marks it 'pending' again or 'success'.
+// The reservations left 'initializing' or 'updating' for stale, by a replica
+// that died meanwhile, are claimed too, to be initialized again.
+// Returns pgx.ErrNoRows if there is none.
+func ClaimPendingReservation(dbpool *pgxpool.Pool, retry time.Duration, stale time.Duration) (*Reservation, error) {
+	return scanReservation(dbpool.QueryRow(
+		context.Background(),
+		`UPDATE reservations SET status = 'initializing'
+		 WHERE id = (
+		   SELECT id FROM reservations
+		   WHERE (status = 'pending' AND updated_at <= now() - make_interval(secs => $1))
+		   OR (status IN ('initializing', 'updating') AND updated_at <= now() - make_interval(secs => $2))
+		   ORDER BY updated_at LIMIT 1
+		   FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING `+reservationColumns,
+		retry.Seconds(), stale.Seconds(),
+	))
+}
+
+// ClaimReservationToRelease marks 'releasing' a reservation past its end, and
+// returns it. The reservations being initialized, updated, released or deleted
+// are claimed once they are done, or once they didn't change for stale: the
+// replica doing it died meanwhile. The reservations in 'error', ex: a release
+// that failed, are claimed again once they didn't change for retry.
+// Returns pgx.ErrNoRows if there is none.
+func ClaimReservationToRelease(dbpool *pgxpool.Pool, retry time.Duration, stale time.Duration) (*Reservation, error) {
+	return scanReservation(dbpool.QueryRow(
+		context.Background(),
+		`UPDATE reservations SET status = 'releasing'
+		 WHERE id = (
+		   SELECT id FROM reservations
+		   WHERE ends_at <= now()
+		   AND (status IN ('scheduled', 'pending', 'success')
+		        OR (status = 'error' AND updated_at <= now() - make_interval(secs => $1))
+		        OR (status IN ('initializing', 'updating', 'releasing')
+		            AND updated_at <= now() - make_interval(secs => $2)))
+		   ORDER BY ends_at LIMIT 1
+		   FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING `+reservationColumns,
+		retry.Seconds(), stale.Seconds(),
+	))
+}
+
+// Release releases the resources of a reservation past its end, and marks it
+// 'expired'. The reservation is kept, it can be rescheduled with a new window.
+// If something goes wrong, the reservation is marked as 'error' and released again later.
+func (r *Reservation) Release(dbpool *pgxpool.Pool, a AwsAccountProvider) {
+	release, ok := r.lock(dbpool)
+	if !ok {
+		return
+	}
+	defer release()
+
+	for _, resource := range r.Request.Resources {
+		switch resource.Kind {
+		case "AwsSandbox", "AwsAccount", "aws_account":
+			if err := a.ScaleDownReservation(r.Name, 0); err != nil {
+				log.Logger.Error("Error releasing reservation", "error", err, "reservation", r.Name)
+				r.UpdateStatus(dbpool, "error")
+				return
+			}
+		case "OcpSandbox":
+			if err := releaseOcpClusters(dbpool, r.Name); err != nil {
+				log.Logger.Error("Error releasing OCP clusters", "error", err, "reservation", r.Name)
+				r.UpdateStatus(dbpool, "error")
+				return
+			}
+		}
+	}
+
+	r.UpdateProgress(dbpool, 0, 0)
+	r.UpdateStatus(dbpool, "expired")
 }
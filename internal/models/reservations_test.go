@@ -0,0 +1,112 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReservationWindow(t *testing.T) {
+	now := time.Date(2033, 4, 15, 8, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour)
+	soon := now.Add(30 * time.Minute)
+	later := now.Add(24 * time.Hour)
+
+	if _, err := (&ReservationRequest{}).validateWindow(now); err != nil {
+		t.Errorf("No window should be valid, got %v", err)
+	}
+	if _, err := (&ReservationRequest{StartsAt: &past, EndsAt: &later}).validateWindow(now); err != nil {
+		t.Errorf("Started window should be valid, got %v", err)
+	}
+	if _, err := (&ReservationRequest{EndsAt: &past}).validateWindow(now); err == nil {
+		t.Error("Ended window should be invalid")
+	}
+	if _, err := (&ReservationRequest{StartsAt: &later, EndsAt: &soon}).validateWindow(now); err == nil {
+		t.Error("Window ending before its start should be invalid")
+	}
+
+	if (&ReservationRequest{}).Scheduled(now, time.Hour) {
+		t.Error("Reservation without start should be activated immediately")
+	}
+	if (&ReservationRequest{StartsAt: &soon}).Scheduled(now, time.Hour) {
+		t.Error("Reservation starting within the lead time should be activated")
+	}
+	if !(&ReservationRequest{StartsAt: &later}).Scheduled(now, time.Hour) {
+		t.Error("Reservation starting later should be scheduled")
+	}
+}
+
+func TestReservedAccountDrift(t *testing.T) {
+	healthy := AwsAccount{Name: "sandbox1", AccountID: "123456789012", Zone: "sandbox1.example.com", HostedZoneID: "Z123"}
+	if reason := reservedAccountDrift(healthy); reason != "" {
+		t.Errorf("Healthy account should stay in the reservation, got %q", reason)
+	}
+
+	inUse := healthy
+	inUse.Available = false
+	inUse.ServiceUuid = "6548dc97-0000"
+	if reason := reservedAccountDrift(inUse); reason != "" {
+		t.Errorf("Account in use should stay in the reservation, got %q", reason)
+	}
+
+	toCleanup := healthy
+	toCleanup.ToCleanup = true
+	if reason := reservedAccountDrift(toCleanup); reason != "marked for cleanup" {
+		t.Errorf("Account marked for cleanup should be replaced, got %q", reason)
+	}
+
+	broken := healthy
+	broken.HostedZoneID = ""
+	if reason := reservedAccountDrift(broken); reason != "hosted zone ID missing" {
+		t.Errorf("Broken account should be replaced, got %q", reason)
+	}
+}
+
+func TestCountAwsReservations(t *testing.T) {
+	account := func(name string, reservation string, available bool, toCleanup bool) AwsAccount {
+		a := AwsAccount{Name: name, Reservation: reservation}
+		a.Available = available
+		a.ToCleanup = toCleanup
+		return a
+	}
+	accounts := []AwsAccount{
+		account("sandbox1", "summit", true, false),
+		account("sandbox2", "summit", false, false),
+		account("sandbox3", "summit", false, false),
+		account("sandbox4", "summit", false, true),
+		account("sandbox5", "gpte", true, false),
+		account("sandbox6", "", false, false),
+	}
+
+	counts := CountAwsReservations(accounts)
+	if len(counts) != 2 {
+		t.Fatalf("Accounts without reservation should be ignored, got %v", counts)
+	}
+
+	expected := AwsReservationCounts{Reserved: 4, InUse: 2, Available: 1, ToCleanup: 1}
+	if counts["summit"] != expected {
+		t.Errorf("Expected %+v for summit, got %+v", expected, counts["summit"])
+	}
+
+	expected = AwsReservationCounts{Reserved: 1, Available: 1}
+	if counts["gpte"] != expected {
+		t.Errorf("Expected %+v for gpte, got %+v", expected, counts["gpte"])
+	}
+}
+
+func TestOrphanReservationAccounts(t *testing.T) {
+	accounts := []AwsAccount{
+		{Name: "sandbox1", Reservation: "summit"},
+		{Name: "sandbox2", Reservation: "deleted"},
+		{Name: "sandbox3"},
+	}
+	reservations := []Reservation{{Name: "summit"}, {Name: "gpte"}}
+
+	orphans := OrphanReservationAccounts(accounts, reservations)
+	if len(orphans) != 1 || orphans[0].Name != "sandbox2" {
+		t.Errorf("Expected sandbox2 to be the only orphan, got %v", orphans)
+	}
+
+	if orphans := OrphanReservationAccounts(accounts, nil); len(orphans) != 2 {
+		t.Errorf("Without reservations, all the reserved accounts should be orphans, got %v", orphans)
+	}
+}
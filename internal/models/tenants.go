@@ -0,0 +1,255 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Tenant holds the quotas of a tenant, on the resources held at once by the
+// placements created with tokens having the claim 'tenant'. A nil field means
+// unlimited.
+type Tenant struct {
+	Name string `json:"name"`
+
+	MaxAwsAccounts  *int `json:"max_aws_accounts"`
+	MaxOcpSandboxes *int `json:"max_ocp_sandboxes"`
+
+	// Total of the requests.cpu and requests.memory quotas of the OcpSandboxes
+	MaxCpu    *resource.Quantity `json:"max_cpu"`
+	MaxMemory *resource.Quantity `json:"max_memory"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Tenants []Tenant
+
+// TenantUsage is the resources held by the placements of a tenant
+type TenantUsage struct {
+	Name         string            `json:"name"`
+	Placements   int               `json:"placements"`
+	AwsAccounts  int               `json:"aws_accounts"`
+	OcpSandboxes int               `json:"ocp_sandboxes"`
+	Cpu          resource.Quantity `json:"cpu"`
+	Memory       resource.Quantity `json:"memory"`
+
+	// Quotas of the tenant, if any
+	Quotas *Tenant `json:"quotas,omitempty"`
+}
+
+// ErrTenantQuotaExceeded is returned, wrapped, when a placement would exceed the quotas of the tenant
+var ErrTenantQuotaExceeded = errors.New("tenant quota exceeded")
+
+func (t *Tenant) Bind(r *http.Request) error {
+	for name, value := range map[string]*int{
+		"max_aws_accounts":  t.MaxAwsAccounts,
+		"max_ocp_sandboxes": t.MaxOcpSandboxes,
+	} {
+		if value != nil && *value < 0 {
+			return fmt.Errorf("%s must be positive", name)
+		}
+	}
+
+	for name, value := range map[string]*resource.Quantity{
+		"max_cpu":    t.MaxCpu,
+		"max_memory": t.MaxMemory,
+	} {
+		if value != nil && value.Sign() < 0 {
+			return fmt.Errorf("%s must be positive", name)
+		}
+	}
+
+	return nil
+}
+
+func (t *Tenant) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func (t Tenants) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func (u *TenantUsage) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// QuotaCpuMemory returns the requests.cpu and requests.memory of the quota of
+// an OcpSandbox, or their aliases cpu and memory. They are nil if not set.
+func QuotaCpuMemory(quota v1.ResourceList) (cpu *resource.Quantity, memory *resource.Quantity) {
+	for _, key := range []v1.ResourceName{v1.ResourceRequestsCPU, v1.ResourceCPU} {
+		if q, ok := quota[key]; ok {
+			cpu = &q
+			break
+		}
+	}
+	for _, key := range []v1.ResourceName{v1.ResourceRequestsMemory, v1.ResourceMemory} {
+		if q, ok := quota[key]; ok {
+			memory = &q
+			break
+		}
+	}
+	return cpu, memory
+}
+
+// Add adds the resources of other to the usage
+func (u *TenantUsage) Add(other TenantUsage) {
+	u.Placements += other.Placements
+	u.AwsAccounts += other.AwsAccounts
+	u.OcpSandboxes += other.OcpSandboxes
+	u.Cpu.Add(other.Cpu)
+	u.Memory.Add(other.Memory)
+}
+
+// Allows returns an error wrapping ErrTenantQuotaExceeded if the resources
+// requested, added to the usage, exceed the quotas of the tenant.
+func (t *Tenant) Allows(usage TenantUsage, requested TenantUsage) error {
+	total := TenantUsage{
+		AwsAccounts:  usage.AwsAccounts,
+		OcpSandboxes: usage.OcpSandboxes,
+		Cpu:          usage.Cpu.DeepCopy(),
+		Memory:       usage.Memory.DeepCopy(),
+	}
+	total.Add(requested)
+
+	if t.MaxAwsAccounts != nil && requested.AwsAccounts > 0 && total.AwsAccounts > *t.MaxAwsAccounts {
+		return fmt.Errorf("%w: %d AWS accounts held, %d requested, max_aws_accounts is %d",
+			ErrTenantQuotaExceeded, usage.AwsAccounts, requested.AwsAccounts, *t.MaxAwsAccounts)
+	}
+	if t.MaxOcpSandboxes != nil && requested.OcpSandboxes > 0 && total.OcpSandboxes > *t.MaxOcpSandboxes {
+		return fmt.Errorf("%w: %d OcpSandboxes held, %d requested, max_ocp_sandboxes is %d",
+			ErrTenantQuotaExceeded, usage.OcpSandboxes, requested.OcpSandboxes, *t.MaxOcpSandboxes)
+	}
+	if t.MaxCpu != nil && !requested.Cpu.IsZero() && total.Cpu.Cmp(*t.MaxCpu) > 0 {
+		return fmt.Errorf("%w: %s cpu held, %s requested, max_cpu is %s",
+			ErrTenantQuotaExceeded, usage.Cpu.String(), requested.Cpu.String(), t.MaxCpu.String())
+	}
+	if t.MaxMemory != nil && !requested.Memory.IsZero() && total.Memory.Cmp(*t.MaxMemory) > 0 {
+		return fmt.Errorf("%w: %s memory held, %s requested, max_memory is %s",
+			ErrTenantQuotaExceeded, usage.Memory.String(), requested.Memory.String(), t.MaxMemory.String())
+	}
+	return nil
+}
+
+const tenantColumns = `name, max_aws_accounts, max_ocp_sandboxes, max_cpu, max_memory, created_at, updated_at`
+
+func scanTenant(row interface{ Scan(...any) error }) (Tenant, error) {
+	var t Tenant
+	var maxCpu, maxMemory *string
+	if err := row.Scan(&t.Name, &t.MaxAwsAccounts, &t.MaxOcpSandboxes, &maxCpu, &maxMemory, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		return t, err
+	}
+
+	var err error
+	if t.MaxCpu, err = parseQuantity(maxCpu); err != nil {
+		return t, err
+	}
+	t.MaxMemory, err = parseQuantity(maxMemory)
+	return t, err
+}
+
+func parseQuantity(s *string) (*resource.Quantity, error) {
+	if s == nil {
+		return nil, nil
+	}
+	q, err := resource.ParseQuantity(*s)
+	if err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+func quantityString(q *resource.Quantity) *string {
+	if q == nil {
+		return nil
+	}
+	s := q.String()
+	return &s
+}
+
+// GetTenant returns the quotas of the tenant.
+// Returns pgx.ErrNoRows if the tenant has no quotas.
+func GetTenant(dbpool *pgxpool.Pool, name string) (*Tenant, error) {
+	t, err := scanTenant(dbpool.QueryRow(
+		context.Background(),
+		"SELECT "+tenantColumns+" FROM tenants WHERE name = $1",
+		name,
+	))
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// FetchTenants returns all the tenants with quotas
+func FetchTenants(dbpool *pgxpool.Pool) (Tenants, error) {
+	rows, err := dbpool.Query(
+		context.Background(),
+		"SELECT "+tenantColumns+" FROM tenants ORDER BY name",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := Tenants{}
+	for rows.Next() {
+		t, err := scanTenant(rows)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, t)
+	}
+
+	return tenants, rows.Err()
+}
+
+// Save creates or replaces the quotas of the tenant
+func (t *Tenant) Save(dbpool *pgxpool.Pool) error {
+	return dbpool.QueryRow(
+		context.Background(),
+		`INSERT INTO tenants (name, max_aws_accounts, max_ocp_sandboxes, max_cpu, max_memory)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (name) DO UPDATE SET
+		   max_aws_accounts = EXCLUDED.max_aws_accounts,
+		   max_ocp_sandboxes = EXCLUDED.max_ocp_sandboxes,
+		   max_cpu = EXCLUDED.max_cpu,
+		   max_memory = EXCLUDED.max_memory
+		 RETURNING created_at, updated_at`,
+		t.Name, t.MaxAwsAccounts, t.MaxOcpSandboxes, quantityString(t.MaxCpu), quantityString(t.MaxMemory),
+	).Scan(&t.CreatedAt, &t.UpdatedAt)
+}
+
+// DeleteTenant deletes the quotas of the tenant, its placements are kept.
+// Returns pgx.ErrNoRows if the tenant has no quotas.
+func DeleteTenant(dbpool *pgxpool.Pool, name string) error {
+	tag, err := dbpool.Exec(context.Background(), "DELETE FROM tenants WHERE name = $1", name)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// LockTenant takes a lock on the tenant, shared by all the replicas, so its
+// quotas can't be exceeded by concurrent placements.
+// The returned function releases the lock.
+func LockTenant(ctx context.Context, dbpool *pgxpool.Pool, name string) (func(), error) {
+	return advisoryLock(ctx, dbpool, "tenants:"+name)
+}
+
+// GetTenantUsage returns the resources held by the placements of the tenant,
+// see placementsUsage. The cpu and memory are the quotas of the OcpSandboxes.
+func GetTenantUsage(ctx context.Context, dbpool *pgxpool.Pool, accountProvider AwsAccountProvider, name string) (TenantUsage, error) {
+	return placementsUsage(ctx, dbpool, accountProvider, "tenant", name)
+}
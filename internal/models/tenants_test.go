@@ -0,0 +1,60 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestQuotaCpuMemory(t *testing.T) {
+	cpu, memory := QuotaCpuMemory(v1.ResourceList{
+		v1.ResourceCPU:            resource.MustParse("4"),
+		v1.ResourceRequestsCPU:    resource.MustParse("2"),
+		v1.ResourceRequestsMemory: resource.MustParse("8Gi"),
+	})
+	if cpu == nil || cpu.Cmp(resource.MustParse("2")) != 0 {
+		t.Errorf("requests.cpu should be preferred over cpu, got %v", cpu)
+	}
+	if memory == nil || memory.Cmp(resource.MustParse("8Gi")) != 0 {
+		t.Errorf("memory should be 8Gi, got %v", memory)
+	}
+
+	cpu, memory = QuotaCpuMemory(v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")})
+	if cpu != nil {
+		t.Errorf("cpu should not be set, got %v", cpu)
+	}
+	if memory == nil || memory.Cmp(resource.MustParse("1Gi")) != 0 {
+		t.Errorf("memory alias should be used, got %v", memory)
+	}
+}
+
+func TestTenantAllows(t *testing.T) {
+	two := 2
+	maxCpu := resource.MustParse("10")
+	tenant := Tenant{MaxAwsAccounts: &two, MaxCpu: &maxCpu}
+
+	usage := TenantUsage{AwsAccounts: 1, OcpSandboxes: 3, Cpu: resource.MustParse("8")}
+
+	if err := tenant.Allows(usage, TenantUsage{AwsAccounts: 1}); err != nil {
+		t.Errorf("2 accounts should be allowed, got %v", err)
+	}
+	if err := tenant.Allows(usage, TenantUsage{AwsAccounts: 2}); !errors.Is(err, ErrTenantQuotaExceeded) {
+		t.Errorf("3 accounts should exceed the quota, got %v", err)
+	}
+	if err := tenant.Allows(usage, TenantUsage{OcpSandboxes: 10}); err != nil {
+		t.Errorf("OcpSandboxes are not limited, got %v", err)
+	}
+	if err := tenant.Allows(usage, TenantUsage{OcpSandboxes: 1, Cpu: resource.MustParse("2")}); err != nil {
+		t.Errorf("10 cpu should be allowed, got %v", err)
+	}
+	if err := tenant.Allows(usage, TenantUsage{OcpSandboxes: 1, Cpu: resource.MustParse("2500m")}); !errors.Is(err, ErrTenantQuotaExceeded) {
+		t.Errorf("10.5 cpu should exceed the quota, got %v", err)
+	}
+
+	// The usage is not modified
+	if usage.Cpu.Cmp(resource.MustParse("8")) != 0 || usage.AwsAccounts != 1 {
+		t.Errorf("usage should not be modified, got %+v", usage)
+	}
+}
@@ -1,9 +1,16 @@
 package models
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v4/pgxpool"
@@ -12,13 +19,148 @@ import (
 type Token struct {
 	Model
 
-	Kind       string    `json:"kind"`
-	Name       string    `json:"name"`
-	Role       string    `json:"role"`
-	Iat        int64     `json:"iat"`
-	Exp        int64     `json:"exp"`
-	Expiration time.Time `json:"expiration"`
-	Valid      bool      `json:"valid"`
+	Kind       string      `json:"kind"`
+	Name       string      `json:"name"`
+	Role       string      `json:"role"`
+	Iat        int64       `json:"iat"`
+	Exp        int64       `json:"exp"`
+	Expiration time.Time   `json:"expiration"`
+	Valid      bool        `json:"valid"`
+	Scope      *TokenScope `json:"scope,omitempty"`
+	// ID of the login token a refresh token was issued from
+	ParentID *int `json:"parent_id,omitempty"`
+}
+
+// TokenScope restricts what a token can do, it's set in the 'scope' claim.
+// Every field is optional, an empty field doesn't restrict anything.
+type TokenScope struct {
+	// Prefix of the service_uuid of the placements
+	ServiceUuidPrefix string `json:"service_uuid_prefix,omitempty"`
+	// Kinds of resources, for example AwsSandbox, OcpSandbox
+	Kinds []string `json:"kinds,omitempty"`
+	// Actions allowed, see TokenScopeActions
+	Actions []string `json:"actions,omitempty"`
+	// Annotations the placements and resources must have, for example tenant=foo
+	Annotations Annotations `json:"annotations,omitempty"`
+	// Maximum number of resources in a placement
+	MaxResources int `json:"max_resources,omitempty"`
+}
+
+// TokenScopeActions are the actions of a scope:
+// create placements, read, start/stop/status (lifecycle) and delete.
+var TokenScopeActions = []string{"create", "read", "lifecycle", "delete"}
+
+// ParseTokenScope returns the scope from the value of the 'scope' claim.
+// It returns nil if the claim is not set.
+func ParseTokenScope(claim any) (*TokenScope, error) {
+	if claim == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(claim)
+	if err != nil {
+		return nil, err
+	}
+
+	var scope TokenScope
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&scope); err != nil {
+		return nil, fmt.Errorf("invalid scope: %w", err)
+	}
+
+	for _, action := range scope.Actions {
+		if !slices.Contains(TokenScopeActions, action) {
+			return nil, fmt.Errorf("invalid scope: unknown action '%s', must be one of %s",
+				action, strings.Join(TokenScopeActions, ", "))
+		}
+	}
+
+	for _, kind := range scope.Kinds {
+		if canonicalKind(kind) == "" {
+			return nil, fmt.Errorf("invalid scope: unknown kind '%s'", kind)
+		}
+	}
+
+	if scope.MaxResources < 0 {
+		return nil, fmt.Errorf("invalid scope: max_resources must be positive")
+	}
+
+	return &scope, nil
+}
+
+// canonicalKind returns the kind without alias, or "" if the kind is unknown
+func canonicalKind(kind string) string {
+	switch kind {
+	case "AwsSandbox", "AwsAccount", "aws_account", "aws":
+		return "AwsSandbox"
+	case "OcpSandbox", "ocp":
+		return "OcpSandbox"
+	}
+	return ""
+}
+
+// AllowsAction returns true if the action is allowed by the scope.
+// A nil scope allows everything.
+func (s *TokenScope) AllowsAction(action string) bool {
+	if s == nil || len(s.Actions) == 0 {
+		return true
+	}
+	return slices.Contains(s.Actions, action)
+}
+
+// AllowsKind returns true if the kind of resource is allowed by the scope
+func (s *TokenScope) AllowsKind(kind string) bool {
+	if s == nil || len(s.Kinds) == 0 {
+		return true
+	}
+	for _, k := range s.Kinds {
+		if canonicalKind(k) == canonicalKind(kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsServiceUuid returns true if the service_uuid matches the prefix of the scope
+func (s *TokenScope) AllowsServiceUuid(serviceUuid string) bool {
+	if s == nil {
+		return true
+	}
+	return strings.HasPrefix(serviceUuid, s.ServiceUuidPrefix)
+}
+
+// AllowsAnnotations returns true if the annotations contain all the annotations of the scope
+func (s *TokenScope) AllowsAnnotations(annotations map[string]string) bool {
+	if s == nil {
+		return true
+	}
+	for k, v := range s.Annotations {
+		if annotations[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// AllowsCount returns true if count resources can be requested at once
+func (s *TokenScope) AllowsCount(count int) bool {
+	if s == nil || s.MaxResources == 0 {
+		return true
+	}
+	return count <= s.MaxResources
+}
+
+// Allows returns true if the placement or the resource identified by its
+// service_uuid and its annotations is in the scope
+func (s *TokenScope) Allows(serviceUuid string, annotations map[string]string) bool {
+	return s.AllowsServiceUuid(serviceUuid) && s.AllowsAnnotations(annotations)
+}
+
+// Restricted returns true if the scope restricts the placements or resources
+// that can be accessed, not only the actions.
+func (s *TokenScope) Restricted() bool {
+	return s != nil && (s.ServiceUuidPrefix != "" || len(s.Kinds) > 0 || len(s.Annotations) > 0)
 }
 
 func CreateToken(claims map[string]any) (Token, error) {
@@ -46,6 +188,11 @@ func CreateToken(claims map[string]any) (Token, error) {
 		return Token{}, fmt.Errorf("invalid role in claims")
 	}
 
+	scope, err := ParseTokenScope(claims["scope"])
+	if err != nil {
+		return Token{}, err
+	}
+
 	return Token{
 		Kind:       kind,
 		Name:       name,
@@ -54,14 +201,15 @@ func CreateToken(claims map[string]any) (Token, error) {
 		Exp:        exp,
 		Expiration: time.Unix(exp, 0),
 		Valid:      true,
+		Scope:      scope,
 	}, nil
 }
 
 func (t Token) Save(dbpool *pgxpool.Pool) (id int, err error) {
 	err = dbpool.QueryRow(context.Background(), `
-		INSERT INTO tokens (kind, name, role, iat, exp, expiration, valid)
-		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
-		t.Kind, t.Name, t.Role, t.Iat, t.Exp, t.Expiration, t.Valid).Scan(&id)
+		INSERT INTO tokens (kind, name, role, iat, exp, expiration, valid, scope, parent_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		t.Kind, t.Name, t.Role, t.Iat, t.Exp, t.Expiration, t.Valid, t.Scope, t.ParentID).Scan(&id)
 	if err != nil {
 		return 0, err
 	}
@@ -69,10 +217,10 @@ func (t Token) Save(dbpool *pgxpool.Pool) (id int, err error) {
 	return id, nil
 }
 
-// Invalidate the token
+// Invalidate the token and the refresh tokens issued from it
 func (t Token) Invalidate(dbpool *pgxpool.Pool) error {
 	_, err := dbpool.Exec(context.Background(), `
-		UPDATE tokens SET valid = false WHERE id = $1`,
+		UPDATE tokens SET valid = false WHERE id = $1 OR parent_id = $1`,
 		t.ID)
 	if err != nil {
 		return err
@@ -87,10 +235,12 @@ func (t *Tokens) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-func FetchAllTokens(dbpool *pgxpool.Pool) (Tokens, error) {
+// FetchAllLoginTokens returns the login tokens, without the refresh tokens issued from them
+func FetchAllLoginTokens(dbpool *pgxpool.Pool) (Tokens, error) {
 	rows, err := dbpool.Query(context.Background(), `
-		SELECT id, kind, name, role, iat, exp, expiration, created_at, updated_at, valid
+		SELECT id, kind, name, role, iat, exp, expiration, created_at, updated_at, valid, scope, parent_id
 		FROM tokens
+		WHERE kind = 'login'
 	`)
 	if err != nil {
 		return []Token{}, err
@@ -101,7 +251,7 @@ func FetchAllTokens(dbpool *pgxpool.Pool) (Tokens, error) {
 
 	for rows.Next() {
 		var t Token
-		err = rows.Scan(&t.ID, &t.Kind, &t.Name, &t.Role, &t.Iat, &t.Exp, &t.Expiration, &t.CreatedAt, &t.UpdatedAt, &t.Valid)
+		err = rows.Scan(&t.ID, &t.Kind, &t.Name, &t.Role, &t.Iat, &t.Exp, &t.Expiration, &t.CreatedAt, &t.UpdatedAt, &t.Valid, &t.Scope, &t.ParentID)
 		if err != nil {
 			return []Token{}, err
 		}
@@ -115,13 +265,217 @@ func FetchAllTokens(dbpool *pgxpool.Pool) (Tokens, error) {
 func FetchTokenById(dbpool *pgxpool.Pool, id int) (Token, error) {
 	var t Token
 	err := dbpool.QueryRow(context.Background(), `
-		SELECT id, kind, name, role, iat, exp, expiration, created_at, updated_at, valid
+		SELECT id, kind, name, role, iat, exp, expiration, created_at, updated_at, valid, scope, parent_id
 		FROM tokens
 		WHERE id = $1
-	`, id).Scan(&t.ID, &t.Kind, &t.Name, &t.Role, &t.Iat, &t.Exp, &t.Expiration, &t.CreatedAt, &t.UpdatedAt, &t.Valid)
+	`, id).Scan(&t.ID, &t.Kind, &t.Name, &t.Role, &t.Iat, &t.Exp, &t.Expiration, &t.CreatedAt, &t.UpdatedAt, &t.Valid, &t.Scope, &t.ParentID)
 	if err != nil {
 		return Token{}, err
 	}
 
 	return t, nil
 }
+
+// ErrRefreshTokenReused is returned when a refresh token is used after it was
+// rotated or invalidated.
+var ErrRefreshTokenReused = errors.New("refresh token already used or invalidated")
+
+// RotateRefreshToken invalidates the refresh token and saves the new one,
+// issued from the same login token, in a single transaction.
+// If the refresh token was already used, all the refresh tokens of the login
+// token are invalidated, as the token was probably stolen, and
+// ErrRefreshTokenReused is returned.
+// It returns pgx.ErrNoRows if the refresh token doesn't exist or if the
+// login token it was issued from is no longer valid.
+func RotateRefreshToken(dbpool *pgxpool.Pool, id int, next Token) (Token, error) {
+	ctx := context.Background()
+	tx, err := dbpool.Begin(ctx)
+	if err != nil {
+		return Token{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var parentID int
+	var valid bool
+	err = tx.QueryRow(ctx, `
+		SELECT r.parent_id, r.valid
+		FROM tokens r JOIN tokens l ON l.id = r.parent_id
+		WHERE r.id = $1 AND r.kind = 'refresh' AND l.valid = true
+		FOR UPDATE OF r`,
+		id).Scan(&parentID, &valid)
+	if err != nil {
+		return Token{}, err
+	}
+
+	if !valid {
+		if _, err := tx.Exec(ctx,
+			"UPDATE tokens SET valid = false WHERE parent_id = $1 AND kind = 'refresh'",
+			parentID); err != nil {
+			return Token{}, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return Token{}, err
+		}
+		return Token{}, ErrRefreshTokenReused
+	}
+
+	if _, err := tx.Exec(ctx, "UPDATE tokens SET valid = false WHERE id = $1", id); err != nil {
+		return Token{}, err
+	}
+
+	next.ParentID = &parentID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO tokens (kind, name, role, iat, exp, expiration, valid, scope, parent_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		next.Kind, next.Name, next.Role, next.Iat, next.Exp, next.Expiration, next.Valid, next.Scope, next.ParentID).Scan(&next.ID)
+	if err != nil {
+		return Token{}, err
+	}
+
+	return next, tx.Commit(ctx)
+}
+
+// RevokeAccessToken adds the access token to the revocation list until it expires
+func RevokeAccessToken(dbpool *pgxpool.Pool, jti string, expiration time.Time) error {
+	_, err := dbpool.Exec(context.Background(), `
+		INSERT INTO revoked_access_tokens (jti, expiration) VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING`,
+		jti, expiration)
+	return err
+}
+
+// PurgeTokens deletes the revoked access tokens that expired, and the refresh
+// tokens that expired or whose login token is invalid. The refresh tokens
+// rotated are kept until they expire, to detect their reuse.
+// Only one replica purges at a time: it returns false, without purging,
+// if another replica is purging.
+func PurgeTokens(dbpool *pgxpool.Pool) (bool, error) {
+	ctx := context.Background()
+	tx, err := dbpool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var locked bool
+	if err := tx.QueryRow(ctx, "SELECT pg_try_advisory_xact_lock(hashtext('tokens_purge'))").Scan(&locked); err != nil {
+		return false, err
+	}
+	if !locked {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM revoked_access_tokens WHERE expiration < now()"); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM tokens r
+		WHERE r.kind = 'refresh'
+		AND (r.expiration < now()
+		     OR EXISTS (SELECT 1 FROM tokens l WHERE l.id = r.parent_id AND l.valid = false))`,
+	); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit(ctx)
+}
+
+// Revocations is the in-memory list of the revoked tokens checked on each
+// request: the jti of the revoked access tokens and of the invalid login
+// tokens, which revokes all the access tokens issued from them.
+type Revocations struct {
+	mu       sync.RWMutex
+	logins   map[string]struct{}
+	accesses map[string]struct{}
+}
+
+// NewRevocations returns an empty revocation list
+func NewRevocations() *Revocations {
+	return &Revocations{
+		logins:   map[string]struct{}{},
+		accesses: map[string]struct{}{},
+	}
+}
+
+// Load replaces the list with the revocations from the database.
+func (rv *Revocations) Load(dbpool *pgxpool.Pool) error {
+	ctx := context.Background()
+	logins := map[string]struct{}{}
+	rows, err := dbpool.Query(ctx, "SELECT id FROM tokens WHERE kind = 'login' AND valid = false")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		logins[strconv.Itoa(id)] = struct{}{}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	accesses := map[string]struct{}{}
+	rows, err = dbpool.Query(ctx, "SELECT jti FROM revoked_access_tokens")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var jti string
+		if err := rows.Scan(&jti); err != nil {
+			return err
+		}
+		accesses[jti] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+	rv.logins = logins
+	rv.accesses = accesses
+
+	return nil
+}
+
+// Apply adds the revocation from the payload of a notification
+// on the tokens_revocations_channel: 'login:<id>' or 'access:<jti>'.
+func (rv *Revocations) Apply(payload string) error {
+	kind, id, ok := strings.Cut(payload, ":")
+	if !ok || id == "" {
+		return fmt.Errorf("invalid revocation '%s'", payload)
+	}
+
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+
+	switch kind {
+	case "login":
+		rv.logins[id] = struct{}{}
+	case "access":
+		rv.accesses[id] = struct{}{}
+	default:
+		return fmt.Errorf("invalid revocation '%s'", payload)
+	}
+	return nil
+}
+
+// Revoked returns true if the access token, identified by its jti, or the
+// login token it was issued from, identified by its jti, is revoked.
+func (rv *Revocations) Revoked(jti string, loginJti string) bool {
+	rv.mu.RLock()
+	defer rv.mu.RUnlock()
+
+	if _, ok := rv.accesses[jti]; ok && jti != "" {
+		return true
+	}
+	if _, ok := rv.logins[loginJti]; ok && loginJti != "" {
+		return true
+	}
+	return false
+}
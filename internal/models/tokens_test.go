@@ -0,0 +1,118 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestParseTokenScope(t *testing.T) {
+	scope, err := ParseTokenScope(nil)
+	if err != nil || scope != nil {
+		t.Errorf("No claim should be no scope, got %v, %v", scope, err)
+	}
+
+	// Claims decoded from a JWT
+	claim := map[string]any{
+		"service_uuid_prefix": "babylon-",
+		"kinds":               []any{"AwsSandbox"},
+		"actions":             []any{"read", "lifecycle"},
+		"annotations":         map[string]any{"tenant": "foo"},
+		"max_resources":       float64(2),
+	}
+	scope, err = ParseTokenScope(claim)
+	if err != nil {
+		t.Fatalf("Valid scope should parse, got %v", err)
+	}
+	if scope.ServiceUuidPrefix != "babylon-" || scope.MaxResources != 2 || scope.Annotations["tenant"] != "foo" {
+		t.Errorf("Scope not parsed correctly: %+v", scope)
+	}
+
+	invalid := []map[string]any{
+		{"actions": []any{"admin"}},
+		{"kinds": []any{"GcpSandbox"}},
+		{"max_resources": float64(-1)},
+		{"unknown": "field"},
+	}
+	for _, claim := range invalid {
+		if _, err := ParseTokenScope(claim); err == nil {
+			t.Errorf("Scope %v should be invalid", claim)
+		}
+	}
+}
+
+func TestTokenScopeAllows(t *testing.T) {
+	var unrestricted *TokenScope
+	if !unrestricted.AllowsAction("delete") || !unrestricted.AllowsKind("OcpSandbox") ||
+		!unrestricted.Allows("any", nil) || !unrestricted.AllowsCount(100) || unrestricted.Restricted() {
+		t.Error("A nil scope should allow everything")
+	}
+
+	scope := &TokenScope{
+		ServiceUuidPrefix: "babylon-",
+		Kinds:             []string{"AwsSandbox"},
+		Actions:           []string{"read"},
+		Annotations:       Annotations{"tenant": "foo"},
+		MaxResources:      2,
+	}
+
+	if !scope.AllowsAction("read") || scope.AllowsAction("delete") {
+		t.Error("Only the actions of the scope should be allowed")
+	}
+
+	// Aliases of the kinds
+	for _, kind := range []string{"AwsSandbox", "AwsAccount", "aws_account", "aws"} {
+		if !scope.AllowsKind(kind) {
+			t.Errorf("Kind %s should be allowed", kind)
+		}
+	}
+	if scope.AllowsKind("OcpSandbox") || scope.AllowsKind("ocp") {
+		t.Error("OcpSandbox should not be allowed")
+	}
+
+	if !scope.Allows("babylon-1234", map[string]string{"tenant": "foo", "guid": "abcd"}) {
+		t.Error("Placement in scope should be allowed")
+	}
+	if scope.Allows("other-1234", map[string]string{"tenant": "foo"}) {
+		t.Error("Placement with another prefix should not be allowed")
+	}
+	if scope.Allows("babylon-1234", map[string]string{"tenant": "bar"}) || scope.Allows("babylon-1234", nil) {
+		t.Error("Placement without the annotations of the scope should not be allowed")
+	}
+
+	if !scope.AllowsCount(2) || scope.AllowsCount(3) {
+		t.Error("Count should be limited to max_resources")
+	}
+
+	if !scope.Restricted() || (&TokenScope{Actions: []string{"read"}}).Restricted() {
+		t.Error("Only the scopes restricting the resources should be restricted")
+	}
+}
+
+func TestRevocations(t *testing.T) {
+	revocations := NewRevocations()
+	if revocations.Revoked("abcd", "1") || revocations.Revoked("", "") {
+		t.Error("Empty list should revoke nothing")
+	}
+
+	if err := revocations.Apply("login:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := revocations.Apply("access:abcd"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !revocations.Revoked("efgh", "1") {
+		t.Error("Access token of a revoked login token should be revoked")
+	}
+	if !revocations.Revoked("abcd", "2") {
+		t.Error("Revoked access token should be revoked")
+	}
+	if revocations.Revoked("efgh", "2") || revocations.Revoked("", "") {
+		t.Error("Other tokens should not be revoked")
+	}
+
+	for _, payload := range []string{"", "login", "login:", "refresh:3"} {
+		if err := revocations.Apply(payload); err == nil {
+			t.Errorf("Revocation '%s' should be invalid", payload)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package models
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/rhpds/sandbox/internal/config"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// WebhookEvents is the list of the events a subscription can receive
+var WebhookEvents = []string{
+	"placement.created",
+	"placement.deleted",
+	"resource.ready",
+	"resource.error",
+	"lifecycle.completed",
+	"reservation.updated",
+}
+
+// WebhookMaxAttempts is the number of attempts before a delivery is marked as failed
+const WebhookMaxAttempts = 10
+
+// WebhookSubscription is an URL called for each event the subscription is interested in.
+// The payload is signed with the secret.
+type WebhookSubscription struct {
+	Model
+
+	Name    string   `json:"name"`
+	URL     string   `json:"url"`
+	Secret  string   `json:"secret,omitempty"`
+	Events  []string `json:"events"`
+	Enabled bool     `json:"enabled"`
+
+	DbPool      *pgxpool.Pool `json:"-"`
+	VaultSecret string        `json:"-"`
+}
+
+type WebhookSubscriptions []WebhookSubscription
+
+// WebhookDelivery is the delivery of one event to one subscription.
+type WebhookDelivery struct {
+	Model
+
+	SubscriptionID int            `json:"subscription_id"`
+	EventID        string         `json:"event_id"`
+	EventType      string         `json:"event_type"`
+	Payload        map[string]any `json:"payload"`
+	Status         string         `json:"status"`
+	Attempts       int            `json:"attempts"`
+	NextAttemptAt  time.Time      `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time     `json:"last_attempt_at,omitempty"`
+	LastStatusCode int            `json:"last_status_code,omitempty"`
+	LastError      string         `json:"last_error,omitempty"`
+	Locality       string         `json:"locality,omitempty"`
+
+	DbPool *pgxpool.Pool `json:"-"`
+}
+
+type WebhookDeliveries []WebhookDelivery
+
+// MakeWebhookSubscription returns a subscription with the default values
+func MakeWebhookSubscription() *WebhookSubscription {
+	return &WebhookSubscription{
+		Enabled: true,
+		Events:  []string{},
+	}
+}
+
+// Bind and Render
+func (s *WebhookSubscription) Bind(r *http.Request) error {
+	if s.Name == "" {
+		return errors.New("name is required")
+	}
+
+	if !nameRegex.MatchString(s.Name) {
+		return errors.New("name is invalid, must be only alphanumeric and '-'")
+	}
+
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url is invalid, must be an http or https URL")
+	}
+
+	if len(s.Secret) < 16 {
+		return errors.New("secret is required and must be at least 16 characters")
+	}
+
+	for _, event := range s.Events {
+		if !slices.Contains(WebhookEvents, event) {
+			return errors.New("unknown event " + event)
+		}
+	}
+
+	return nil
+}
+
+func (s *WebhookSubscription) Render(w http.ResponseWriter, r *http.Request) error {
+	// Never send back the secret
+	s.Secret = ""
+	return nil
+}
+
+func (s WebhookSubscriptions) Render(w http.ResponseWriter, r *http.Request) error {
+	for i := range s {
+		s[i].Secret = ""
+	}
+	return nil
+}
+
+func (d WebhookDeliveries) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Save inserts the subscription, the secret is encrypted
+func (s *WebhookSubscription) Save() error {
+	return s.DbPool.QueryRow(
+		context.Background(),
+		`INSERT INTO webhook_subscriptions (name, url, secret, events, enabled)
+		 VALUES ($1, $2, pgp_sym_encrypt($3::text, $4), $5, $6)
+		 RETURNING id, created_at, updated_at`,
+		s.Name, s.URL, s.Secret, s.VaultSecret, s.Events, s.Enabled,
+	).Scan(&s.ID, &s.CreatedAt, &s.UpdatedAt)
+}
+
+// Delete deletes the subscription and its delivery log
+func (s *WebhookSubscription) Delete() error {
+	_, err := s.DbPool.Exec(
+		context.Background(),
+		"DELETE FROM webhook_subscriptions WHERE id = $1",
+		s.ID,
+	)
+	return err
+}
+
+const webhookSubscriptionColumns = `id, name, url, pgp_sym_decrypt(secret, $1), events, enabled, created_at, updated_at`
+
+func scanWebhookSubscription(row interface{ Scan(...any) error }) (WebhookSubscription, error) {
+	var s WebhookSubscription
+	err := row.Scan(&s.ID, &s.Name, &s.URL, &s.Secret, &s.Events, &s.Enabled, &s.CreatedAt, &s.UpdatedAt)
+	return s, err
+}
+
+// GetWebhookSubscriptionByName returns a subscription, including its secret
+func GetWebhookSubscriptionByName(dbpool *pgxpool.Pool, vaultSecret string, name string) (*WebhookSubscription, error) {
+	s, err := scanWebhookSubscription(dbpool.QueryRow(
+		context.Background(),
+		"SELECT "+webhookSubscriptionColumns+" FROM webhook_subscriptions WHERE name = $2",
+		vaultSecret, name,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	s.DbPool = dbpool
+	s.VaultSecret = vaultSecret
+	return &s, nil
+}
+
+// GetWebhookSubscription returns a subscription by ID, including its secret
+func GetWebhookSubscription(dbpool *pgxpool.Pool, vaultSecret string, id int) (*WebhookSubscription, error) {
+	s, err := scanWebhookSubscription(dbpool.QueryRow(
+		context.Background(),
+		"SELECT "+webhookSubscriptionColumns+" FROM webhook_subscriptions WHERE id = $2",
+		vaultSecret, id,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	s.DbPool = dbpool
+	s.VaultSecret = vaultSecret
+	return &s, nil
+}
+
+// GetWebhookSubscriptions returns all the subscriptions
+func GetWebhookSubscriptions(dbpool *pgxpool.Pool, vaultSecret string) (WebhookSubscriptions, error) {
+	rows, err := dbpool.Query(
+		context.Background(),
+		"SELECT "+webhookSubscriptionColumns+" FROM webhook_subscriptions ORDER BY name",
+		vaultSecret,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := WebhookSubscriptions{}
+	for rows.Next() {
+		s, err := scanWebhookSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+// EmitWebhookEvent queues an event for all the subscriptions interested in it.
+// Most events are emitted by database triggers, this is for the events
+// that don't map to a row change in Postgres.
+func EmitWebhookEvent(dbpool *pgxpool.Pool, eventType string, data map[string]any) error {
+	_, err := dbpool.Exec(
+		context.Background(),
+		"SELECT webhook_emit($1, $2)",
+		eventType, data,
+	)
+	return err
+}
+
+const webhookDeliveryColumns = `id, subscription_id, event_id::text, event_type, payload, status,
+	attempts, next_attempt_at, last_attempt_at, COALESCE(last_status_code, 0),
+	COALESCE(last_error, ''), COALESCE(locality, ''), created_at, updated_at`
+
+func scanWebhookDelivery(row interface{ Scan(...any) error }) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	err := row.Scan(
+		&d.ID,
+		&d.SubscriptionID,
+		&d.EventID,
+		&d.EventType,
+		&d.Payload,
+		&d.Status,
+		&d.Attempts,
+		&d.NextAttemptAt,
+		&d.LastAttemptAt,
+		&d.LastStatusCode,
+		&d.LastError,
+		&d.Locality,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+	)
+	return d, err
+}
+
+// GetDeliveries returns the delivery log of the subscription, most recent first.
+// If status is not empty, only the deliveries with that status are returned.
+func (s *WebhookSubscription) GetDeliveries(status string, limit int) (WebhookDeliveries, error) {
+	rows, err := s.DbPool.Query(
+		context.Background(),
+		`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		 WHERE subscription_id = $1
+		 AND ($2 = '' OR status::text = $2)
+		 ORDER BY id DESC LIMIT $3`,
+		s.ID, status, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := WebhookDeliveries{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// ClaimWebhookDelivery claims the next delivery due and increments its attempts.
+// A delivery stuck in 'delivering', because the process delivering it died, is claimed again
+// after 5 minutes.
+// Returns pgx.ErrNoRows if there is nothing to deliver.
+func ClaimWebhookDelivery(dbpool *pgxpool.Pool) (*WebhookDelivery, error) {
+	d, err := scanWebhookDelivery(dbpool.QueryRow(
+		context.Background(),
+		`UPDATE webhook_deliveries
+		 SET status = 'delivering', attempts = attempts + 1,
+		     last_attempt_at = now(), locality = $1
+		 WHERE id = (
+		   SELECT id FROM webhook_deliveries
+		   WHERE (status = 'pending' AND next_attempt_at <= now())
+		   OR (status = 'delivering' AND last_attempt_at < now() - interval '5 minutes')
+		   ORDER BY next_attempt_at
+		   LIMIT 1
+		   FOR UPDATE SKIP LOCKED)
+		 RETURNING `+webhookDeliveryColumns,
+		config.LocalityID,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	d.DbPool = dbpool
+	return &d, nil
+}
+
+// WebhookBackoff returns the delay before the next attempt of a delivery
+// that failed attempts times: 30s, 1m, 2m, 4m... up to 1h.
+func WebhookBackoff(attempts int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempts; i++ {
+		delay = delay * 2
+		if delay >= time.Hour {
+			return time.Hour
+		}
+	}
+	return delay
+}
+
+// SignWebhookPayload returns the HMAC-SHA256 signature of the body, as sent in
+// the X-Sandbox-Signature-256 header.
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SetResult records the result of an attempt.
+// On failure, the delivery is retried later with a backoff, until WebhookMaxAttempts.
+func (d *WebhookDelivery) SetResult(statusCode int, deliveryErr error) error {
+	lastError := ""
+	if deliveryErr != nil {
+		lastError = deliveryErr.Error()
+	}
+
+	switch {
+	case deliveryErr == nil:
+		d.Status = "success"
+	case d.Attempts >= WebhookMaxAttempts:
+		d.Status = "failed"
+	default:
+		d.Status = "pending"
+	}
+
+	_, err := d.DbPool.Exec(
+		context.Background(),
+		`UPDATE webhook_deliveries
+		 SET status = $1, last_status_code = NULLIF($2, 0), last_error = NULLIF($3, ''),
+		     next_attempt_at = now() + $4::interval
+		 WHERE id = $5`,
+		d.Status, statusCode, lastError, WebhookBackoff(d.Attempts), d.ID,
+	)
+	return err
+}
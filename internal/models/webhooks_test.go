@@ -0,0 +1,33 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWebhookBackoff(t *testing.T) {
+	expected := map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		7:  32 * time.Minute,
+		8:  time.Hour,
+		50: time.Hour,
+	}
+
+	for attempts, delay := range expected {
+		if got := WebhookBackoff(attempts); got != delay {
+			t.Errorf("WebhookBackoff(%d) should be %s, got %s", attempts, delay, got)
+		}
+	}
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	// echo -n '{"type":"placement.created"}' | openssl dgst -sha256 -hmac 'secretsecretsecret'
+	expected := "sha256=3db26786c94602bee58159123d819ea2fcaec68ade1fe891c854d5d132f14ed1"
+
+	signature := SignWebhookPayload("secretsecretsecret", []byte(`{"type":"placement.created"}`))
+	if signature != expected {
+		t.Errorf("Signature should be %s, got %s", expected, signature)
+	}
+}
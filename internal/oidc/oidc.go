@@ -0,0 +1,232 @@
+// Package oidc validates the bearer tokens issued by an OpenID Connect
+// provider, so the operators can use their SSO identity instead of a login token.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// The JWKS is refreshed at most once per minRefreshInterval when a token is
+// signed by an unknown key, in case the provider rotated its keys.
+const minRefreshInterval = time.Minute
+
+// ErrNoRole is returned when none of the groups of the user is mapped to a role
+var ErrNoRole = errors.New("no role for the groups of the user")
+
+// Config of the OIDC provider and of the mapping of the groups to the roles
+type Config struct {
+	// URL of the issuer, the 'iss' claim of the tokens.
+	// The JWKS is found with the discovery document of the issuer.
+	IssuerURL string
+	// Expected 'aud' claim, usually the client ID. Not checked if empty.
+	Audience string
+	// Claim holding the name of the user, 'preferred_username' by default
+	UsernameClaim string
+	// Claim holding the groups of the user, 'groups' by default
+	GroupsClaim string
+	// Members of these groups get the admin role
+	AdminGroups []string
+	// Members of these groups get the app role
+	AppGroups []string
+}
+
+// Role returns the role of a member of the groups. admin wins over app.
+func (c Config) Role(groups []string) (string, error) {
+	for _, group := range groups {
+		if slices.Contains(c.AdminGroups, group) {
+			return "admin", nil
+		}
+	}
+	for _, group := range groups {
+		if slices.Contains(c.AppGroups, group) {
+			return "app", nil
+		}
+	}
+	return "", ErrNoRole
+}
+
+// Identity is the user authenticated by an OIDC token
+type Identity struct {
+	// ID of the token, the 'jti' claim, may be empty
+	ID         string
+	Subject    string
+	Name       string
+	Groups     []string
+	Role       string
+	IssuedAt   time.Time
+	Expiration time.Time
+}
+
+// Verifier validates the tokens of the issuer against its JWKS
+type Verifier struct {
+	config  Config
+	jwksURL string
+	cache   *jwk.Cache
+
+	mu          sync.Mutex
+	lastRefresh time.Time
+}
+
+// discovery is the part of the OpenID discovery document used here
+type discovery struct {
+	Issuer  string `json:"issuer"`
+	JwksURI string `json:"jwks_uri"`
+}
+
+// NewVerifier reads the discovery document of the issuer and fetches its JWKS.
+// The JWKS is refreshed in the background until the context is cancelled.
+func NewVerifier(ctx context.Context, config Config) (*Verifier, error) {
+	if config.IssuerURL == "" {
+		return nil, errors.New("issuer URL is required")
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "preferred_username"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+
+	wellKnown := strings.TrimSuffix(config.IssuerURL, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error getting the discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error getting the discovery document: %s", resp.Status)
+	}
+
+	var doc discovery
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid discovery document: %w", err)
+	}
+	if doc.Issuer != config.IssuerURL {
+		return nil, fmt.Errorf("issuer mismatch: discovery document is for '%s'", doc.Issuer)
+	}
+	if doc.JwksURI == "" {
+		return nil, errors.New("invalid discovery document: no jwks_uri")
+	}
+
+	cache := jwk.NewCache(ctx)
+	if err := cache.Register(doc.JwksURI, jwk.WithMinRefreshInterval(15*time.Minute)); err != nil {
+		return nil, err
+	}
+	if _, err := cache.Refresh(ctx, doc.JwksURI); err != nil {
+		return nil, fmt.Errorf("error getting the JWKS: %w", err)
+	}
+
+	return &Verifier{
+		config:      config,
+		jwksURL:     doc.JwksURI,
+		cache:       cache,
+		lastRefresh: time.Now(),
+	}, nil
+}
+
+// Verify validates the signature, the issuer, the audience and the expiration
+// of the token, and maps the groups of the user to a role.
+func (v *Verifier) Verify(ctx context.Context, tokenString string) (Identity, error) {
+	token, err := v.parse(ctx, tokenString)
+	if err != nil && !jwt.IsValidationError(err) && v.refresh(ctx) {
+		// The token may be signed by a new key if the provider rotated its keys
+		token, err = v.parse(ctx, tokenString)
+	}
+	if err != nil {
+		return Identity{}, err
+	}
+
+	claims := token.PrivateClaims()
+
+	name, _ := claims[v.config.UsernameClaim].(string)
+	if name == "" {
+		name = token.Subject()
+	}
+
+	groups := claimStrings(claims[v.config.GroupsClaim])
+
+	role, err := v.config.Role(groups)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	return Identity{
+		ID:         token.JwtID(),
+		Subject:    token.Subject(),
+		Name:       name,
+		Groups:     groups,
+		Role:       role,
+		IssuedAt:   token.IssuedAt(),
+		Expiration: token.Expiration(),
+	}, nil
+}
+
+// claimStrings returns the strings of a claim holding a list of strings or,
+// as sent by some providers for a single value, a string.
+func claimStrings(claim any) []string {
+	values := []string{}
+	switch claim := claim.(type) {
+	case string:
+		if claim != "" {
+			values = append(values, claim)
+		}
+	case []any:
+		for _, value := range claim {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+func (v *Verifier) parse(ctx context.Context, tokenString string) (jwt.Token, error) {
+	set, err := v.cache.Get(ctx, v.jwksURL)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []jwt.ParseOption{
+		jwt.WithKeySet(set, jws.WithInferAlgorithmFromKey(true)),
+		jwt.WithValidate(true),
+		jwt.WithIssuer(v.config.IssuerURL),
+		jwt.WithRequiredClaim("exp"),
+		jwt.WithAcceptableSkew(30 * time.Second),
+	}
+	if v.config.Audience != "" {
+		options = append(options, jwt.WithAudience(v.config.Audience))
+	}
+
+	return jwt.ParseString(tokenString, options...)
+}
+
+// refresh fetches the JWKS again, at most once per minRefreshInterval.
+// It returns true if the JWKS was refreshed.
+func (v *Verifier) refresh(ctx context.Context) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if time.Since(v.lastRefresh) < minRefreshInterval {
+		return false
+	}
+	v.lastRefresh = time.Now()
+
+	_, err := v.cache.Refresh(ctx, v.jwksURL)
+	return err == nil
+}
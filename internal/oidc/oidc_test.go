@@ -0,0 +1,117 @@
+package oidc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rhpds/sandbox/internal/oidc/oidctest"
+)
+
+func TestConfigRole(t *testing.T) {
+	config := Config{
+		AdminGroups: []string{"sandbox-admins"},
+		AppGroups:   []string{"sandbox-users", "ci"},
+	}
+
+	if role, _ := config.Role([]string{"ci", "sandbox-admins"}); role != "admin" {
+		t.Errorf("admin should win over app, got %s", role)
+	}
+	if role, _ := config.Role([]string{"other", "ci"}); role != "app" {
+		t.Errorf("ci should be app, got %s", role)
+	}
+	if _, err := config.Role([]string{"other"}); err != ErrNoRole {
+		t.Errorf("Unmapped groups should have no role, got %v", err)
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	issuer, err := oidctest.NewIssuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer issuer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	verifier, err := NewVerifier(ctx, Config{
+		IssuerURL:   issuer.URL(),
+		Audience:    "sandbox-api",
+		AdminGroups: []string{"sandbox-admins"},
+		AppGroups:   []string{"sandbox-users"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := issuer.Token(map[string]any{
+		"sub":                "1234",
+		"aud":                "sandbox-api",
+		"preferred_username": "alice",
+		"groups":             []string{"sandbox-admins"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := verifier.Verify(ctx, token)
+	if err != nil {
+		t.Fatalf("Valid token should be verified, got %v", err)
+	}
+	if identity.Name != "alice" || identity.Role != "admin" || identity.Subject != "1234" {
+		t.Errorf("Wrong identity: %+v", identity)
+	}
+
+	// Groups claim holding a single group
+	token, err = issuer.Token(map[string]any{
+		"sub":    "5678",
+		"aud":    "sandbox-api",
+		"groups": "sandbox-users",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err = verifier.Verify(ctx, token)
+	if err != nil {
+		t.Fatalf("Token with a single group should be verified, got %v", err)
+	}
+	if identity.Role != "app" || identity.Name != "5678" {
+		t.Errorf("Wrong identity: %+v", identity)
+	}
+
+	invalid := map[string]map[string]any{
+		"wrong audience": {"aud": "other", "groups": []string{"sandbox-users"}},
+		"no role":        {"aud": "sandbox-api", "groups": []string{"other"}},
+		"expired":        {"aud": "sandbox-api", "groups": []string{"sandbox-users"}, "exp": time.Now().Add(-time.Hour)},
+		"wrong issuer":   {"aud": "sandbox-api", "groups": []string{"sandbox-users"}, "iss": "https://example.com"},
+	}
+	for name, claims := range invalid {
+		token, err := issuer.Token(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := verifier.Verify(ctx, token); err == nil {
+			t.Errorf("Token with %s should be rejected", name)
+		}
+	}
+
+	// Token signed by another issuer with the same key ID
+	other, err := oidctest.NewIssuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	token, err = other.Token(map[string]any{
+		"iss":    issuer.URL(),
+		"aud":    "sandbox-api",
+		"groups": []string{"sandbox-admins"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Verify(ctx, token); err == nil {
+		t.Error("Token with a wrong signature should be rejected")
+	}
+}
@@ -0,0 +1,101 @@
+// Package oidctest runs an in-process OIDC issuer, to verify the OIDC
+// authentication offline, without an identity provider.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// Issuer serves the discovery document and the JWKS of a signing key,
+// and issues the tokens signed with it.
+type Issuer struct {
+	server *httptest.Server
+	key    jwk.Key
+	public jwk.Set
+}
+
+// NewIssuer starts an issuer listening on a local port. Call Close when done.
+func NewIssuer() (*Issuer, error) {
+	raw, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := jwk.FromRaw(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := key.Set(jwk.KeyIDKey, "oidctest"); err != nil {
+		return nil, err
+	}
+	if err := key.Set(jwk.AlgorithmKey, jwa.RS256); err != nil {
+		return nil, err
+	}
+
+	publicKey, err := key.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	public := jwk.NewSet()
+	if err := public.AddKey(publicKey); err != nil {
+		return nil, err
+	}
+
+	issuer := &Issuer{key: key, public: public}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   issuer.URL(),
+			"jwks_uri": issuer.URL() + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(issuer.public)
+	})
+	issuer.server = httptest.NewServer(mux)
+
+	return issuer, nil
+}
+
+// URL of the issuer, the 'iss' claim of its tokens
+func (i *Issuer) URL() string {
+	return i.server.URL
+}
+
+// Close stops the issuer
+func (i *Issuer) Close() {
+	i.server.Close()
+}
+
+// Token returns a signed token with the claims. 'iss', 'iat' and 'exp'
+// are set if missing, the token expires in one hour.
+func (i *Issuer) Token(claims map[string]any) (string, error) {
+	token := jwt.New()
+	token.Set(jwt.IssuerKey, i.URL())
+	token.Set(jwt.IssuedAtKey, time.Now())
+	token.Set(jwt.ExpirationKey, time.Now().Add(time.Hour))
+
+	for k, v := range claims {
+		if err := token.Set(k, v); err != nil {
+			return "", err
+		}
+	}
+
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256, i.key))
+	if err != nil {
+		return "", err
+	}
+	return string(signed), nil
+}
@@ -0,0 +1,120 @@
+// Package tracing configures OpenTelemetry tracing and holds the helpers to
+// create spans and to carry the trace context across asynchronous work.
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/rhpds/sandbox"
+
+// Init configures the global tracer provider to export the spans with OTLP over HTTP.
+// Tracing is enabled only if OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, the exporter reads the standard
+// OTEL_* environment variables.
+// The returned function flushes and stops the exporter.
+func Init(ctx context.Context, serviceName string, version string) (func(context.Context) error, error) {
+	// The trace context is always propagated, even if the spans are not exported
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(serviceName),
+			semconv.ServiceVersion(version),
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span, child of the span in ctx if any
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error, if any, and ends the span
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Call runs fn in a span named name, child of the span in ctx.
+// It's used to trace the calls of methods that don't take a context.
+func Call[T any](ctx context.Context, name string, fn func() (T, error)) (T, error) {
+	_, span := Start(ctx, name)
+	result, err := fn()
+	End(span, err)
+	return result, err
+}
+
+// Detach returns a new context carrying only the span of ctx.
+// It's used by the goroutines that outlive the request that started them:
+// they join the trace of the request but are not cancelled with it.
+func Detach(ctx context.Context) context.Context {
+	return trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+}
+
+// Inject returns the trace context of ctx as a map, to be saved with an
+// asynchronous job. The map is empty if ctx has no span.
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier
+}
+
+// Extract returns a context carrying the trace context saved with Inject
+func Extract(ctx context.Context, carrier map[string]string) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(carrier))
+}
+
+// Transport wraps an HTTP transport to create a client span for each request
+func Transport(rt http.RoundTripper, name string) http.RoundTripper {
+	return otelhttp.NewTransport(
+		rt,
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return name + " " + r.Method
+		}),
+	)
+}